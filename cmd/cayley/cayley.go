@@ -43,7 +43,21 @@ import (
 
 var (
 	quadFile           = flag.String("quads", "", "Quad file to load before going to REPL.")
-	quadType           = flag.String("format", "cquad", `Quad format to use for loading ("cquad" or "nquad").`)
+	checkpoint         = flag.String("checkpoint", "", "File recording the progress of a load, so that it can be resumed if interrupted.")
+	quadType           = flag.String("format", "", `Quad format to use for loading ("cquad", "nquad", "bquad", "jsonld", "turtle", "trig", "csv" or "tsv") and dumping ("cquad", "nquad", "bquad", "jsonld", "graphml", "gexf" or "dot"). Detected from file names and content if not given.`)
+	mappingFile        = flag.String("mapping", "", "File describing how the columns of csv and tsv input are loaded as quads.")
+	lenient            = flag.Bool("lenient", false, "Skip N-Quad statements that cannot be parsed when loading, rather than failing.")
+	deadLetter         = flag.String("dead_letter", "", "File to which the statements skipped by a lenient load are written.")
+	preserveBlank      = flag.Bool("preserve_blank_nodes", false, "Load blank nodes with their labels, as for a dump, rather than as IRIs scoped to the file they are read from.")
+	skolemPrefix       = flag.String("skolem_prefix", internal.DefaultSkolemPrefix, "Start of the IRIs that the blank nodes of loaded files are rewritten as.")
+	dumpFile           = flag.String("dump", "dbdump.nq", `Quad file to dump the database to ("-" for stdout).`)
+	dumpGzip           = flag.Bool("dump_gzip", false, "Compress the dumped quads with gzip.")
+	dumpLabel          = flag.String("dump_label", "", "Only dump quads with this label.")
+	fromBackend        = flag.String("from-db", "", "Database backend to migrate from (defaults to -db).")
+	fromPath           = flag.String("from-path", "", "Path to the database to migrate from (defaults to -dbpath).")
+	toBackend          = flag.String("to-db", "", "Database backend to migrate to.")
+	toPath             = flag.String("to-path", "", "Path to the database to migrate to.")
+	repair             = flag.Bool("repair", false, "Repair the problems found by fsck.")
 	cpuprofile         = flag.String("prof", "", "Output profiling file.")
 	queryLanguage      = flag.String("query_lang", "gremlin", "Use this parser as the query language.")
 	configFile         = flag.String("config", "", "Path to an explicit configuration file.")
@@ -52,6 +66,7 @@ var (
 	replicationBackend = flag.String("replication", "single", "Replication method.")
 	host               = flag.String("host", "127.0.0.1", "Host to listen on (defaults to all).")
 	loadSize           = flag.Int("load_size", 10000, "Size of quadsets to load")
+	iteratorMemory     = flag.Int("iterator_memory", 64, "Megabytes of results an iterator may cache or deduplicate in memory before spilling them to disk (negative to never spill).")
 	port               = flag.String("port", "64210", "Port to listen on.")
 	readOnly           = flag.Bool("read_only", false, "Disable writing via HTTP.")
 	timeout            = flag.Duration("timeout", 30*time.Second, "Elapsed time until an individual query times out.")
@@ -71,6 +86,9 @@ Usage:
 Commands:
   init      Create an empty database.
   load      Bulk-load a quad file into the database.
+  dump      Write the contents of the database to a quad file.
+  migrate   Copy the contents of one database into another.
+  fsck      Check the consistency of the database indexes.
   http      Serve an HTTP endpoint on the given host and port.
   repl      Drop into a REPL of the given query language.
   version   Version information.
@@ -130,11 +148,63 @@ func configFrom(file string) *config.Config {
 		cfg.LoadSize = *loadSize
 	}
 
+	if cfg.IteratorMemory == 0 {
+		cfg.IteratorMemory = *iteratorMemory
+	}
+
 	cfg.ReadOnly = cfg.ReadOnly || *readOnly
 
+	cfg.LoadLenient = *lenient
+	cfg.LoadDeadLetter = *deadLetter
+	cfg.LoadMapping = *mappingFile
+	cfg.SkolemPrefix = *skolemPrefix
+	cfg.PreserveBlankNodes = *preserveBlank
+
 	return cfg
 }
 
+// migrate copies the database described by the -from-db and -from-path flags
+// into the database described by the -to-db and -to-path flags.
+func migrate(cfg *config.Config) error {
+	from, to := *cfg, *cfg
+	if *fromBackend != "" {
+		from.DatabaseType = *fromBackend
+	}
+	if *fromPath != "" {
+		from.DatabasePath = *fromPath
+	}
+	if *toBackend != "" {
+		to.DatabaseType = *toBackend
+	}
+	to.DatabasePath = *toPath
+	if to.DatabasePath == "" {
+		return fmt.Errorf("no path given for the database to migrate to")
+	}
+	if !graph.IsPersistent(to.DatabaseType) {
+		return fmt.Errorf("cannot migrate to %q: %v", to.DatabaseType, db.ErrNotPersistent)
+	}
+
+	src, err := db.Open(&from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if !graph.IsPersistent(from.DatabaseType) {
+		err = internal.Load(src.QuadWriter, &from, "", *quadType)
+		if err != nil {
+			return err
+		}
+	}
+
+	dst, err := db.OpenQuadStore(&to)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	return db.Migrate(dst, src.QuadStore, &to)
+}
+
 func main() {
 	// No command? It's time for usage.
 	if len(os.Args) == 1 {
@@ -185,7 +255,7 @@ func main() {
 			if err != nil {
 				break
 			}
-			err = internal.Load(handle.QuadWriter, cfg, *quadFile, *quadType)
+			err = internal.BulkLoad(handle, cfg, *quadFile, *quadType, *checkpoint)
 			if err != nil {
 				break
 			}
@@ -197,13 +267,59 @@ func main() {
 		if err != nil {
 			break
 		}
-		err = internal.Load(handle.QuadWriter, cfg, *quadFile, *quadType)
+		err = internal.BulkLoad(handle, cfg, *quadFile, *quadType, *checkpoint)
+		if err != nil {
+			break
+		}
+
+		handle.Close()
+
+	case "dump":
+		handle, err = db.Open(cfg)
+		if err != nil {
+			break
+		}
+		if !graph.IsPersistent(cfg.DatabaseType) {
+			err = internal.Load(handle.QuadWriter, cfg, "", *quadType)
+			if err != nil {
+				break
+			}
+		}
+		err = internal.Dump(handle.QuadStore, *dumpFile, *quadType, *dumpLabel, *dumpGzip)
 		if err != nil {
 			break
 		}
 
 		handle.Close()
 
+	case "migrate":
+		err = migrate(cfg)
+
+	case "fsck":
+		if !graph.IsPersistent(cfg.DatabaseType) {
+			err = fmt.Errorf("cannot check %q: %v", cfg.DatabaseType, db.ErrNotPersistent)
+			break
+		}
+		var qs graph.QuadStore
+		qs, err = db.OpenQuadStore(cfg)
+		if err != nil {
+			break
+		}
+		var n int
+		n, err = db.Fsck(qs, *repair)
+		qs.Close()
+		if err != nil {
+			break
+		}
+		switch {
+		case n == 0:
+			glog.Infoln("No problems found.")
+		case *repair:
+			glog.Infof("Repaired %d problems.", n)
+		default:
+			err = fmt.Errorf("found %d problems, run with -repair to fix them", n)
+		}
+
 	case "repl":
 		handle, err = db.Open(cfg)
 		if err != nil {
@@ -0,0 +1,47 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+var dotEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+// dotID returns s as a quoted DOT identifier.
+func dotID(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+func writeDOT(w io.Writer, g *propertyGraph) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("digraph {\n")
+	for _, n := range g.nodes {
+		bw.WriteString("  " + dotID(n.id) + " [label=" + dotID(n.label))
+		for k, name := range g.keys {
+			if v, ok := n.attr(k); ok {
+				bw.WriteString(", " + dotID(name) + "=" + dotID(v))
+			}
+		}
+		bw.WriteString("];\n")
+	}
+	for _, e := range g.edges {
+		bw.WriteString("  " + dotID(e.source.id) + " -> " + dotID(e.target.id) + " [label=" + dotID(e.predicate) + "];\n")
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
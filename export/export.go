@@ -0,0 +1,175 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package export implements writing quads as property graphs in the GraphML,
+// GEXF and Graphviz DOT formats, for use in graph visualization tools.
+//
+// Quads whose object is a literal become attributes of their subject node,
+// named by their predicate. Other quads become edges from their subject to
+// their object, labelled by their predicate. Literals are values written as
+// "value", "value"@lang or "value"^^<type>, or plain values, such as those
+// read by the cquads decoder, that are not the subject of any quad written.
+// Values written as <iri> or _:label are always nodes.
+package export
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/cayley/quad"
+)
+
+// Encoder implements property graph document generation. Quads are held in
+// memory until the Encoder is closed, when the graph is written.
+type Encoder struct {
+	w     io.Writer
+	write func(io.Writer, *propertyGraph) error
+	quads []quad.Quad
+}
+
+// NewGraphMLEncoder returns an encoder that writes a GraphML document to the
+// provided io.Writer.
+func NewGraphMLEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, write: writeGraphML}
+}
+
+// NewGEXFEncoder returns an encoder that writes a GEXF document to the
+// provided io.Writer.
+func NewGEXFEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, write: writeGEXF}
+}
+
+// NewDOTEncoder returns an encoder that writes a Graphviz DOT document to the
+// provided io.Writer.
+func NewDOTEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, write: writeDOT}
+}
+
+// Marshal adds q to the graph.
+func (enc *Encoder) Marshal(q quad.Quad) error {
+	if !q.IsValid() {
+		return quad.ErrInvalid
+	}
+	enc.quads = append(enc.quads, q)
+	return nil
+}
+
+// Close writes the graph. It does not close the underlying writer.
+func (enc *Encoder) Close() error {
+	return enc.write(enc.w, build(enc.quads))
+}
+
+// propertyGraph is a graph of nodes with attributes joined by labelled edges.
+type propertyGraph struct {
+	nodes []*node
+	index map[string]*node
+	edges []edge
+
+	// keys holds the attribute names in the order they were first seen.
+	keys     []string
+	keyIndex map[string]int
+}
+
+type node struct {
+	id    string
+	label string
+	// attrs holds the values of the node's attributes by key index.
+	attrs map[int][]string
+}
+
+type edge struct {
+	id             string
+	source, target *node
+	predicate      string
+	label          string
+}
+
+func build(quads []quad.Quad) *propertyGraph {
+	subjects := make(map[string]bool)
+	for _, q := range quads {
+		subjects[q.Subject] = true
+	}
+	g := &propertyGraph{index: make(map[string]*node), keyIndex: make(map[string]int)}
+	for _, q := range quads {
+		s := g.node(q.Subject)
+		if isLiteral(q.Object, subjects) {
+			k := g.key(display(q.Predicate))
+			s.attrs[k] = append(s.attrs[k], display(q.Object))
+			continue
+		}
+		g.edges = append(g.edges, edge{
+			id:        "e" + strconv.Itoa(len(g.edges)),
+			source:    s,
+			target:    g.node(q.Object),
+			predicate: display(q.Predicate),
+			label:     display(q.Label),
+		})
+	}
+	return g
+}
+
+func (g *propertyGraph) node(v string) *node {
+	n, ok := g.index[v]
+	if !ok {
+		n = &node{id: "n" + strconv.Itoa(len(g.nodes)), label: display(v), attrs: make(map[int][]string)}
+		g.index[v] = n
+		g.nodes = append(g.nodes, n)
+	}
+	return n
+}
+
+func (g *propertyGraph) key(name string) int {
+	k, ok := g.keyIndex[name]
+	if !ok {
+		k = len(g.keys)
+		g.keyIndex[name] = k
+		g.keys = append(g.keys, name)
+	}
+	return k
+}
+
+// attr returns the value of the attribute with key index k of n. Multiple
+// values are joined by semicolons.
+func (n *node) attr(k int) (string, bool) {
+	v, ok := n.attrs[k]
+	return strings.Join(v, "; "), ok
+}
+
+func isLiteral(v string, subjects map[string]bool) bool {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		return true
+	case strings.HasPrefix(v, "<"), strings.HasPrefix(v, "_:"):
+		return false
+	}
+	return !subjects[v]
+}
+
+// display returns the value v as shown to users: IRIs without their angle
+// brackets and literals without their quotes, language or type.
+func display(v string) string {
+	switch {
+	case len(v) >= 2 && v[0] == '<' && v[len(v)-1] == '>':
+		return v[1 : len(v)-1]
+	case strings.HasPrefix(v, `"`):
+		// Literal bodies are stored unescaped, so are shown as they are.
+		i := strings.LastIndex(v, `"`)
+		if i == 0 {
+			return v
+		}
+		return v[1:i]
+	}
+	return v
+}
@@ -0,0 +1,145 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/path"
+	"github.com/google/cayley/quad"
+
+	_ "github.com/google/cayley/graph/memstore"
+	_ "github.com/google/cayley/writer"
+)
+
+var testQuads = []quad.Quad{
+	{"<http://example.com/alice>", "<http://schema.org/name>", `"Alice "Al""@en`, ""},
+	{"<http://example.com/alice>", "<http://schema.org/knows>", "<http://example.com/bob>", ""},
+	{"<http://example.com/alice>", "<http://schema.org/age>", `"42"^^<http://www.w3.org/2001/XMLSchema#integer>`, ""},
+	{"<http://example.com/bob>", "<http://schema.org/name>", `"Bob"`, ""},
+	{"<http://example.com/bob>", "<http://schema.org/name>", `"Robert"`, ""},
+	{"charlie", "follows", "<http://example.com/bob>", "people"},
+	{"charlie", "status", "cool", ""},
+	{"dani", "follows", "charlie", ""},
+	{"dani", "status", `"C:\temp \u0041"`, ""},
+}
+
+func encode(t *testing.T, newEncoder func(io.Writer) *Encoder, quads []quad.Quad) []byte {
+	var buf bytes.Buffer
+	enc := newEncoder(&buf)
+	for _, q := range quads {
+		err := enc.Marshal(q)
+		if err != nil {
+			t.Fatalf("Unexpected error encoding %v: %v", q, err)
+		}
+	}
+	err := enc.Close()
+	if err != nil {
+		t.Fatalf("Unexpected error closing encoder: %v", err)
+	}
+	return buf.Bytes()
+}
+
+const expectDOT = `digraph {
+  "n0" [label="http://example.com/alice", "http://schema.org/name"="Alice \"Al\"", "http://schema.org/age"="42"];
+  "n1" [label="http://example.com/bob", "http://schema.org/name"="Bob; Robert"];
+  "n2" [label="charlie", "status"="cool"];
+  "n3" [label="dani", "status"="C:\\temp \\u0041"];
+  "n0" -> "n1" [label="http://schema.org/knows"];
+  "n2" -> "n1" [label="follows"];
+  "n3" -> "n2" [label="follows"];
+}
+`
+
+func TestDOT(t *testing.T) {
+	got := string(encode(t, NewDOTEncoder, testQuads))
+	if got != expectDOT {
+		t.Errorf("Unexpected DOT output, got:\n%s\nexpect:\n%s", got, expectDOT)
+	}
+}
+
+func TestGraphML(t *testing.T) {
+	var doc graphML
+	err := xml.Unmarshal(encode(t, NewGraphMLEncoder, testQuads), &doc)
+	if err != nil {
+		t.Fatalf("Failed to parse GraphML output: %v", err)
+	}
+	if len(doc.Keys) != 3+3 || len(doc.Graph.Nodes) != 4 || len(doc.Graph.Edges) != 3 {
+		t.Fatalf("Unexpected GraphML graph size, got %d keys, %d nodes and %d edges", len(doc.Keys), len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+	expect := graphMLItem{ID: "e1", Source: "n2", Target: "n1", Data: []graphMLData{{"predicate", "follows"}, {"graph", "people"}}}
+	if !reflect.DeepEqual(doc.Graph.Edges[1], expect) {
+		t.Errorf("Unexpected GraphML edge, got:%+v expect:%+v", doc.Graph.Edges[1], expect)
+	}
+	expect = graphMLItem{ID: "n1", Data: []graphMLData{{"label", "http://example.com/bob"}, {"d0", "Bob; Robert"}}}
+	if !reflect.DeepEqual(doc.Graph.Nodes[1], expect) {
+		t.Errorf("Unexpected GraphML node, got:%+v expect:%+v", doc.Graph.Nodes[1], expect)
+	}
+}
+
+func TestGEXF(t *testing.T) {
+	var doc gexf
+	err := xml.Unmarshal(encode(t, NewGEXFEncoder, testQuads), &doc)
+	if err != nil {
+		t.Fatalf("Failed to parse GEXF output: %v", err)
+	}
+	if len(doc.Graph.Attributes.Attributes) != 3 || len(doc.Graph.Nodes) != 4 || len(doc.Graph.Edges) != 3 {
+		t.Fatalf("Unexpected GEXF graph size, got %d attributes, %d nodes and %d edges",
+			len(doc.Graph.Attributes.Attributes), len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+	expect := gexfNode{ID: "n0", Label: "http://example.com/alice", Values: []gexfValue{{"0", `Alice "Al"`}, {"1", "42"}}}
+	if !reflect.DeepEqual(doc.Graph.Nodes[0], expect) {
+		t.Errorf("Unexpected GEXF node, got:%+v expect:%+v", doc.Graph.Nodes[0], expect)
+	}
+	// Backslashes in literals are not escapes.
+	expect = gexfNode{ID: "n3", Label: "dani", Values: []gexfValue{{"2", `C:\temp \u0041`}}}
+	if !reflect.DeepEqual(doc.Graph.Nodes[3], expect) {
+		t.Errorf("Unexpected GEXF node, got:%+v expect:%+v", doc.Graph.Nodes[3], expect)
+	}
+}
+
+type quadList []quad.Quad
+
+func (l *quadList) Marshal(q quad.Quad) error {
+	*l = append(*l, q)
+	return nil
+}
+
+func TestSubgraph(t *testing.T) {
+	qs, _ := graph.NewQuadStore("memstore", "", nil)
+	w, _ := graph.NewQuadWriter("single", qs, nil)
+	for _, q := range testQuads {
+		w.AddQuad(q)
+	}
+
+	var got quadList
+	err := PathSubgraph(qs, path.StartPath(qs, "dani").Tag("follower").Out("follows"), &got)
+	if err != nil {
+		t.Fatalf("Unexpected error exporting subgraph: %v", err)
+	}
+	expect := []quad.Quad{
+		{"charlie", "status", "cool", ""},
+		{"dani", "follows", "charlie", ""},
+		{"dani", "status", `"C:\temp \u0041"`, ""},
+	}
+	if !reflect.DeepEqual([]quad.Quad(got), expect) {
+		t.Errorf("Unexpected subgraph, got:%v expect:%v", got, expect)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"sort"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/path"
+	"github.com/google/cayley/quad"
+)
+
+// Subgraph writes to enc the quads of qs that describe the named nodes: those
+// from one of the nodes to another, and those from one of the nodes to a value
+// that is not the subject of any quad, such as a literal.
+func Subgraph(qs graph.QuadStore, nodes []string, enc quad.Marshaler) error {
+	in := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		in[n] = true
+	}
+	leaves := make(map[string]bool)
+	isLeaf := func(name string) bool {
+		leaf, ok := leaves[name]
+		if !ok {
+			it := qs.QuadIterator(quad.Subject, qs.ValueOf(name))
+			leaf = !graph.Next(it)
+			it.Close()
+			leaves[name] = leaf
+		}
+		return leaf
+	}
+
+	seen := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		it := qs.QuadIterator(quad.Subject, qs.ValueOf(n))
+		for graph.Next(it) {
+			q := qs.Quad(it.Result())
+			if !in[q.Object] && !isLeaf(q.Object) {
+				continue
+			}
+			err := enc.Marshal(q)
+			if err != nil {
+				it.Close()
+				return err
+			}
+		}
+		it.Close()
+	}
+	return nil
+}
+
+// PathSubgraph writes to enc the subgraph of qs describing the nodes matched
+// by p, including those it tags. See Subgraph for details.
+func PathSubgraph(qs graph.QuadStore, p *path.Path, enc quad.Marshaler) error {
+	it, _ := p.BuildIteratorOn(qs).Optimize()
+	defer it.Close()
+	var nodes []string
+	add := func() {
+		nodes = append(nodes, qs.NameOf(it.Result()))
+		tags := make(map[string]graph.Value)
+		it.TagResults(tags)
+		names := make([]string, 0, len(tags))
+		for k := range tags {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			nodes = append(nodes, qs.NameOf(tags[k]))
+		}
+	}
+	for graph.Next(it) {
+		add()
+		for it.NextPath() {
+			add()
+		}
+	}
+	return Subgraph(qs, nodes, enc)
+}
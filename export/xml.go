@@ -0,0 +1,161 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLItem `xml:"node"`
+		Edges       []graphMLItem `xml:"edge"`
+	} `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLItem struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr,omitempty"`
+	Target string        `xml:"target,attr,omitempty"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+func writeGraphML(w io.Writer, g *propertyGraph) error {
+	doc := graphML{XMLNS: "http://graphml.graphdrawing.org/xmlns"}
+	doc.Keys = []graphMLKey{
+		{ID: "label", For: "node", Name: "label", Type: "string"},
+		{ID: "predicate", For: "edge", Name: "predicate", Type: "string"},
+		{ID: "graph", For: "edge", Name: "graph", Type: "string"},
+	}
+	for i, k := range g.keys {
+		doc.Keys = append(doc.Keys, graphMLKey{ID: "d" + strconv.Itoa(i), For: "node", Name: k, Type: "string"})
+	}
+	doc.Graph.ID = "G"
+	doc.Graph.EdgeDefault = "directed"
+	for _, n := range g.nodes {
+		item := graphMLItem{ID: n.id, Data: []graphMLData{{Key: "label", Value: n.label}}}
+		for k := range g.keys {
+			if v, ok := n.attr(k); ok {
+				item.Data = append(item.Data, graphMLData{Key: "d" + strconv.Itoa(k), Value: v})
+			}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, item)
+	}
+	for _, e := range g.edges {
+		item := graphMLItem{ID: e.id, Source: e.source.id, Target: e.target.id, Data: []graphMLData{{Key: "predicate", Value: e.predicate}}}
+		if e.label != "" {
+			item.Data = append(item.Data, graphMLData{Key: "graph", Value: e.label})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, item)
+	}
+	return writeXML(w, doc)
+}
+
+type gexf struct {
+	XMLName xml.Name `xml:"gexf"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Graph   struct {
+		DefaultEdgeType string         `xml:"defaultedgetype,attr"`
+		Attributes      gexfAttributes `xml:"attributes"`
+		Nodes           []gexfNode     `xml:"nodes>node"`
+		Edges           []gexfEdge     `xml:"edges>edge"`
+	} `xml:"graph"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID     string      `xml:"id,attr"`
+	Label  string      `xml:"label,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue,omitempty"`
+}
+
+type gexfValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+	Label  string `xml:"label,attr"`
+}
+
+func writeGEXF(w io.Writer, g *propertyGraph) error {
+	doc := gexf{XMLNS: "http://www.gexf.net/1.2draft", Version: "1.2"}
+	doc.Graph.DefaultEdgeType = "directed"
+	doc.Graph.Attributes.Class = "node"
+	for i, k := range g.keys {
+		doc.Graph.Attributes.Attributes = append(doc.Graph.Attributes.Attributes, gexfAttribute{ID: strconv.Itoa(i), Title: k, Type: "string"})
+	}
+	for _, n := range g.nodes {
+		item := gexfNode{ID: n.id, Label: n.label}
+		for k := range g.keys {
+			if v, ok := n.attr(k); ok {
+				item.Values = append(item.Values, gexfValue{For: strconv.Itoa(k), Value: v})
+			}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, item)
+	}
+	for _, e := range g.edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{ID: e.id, Source: e.source.id, Target: e.target.id, Label: e.predicate})
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc interface{}) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
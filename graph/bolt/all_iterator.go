@@ -30,6 +30,8 @@ type AllIterator struct {
 	uid    uint64
 	tags   graph.Tagger
 	bucket []byte
+	start  []byte
+	end    []byte
 	dir    quad.Direction
 	qs     *QuadStore
 	result *Token
@@ -74,6 +76,7 @@ func (it *AllIterator) TagResults(dst map[string]graph.Value) {
 
 func (it *AllIterator) Clone() graph.Iterator {
 	out := NewAllIterator(it.bucket, it.dir, it.qs)
+	out.start, out.end = it.start, it.end
 	out.tags.CopyFrom(it)
 	return out
 }
@@ -89,17 +92,30 @@ func (it *AllIterator) Next() bool {
 			last = it.buffer[len(it.buffer)-1]
 		}
 		it.buffer = make([][]byte, 0, bufferSize)
-		err := it.qs.db.View(func(tx *bolt.Tx) error {
+		err := it.qs.view(func(tx *bolt.Tx) error {
 			i := 0
 			b := tx.Bucket(it.bucket)
 			cur := b.Cursor()
+			// Quad buckets keep entries for deleted quads; skip them.
+			live := func(v []byte) bool {
+				return it.dir == quad.Any || isLiveValue(v)
+			}
 			if last == nil {
-				k, _ := cur.First()
-				var out []byte
-				out = make([]byte, len(k))
-				copy(out, k)
-				it.buffer = append(it.buffer, out)
-				i++
+				k, v := cur.First()
+				if it.start != nil {
+					k, v = cur.Seek(it.start)
+				}
+				if !it.inRange(k) {
+					it.buffer = append(it.buffer, nil)
+					return nil
+				}
+				if live(v) {
+					var out []byte
+					out = make([]byte, len(k))
+					copy(out, k)
+					it.buffer = append(it.buffer, out)
+					i++
+				}
 			} else {
 				k, _ := cur.Seek(last)
 				if !bytes.Equal(k, last) {
@@ -107,11 +123,14 @@ func (it *AllIterator) Next() bool {
 				}
 			}
 			for i < bufferSize {
-				k, _ := cur.Next()
-				if k == nil {
-					it.buffer = append(it.buffer, k)
+				k, v := cur.Next()
+				if !it.inRange(k) {
+					it.buffer = append(it.buffer, nil)
 					break
 				}
+				if !live(v) {
+					continue
+				}
 				var out []byte
 				out = make([]byte, len(k))
 				copy(out, k)
@@ -136,6 +155,47 @@ func (it *AllIterator) Next() bool {
 	return true
 }
 
+// NextBatch fills dst with the following keys. They are read from the bucket
+// in the same batches as for Next.
+func (it *AllIterator) NextBatch(dst []graph.Value) int {
+	var n int
+	for n < len(dst) && it.Next() {
+		dst[n] = it.Result()
+		n++
+	}
+	return n
+}
+
+func (it *AllIterator) inRange(key []byte) bool {
+	return key != nil && (it.end == nil || bytes.Compare(key, it.end) < 0)
+}
+
+// Partition splits the keys into ranges by their first byte.
+func (it *AllIterator) Partition(n int) []graph.Iterator {
+	if n > 256 {
+		n = 256
+	}
+	if n < 1 {
+		n = 1
+	}
+	bound := func(i int) []byte {
+		return []byte{byte(256 * i / n)}
+	}
+	parts := make([]graph.Iterator, n)
+	for i := range parts {
+		out := NewAllIterator(it.bucket, it.dir, it.qs)
+		if i > 0 {
+			out.start = bound(i)
+		}
+		if i < n-1 {
+			out.end = bound(i + 1)
+		}
+		out.tags.CopyFrom(it)
+		parts[i] = out
+	}
+	return parts
+}
+
 func (it *AllIterator) Err() error {
 	return it.err
 }
@@ -178,7 +238,7 @@ func (it *AllIterator) Close() error {
 }
 
 func (it *AllIterator) Size() (int64, bool) {
-	return it.qs.size, true
+	return it.qs.size, it.start == nil && it.end == nil
 }
 
 func (it *AllIterator) Describe() graph.Description {
@@ -208,4 +268,5 @@ func (it *AllIterator) Stats() graph.IteratorStats {
 	}
 }
 
-var _ graph.Nexter = &AllIterator{}
+var _ graph.BatchNexter = &AllIterator{}
+var _ graph.Partitioner = &AllIterator{}
@@ -15,15 +15,22 @@
 package bolt
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"reflect"
+	"regexp"
 	"sort"
+	"strings"
 	"testing"
 
+	"github.com/boltdb/bolt"
+
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/graph/search"
 	"github.com/google/cayley/quad"
 	"github.com/google/cayley/writer"
 )
@@ -466,6 +473,98 @@ func TestOptimize(t *testing.T) {
 	}
 }
 
+func TestNameIndex(t *testing.T) {
+	tmpFile, _ := ioutil.TempFile(os.TempDir(), "cayley_test")
+	t.Log(tmpFile.Name())
+	defer os.RemoveAll(tmpFile.Name())
+	err := createNewBolt(tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpFile.Name(), nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create bolt QuadStore.")
+	}
+
+	// Names longer than the index holds share their key prefix.
+	long := strings.Repeat("x", maxNameSize)
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(append(makeQuadSet(),
+		quad.Quad{"A", "named", long + "a", ""},
+		quad.Quad{"B", "named", long + "b", ""},
+	))
+
+	prefix := func(prefix string) (graph.Iterator, bool) {
+		return iterator.NewPrefix(qs.NodesAllIterator(), prefix, qs).Optimize()
+	}
+	it, ok := prefix("st")
+	if !ok || it.Type() != namesType {
+		t.Errorf("Failed to optimize prefix to a name scan, got:%v", it.Type())
+	}
+	expect := []string{"status", "status_graph"}
+	if got := iteratedNames(qs, it); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+	if s, exact := it.Size(); s != 2 || !exact {
+		t.Errorf("Unexpected size, got:%d, %t expect:2, true", s, exact)
+	}
+	if it.Contains(qs.ValueOf("cool")) || !it.Contains(qs.ValueOf("status")) {
+		t.Error("Unexpected result checking names against the prefix")
+	}
+	it, _ = prefix(long + "b")
+	expect = []string{long + "b"}
+	if got := iteratedNames(qs, it); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get the long name, got %d results", len(got))
+	}
+	it, ok = iterator.NewRegex(qs.NodesAllIterator(), regexp.MustCompile("^st.*h$"), qs).Optimize()
+	if !ok || it.Type() != graph.Regex || it.SubIterators()[0].Type() != namesType {
+		t.Errorf("Failed to optimize regex to a filtered name scan, got:%v", it.Type())
+	}
+	expect = []string{"status_graph"}
+	if got := iteratedNames(qs, it); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+
+	// A database without a name index falls back to filtering all nodes,
+	// until the index is built by a repair.
+	err = qs.(*QuadStore).db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(nameBucket)
+	})
+	if err != nil {
+		t.Fatalf("Failed to delete the name index: %v", err)
+	}
+	qs.Close()
+	qs, err = newQuadStore(tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen bolt QuadStore: %v", err)
+	}
+	defer qs.Close()
+	if it, ok := prefix("st"); ok {
+		t.Errorf("Unexpected optimization without a name index to %v", it.Type())
+	}
+	var problems []error
+	err = qs.(graph.Checker).Check(true, func(err error) {
+		problems = append(problems, err)
+	})
+	if err != nil || len(problems) != 1 {
+		t.Errorf("Unexpected repair of the name index, got:%v (%v)", problems, err)
+	}
+	err = qs.(graph.Checker).Check(false, func(err error) {
+		t.Errorf("Unexpected problem after repair: %v", err)
+	})
+	if err != nil {
+		t.Errorf("Failed to check database: %v", err)
+	}
+	it, ok = prefix("st")
+	if !ok {
+		t.Error("Failed to optimize prefix after repair")
+	}
+	expect = []string{"status", "status_graph"}
+	if got := iteratedNames(qs, it); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+}
+
 func TestDeletedFromIterator(t *testing.T) {
 
 	tmpFile, _ := ioutil.TempFile(os.TempDir(), "cayley_test")
@@ -505,3 +604,541 @@ func TestDeletedFromIterator(t *testing.T) {
 		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
 	}
 }
+
+func TestSearchIndex(t *testing.T) {
+	tmpFile, _ := ioutil.TempFile(os.TempDir(), "cayley_test")
+	t.Log(tmpFile.Name())
+	defer os.RemoveAll(tmpFile.Name())
+	err := createNewBolt(tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpFile.Name(), nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create bolt QuadStore.")
+	}
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+	it := search.NewSearch(qs, "cool")
+	if graph.Next(it) || it.Err() != search.ErrNoIndex {
+		t.Errorf("Unexpected search without an index, got error:%v", it.Err())
+	}
+
+	// Opening the database with the search option builds the index.
+	qs.Close()
+	qs, err = newQuadStore(tmpFile.Name(), graph.Options{"search": true})
+	if err != nil {
+		t.Fatalf("Failed to reopen bolt QuadStore: %v", err)
+	}
+	expect := []string{"status", "status_graph"}
+	if got := iteratedNames(qs, search.NewSearch(qs, "st")); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+
+	// The index is kept once built, and nodes are removed from it with their
+	// last quad.
+	qs.Close()
+	qs, err = newQuadStore(tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen bolt QuadStore: %v", err)
+	}
+	defer qs.Close()
+	w, _ = writer.NewSingleReplication(qs, nil)
+	for _, q := range makeQuadSet() {
+		if q.Object == "cool" {
+			w.RemoveQuad(q)
+		}
+	}
+	w.AddQuad(quad.Quad{"E", "status", "cooler", ""})
+	expect = []string{"cooler"}
+	if got := iteratedNames(qs, search.NewSearch(qs, "coo")); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+	expect = []string{"status"}
+	if got := iteratedNames(qs, search.NewSearch(qs, "st")); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	tmpFile, _ := ioutil.TempFile(os.TempDir(), "cayley_test")
+	t.Log(tmpFile.Name())
+	defer os.RemoveAll(tmpFile.Name())
+	err := createNewBolt(tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpFile.Name(), nil)
+	if qs == nil || err != nil {
+		t.Error("Failed to create bolt QuadStore.")
+	}
+	defer qs.Close()
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+
+	snap, err := qs.(graph.Snapshotter).Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	expect := makeQuadSet()
+	sort.Sort(ordered(expect))
+	if got := iteratedQuads(snap, snap.QuadsAllIterator()); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected snapshot results, got:%v expect:%v", got, expect)
+	}
+	if err := snap.ApplyDeltas(nil, graph.IgnoreOpts{}); err != errReadOnly {
+		t.Errorf("Unexpected error writing to snapshot, got:%v expect:%v", err, errReadOnly)
+	}
+	// A bolt read transaction blocks writers from remapping the file,
+	// so the snapshot is released before writing.
+	snap.Close()
+
+	removed := quad.Quad{"E", "follows", "F", ""}
+	w.RemoveQuad(removed)
+	expect = nil
+	for _, q := range makeQuadSet() {
+		if q != removed {
+			expect = append(expect, q)
+		}
+	}
+	sort.Sort(ordered(expect))
+	if got := iteratedQuads(qs, qs.QuadsAllIterator()); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+}
+
+func TestPreconditions(t *testing.T) {
+	tmpFile, _ := ioutil.TempFile(os.TempDir(), "cayley_test")
+	t.Log(tmpFile.Name())
+	defer os.RemoveAll(tmpFile.Name())
+	err := createNewBolt(tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpFile.Name(), nil)
+	if qs == nil || err != nil {
+		t.Error("Failed to create bolt QuadStore.")
+	}
+	defer qs.Close()
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+	horizon := qs.Horizon()
+
+	tx := graph.NewTransaction()
+	tx.RequireQuad(quad.Quad{"E", "follows", "F", ""})
+	tx.RequireNoQuad(quad.Quad{"E", "follows", "G", ""})
+	tx.RequireHorizon(horizon)
+	tx.RemoveQuad(quad.Quad{"E", "follows", "F", ""})
+	tx.AddQuad(quad.Quad{"E", "follows", "G", ""})
+	err = w.ApplyTransaction(tx)
+	if err != nil {
+		t.Fatalf("Failed to apply transaction: %v", err)
+	}
+
+	// The same transaction no longer applies: its quad preconditions
+	// and the horizon have changed.
+	size := qs.Size()
+	for _, cond := range tx.Preconditions {
+		tx := graph.NewTransaction()
+		tx.Preconditions = []graph.Precondition{cond}
+		tx.AddQuad(quad.Quad{"E", "follows", "H", ""})
+		err = w.ApplyTransaction(tx)
+		if err != graph.ErrPreconditionFailed {
+			t.Errorf("Unexpected error for failed precondition %v, got:%v expect:%v", cond, err, graph.ErrPreconditionFailed)
+		}
+	}
+	if qs.Size() != size {
+		t.Error("Applied a transaction with a failed precondition")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tmpFile, _ := ioutil.TempFile(os.TempDir(), "cayley_test")
+	t.Log(tmpFile.Name())
+	defer os.RemoveAll(tmpFile.Name())
+	err := createNewBolt(tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpFile.Name(), nil)
+	if qs == nil || err != nil {
+		t.Error("Failed to create bolt QuadStore.")
+	}
+	defer qs.Close()
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+
+	check := func(repair bool) []error {
+		var problems []error
+		err := qs.(graph.Checker).Check(repair, func(err error) {
+			problems = append(problems, err)
+		})
+		if err != nil {
+			t.Fatalf("Failed to check database: %v", err)
+		}
+		return problems
+	}
+	if got := check(false); len(got) != 0 {
+		t.Errorf("Unexpected problems in a consistent database: %v", got)
+	}
+
+	bs := qs.(*QuadStore)
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		tx.Bucket(ospBucket).Delete(bs.createKeyFor(osp, quad.Quad{"A", "follows", "B", ""}))
+		tx.Bucket(spoBucket).Delete(bs.createKeyFor(spo, quad.Quad{"B", "status", "cool", "status_graph"}))
+		return bs.UpdateValueKeyBy("follows", 3, tx)
+	})
+	if err != nil {
+		t.Fatalf("Failed to damage database: %v", err)
+	}
+	bs.size += 2
+
+	if got := check(true); len(got) != 4 {
+		t.Errorf("Unexpected number of problems, got:%d expect:4 (%v)", len(got), got)
+	}
+	if got := check(false); len(got) != 0 {
+		t.Errorf("Unexpected problems after repair: %v", got)
+	}
+
+	expect := makeQuadSet()
+	sort.Sort(ordered(expect))
+	if got := iteratedQuads(qs, qs.QuadsAllIterator()); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+	if s := bs.SizeOf(qs.ValueOf("follows")); s != 8 {
+		t.Errorf("Unexpected size for follows, got:%d expect:8", s)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quad store size, got:%d expect:11", s)
+	}
+}
+
+// quadSlice is a quad.Unmarshaler reading from a slice.
+type quadSlice []quad.Quad
+
+func (s *quadSlice) Unmarshal() (quad.Quad, error) {
+	if len(*s) == 0 {
+		return quad.Quad{}, io.EOF
+	}
+	q := (*s)[0]
+	*s = (*s)[1:]
+	return q, nil
+}
+
+func TestBulkLoad(t *testing.T) {
+	tmpFile, _ := ioutil.TempFile(os.TempDir(), "cayley_test")
+	t.Log(tmpFile.Name())
+	defer os.RemoveAll(tmpFile.Name())
+	err := createNewBolt(tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpFile.Name(), nil)
+	if qs == nil || err != nil {
+		t.Error("Failed to create bolt QuadStore.")
+	}
+	defer qs.Close()
+
+	// Force the sorts to spill to disk.
+	defer func(n int) { bulkMemory = n }(bulkMemory)
+	bulkMemory = 1 << 10
+
+	set := append(makeQuadSet(), quad.Quad{"A", "follows", "B", ""})
+	dec := quadSlice(set)
+	err = qs.(graph.BulkLoader).BulkLoad(&dec)
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+
+	expect := makeQuadSet()
+	sort.Sort(ordered(expect))
+	if got := iteratedQuads(qs, qs.QuadsAllIterator()); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quad store size, got:%d expect:11", s)
+	}
+	if h := qs.Horizon(); h.Int() != 11 {
+		t.Errorf("Unexpected horizon, got:%d expect:11", h.Int())
+	}
+	err = qs.(graph.Checker).Check(false, func(err error) {
+		t.Errorf("Unexpected problem after bulk load: %v", err)
+	})
+	if err != nil {
+		t.Errorf("Failed to check database: %v", err)
+	}
+
+	dec = quadSlice(makeQuadSet())
+	if err := qs.(graph.BulkLoader).BulkLoad(&dec); err != graph.ErrCannotBulkLoad {
+		t.Errorf("Unexpected error bulk loading into a non-empty store, got:%v expect:%v", err, graph.ErrCannotBulkLoad)
+	}
+}
+
+func TestBulkLoadSearch(t *testing.T) {
+	tmpFile, _ := ioutil.TempFile(os.TempDir(), "cayley_test")
+	t.Log(tmpFile.Name())
+	defer os.RemoveAll(tmpFile.Name())
+	err := createNewBolt(tmpFile.Name(), graph.Options{"search": true})
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpFile.Name(), nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create bolt QuadStore.")
+	}
+	defer qs.Close()
+
+	dec := quadSlice(makeQuadSet())
+	err = qs.(graph.BulkLoader).BulkLoad(&dec)
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	expect := []string{"status", "status_graph"}
+	if got := iteratedNames(qs, search.NewSearch(qs, "st")); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected search results, got:%v expect:%v", got, expect)
+	}
+	err = qs.(graph.Checker).Check(false, func(err error) {
+		t.Errorf("Unexpected problem after bulk load: %v", err)
+	})
+	if err != nil {
+		t.Errorf("Failed to check database: %v", err)
+	}
+}
+
+// failingSlice is a quadSlice whose input fails once the quads are read.
+type failingSlice struct{ quadSlice }
+
+var errInput = errors.New("input failed")
+
+func (s *failingSlice) Unmarshal() (quad.Quad, error) {
+	q, err := s.quadSlice.Unmarshal()
+	if err == io.EOF {
+		err = errInput
+	}
+	return q, err
+}
+
+func TestIncompleteBulkLoad(t *testing.T) {
+	tmpFile, _ := ioutil.TempFile(os.TempDir(), "cayley_test")
+	t.Log(tmpFile.Name())
+	defer os.RemoveAll(tmpFile.Name())
+	err := createNewBolt(tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpFile.Name(), nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create bolt QuadStore.")
+	}
+
+	dec := &failingSlice{makeQuadSet()}
+	if err := qs.(graph.BulkLoader).BulkLoad(dec); err != errInput {
+		t.Errorf("Unexpected error from a failing bulk load, got:%v expect:%v", err, errInput)
+	}
+	again := quadSlice(makeQuadSet())
+	if err := qs.(graph.BulkLoader).BulkLoad(&again); err != graph.ErrIncompleteBulkLoad {
+		t.Errorf("Unexpected error bulk loading again, got:%v expect:%v", err, graph.ErrIncompleteBulkLoad)
+	}
+	qs.Close()
+	if _, err := newQuadStore(tmpFile.Name(), nil); err != graph.ErrIncompleteBulkLoad {
+		t.Errorf("Unexpected error opening after a failed bulk load, got:%v expect:%v", err, graph.ErrIncompleteBulkLoad)
+	}
+}
+
+func predicateStats(t *testing.T, qs graph.QuadStore) map[string]graph.PredicateStats {
+	stats := make(map[string]graph.PredicateStats)
+	for _, p := range []string{"follows", "status"} {
+		st, ok := qs.(graph.Statistician).PredicateStats(qs.ValueOf(p))
+		if !ok {
+			t.Fatalf("Expected statistics for %q", p)
+		}
+		stats[p] = st
+	}
+	return stats
+}
+
+func TestPredicateStats(t *testing.T) {
+	var files []string
+	for i := 0; i < 2; i++ {
+		tmpFile, _ := ioutil.TempFile(os.TempDir(), "cayley_test")
+		t.Log(tmpFile.Name())
+		defer os.RemoveAll(tmpFile.Name())
+		err := createNewBolt(tmpFile.Name(), nil)
+		if err != nil {
+			t.Fatalf("Failed to create working directory")
+		}
+		files = append(files, tmpFile.Name())
+	}
+	qs, err := newQuadStore(files[0], nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create bolt QuadStore.")
+	}
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(append(makeQuadSet(), quad.Quad{"G", "follows", "A", ""}))
+	w.RemoveQuad(quad.Quad{"G", "follows", "A", ""})
+	got := predicateStats(t, qs)
+
+	expect := graph.PredicateStats{
+		Quads:         8,
+		Subjects:      6,
+		Objects:       4,
+		SubjectFanout: graph.Histogram{4, 2},
+		ObjectFanout:  graph.Histogram{1, 3},
+	}
+	if !reflect.DeepEqual(got["follows"], expect) {
+		t.Errorf("Unexpected predicate stats, got:%+v expect:%+v", got["follows"], expect)
+	}
+
+	loaded, err := newQuadStore(files[1], nil)
+	if loaded == nil || err != nil {
+		t.Fatal("Failed to create bolt QuadStore.")
+	}
+	defer loaded.Close()
+	dec := quadSlice(makeQuadSet())
+	err = loaded.(graph.BulkLoader).BulkLoad(&dec)
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	if stats := predicateStats(t, loaded); !reflect.DeepEqual(stats, got) {
+		t.Errorf("Bulk loaded stats differ from written stats, got:%+v expect:%+v", stats, got)
+	}
+
+	// A database without statistics has them collected by a repair.
+	err = qs.(*QuadStore).db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(statsBucket)
+	})
+	if err != nil {
+		t.Fatalf("Failed to delete statistics: %v", err)
+	}
+	qs.Close()
+	qs, err = newQuadStore(files[0], nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to reopen bolt QuadStore.")
+	}
+	defer qs.Close()
+	if _, ok := qs.(graph.Statistician).PredicateStats(qs.ValueOf("follows")); ok {
+		t.Error("Unexpected statistics without the stats bucket")
+	}
+	check := func(repair bool) []error {
+		var problems []error
+		err := qs.(graph.Checker).Check(repair, func(err error) {
+			problems = append(problems, err)
+		})
+		if err != nil {
+			t.Fatalf("Failed to check database: %v", err)
+		}
+		return problems
+	}
+	if got := check(true); len(got) != 1 {
+		t.Errorf("Unexpected number of problems, got:%d expect:1 (%v)", len(got), got)
+	}
+	if got := check(false); len(got) != 0 {
+		t.Errorf("Unexpected problems after repair: %v", got)
+	}
+	if stats := predicateStats(t, qs); !reflect.DeepEqual(stats, got) {
+		t.Errorf("Repaired stats differ from written stats, got:%+v expect:%+v", stats, got)
+	}
+}
+
+func TestLeapfrogJoin(t *testing.T) {
+	tmpFile, _ := ioutil.TempFile(os.TempDir(), "cayley_test")
+	t.Log(tmpFile.Name())
+	defer os.RemoveAll(tmpFile.Name())
+	err := createNewBolt(tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpFile.Name(), nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create bolt QuadStore.")
+	}
+	defer qs.Close()
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+
+	follows := qs.ValueOf("follows")
+	idx := qs.(graph.SortedIndexer)
+	sorted, indexed := idx.SortedQuadIterator([]graph.Linkage{{Dir: quad.Predicate, Value: follows}}, quad.Object)
+	if !indexed {
+		t.Error("Expected the quads of a predicate to be read in object order from an index")
+	}
+	var last graph.Value
+	for graph.Next(sorted) {
+		obj := qs.QuadDirection(sorted.Result(), quad.Object)
+		if last != nil && idx.CompareValues(last, obj) > 0 {
+			t.Errorf("Quads out of order, %q before %q", qs.NameOf(last), qs.NameOf(obj))
+		}
+		last = obj
+	}
+	sorted.Reset()
+	if !sorted.Seek(qs.ValueOf("G")) {
+		t.Fatal("Failed to seek to an object")
+	}
+	if got := qs.NameOf(qs.QuadDirection(sorted.Result(), quad.Object)); got != "G" {
+		t.Errorf("Unexpected object after seeking, got:%q expect:%q", got, "G")
+	}
+
+	// The only triangle in the graph is C->D->B with C->B.
+	join := iterator.NewLeapfrog(qs, []iterator.Pattern{
+		{quad.Subject: {Var: "a"}, quad.Predicate: {Value: follows}, quad.Object: {Var: "b"}},
+		{quad.Subject: {Var: "b"}, quad.Predicate: {Value: follows}, quad.Object: {Var: "c"}},
+		{quad.Subject: {Var: "a"}, quad.Predicate: {Value: follows}, quad.Object: {Var: "c"}},
+	}, "c")
+	for _, v := range []string{"a", "b", "c"} {
+		join.TagVariable(v, v)
+	}
+	var got []string
+	for graph.Next(join) {
+		tags := make(map[string]graph.Value)
+		join.TagResults(tags)
+		got = append(got, qs.NameOf(tags["a"])+qs.NameOf(tags["b"])+qs.NameOf(tags["c"]))
+	}
+	if expect := []string{"CDB"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected triangles, got:%v expect:%v", got, expect)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	tmpFile, _ := ioutil.TempFile(os.TempDir(), "cayley_test")
+	t.Log(tmpFile.Name())
+	defer os.RemoveAll(tmpFile.Name())
+	err := createNewBolt(tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpFile.Name(), nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create bolt QuadStore.")
+	}
+	defer qs.Close()
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+
+	for _, all := range []func() graph.Iterator{qs.NodesAllIterator, qs.QuadsAllIterator} {
+		var expect []graph.Value
+		it := all()
+		for graph.Next(it) {
+			expect = append(expect, it.Result())
+		}
+		it.Close()
+		for _, n := range []int{1, 3, 1000} {
+			parts := all().(graph.Partitioner).Partition(n)
+			if len(parts) > n || len(parts) > 256 {
+				t.Errorf("Unexpected number of parts, got:%d for %d", len(parts), n)
+			}
+			var got []graph.Value
+			for _, part := range parts {
+				for graph.Next(part) {
+					got = append(got, part.Result())
+				}
+				part.Close()
+			}
+			if !reflect.DeepEqual(got, expect) {
+				t.Errorf("Unexpected values in %d parts, got:%v expect:%v", n, got, expect)
+			}
+		}
+	}
+}
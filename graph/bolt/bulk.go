@@ -0,0 +1,466 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/barakmich/glog"
+	"github.com/boltdb/bolt"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/search"
+	"github.com/google/cayley/internal/extsort"
+	"github.com/google/cayley/quad"
+)
+
+var (
+	// bulkMemory is the memory used by each sort while bulk loading.
+	bulkMemory = 32 << 20
+	// bulkBatchSize is the number of writes in each bulk load transaction.
+	bulkBatchSize = 10000
+)
+
+// bulkWriter appends keys, in sorted order, to a bucket, committing a
+// transaction every bulkBatchSize writes.
+type bulkWriter struct {
+	db     *bolt.DB
+	bucket []byte
+	tx     *bolt.Tx
+	b      *bolt.Bucket
+	n      int
+}
+
+func (w *bulkWriter) put(k, v []byte) error {
+	if w.tx == nil {
+		tx, err := w.db.Begin(true)
+		if err != nil {
+			return err
+		}
+		w.tx = tx
+		w.b = tx.Bucket(w.bucket)
+		// Keys arrive in order, so pages can be filled completely.
+		w.b.FillPercent = 1.0
+	}
+	err := w.b.Put(append([]byte(nil), k...), append([]byte(nil), v...))
+	if err != nil {
+		w.rollback()
+		return err
+	}
+	w.n++
+	if w.n < bulkBatchSize {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *bulkWriter) flush() error {
+	if w.tx == nil {
+		return nil
+	}
+	err := w.tx.Commit()
+	w.tx = nil
+	w.n = 0
+	return err
+}
+
+func (w *bulkWriter) rollback() {
+	if w.tx != nil {
+		w.tx.Rollback()
+		w.tx = nil
+	}
+}
+
+// writeSorted writes the records of s to bucket. Each record holds a key of
+// keySize bytes followed by its value.
+func (qs *QuadStore) writeSorted(s *extsort.Sorter, bucket []byte, keySize int) error {
+	w := &bulkWriter{db: qs.db, bucket: bucket}
+	err := s.Each(func(rec []byte) error {
+		return w.put(rec[:keySize], rec[keySize:])
+	})
+	if err != nil {
+		w.rollback()
+		return err
+	}
+	return w.flush()
+}
+
+// BulkLoad loads the quads read from dec into an empty QuadStore. The quads
+// are sorted on disk so that duplicates can be dropped and value reference
+// counts and predicate statistics computed without reading from the
+// database, and the keys of each
+// bucket are sorted so that they can be appended in large transactions.
+// Each quad is recorded in the log as an added delta with an ID given by its
+// position in the input.
+func (qs *QuadStore) BulkLoad(dec quad.Unmarshaler) error {
+	if qs.tx != nil {
+		return errReadOnly
+	}
+	if qs.size != 0 || qs.horizon != 0 {
+		return graph.ErrCannotBulkLoad
+	}
+	// The marker is removed with the write of the size and horizon, so a
+	// load that fails part way leaves a database that will not open.
+	err := qs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(metaBucket)
+		if b.Get(bulkKey) != nil {
+			return graph.ErrIncompleteBulkLoad
+		}
+		return b.Put(bulkKey, []byte{1})
+	})
+	if err != nil {
+		return err
+	}
+
+	// Quads are sorted by their spo key followed by their position in the
+	// input, so that the first of any duplicates is kept.
+	keySize := hashSize * 4
+	quads := extsort.New("", bulkMemory)
+	defer quads.Close()
+	var id int64
+	for {
+		q, err := dec.Unmarshal()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		id++
+		b, err := json.Marshal(q)
+		if err != nil {
+			return err
+		}
+		rec := make([]byte, keySize+8, keySize+8+len(b))
+		copy(rec, qs.createKeyFor(spo, q))
+		binary.BigEndian.PutUint64(rec[keySize:], uint64(id))
+		err = quads.Add(append(rec, b...))
+		if err != nil {
+			return err
+		}
+	}
+
+	indexes := map[[4]quad.Direction]*extsort.Sorter{
+		osp: extsort.New("", bulkMemory),
+		pos: extsort.New("", bulkMemory),
+		cps: extsort.New("", bulkMemory),
+	}
+	for _, s := range indexes {
+		defer s.Close()
+	}
+	deltas := extsort.New("", bulkMemory)
+	defer deltas.Close()
+	nodes := extsort.New("", bulkMemory)
+	defer nodes.Close()
+	names := extsort.New("", bulkMemory)
+	defer names.Close()
+	postings := extsort.New("", bulkMemory)
+	defer postings.Close()
+	// Objects are counted by sorting predicate and object pairs; subjects
+	// are counted as they go by, since the quads are sorted by subject and
+	// predicate. The counts are then sorted to be appended to the bucket.
+	objects := extsort.New("", bulkMemory)
+	defer objects.Close()
+	fanout := extsort.New("", bulkMemory)
+	defer fanout.Close()
+	stats := make(map[string]*graph.PredicateStats)
+	statsFor := func(p []byte) *graph.PredicateStats {
+		st, ok := stats[string(p)]
+		if !ok {
+			st = &graph.PredicateStats{}
+			stats[string(p)] = st
+		}
+		return st
+	}
+	var (
+		run  []byte
+		runN int64
+	)
+	// putRun records that n quads have the predicate and the node in
+	// direction dir given by pair, the hashes of both.
+	putRun := func(dir quad.Direction, pair []byte, n int64) error {
+		statsFor(pair[:hashSize]).UpdateFanout(dir, 0, n)
+		rec := make([]byte, 0, 2+2*hashSize+8)
+		rec = append(rec, 'f', dir.Prefix())
+		rec = append(rec, pair...)
+		rec = rec[:len(rec)+8]
+		binary.LittleEndian.PutUint64(rec[len(rec)-8:], uint64(n))
+		return fanout.Add(rec)
+	}
+
+	now := time.Now()
+	spoWriter := &bulkWriter{db: qs.db, bucket: spoBucket}
+	var (
+		last    []byte
+		size    int64
+		horizon int64
+	)
+	err = quads.Each(func(rec []byte) error {
+		if bytes.Equal(rec[:keySize], last) {
+			return nil
+		}
+		last = rec[:keySize]
+		id := int64(binary.BigEndian.Uint64(rec[keySize:]))
+		var q quad.Quad
+		err := json.Unmarshal(rec[keySize+8:], &q)
+		if err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(graph.Delta{
+			ID:        graph.NewSequentialKey(id),
+			Quad:      q,
+			Action:    graph.Add,
+			Timestamp: now,
+		})
+		if err != nil {
+			return err
+		}
+		err = deltas.Add(append(qs.createDeltaKeyFor(id), b...))
+		if err != nil {
+			return err
+		}
+		b, err = json.Marshal(IndexEntry{History: []int64{id}})
+		if err != nil {
+			return err
+		}
+		err = spoWriter.put(last, b)
+		if err != nil {
+			return err
+		}
+		for index, s := range indexes {
+			if index == cps && q.Label == "" {
+				continue
+			}
+			err = s.Add(append(qs.createKeyFor(index, q), b...))
+			if err != nil {
+				return err
+			}
+		}
+		for _, d := range []quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label} {
+			name := q.Get(d)
+			if d == quad.Label && name == "" {
+				continue
+			}
+			err = nodes.Add(append(qs.createValueKeyFor(name), name...))
+			if err != nil {
+				return err
+			}
+		}
+
+		// The quads are in subject and predicate order, so a run of
+		// them with the same pair gives the fanout of the subject.
+		s, p, o := last[:hashSize], last[hashSize:2*hashSize], last[2*hashSize:3*hashSize]
+		ps := append(append(make([]byte, 0, 2*hashSize), p...), s...)
+		if !bytes.Equal(ps, run) {
+			if runN > 0 {
+				err = putRun(quad.Subject, run, runN)
+				if err != nil {
+					return err
+				}
+			}
+			run, runN = ps, 0
+		}
+		runN++
+		statsFor(p).Quads++
+		err = objects.Add(append(append(make([]byte, 0, 2*hashSize), p...), o...))
+		if err != nil {
+			return err
+		}
+
+		size++
+		if id > horizon {
+			horizon = id
+		}
+		if glog.V(2) && size%int64(bulkBatchSize) == 0 {
+			glog.V(2).Infof("Bulk loaded %d quads.", size)
+		}
+		return nil
+	})
+	if err == nil {
+		err = spoWriter.flush()
+	} else {
+		spoWriter.rollback()
+	}
+	if err != nil {
+		return err
+	}
+
+	for index, s := range indexes {
+		err = qs.writeSorted(s, bucketFor(index), keySize)
+		if err != nil {
+			return err
+		}
+	}
+	err = qs.writeSorted(deltas, logBucket, len(qs.createDeltaKeyFor(0)))
+	if err != nil {
+		return err
+	}
+
+	if runN > 0 {
+		err = putRun(quad.Subject, run, runN)
+		if err != nil {
+			return err
+		}
+	}
+	run, runN = nil, 0
+	err = objects.Each(func(rec []byte) error {
+		if bytes.Equal(rec, run) {
+			runN++
+			return nil
+		}
+		if runN > 0 {
+			err := putRun(quad.Object, run, runN)
+			if err != nil {
+				return err
+			}
+		}
+		run, runN = rec, 1
+		return nil
+	})
+	if err == nil && runN > 0 {
+		err = putRun(quad.Object, run, runN)
+	}
+	if err != nil {
+		return err
+	}
+	err = qs.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(statsBucket)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = qs.writeSorted(fanout, statsBucket, 2+2*hashSize)
+	if err != nil {
+		return err
+	}
+
+	// Node records are sorted by value key, and equal records share a name,
+	// so each run of them gives a value's count in bucket order.
+	nodeWriter := &bulkWriter{db: qs.db, bucket: nodeBucket}
+	var value *ValueData
+	putValue := func() error {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		err = names.Add(qs.createNameKeyFor(value.Name))
+		if err != nil {
+			return err
+		}
+		if qs.search {
+			for term, n := range search.Terms(value.Name) {
+				err = postings.Add(append(qs.createSearchKeyFor(term, value.Name), postingValue(n)...))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nodeWriter.put(last[:hashSize], b)
+	}
+	last = nil
+	err = nodes.Each(func(rec []byte) error {
+		if bytes.Equal(rec, last) {
+			value.Size++
+			return nil
+		}
+		if value != nil {
+			err := putValue()
+			if err != nil {
+				return err
+			}
+		}
+		last = rec
+		value = &ValueData{Name: string(rec[hashSize:]), Size: 1}
+		return nil
+	})
+	if err == nil && value != nil {
+		err = putValue()
+	}
+	if err == nil {
+		err = nodeWriter.flush()
+	} else {
+		nodeWriter.rollback()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Name keys end with the value key they refer to.
+	err = qs.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(nameBucket)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	nameWriter := &bulkWriter{db: qs.db, bucket: nameBucket}
+	err = names.Each(func(rec []byte) error {
+		return nameWriter.put(rec, rec[len(rec)-hashSize:])
+	})
+	if err == nil {
+		err = nameWriter.flush()
+	} else {
+		nameWriter.rollback()
+	}
+	if err != nil {
+		return err
+	}
+	qs.names = true
+
+	// Postings end with the count of their term.
+	if qs.search {
+		w := &bulkWriter{db: qs.db, bucket: searchBucket}
+		err = postings.Each(func(rec []byte) error {
+			return w.put(rec[:len(rec)-4], rec[len(rec)-4:])
+		})
+		if err == nil {
+			err = w.flush()
+		} else {
+			w.rollback()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	qs.size = size
+	qs.horizon = horizon
+	err = qs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(statsBucket)
+		for p, st := range stats {
+			err := putStats(b, createStatsKeyFor([]byte(p)), st)
+			if err != nil {
+				return err
+			}
+		}
+		err := qs.WriteHorizonAndSize(tx)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucket).Delete(bulkKey)
+	})
+	if err != nil {
+		return err
+	}
+	qs.stats = true
+	return nil
+}
+
+var _ graph.BulkLoader = &QuadStore{}
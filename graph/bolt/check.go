@@ -0,0 +1,506 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/quad"
+)
+
+// indexKey rearranges key, an entry in the index ordered by from, into the
+// key of the same quad in the index ordered by to.
+func indexKey(key []byte, from, to [4]quad.Direction) []byte {
+	out := make([]byte, 0, hashSize*4)
+	for _, d := range to {
+		for i, f := range from {
+			if f == d {
+				out = append(out, key[i*hashSize:(i+1)*hashSize]...)
+			}
+		}
+	}
+	return out
+}
+
+// fix is a pending write made by a repairing check; a nil value deletes key.
+type fix struct {
+	bucket []byte
+	key    []byte
+	value  []byte
+}
+
+// checker holds the state of a single consistency check.
+type checker struct {
+	qs     *QuadStore
+	tx     *bolt.Tx
+	repair bool
+	report func(error)
+	fixes  []fix
+
+	// refs holds the expected value data, keyed by value key.
+	refs    map[string]*ValueData
+	size    int64
+	horizon int64
+
+	// names holds the names of the value entries, as repaired.
+	names map[string]bool
+
+	// fanout holds the expected fanout counts, keyed by fanout key.
+	fanout map[string]int64
+
+	// corrupt holds primary keys that could not be decoded; restored holds
+	// primary keys rebuilt from one of the other indexes.
+	corrupt  map[string]bool
+	restored map[string]bool
+}
+
+// Check verifies that every quad is present in all of its indexes and can be
+// resolved through the log, that value reference counts match the live quads,
+// and that the stored size and horizon agree with the indexes. The spo index
+// is treated as authoritative, except that a quad found only in the other
+// indexes is restored.
+func (qs *QuadStore) Check(repair bool, fn func(error)) error {
+	if repair && qs.tx != nil {
+		return errReadOnly
+	}
+	check := func(tx *bolt.Tx) error {
+		c := &checker{
+			qs:       qs,
+			tx:       tx,
+			repair:   repair,
+			report:   fn,
+			refs:     make(map[string]*ValueData),
+			names:    make(map[string]bool),
+			fanout:   make(map[string]int64),
+			corrupt:  make(map[string]bool),
+			restored: make(map[string]bool),
+		}
+		err := c.checkPrimary()
+		if err != nil {
+			return err
+		}
+		for _, index := range [][4]quad.Direction{osp, pos, cps} {
+			err = c.checkSecondary(index)
+			if err != nil {
+				return err
+			}
+		}
+		err = c.checkValues()
+		if err != nil {
+			return err
+		}
+		err = c.checkNames()
+		if err != nil {
+			return err
+		}
+		err = c.checkStats()
+		if err != nil {
+			return err
+		}
+		c.checkMetadata()
+		if !repair {
+			return nil
+		}
+		return c.apply()
+	}
+	if !repair {
+		return qs.view(check)
+	}
+	oldSize := qs.size
+	oldHorizon := qs.horizon
+	oldNames := qs.names
+	oldStats := qs.stats
+	err := qs.db.Update(check)
+	if err != nil {
+		qs.size = oldSize
+		qs.horizon = oldHorizon
+		qs.names = oldNames
+		qs.stats = oldStats
+	}
+	return err
+}
+
+func (c *checker) fix(bucket, key, value []byte) {
+	if !c.repair {
+		return
+	}
+	f := fix{bucket: bucket, key: append([]byte(nil), key...)}
+	if value != nil {
+		f.value = append([]byte(nil), value...)
+	}
+	c.fixes = append(c.fixes, f)
+}
+
+func (c *checker) apply() error {
+	for _, f := range c.fixes {
+		b := c.tx.Bucket(f.bucket)
+		var err error
+		if f.value == nil {
+			err = b.Delete(f.key)
+		} else {
+			err = b.Put(f.key, f.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if c.qs.size == c.size && c.qs.horizon >= c.horizon {
+		return nil
+	}
+	c.qs.size = c.size
+	if c.qs.horizon < c.horizon {
+		c.qs.horizon = c.horizon
+	}
+	return c.qs.WriteHorizonAndSize(c.tx)
+}
+
+// quadFor returns the quad recorded by the last delta in entry's history.
+func (c *checker) quadFor(entry IndexEntry) (quad.Quad, bool) {
+	if len(entry.History) == 0 {
+		return quad.Quad{}, false
+	}
+	data := c.tx.Bucket(logBucket).Get(c.qs.createDeltaKeyFor(entry.History[len(entry.History)-1]))
+	if data == nil {
+		return quad.Quad{}, false
+	}
+	var d graph.Delta
+	err := json.Unmarshal(data, &d)
+	if err != nil {
+		return quad.Quad{}, false
+	}
+	return d.Quad, true
+}
+
+// count adds a quad's contribution to the expected size, horizon, value
+// reference counts and fanout counts.
+func (c *checker) count(q quad.Quad, entry IndexEntry) {
+	for _, id := range entry.History {
+		if id > c.horizon {
+			c.horizon = id
+		}
+	}
+	if len(entry.History)%2 == 0 {
+		return
+	}
+	c.size++
+	for _, d := range []quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label} {
+		name := q.Get(d)
+		if d == quad.Label && name == "" {
+			continue
+		}
+		key := string(c.qs.createValueKeyFor(name))
+		v, ok := c.refs[key]
+		if !ok {
+			v = &ValueData{Name: name}
+			c.refs[key] = v
+		}
+		v.Size++
+	}
+	for _, d := range []quad.Direction{quad.Subject, quad.Object} {
+		k := graph.FanoutKey{Predicate: q.Predicate, Dir: d, Node: q.Get(d)}
+		c.fanout[string(c.qs.createFanoutKeyFor(k))]++
+	}
+}
+
+func (c *checker) checkPrimary() error {
+	return c.tx.Bucket(spoBucket).ForEach(func(k, v []byte) error {
+		var entry IndexEntry
+		err := json.Unmarshal(v, &entry)
+		if err != nil {
+			c.report(fmt.Errorf("bolt: corrupt spo entry %x: %v", k, err))
+			c.corrupt[string(k)] = true
+			c.fix(spoBucket, k, nil)
+			return nil
+		}
+		q, ok := c.quadFor(entry)
+		if !ok {
+			c.report(fmt.Errorf("bolt: spo entry %x has no quad in the log", k))
+			for _, index := range [][4]quad.Direction{spo, osp, pos, cps} {
+				c.fix(bucketFor(index), indexKey(k, spo, index), nil)
+			}
+			return nil
+		}
+		for _, index := range [][4]quad.Direction{osp, pos, cps} {
+			if index == cps && q.Label == "" {
+				continue
+			}
+			sk := indexKey(k, spo, index)
+			sv := c.tx.Bucket(bucketFor(index)).Get(sk)
+			if sv == nil {
+				c.report(fmt.Errorf("bolt: missing %s entry for quad %v", bucketFor(index), q))
+			} else if !bytes.Equal(sv, v) {
+				c.report(fmt.Errorf("bolt: %s entry for quad %v does not match spo entry", bucketFor(index), q))
+			} else {
+				continue
+			}
+			c.fix(bucketFor(index), sk, v)
+		}
+		c.count(q, entry)
+		return nil
+	})
+}
+
+func (c *checker) checkSecondary(index [4]quad.Direction) error {
+	bucket := bucketFor(index)
+	return c.tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+		pk := indexKey(k, index, spo)
+		if !c.corrupt[string(pk)] && c.tx.Bucket(spoBucket).Get(pk) != nil {
+			return nil
+		}
+		if c.restored[string(pk)] {
+			return nil
+		}
+		var entry IndexEntry
+		err := json.Unmarshal(v, &entry)
+		if err != nil {
+			c.report(fmt.Errorf("bolt: corrupt %s entry %x: %v", bucket, k, err))
+			c.fix(bucket, k, nil)
+			return nil
+		}
+		q, ok := c.quadFor(entry)
+		if !ok {
+			c.report(fmt.Errorf("bolt: %s entry %x has no quad in the log", bucket, k))
+			c.fix(bucket, k, nil)
+			return nil
+		}
+		c.report(fmt.Errorf("bolt: missing spo entry for quad %v", q))
+		c.restored[string(pk)] = true
+		for _, d := range [][4]quad.Direction{spo, osp, pos, cps} {
+			if d == cps && q.Label == "" {
+				continue
+			}
+			c.fix(bucketFor(d), c.qs.createKeyFor(d, q), v)
+		}
+		c.count(q, entry)
+		return nil
+	})
+}
+
+func (c *checker) checkValues() error {
+	err := c.tx.Bucket(nodeBucket).ForEach(func(k, v []byte) error {
+		var got ValueData
+		err := json.Unmarshal(v, &got)
+		if err != nil {
+			c.report(fmt.Errorf("bolt: corrupt value entry %x: %v", k, err))
+			c.fix(nodeBucket, k, nil)
+			return nil
+		}
+		want, ok := c.refs[string(k)]
+		if !ok {
+			want = &ValueData{Name: got.Name}
+		}
+		delete(c.refs, string(k))
+		c.names[want.Name] = true
+		if got == *want {
+			return nil
+		}
+		if got.Name != want.Name {
+			c.report(fmt.Errorf("bolt: value entry %x holds %q, expected %q", k, got.Name, want.Name))
+		} else {
+			c.report(fmt.Errorf("bolt: value %q has reference count %d, expected %d", got.Name, got.Size, want.Size))
+		}
+		return c.putValue(k, want)
+	})
+	if err != nil {
+		return err
+	}
+	for k, want := range c.refs {
+		c.report(fmt.Errorf("bolt: missing value entry for %q", want.Name))
+		c.names[want.Name] = true
+		err = c.putValue([]byte(k), want)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkNames verifies that the name index holds exactly the names of the
+// value entries. A database without a name index has one built on repair.
+func (c *checker) checkNames() error {
+	want := make(map[string][]byte, len(c.names))
+	for name := range c.names {
+		want[string(c.qs.createNameKeyFor(name))] = c.qs.createValueKeyFor(name)
+	}
+	b := c.tx.Bucket(nameBucket)
+	if b == nil {
+		c.report(fmt.Errorf("bolt: name index is missing"))
+		if c.repair {
+			_, err := c.tx.CreateBucket(nameBucket)
+			if err != nil {
+				return err
+			}
+			c.qs.names = true
+		}
+	} else {
+		err := b.ForEach(func(k, v []byte) error {
+			if bytes.Equal(want[string(k)], v) {
+				delete(want, string(k))
+				return nil
+			}
+			name := k
+			if len(name) >= hashSize {
+				name = name[:len(name)-hashSize]
+			}
+			c.report(fmt.Errorf("bolt: name index holds %q, which has no value entry", name))
+			c.fix(nameBucket, k, nil)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for k, v := range want {
+		if b != nil {
+			c.report(fmt.Errorf("bolt: name index is missing %q", k[:len(k)-hashSize]))
+		}
+		c.fix(nameBucket, []byte(k), v)
+	}
+	return nil
+}
+
+// checkStats verifies the fanout counts and the predicate statistics computed
+// from them. A database without statistics has them collected on repair.
+func (c *checker) checkStats() error {
+	stats := make(map[string]*graph.PredicateStats)
+	for k, n := range c.fanout {
+		p := k[2 : 2+hashSize]
+		st, ok := stats[p]
+		if !ok {
+			st = &graph.PredicateStats{}
+			stats[p] = st
+		}
+		dir := quad.Subject
+		if k[1] == quad.Object.Prefix() {
+			dir = quad.Object
+		} else {
+			st.Quads += n
+		}
+		st.UpdateFanout(dir, 0, n)
+	}
+	fixCount := func(k []byte, n int64) {
+		v := make([]byte, 8)
+		binary.LittleEndian.PutUint64(v, uint64(n))
+		c.fix(statsBucket, k, v)
+	}
+	fixStats := func(k []byte, st *graph.PredicateStats) error {
+		if st.Quads == 0 {
+			c.fix(statsBucket, k, nil)
+			return nil
+		}
+		v, err := json.Marshal(st)
+		if err != nil {
+			return err
+		}
+		c.fix(statsBucket, k, v)
+		return nil
+	}
+	b := c.tx.Bucket(statsBucket)
+	if b == nil {
+		c.report(fmt.Errorf("bolt: predicate statistics are missing"))
+		if !c.repair {
+			return nil
+		}
+		_, err := c.tx.CreateBucket(statsBucket)
+		if err != nil {
+			return err
+		}
+		c.qs.stats = true
+		for k, n := range c.fanout {
+			fixCount([]byte(k), n)
+		}
+		for p, st := range stats {
+			err = fixStats(createStatsKeyFor([]byte(p)), st)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := b.ForEach(func(k, v []byte) error {
+		switch k[0] {
+		case 'f':
+			want := c.fanout[string(k)]
+			delete(c.fanout, string(k))
+			if len(v) == 8 && int64(binary.LittleEndian.Uint64(v)) == want {
+				return nil
+			}
+			c.report(fmt.Errorf("bolt: fanout count %x is wrong, expected %d", k, want))
+			if want == 0 {
+				c.fix(statsBucket, k, nil)
+			} else {
+				fixCount(k, want)
+			}
+		case 'r':
+			want, ok := stats[string(k[1:])]
+			delete(stats, string(k[1:]))
+			var got graph.PredicateStats
+			if json.Unmarshal(v, &got) == nil && ok && reflect.DeepEqual(got, *want) {
+				return nil
+			}
+			if !ok {
+				c.report(fmt.Errorf("bolt: statistics entry %x is for a predicate without quads", k))
+				want = &graph.PredicateStats{}
+			} else {
+				c.report(fmt.Errorf("bolt: statistics entry %x is wrong", k))
+			}
+			return fixStats(k, want)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for k, n := range c.fanout {
+		c.report(fmt.Errorf("bolt: missing fanout count %x", k))
+		fixCount([]byte(k), n)
+	}
+	for p, st := range stats {
+		k := createStatsKeyFor([]byte(p))
+		c.report(fmt.Errorf("bolt: missing statistics entry %x", k))
+		err = fixStats(k, st)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) putValue(k []byte, v *ValueData) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.fix(nodeBucket, k, b)
+	return nil
+}
+
+func (c *checker) checkMetadata() {
+	if c.qs.size != c.size {
+		c.report(fmt.Errorf("bolt: size is %d, expected %d", c.qs.size, c.size))
+	}
+	if c.qs.horizon < c.horizon {
+		c.report(fmt.Errorf("bolt: horizon is %d, but quads reference delta %d", c.qs.horizon, c.horizon))
+	}
+}
+
+var _ graph.Checker = &QuadStore{}
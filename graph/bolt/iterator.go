@@ -51,25 +51,55 @@ type Iterator struct {
 	done    bool
 	size    int64
 	err     error
+
+	// links holds the linkages fixed by prefix, in index order, and by is
+	// the direction whose nodes follow them in the keys.
+	links  []graph.Linkage
+	prefix []byte
+	by     quad.Direction
+	seek   []byte
 }
 
 func NewIterator(bucket []byte, d quad.Direction, value graph.Value, qs *QuadStore) *Iterator {
-	tok := value.(*Token)
-	if !bytes.Equal(tok.bucket, nodeBucket) {
-		glog.Error("creating an iterator from a non-node value")
-		return &Iterator{done: true}
-	}
+	return newIterator(bucket, []graph.Linkage{{Dir: d, Value: value}}, qs)
+}
 
+// newIterator returns an iterator over the keys of the index bucket that have
+// all of links, which must be for the leading directions of the index.
+func newIterator(bucket []byte, links []graph.Linkage, qs *QuadStore) *Iterator {
+	order := indexOrder(bucket)
 	it := Iterator{
 		uid:    iterator.NextUID(),
 		bucket: bucket,
-		dir:    d,
+		dir:    quad.Any,
 		qs:     qs,
-		size:   qs.SizeOf(value),
+		size:   qs.Size(),
+	}
+	for _, d := range order[:len(links)] {
+		for _, l := range links {
+			if l.Dir != d {
+				continue
+			}
+			tok := l.Value.(*Token)
+			if !bytes.Equal(tok.bucket, nodeBucket) {
+				glog.Error("creating an iterator from a non-node value")
+				return &Iterator{done: true}
+			}
+			it.prefix = append(it.prefix, tok.key...)
+			it.links = append(it.links, graph.Linkage{Dir: d, Value: &Token{nodeBucket, tok.key}})
+			break
+		}
+	}
+	if len(it.links) > 0 {
+		first := it.links[0].Value.(*Token)
+		it.dir = it.links[0].Dir
+		it.checkID = make([]byte, len(first.key))
+		copy(it.checkID, first.key)
+		it.size = qs.SizeOf(first)
+	}
+	if len(links) < len(order) {
+		it.by = order[len(links)]
 	}
-
-	it.checkID = make([]byte, len(tok.key))
-	copy(it.checkID, tok.key)
 
 	return &it
 }
@@ -101,11 +131,39 @@ func (it *Iterator) TagResults(dst map[string]graph.Value) {
 }
 
 func (it *Iterator) Clone() graph.Iterator {
-	out := NewIterator(it.bucket, it.dir, &Token{nodeBucket, it.checkID}, it.qs)
+	out := newIterator(it.bucket, it.links, it.qs)
 	out.Tagger().CopyFrom(it)
 	return out
 }
 
+// Linkage returns the direction and value the iterator was created for.
+func (it *Iterator) Linkage() (graph.Linkage, bool) {
+	if len(it.links) != 1 {
+		return graph.Linkage{}, false
+	}
+	return it.links[0], true
+}
+
+// SortedBy returns the direction whose nodes the quads are ordered by.
+func (it *Iterator) SortedBy() quad.Direction {
+	return it.by
+}
+
+// Seek advances the iterator to the first quad whose node in the SortedBy
+// direction is not less than v.
+func (it *Iterator) Seek(v graph.Value) bool {
+	if it.bucket == nil {
+		return false
+	}
+	it.seek = make([]byte, 0, len(it.prefix)+hashSize)
+	it.seek = append(it.seek, it.prefix...)
+	it.seek = append(it.seek, v.(*Token).key...)
+	it.buffer = nil
+	it.offset = 0
+	it.done = false
+	return it.Next()
+}
+
 func (it *Iterator) Close() error {
 	it.result = nil
 	it.buffer = nil
@@ -113,7 +171,7 @@ func (it *Iterator) Close() error {
 	return nil
 }
 
-func (it *Iterator) isLiveValue(val []byte) bool {
+func isLiveValue(val []byte) bool {
 	var entry IndexEntry
 	json.Unmarshal(val, &entry)
 	return len(entry.History)%2 != 0
@@ -130,14 +188,18 @@ func (it *Iterator) Next() bool {
 			last = it.buffer[len(it.buffer)-1]
 		}
 		it.buffer = make([][]byte, 0, bufferSize)
-		err := it.qs.db.View(func(tx *bolt.Tx) error {
+		err := it.qs.view(func(tx *bolt.Tx) error {
 			i := 0
 			b := tx.Bucket(it.bucket)
 			cur := b.Cursor()
 			if last == nil {
-				k, v := cur.Seek(it.checkID)
-				if bytes.HasPrefix(k, it.checkID) {
-					if it.isLiveValue(v) {
+				start := it.prefix
+				if it.seek != nil {
+					start, it.seek = it.seek, nil
+				}
+				k, v := cur.Seek(start)
+				if bytes.HasPrefix(k, it.prefix) {
+					if isLiveValue(v) {
 						var out []byte
 						out = make([]byte, len(k))
 						copy(out, k)
@@ -156,11 +218,11 @@ func (it *Iterator) Next() bool {
 			}
 			for i < bufferSize {
 				k, v := cur.Next()
-				if k == nil || !bytes.HasPrefix(k, it.checkID) {
+				if k == nil || !bytes.HasPrefix(k, it.prefix) {
 					it.buffer = append(it.buffer, nil)
 					break
 				}
-				if !it.isLiveValue(v) {
+				if !isLiveValue(v) {
 					continue
 				}
 				var out []byte
@@ -189,6 +251,17 @@ func (it *Iterator) Next() bool {
 	return true
 }
 
+// NextBatch fills dst with the following keys. They are read from the bucket
+// in the same batches as for Next.
+func (it *Iterator) NextBatch(dst []graph.Value) int {
+	var n int
+	for n < len(dst) && it.Next() {
+		dst[n] = it.Result()
+		n++
+	}
+	return n
+}
+
 func (it *Iterator) Err() error {
 	return it.err
 }
@@ -275,8 +348,12 @@ func (it *Iterator) Contains(v graph.Value) bool {
 	if bytes.Equal(val.bucket, nodeBucket) {
 		return false
 	}
-	offset := PositionOf(val, it.dir, it.qs)
-	if len(val.key) != 0 && bytes.HasPrefix(val.key[offset:], it.checkID) {
+	matches := len(val.key) != 0
+	for _, l := range it.links {
+		offset := PositionOf(val, l.Dir, it.qs)
+		matches = matches && bytes.HasPrefix(val.key[offset:], l.Value.(*Token).key)
+	}
+	if matches {
 		// You may ask, why don't we check to see if it's a valid (not deleted) quad
 		// again?
 		//
@@ -292,7 +369,8 @@ func (it *Iterator) Contains(v graph.Value) bool {
 }
 
 func (it *Iterator) Size() (int64, bool) {
-	return it.size, true
+	// Further linkages can only make it smaller.
+	return it.size, len(it.links) <= 1
 }
 
 func (it *Iterator) Describe() graph.Description {
@@ -322,4 +400,6 @@ func (it *Iterator) Stats() graph.IteratorStats {
 	}
 }
 
-var _ graph.Nexter = &Iterator{}
+var _ graph.BatchNexter = &Iterator{}
+var _ graph.LinkageIterator = &Iterator{}
+var _ graph.Seeker = &Iterator{}
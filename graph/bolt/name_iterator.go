@@ -0,0 +1,245 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bolt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/barakmich/glog"
+	"github.com/boltdb/bolt"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+)
+
+var namesType graph.Type
+
+func init() {
+	namesType = graph.RegisterIterator("bolt-names")
+}
+
+// NameIterator iterates over the nodes with names starting with a prefix, in
+// order of their names, by scanning the range of the name index they are in.
+type NameIterator struct {
+	uid    uint64
+	tags   graph.Tagger
+	prefix string
+	key    []byte // the prefix, cut to fit the index
+	qs     *QuadStore
+	result *Token
+	err    error
+	buffer [][]byte
+	offset int
+	last   []byte
+	done   bool
+	size   int64
+}
+
+func NewNameIterator(prefix string, qs *QuadStore) *NameIterator {
+	key := []byte(prefix)
+	if len(key) > maxNameSize {
+		key = key[:maxNameSize]
+	}
+	return &NameIterator{
+		uid:    iterator.NextUID(),
+		prefix: prefix,
+		key:    key,
+		qs:     qs,
+		size:   -1,
+	}
+}
+
+func (it *NameIterator) UID() uint64 {
+	return it.uid
+}
+
+func (it *NameIterator) Reset() {
+	it.result = nil
+	it.buffer = nil
+	it.offset = 0
+	it.last = nil
+	it.done = false
+}
+
+func (it *NameIterator) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *NameIterator) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+}
+
+func (it *NameIterator) Clone() graph.Iterator {
+	out := NewNameIterator(it.prefix, it.qs)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+// inRange returns whether k is the key of a name starting with the prefix, as
+// far as the index holds it.
+func (it *NameIterator) inRange(k []byte) bool {
+	return k != nil && bytes.HasPrefix(k[:len(k)-hashSize], it.key)
+}
+
+// fill reads the value keys of up to bufferSize more names.
+func (it *NameIterator) fill() error {
+	it.buffer = make([][]byte, 0, bufferSize)
+	it.offset = 0
+	return it.qs.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(nameBucket)
+		if b == nil {
+			return errNoBucket
+		}
+		cur := b.Cursor()
+		var k, v []byte
+		if it.last == nil {
+			k, v = cur.Seek(it.key)
+		} else {
+			k, v = cur.Seek(it.last)
+			if bytes.Equal(k, it.last) {
+				k, v = cur.Next()
+			}
+		}
+		for ; it.inRange(k); k, v = cur.Next() {
+			if len(it.buffer) == bufferSize {
+				return nil
+			}
+			it.buffer = append(it.buffer, append([]byte(nil), v...))
+			it.last = append(it.last[:0], k...)
+		}
+		it.done = true
+		return nil
+	})
+}
+
+func (it *NameIterator) Next() bool {
+	for {
+		for it.offset >= len(it.buffer) {
+			if it.done {
+				it.result = nil
+				return false
+			}
+			err := it.fill()
+			if err != nil {
+				glog.Error("Error nexting in database: ", err)
+				it.err = err
+				it.done = true
+				it.result = nil
+				return false
+			}
+		}
+		it.result = &Token{bucket: nodeBucket, key: it.buffer[it.offset]}
+		it.offset++
+		// Names longer than the index holds are checked in full.
+		if len(it.key) == len(it.prefix) || strings.HasPrefix(it.qs.NameOf(it.result), it.prefix) {
+			return true
+		}
+	}
+}
+
+func (it *NameIterator) Err() error {
+	return it.err
+}
+
+func (it *NameIterator) Result() graph.Value {
+	if it.result == nil {
+		return nil
+	}
+	return it.result
+}
+
+func (it *NameIterator) NextPath() bool {
+	return false
+}
+
+// No subiterators.
+func (it *NameIterator) SubIterators() []graph.Iterator {
+	return nil
+}
+
+func (it *NameIterator) Contains(v graph.Value) bool {
+	if !strings.HasPrefix(it.qs.NameOf(v), it.prefix) {
+		return false
+	}
+	it.result = v.(*Token)
+	return true
+}
+
+func (it *NameIterator) Close() error {
+	it.result = nil
+	it.buffer = nil
+	it.done = true
+	return nil
+}
+
+// Size counts the names in the range on the first call.
+func (it *NameIterator) Size() (int64, bool) {
+	if it.size < 0 {
+		var n int64
+		err := it.qs.view(func(tx *bolt.Tx) error {
+			b := tx.Bucket(nameBucket)
+			if b == nil {
+				return errNoBucket
+			}
+			cur := b.Cursor()
+			for k, _ := cur.Seek(it.key); it.inRange(k); k, _ = cur.Next() {
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return it.qs.size, false
+		}
+		it.size = n
+	}
+	return it.size, len(it.key) == len(it.prefix)
+}
+
+func (it *NameIterator) Describe() graph.Description {
+	size, _ := it.Size()
+	return graph.Description{
+		UID:  it.UID(),
+		Name: fmt.Sprintf("%q", it.prefix),
+		Type: it.Type(),
+		Tags: it.tags.Tags(),
+		Size: size,
+	}
+}
+
+func (it *NameIterator) Type() graph.Type { return namesType }
+func (it *NameIterator) Sorted() bool     { return false }
+
+func (it *NameIterator) Optimize() (graph.Iterator, bool) {
+	return it, false
+}
+
+func (it *NameIterator) Stats() graph.IteratorStats {
+	s, _ := it.Size()
+	return graph.IteratorStats{
+		ContainsCost: 2,
+		NextCost:     1,
+		Size:         s,
+	}
+}
+
+var _ graph.Nexter = &NameIterator{}
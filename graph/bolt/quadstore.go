@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
+	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -29,15 +30,18 @@ import (
 
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/graph/search"
 	"github.com/google/cayley/quad"
 )
 
 func init() {
 	graph.RegisterQuadStore("bolt", true, newQuadStore, createNewBolt, nil)
+	gob.Register(&Token{})
 }
 
 var (
 	errNoBucket = errors.New("bolt: bucket is missing")
+	errReadOnly = errors.New("bolt: snapshot is read-only")
 )
 
 var (
@@ -50,6 +54,10 @@ var (
 
 const (
 	QuadStoreType = "bolt"
+
+	// maxNameSize is the most of a name kept in a key of the name index, well
+	// within the largest key bolt allows.
+	maxNameSize = 512
 )
 
 type Token struct {
@@ -61,15 +69,42 @@ func (t *Token) Key() interface{} {
 	return fmt.Sprint(t.bucket, t.key)
 }
 
+// GobEncode encodes the token, so that iterators can spill it to disk.
+func (t *Token) GobEncode() ([]byte, error) {
+	b := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(t.bucket)+len(t.key))
+	b = b[:binary.PutUvarint(b, uint64(len(t.bucket)))]
+	b = append(b, t.bucket...)
+	return append(b, t.key...), nil
+}
+
+func (t *Token) GobDecode(b []byte) error {
+	n, i := binary.Uvarint(b)
+	if i <= 0 || uint64(len(b)-i) < n {
+		return errors.New("bolt: malformed token")
+	}
+	b = b[i:]
+	t.bucket = append([]byte(nil), b[:n]...)
+	t.key = append([]byte(nil), b[n:]...)
+	return nil
+}
+
 type QuadStore struct {
 	db      *bolt.DB
+	tx      *bolt.Tx
 	path    string
 	open    bool
 	size    int64
 	horizon int64
+	names   bool // whether the name index exists
+	search  bool // whether the search index exists
+	stats   bool // whether predicate statistics are maintained
 }
 
-func createNewBolt(path string, _ graph.Options) error {
+func createNewBolt(path string, options graph.Options) error {
+	indexSearch, _, err := options.BoolKey("search")
+	if err != nil {
+		return err
+	}
 	db, err := bolt.Open(path, 0600, nil)
 	if err != nil {
 		glog.Errorf("Error: couldn't create Bolt database: %v", err)
@@ -79,6 +114,12 @@ func createNewBolt(path string, _ graph.Options) error {
 	qs := &QuadStore{}
 	qs.db = db
 	err = qs.createBuckets()
+	if err == nil && indexSearch {
+		err = qs.db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucket(searchBucket)
+			return err
+		})
+	}
 	if err != nil {
 		return err
 	}
@@ -100,12 +141,23 @@ func newQuadStore(path string, options graph.Options) (graph.QuadStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	indexSearch, _, err := options.BoolKey("search")
+	if err != nil {
+		return nil, err
+	}
 	err = qs.getMetadata()
 	if err == errNoBucket {
 		return nil, errors.New("bolt: quadstore has not been initialised")
 	} else if err != nil {
+		db.Close()
 		return nil, err
 	}
+	if indexSearch && !qs.search {
+		err = qs.buildSearchIndex()
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &qs, nil
 }
 
@@ -126,6 +178,14 @@ func (qs *QuadStore) createBuckets() error {
 		if err != nil {
 			return fmt.Errorf("could not create bucket: %s", err)
 		}
+		_, err = tx.CreateBucket(nameBucket)
+		if err != nil {
+			return fmt.Errorf("could not create bucket: %s", err)
+		}
+		_, err = tx.CreateBucket(statsBucket)
+		if err != nil {
+			return fmt.Errorf("could not create bucket: %s", err)
+		}
 		_, err = tx.CreateBucket(metaBucket)
 		if err != nil {
 			return fmt.Errorf("could not create bucket: %s", err)
@@ -175,6 +235,49 @@ func (qs *QuadStore) createValueKeyFor(s string) []byte {
 	return key
 }
 
+// createNameKeyFor returns the key of s in the name index, which orders the
+// nodes by name. Names are cut to maxNameSize bytes to fit in a key, and
+// followed by their value key to keep the keys unique.
+func (qs *QuadStore) createNameKeyFor(s string) []byte {
+	name := s
+	if len(name) > maxNameSize {
+		name = name[:maxNameSize]
+	}
+	key := make([]byte, 0, len(name)+hashSize)
+	key = append(key, name...)
+	key = append(key, hashOf(s)...)
+	return key
+}
+
+// createFanoutKeyFor returns the key in the stats bucket of the number of
+// quads with the predicate and the node of k.
+func (qs *QuadStore) createFanoutKeyFor(k graph.FanoutKey) []byte {
+	key := make([]byte, 0, 2+hashSize*2)
+	key = append(key, 'f', k.Dir.Prefix())
+	key = append(key, hashOf(k.Predicate)...)
+	key = append(key, hashOf(k.Node)...)
+	return key
+}
+
+// createStatsKeyFor returns the key in the stats bucket of the statistics of
+// the predicate whose name has the hash h.
+func createStatsKeyFor(h []byte) []byte {
+	key := make([]byte, 0, 1+hashSize)
+	key = append(key, 'r')
+	key = append(key, h...)
+	return key
+}
+
+// createSearchKeyFor returns the key of the node named s in the postings of
+// term in the search index.
+func (qs *QuadStore) createSearchKeyFor(term, s string) []byte {
+	key := make([]byte, 0, len(term)+1+hashSize)
+	key = append(key, term...)
+	key = append(key, 0)
+	key = append(key, hashOf(s)...)
+	return key
+}
+
 type IndexEntry struct {
 	History []int64
 }
@@ -187,23 +290,49 @@ var (
 	cps = [4]quad.Direction{quad.Label, quad.Predicate, quad.Subject, quad.Object}
 
 	// Byte arrays for each bucket name.
-	spoBucket  = bucketFor(spo)
-	ospBucket  = bucketFor(osp)
-	posBucket  = bucketFor(pos)
-	cpsBucket  = bucketFor(cps)
-	logBucket  = []byte("log")
-	nodeBucket = []byte("node")
-	metaBucket = []byte("meta")
+	spoBucket    = bucketFor(spo)
+	ospBucket    = bucketFor(osp)
+	posBucket    = bucketFor(pos)
+	cpsBucket    = bucketFor(cps)
+	logBucket    = []byte("log")
+	nodeBucket   = []byte("node")
+	nameBucket   = []byte("name")
+	searchBucket = []byte("search")
+	statsBucket  = []byte("stats")
+	metaBucket   = []byte("meta")
 )
 
+// bulkKey marks a bulk load in progress in the meta bucket.
+var bulkKey = []byte("bulk")
+
 func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, ignoreOpts graph.IgnoreOpts) error {
+	return qs.ApplyDeltasIf(deltas, nil, ignoreOpts)
+}
+
+// ApplyDeltasIf applies deltas if all of conds hold, checking them under the
+// same transaction as the write.
+func (qs *QuadStore) ApplyDeltasIf(deltas []graph.Delta, conds []graph.Precondition, ignoreOpts graph.IgnoreOpts) error {
+	if qs.tx != nil {
+		return errReadOnly
+	}
 	oldSize := qs.size
 	oldHorizon := qs.horizon
 	err := qs.db.Update(func(tx *bolt.Tx) error {
+		err := graph.CheckPreconditions(conds, graph.NewSequentialKey(qs.horizon), func(q quad.Quad) (bool, error) {
+			return qs.quadExists(tx, q)
+		})
+		if err != nil {
+			return err
+		}
 		b := tx.Bucket(logBucket)
 		b.FillPercent = localFillPercent
 		resizeMap := make(map[string]int64)
 		sizeChange := int64(0)
+		stats := graph.NewPredicateStatsUpdate(func(k graph.FanoutKey) (int64, error) {
+			return getFanout(tx, qs.createFanoutKeyFor(k)), nil
+		}, func(p string) (graph.PredicateStats, error) {
+			return getPredicateStats(tx, createStatsKeyFor(hashOf(p)))
+		})
 		for _, d := range deltas {
 			if d.Action != graph.Add && d.Action != graph.Delete {
 				return errors.New("bolt: invalid action")
@@ -240,6 +369,12 @@ func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, ignoreOpts graph.IgnoreOp
 			}
 			sizeChange += delta
 			qs.horizon = d.ID.Int()
+			if qs.stats {
+				err = stats.Add(d.Quad, delta)
+				if err != nil {
+					return err
+				}
+			}
 		}
 		for k, v := range resizeMap {
 			if v != 0 {
@@ -249,6 +384,10 @@ func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, ignoreOpts graph.IgnoreOp
 				}
 			}
 		}
+		err = qs.writeStats(tx, stats)
+		if err != nil {
+			return err
+		}
 		qs.size += sizeChange
 		return qs.WriteHorizonAndSize(tx)
 	})
@@ -262,6 +401,34 @@ func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, ignoreOpts graph.IgnoreOp
 	return nil
 }
 
+// Deltas calls fn with each delta in the QuadStore's log, in order.
+func (qs *QuadStore) Deltas(fn func(graph.Delta) error) error {
+	return qs.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(logBucket).ForEach(func(_, v []byte) error {
+			var d graph.Delta
+			err := json.Unmarshal(v, &d)
+			if err != nil {
+				return err
+			}
+			return fn(d)
+		})
+	})
+}
+
+// quadExists returns whether q is in the store as seen by tx.
+func (qs *QuadStore) quadExists(tx *bolt.Tx, q quad.Quad) (bool, error) {
+	data := tx.Bucket(spoBucket).Get(qs.createKeyFor(spo, q))
+	if data == nil {
+		return false, nil
+	}
+	var entry IndexEntry
+	err := json.Unmarshal(data, &entry)
+	if err != nil {
+		return false, err
+	}
+	return len(entry.History)%2 == 1, nil
+}
+
 func (qs *QuadStore) buildQuadWrite(tx *bolt.Tx, q quad.Quad, id int64, isAdd bool) error {
 	var entry IndexEntry
 	b := tx.Bucket(spoBucket)
@@ -316,6 +483,7 @@ func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, tx *bolt.Tx) er
 	b.FillPercent = localFillPercent
 	key := qs.createValueKeyFor(name)
 	data := b.Get(key)
+	var old int64
 
 	if data != nil {
 		// Node exists in the database -- unmarshal and update.
@@ -324,6 +492,7 @@ func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, tx *bolt.Tx) er
 			glog.Errorf("Error: couldn't reconstruct value: %v", err)
 			return err
 		}
+		old = value.Size
 		value.Size += amount
 	}
 
@@ -339,9 +508,104 @@ func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, tx *bolt.Tx) er
 		return err
 	}
 	err = b.Put(key, bytes)
+	if err != nil {
+		return err
+	}
+
+	// A node coming into or falling out of use is added to or removed from
+	// the search index.
+	if (old == 0) != (value.Size == 0) {
+		err = qs.writeTerms(tx, name, value.Size != 0)
+	}
+	if err != nil || data != nil {
+		return err
+	}
+
+	// A new node is also added to the name index.
+	if nb := tx.Bucket(nameBucket); nb != nil {
+		nb.FillPercent = localFillPercent
+		err = nb.Put(qs.createNameKeyFor(name), key)
+	}
 	return err
 }
 
+// writeTerms adds the postings of the terms of the name of a node to the
+// search index, if there is one, or deletes them.
+func (qs *QuadStore) writeTerms(tx *bolt.Tx, name string, add bool) error {
+	b := tx.Bucket(searchBucket)
+	if b == nil {
+		return nil
+	}
+	b.FillPercent = localFillPercent
+	for term, n := range search.Terms(name) {
+		key := qs.createSearchKeyFor(term, name)
+		var err error
+		if add {
+			err = b.Put(key, postingValue(n))
+		} else {
+			err = b.Delete(key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postingValue returns the value of a posting of a term occurring n times.
+func postingValue(n int) []byte {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, uint32(n))
+	return v
+}
+
+// buildSearchIndex adds the nodes in use to a new search index.
+func (qs *QuadStore) buildSearchIndex() error {
+	glog.Infoln("bolt: building search index")
+	err := qs.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket(searchBucket)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(nodeBucket).ForEach(func(_, v []byte) error {
+			var value ValueData
+			err := json.Unmarshal(v, &value)
+			if err != nil || value.Size == 0 {
+				return err
+			}
+			return qs.writeTerms(tx, value.Name, true)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	qs.search = true
+	return nil
+}
+
+// Postings calls fn with the nodes with the term, or with terms starting with
+// it, in the search index.
+func (qs *QuadStore) Postings(term string, prefix bool, fn func(graph.Value, int)) error {
+	start := []byte(term)
+	if !prefix {
+		start = append(start, 0)
+	}
+	return qs.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(searchBucket)
+		if b == nil {
+			return search.ErrNoIndex
+		}
+		cur := b.Cursor()
+		for k, v := cur.Seek(start); k != nil && bytes.HasPrefix(k, start); k, v = cur.Next() {
+			fn(&Token{
+				bucket: nodeBucket,
+				key:    append([]byte(nil), k[len(k)-hashSize:]...),
+			}, int(binary.BigEndian.Uint32(v)))
+		}
+		return nil
+	})
+}
+
 func (qs *QuadStore) WriteHorizonAndSize(tx *bolt.Tx) error {
 	buf := new(bytes.Buffer)
 	err := binary.Write(buf, binary.LittleEndian, qs.size)
@@ -356,7 +620,8 @@ func (qs *QuadStore) WriteHorizonAndSize(tx *bolt.Tx) error {
 		glog.Error("Couldn't write size!")
 		return werr
 	}
-	buf.Reset()
+	// Bolt holds on to the value until the transaction commits.
+	buf = new(bytes.Buffer)
 	err = binary.Write(buf, binary.LittleEndian, qs.horizon)
 
 	if err != nil {
@@ -372,7 +637,110 @@ func (qs *QuadStore) WriteHorizonAndSize(tx *bolt.Tx) error {
 	return err
 }
 
+// view runs fn within the snapshot transaction if qs is a snapshot, or
+// within a new read-only transaction otherwise.
+func (qs *QuadStore) view(fn func(*bolt.Tx) error) error {
+	if qs.tx != nil {
+		return fn(qs.tx)
+	}
+	return qs.db.View(fn)
+}
+
+// Snapshot returns a read-only view of the QuadStore as it is at the time of
+// the call. The snapshot holds a read transaction open until it is closed.
+func (qs *QuadStore) Snapshot() (graph.QuadStore, error) {
+	tx, err := qs.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	out := *qs
+	out.tx = tx
+	return &out, nil
+}
+
+// PredicateStats returns the statistics of the quads with the predicate p.
+func (qs *QuadStore) PredicateStats(p graph.Value) (graph.PredicateStats, bool) {
+	if !qs.stats {
+		return graph.PredicateStats{}, false
+	}
+	var stats graph.PredicateStats
+	err := qs.view(func(tx *bolt.Tx) error {
+		var err error
+		stats, err = getPredicateStats(tx, createStatsKeyFor(p.(*Token).key))
+		return err
+	})
+	if err != nil {
+		glog.Errorf("Error reading predicate statistics: %v", err)
+		return graph.PredicateStats{}, false
+	}
+	return stats, true
+}
+
+func getPredicateStats(tx *bolt.Tx, key []byte) (graph.PredicateStats, error) {
+	var stats graph.PredicateStats
+	data := tx.Bucket(statsBucket).Get(key)
+	if data == nil {
+		return stats, nil
+	}
+	err := json.Unmarshal(data, &stats)
+	return stats, err
+}
+
+func getFanout(tx *bolt.Tx, key []byte) int64 {
+	data := tx.Bucket(statsBucket).Get(key)
+	if data == nil {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(data))
+}
+
+// writeStats writes the statistics and fanout counts changed by u.
+func (qs *QuadStore) writeStats(tx *bolt.Tx, u *graph.PredicateStatsUpdate) error {
+	b := tx.Bucket(statsBucket)
+	b.FillPercent = localFillPercent
+	for k, n := range u.Counts {
+		err := putCount(b, qs.createFanoutKeyFor(k), n)
+		if err != nil {
+			return err
+		}
+	}
+	for p, stats := range u.Stats {
+		err := putStats(b, createStatsKeyFor(hashOf(p)), stats)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putCount writes the fanout count n to key, deleting it if it is zero.
+func putCount(b *bolt.Bucket, key []byte, n int64) error {
+	if n == 0 {
+		return b.Delete(key)
+	}
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(n))
+	return b.Put(key, data)
+}
+
+// putStats writes the statistics of a predicate to key, deleting them if it
+// has no quads.
+func putStats(b *bolt.Bucket, key []byte, stats *graph.PredicateStats) error {
+	if stats.Quads == 0 {
+		return b.Delete(key)
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
 func (qs *QuadStore) Close() {
+	if qs.tx != nil {
+		qs.tx.Rollback()
+		return
+	}
 	qs.db.Update(func(tx *bolt.Tx) error {
 		return qs.WriteHorizonAndSize(tx)
 	})
@@ -383,7 +751,7 @@ func (qs *QuadStore) Close() {
 func (qs *QuadStore) Quad(k graph.Value) quad.Quad {
 	var d graph.Delta
 	tok := k.(*Token)
-	err := qs.db.View(func(tx *bolt.Tx) error {
+	err := qs.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(tok.bucket)
 		data := b.Get(tok.key)
 		if data == nil {
@@ -424,7 +792,7 @@ func (qs *QuadStore) valueData(t *Token) ValueData {
 	if glog.V(3) {
 		glog.V(3).Infof("%s %v", string(t.bucket), t.key)
 	}
-	err := qs.db.View(func(tx *bolt.Tx) error {
+	err := qs.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(t.bucket)
 		data := b.Get(t.key)
 		if data != nil {
@@ -473,13 +841,21 @@ func (qs *QuadStore) getInt64ForKey(tx *bolt.Tx, key string, empty int64) (int64
 }
 
 func (qs *QuadStore) getMetadata() error {
-	err := qs.db.View(func(tx *bolt.Tx) error {
+	err := qs.view(func(tx *bolt.Tx) error {
 		var err error
 		qs.size, err = qs.getInt64ForKey(tx, "size", 0)
 		if err != nil {
 			return err
 		}
 		qs.horizon, err = qs.getInt64ForKey(tx, "horizon", 0)
+		if err == nil && tx.Bucket(metaBucket).Get(bulkKey) != nil {
+			return graph.ErrIncompleteBulkLoad
+		}
+		// Databases created before the name index or the predicate
+		// statistics have no bucket for them.
+		qs.names = tx.Bucket(nameBucket) != nil
+		qs.stats = tx.Bucket(statsBucket) != nil
+		qs.search = tx.Bucket(searchBucket) != nil
 		return err
 	})
 	return err
@@ -502,6 +878,49 @@ func (qs *QuadStore) QuadIterator(d quad.Direction, val graph.Value) graph.Itera
 	return NewIterator(bucket, d, val, qs)
 }
 
+// indexOrder returns the order of the directions in the keys of the index
+// bucket.
+func indexOrder(bucket []byte) [4]quad.Direction {
+	for _, order := range [][4]quad.Direction{spo, osp, pos, cps} {
+		if bytes.Equal(bucket, bucketFor(order)) {
+			return order
+		}
+	}
+	panic("unreachable " + string(bucket))
+}
+
+// SortedQuadIterator returns the quads with all of links ordered by their node
+// in direction d. An index can be read if its keys start with the linked
+// directions followed by d; the quads are otherwise sorted in memory.
+func (qs *QuadStore) SortedQuadIterator(links []graph.Linkage, d quad.Direction) (graph.Seeker, bool) {
+	linked := make(map[quad.Direction]bool, len(links))
+	for _, l := range links {
+		linked[l.Dir] = true
+	}
+	for _, order := range [][4]quad.Direction{spo, osp, pos, cps} {
+		if len(links) >= len(order) || order[len(links)] != d {
+			continue
+		}
+		// Quads without a label are missing from the cps index.
+		if order == cps && !linked[quad.Label] {
+			continue
+		}
+		ok := true
+		for _, od := range order[:len(links)] {
+			ok = ok && linked[od]
+		}
+		if ok && len(linked) == len(links) {
+			return newIterator(bucketFor(order), links, qs), true
+		}
+	}
+	return iterator.NewSorted(qs, qs.CompareValues, links, d), false
+}
+
+// CompareValues orders nodes by the hashes of their names, as the indexes do.
+func (qs *QuadStore) CompareValues(a, b graph.Value) int {
+	return bytes.Compare(a.(*Token).key, b.(*Token).key)
+}
+
 func (qs *QuadStore) NodesAllIterator() graph.Iterator {
 	return NewAllIterator(nodeBucket, quad.Any, qs)
 }
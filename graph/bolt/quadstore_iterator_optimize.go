@@ -15,6 +15,8 @@
 package bolt
 
 import (
+	"bytes"
+
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
 )
@@ -23,6 +25,8 @@ func (qs *QuadStore) OptimizeIterator(it graph.Iterator) (graph.Iterator, bool)
 	switch it.Type() {
 	case graph.LinksTo:
 		return qs.optimizeLinksTo(it.(*iterator.LinksTo))
+	case graph.Regex:
+		return qs.optimizeRegex(it.(*iterator.Regex))
 
 	}
 	return it, false
@@ -53,3 +57,26 @@ func (qs *QuadStore) optimizeLinksTo(it *iterator.LinksTo) (graph.Iterator, bool
 	}
 	return it, false
 }
+
+// optimizeRegex replaces a Regex over all nodes with a scan of the names
+// starting with its prefix, which are still matched against the Regex if it
+// asks for more than the prefix.
+func (qs *QuadStore) optimizeRegex(it *iterator.Regex) (graph.Iterator, bool) {
+	prefix, complete := it.Prefix()
+	all, ok := it.SubIterators()[0].(*AllIterator)
+	if !ok || !qs.names || prefix == "" {
+		return it, false
+	}
+	if !bytes.Equal(all.bucket, nodeBucket) || all.start != nil || all.end != nil {
+		return it, false
+	}
+	names := NewNameIterator(prefix, qs)
+	names.Tagger().CopyFrom(all)
+	if !complete {
+		out := iterator.NewRegex(names, it.Regexp(), qs)
+		out.Tagger().CopyFrom(it)
+		return out, true
+	}
+	names.Tagger().CopyFrom(it)
+	return names, true
+}
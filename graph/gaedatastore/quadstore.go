@@ -18,6 +18,7 @@ package gaedatastore
 
 import (
 	"crypto/sha1"
+	"encoding/gob"
 	"encoding/hex"
 	"errors"
 	"hash"
@@ -63,6 +64,12 @@ type Token struct {
 	Hash string
 }
 
+// Key identifies equal tokens, so that iterators can deduplicate and spill
+// them.
+func (t *Token) Key() interface{} {
+	return t.Kind + "/" + t.Hash
+}
+
 type QuadEntry struct {
 	Hash      string
 	Added     []string `datastore:",noindex"`
@@ -87,6 +94,7 @@ type LogEntry struct {
 
 func init() {
 	graph.RegisterQuadStore("gaedatastore", true, newQuadStore, initQuadStore, newQuadStoreForRequest)
+	gob.Register(&Token{})
 }
 
 func initQuadStore(_ string, _ graph.Options) error {
@@ -191,6 +191,50 @@ func Next(it Iterator) bool {
 	return false
 }
 
+// BatchNexter is implemented by iterators that can advance over several values
+// at once, saving the cost of a call to Next for each of them.
+type BatchNexter interface {
+	// NextBatch advances the iterator over up to len(dst) values, storing them in
+	// dst and returning how many it stored. It returns 0 if no further advancement
+	// is possible, or if an error was encountered during iteration. Err should be
+	// consulted to distinguish between the two cases.
+	//
+	// Only the values are batched: Result, TagResults and NextPath are only
+	// meaningful after a call to Next.
+	NextBatch(dst []Value) int
+
+	Nexter
+}
+
+// NextBatch is a convenience function that calls the NextBatch method of an
+// Iterator if it is a BatchNexter, and fills dst by calling Next otherwise.
+func NextBatch(it Iterator, dst []Value) int {
+	if b, ok := it.(BatchNexter); ok {
+		return b.NextBatch(dst)
+	}
+	n, ok := it.(Nexter)
+	if !ok {
+		glog.Errorln("Nexting an un-nextable iterator")
+		return 0
+	}
+	var i int
+	for i < len(dst) && n.Next() {
+		dst[i] = n.Result()
+		i++
+	}
+	return i
+}
+
+// Partitioner is implemented by iterators whose values can be split into parts,
+// to be iterated over concurrently.
+type Partitioner interface {
+	// Partition returns up to n iterators that hold the values of the iterator
+	// between them, in the same order when taken one after the other.
+	Partition(n int) []Iterator
+
+	Iterator
+}
+
 // Height is a convienence function to measure the height of an iterator tree.
 func Height(it Iterator, until Type) int {
 	if it.Type() == until {
@@ -240,6 +284,9 @@ const (
 	Optional
 	Materialize
 	Unique
+	Sorted
+	Leapfrog
+	Regex
 )
 
 var (
@@ -262,6 +309,9 @@ var (
 		"optional",
 		"materialize",
 		"unique",
+		"sorted",
+		"leapfrog",
+		"regex",
 	}
 )
 
@@ -105,6 +105,21 @@ func (it *Int64) Next() bool {
 	return graph.NextLogOut(it, val, true)
 }
 
+// NextBatch fills dst with the following integers.
+func (it *Int64) NextBatch(dst []graph.Value) int {
+	var n int
+	for n < len(dst) && it.at != -1 {
+		dst[n] = it.at
+		n++
+		it.at++
+		if it.at > it.max {
+			it.at = -1
+		}
+	}
+	it.runstats.Next += int64(n)
+	return n
+}
+
 func (it *Int64) Err() error {
 	return nil
 }
@@ -142,6 +157,26 @@ func (it *Int64) Contains(tsv graph.Value) bool {
 	return graph.ContainsLogOut(it, v, false)
 }
 
+// Partition splits the range into up to n ranges of about the same size.
+func (it *Int64) Partition(n int) []graph.Iterator {
+	size, _ := it.Size()
+	if int64(n) > size {
+		n = int(size)
+	}
+	if n < 1 {
+		return []graph.Iterator{it.Clone()}
+	}
+	parts := make([]graph.Iterator, n)
+	for i := range parts {
+		min := it.min + size*int64(i)/int64(n)
+		max := it.min + size*int64(i+1)/int64(n) - 1
+		out := NewInt64(min, max)
+		out.tags.CopyFrom(it)
+		parts[i] = out
+	}
+	return parts
+}
+
 // The type of this iterator is an "all". This is important, as it puts it in
 // the class of "all iterators.
 func (it *Int64) Type() graph.Type { return graph.All }
@@ -162,4 +197,5 @@ func (it *Int64) Stats() graph.IteratorStats {
 	}
 }
 
-var _ graph.Nexter = &Int64{}
+var _ graph.BatchNexter = &Int64{}
+var _ graph.Partitioner = &Int64{}
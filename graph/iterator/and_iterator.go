@@ -151,6 +151,29 @@ func (it *And) Next() bool {
 	return graph.NextLogOut(it, nil, false)
 }
 
+// NextBatch fills dst with a batch of candidates from the primary iterator,
+// keeping those that the other subiterators contain.
+func (it *And) NextBatch(dst []graph.Value) int {
+	for {
+		n := graph.NextBatch(it.primaryIt, dst)
+		if n == 0 {
+			it.err = it.primaryIt.Err()
+			return 0
+		}
+		var kept int
+		for _, curr := range dst[:n] {
+			if it.subItsContain(curr, nil) {
+				dst[kept] = curr
+				kept++
+			}
+		}
+		if kept > 0 {
+			it.runstats.Next += int64(kept)
+			return kept
+		}
+	}
+}
+
 func (it *And) Err() error {
 	return it.err
 }
@@ -298,4 +321,4 @@ func (it *And) Close() error {
 // Register this as an "and" iterator.
 func (it *And) Type() graph.Type { return graph.And }
 
-var _ graph.Nexter = &And{}
+var _ graph.BatchNexter = &And{}
@@ -56,6 +56,13 @@ func (it *And) Optimize() (graph.Iterator, bool) {
 	// is idempotent, so this just protects against any machinations).
 	closeIteratorList(old, nil)
 
+	// Joins under us are merged into one, as an And can't enumerate the
+	// paths of several of them together.
+	if join, ok := it.optimizeJoin(its); ok {
+		closeIteratorList(its, nil)
+		return join, true
+	}
+
 	// If we can find only one subiterator which is equivalent to this whole and,
 	// we can replace the And...
 	out := it.optimizeReplacement(its)
@@ -326,7 +333,7 @@ func hasOneUsefulIterator(its []graph.Iterator) graph.Iterator {
 func materializeIts(its []graph.Iterator) []graph.Iterator {
 	var out []graph.Iterator
 
-	allStats := getStatsForSlice(its)
+	allStats := getStatsForSlice(its, nil)
 	out = append(out, its[0])
 	for _, it := range its[1:] {
 		stats := it.Stats()
@@ -341,7 +348,9 @@ func materializeIts(its []graph.Iterator) []graph.Iterator {
 	return out
 }
 
-func getStatsForSlice(its []graph.Iterator) graph.IteratorStats {
+// getStatsForSlice returns the combined stats of its when intersected. If qs is
+// not nil, its statistics are used to estimate the size of the intersection.
+func getStatsForSlice(its []graph.Iterator, qs graph.QuadStore) graph.IteratorStats {
 	primary := its[0]
 	primaryStats := primary.Stats()
 	ContainsCost := primaryStats.ContainsCost
@@ -355,6 +364,9 @@ func getStatsForSlice(its []graph.Iterator) graph.IteratorStats {
 			Size = stats.Size
 		}
 	}
+	if qs != nil {
+		Size = andSize(qs, its)
+	}
 	return graph.IteratorStats{
 		ContainsCost: ContainsCost,
 		NextCost:     NextCost,
@@ -367,8 +379,33 @@ func getStatsForSlice(its []graph.Iterator) graph.IteratorStats {
 // in the future return different statistics based on how it is optimized.
 // For now, however, it's pretty static.
 func (it *And) Stats() graph.IteratorStats {
-	stats := getStatsForSlice(it.SubIterators())
+	stats := getStatsForSlice(it.SubIterators(), it.qs)
 	stats.Next = it.runstats.Next
 	stats.Contains = it.runstats.Contains
 	return stats
 }
+
+// optimizeJoin returns a Leapfrog iterator equivalent to an And of its, if
+// one of them is a Leapfrog already.
+func (it *And) optimizeJoin(its []graph.Iterator) (graph.Iterator, bool) {
+	var found bool
+	for _, sub := range its {
+		if sub.Type() == graph.Leapfrog {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, false
+	}
+	and := NewAnd(it.qs)
+	for _, sub := range its {
+		and.AddSubIterator(sub)
+	}
+	and.tags.CopyFrom(it)
+	join, ok := newJoin(it.qs, and)
+	if !ok {
+		return nil, false
+	}
+	return join, true
+}
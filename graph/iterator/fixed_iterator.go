@@ -145,6 +145,13 @@ func (it *Fixed) Next() bool {
 	return graph.NextLogOut(it, out, true)
 }
 
+// NextBatch fills dst with the following values.
+func (it *Fixed) NextBatch(dst []graph.Value) int {
+	n := copy(dst, it.values[it.lastIndex:])
+	it.lastIndex += n
+	return n
+}
+
 func (it *Fixed) Err() error {
 	return nil
 }
@@ -188,4 +195,4 @@ func (it *Fixed) Stats() graph.IteratorStats {
 	}
 }
 
-var _ graph.Nexter = &Fixed{}
+var _ graph.BatchNexter = &Fixed{}
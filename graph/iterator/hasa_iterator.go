@@ -105,9 +105,60 @@ func (it *HasA) Optimize() (graph.Iterator, bool) {
 			return it.primaryIt, true
 		}
 	}
+	if fixed, ok := it.resolve(); ok {
+		it.Close()
+		return fixed, true
+	}
+	// Ask the graph.QuadStore if it can evaluate us itself.
+	if replacement, ok := it.qs.OptimizeIterator(it); ok {
+		it.Close()
+		return replacement, true
+	}
+	// Patterns of several variables are better joined all at once.
+	if join, ok := newJoin(it.qs, it); ok {
+		it.Close()
+		return join, true
+	}
 	return it, false
 }
 
+// maxResolvedSize is the largest number of values of a HasA or LinksTo that
+// optimization resolves into a Fixed iterator.
+const maxResolvedSize = 32
+
+// resolve returns a Fixed iterator of the values of the HasA, if its
+// subiterator is small and untagged, as it then holds nothing else. A Fixed
+// is cheap to check, and to intersect with in an And.
+func (it *HasA) resolve() (graph.Iterator, bool) {
+	size, exact := it.primaryIt.Size()
+	if !exact || size > maxResolvedSize || tagged(it.primaryIt) {
+		return nil, false
+	}
+	fixed := it.qs.FixedIterator()
+	for graph.Next(it.primaryIt) {
+		fixed.Add(it.qs.QuadDirection(it.primaryIt.Result(), it.dir))
+	}
+	if it.primaryIt.Err() != nil {
+		it.primaryIt.Reset()
+		return nil, false
+	}
+	fixed.Tagger().CopyFrom(it)
+	return fixed, true
+}
+
+// tagged returns whether any iterator of the tree rooted at it has tags.
+func tagged(it graph.Iterator) bool {
+	if len(it.Tagger().Tags()) != 0 || len(it.Tagger().Fixed()) != 0 {
+		return true
+	}
+	for _, sub := range it.SubIterators() {
+		if tagged(sub) {
+			return true
+		}
+	}
+	return false
+}
+
 // Pass the TagResults down the chain.
 func (it *HasA) TagResults(dst map[string]graph.Value) {
 	for _, tag := range it.tags.Tags() {
@@ -218,6 +269,25 @@ func (it *HasA) Next() bool {
 	return graph.NextLogOut(it, val, true)
 }
 
+// NextBatch fills dst with the nodes of a batch of quads from the subiterator.
+func (it *HasA) NextBatch(dst []graph.Value) int {
+	if it.resultIt != nil {
+		it.resultIt.Close()
+	}
+	it.resultIt = &Null{}
+
+	n := graph.NextBatch(it.primaryIt, dst)
+	if n == 0 {
+		it.err = it.primaryIt.Err()
+		return 0
+	}
+	for i, tID := range dst[:n] {
+		dst[i] = it.qs.QuadDirection(tID, it.dir)
+	}
+	it.runstats.Next += int64(n)
+	return n
+}
+
 func (it *HasA) Err() error {
 	return it.err
 }
@@ -238,6 +308,10 @@ func (it *HasA) Stats() graph.IteratorStats {
 	// and be optimized.
 	faninFactor := int64(1)
 	fanoutFactor := int64(30)
+	if fanout, ok := it.containsFanout(); ok {
+		// Checking a node means iterating over its quads in our direction.
+		fanoutFactor = fanout
+	}
 	nextConstant := int64(2)
 	quadConstant := int64(1)
 	return graph.IteratorStats{
@@ -272,4 +346,4 @@ func (it *HasA) Size() (int64, bool) {
 	return it.Stats().Size, false
 }
 
-var _ graph.Nexter = &HasA{}
+var _ graph.BatchNexter = &HasA{}
@@ -16,8 +16,10 @@ package iterator
 
 import (
 	"errors"
+	"reflect"
 	"testing"
 
+	"github.com/google/cayley/graph"
 	"github.com/google/cayley/quad"
 )
 
@@ -35,3 +37,41 @@ func TestHasAIteratorErr(t *testing.T) {
 		t.Errorf("HasA iterator did not pass through underlying Err")
 	}
 }
+
+func TestHasAResolve(t *testing.T) {
+	qs := &store{
+		data: []string{},
+		iter: NewFixed(Identity),
+	}
+	quads := NewFixed(Identity)
+	quads.Add(1)
+	quads.Add(2)
+	hasa := NewHasA(qs, quads, quad.Subject)
+	hasa.Tagger().Add("a")
+	newIt, changed := hasa.Optimize()
+	if !changed {
+		t.Error("Iterator didn't optimize")
+	}
+	if newIt.Type() != graph.Fixed {
+		t.Fatal("Expected fixed iterator, got ", newIt.Type())
+	}
+	if tags := newIt.Tagger().Tags(); !reflect.DeepEqual(tags, []string{"a"}) {
+		t.Errorf("Tags don't match: got:%v", tags)
+	}
+	// The mock store gives 0 as every direction of every quad.
+	if got := iterated(newIt); !reflect.DeepEqual(got, []int{0, 0}) {
+		t.Errorf("Unexpected results: got:%v", got)
+	}
+
+	tagged := NewFixed(Identity)
+	tagged.Add(1)
+	tagged.Tagger().Add("q")
+	if newIt, _ := NewHasA(qs, tagged, quad.Subject).Optimize(); newIt.Type() != graph.HasA {
+		t.Error("Expected a tagged subiterator to be kept, got ", newIt.Type())
+	}
+
+	large := NewInt64(1, maxResolvedSize+1)
+	if newIt, _ := NewHasA(qs, large, quad.Subject).Optimize(); newIt.Type() != graph.HasA {
+		t.Error("Expected a large subiterator to be kept, got ", newIt.Type())
+	}
+}
@@ -0,0 +1,613 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// Defines the Leapfrog iterator, a multiway join of quad patterns sharing
+// variables, in the style of the leapfrog triejoin.
+//
+// The variables are bound one at a time, in an order chosen up front. To bind
+// a variable, each pattern it appears in gives a graph.Seeker over its quads,
+// sorted by the node in the variable's direction and constrained by the fixed
+// nodes and the variables bound so far. The Seekers are then intersected by
+// repeatedly seeking the one that is furthest behind to the node of the one
+// that is furthest ahead, so large inputs are skipped through rather than read
+// in full. Cyclic patterns, such as triangles, are where this matters most.
+//
+// A pattern without an index in the order needed is only intersected at the
+// last of its variables to be bound, when it is at its most constrained.
+//
+// Like the nested HasA and LinksTo iterators it replaces, the join returns a
+// binding once for each combination of quads that match it, so quads that
+// differ only in a direction the patterns leave open, such as the label, are
+// not collapsed.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/quad"
+)
+
+// A Term is one direction of a Pattern: a variable if Var is set, a fixed node
+// if Value is set, or unconstrained if neither is.
+type Term struct {
+	Var   string
+	Value graph.Value
+}
+
+// A Pattern constrains the quads matched by a Leapfrog join.
+type Pattern map[quad.Direction]Term
+
+var patternDirs = []quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label}
+
+// dirOf returns the direction of the variable v in the pattern.
+func (p Pattern) dirOf(v string) (quad.Direction, bool) {
+	for _, d := range patternDirs {
+		if p[d].Var == v {
+			return d, true
+		}
+	}
+	return quad.Any, false
+}
+
+type leapfrogLevel struct {
+	seekers []graph.Seeker
+	p       int
+	key     graph.Value
+}
+
+// A Leapfrog iterator joins a set of patterns, returning the values of one of
+// their variables for each way of binding all of them.
+type Leapfrog struct {
+	uid      uint64
+	tags     graph.Tagger
+	qs       graph.QuadStore
+	idx      graph.SortedIndexer
+	patterns []Pattern
+	result   string
+	varTags  map[string][]string
+	vars     []string
+	order    map[string]int
+	last     []int
+	levels   []leapfrogLevel
+	values   map[string]graph.Value
+	started  bool
+	done     bool
+	fixed    int64
+	repeat   int64
+	size     int64
+	err      error
+
+	contains      *Leapfrog
+	containsValue graph.Value
+}
+
+// NewLeapfrog returns a Leapfrog iterator over the values of the variable
+// result in the join of patterns. The QuadStore must be a graph.SortedIndexer.
+func NewLeapfrog(qs graph.QuadStore, patterns []Pattern, result string) *Leapfrog {
+	it := &Leapfrog{
+		uid:      NextUID(),
+		qs:       qs,
+		idx:      qs.(graph.SortedIndexer),
+		patterns: patterns,
+		result:   result,
+		varTags:  make(map[string][]string),
+		values:   make(map[string]graph.Value),
+		size:     -1,
+	}
+	it.vars = joinOrder(patterns)
+	it.order = make(map[string]int, len(it.vars))
+	for i, v := range it.vars {
+		it.order[v] = i
+	}
+	it.last = make([]int, len(patterns))
+	for i, p := range patterns {
+		it.last[i] = -1
+		for _, d := range patternDirs {
+			if v := p[d].Var; v != "" && it.order[v] > it.last[i] {
+				it.last[i] = it.order[v]
+			}
+		}
+	}
+	it.levels = make([]leapfrogLevel, len(it.vars))
+	return it
+}
+
+// joinOrder orders the variables of patterns so that each is bound when it is
+// most constrained by the fixed nodes and the variables before it. Ties are
+// broken by the order the variables first appear in.
+func joinOrder(patterns []Pattern) []string {
+	var all []string
+	seen := make(map[string]bool)
+	for _, p := range patterns {
+		for _, d := range patternDirs {
+			if v := p[d].Var; v != "" && !seen[v] {
+				seen[v] = true
+				all = append(all, v)
+			}
+		}
+	}
+
+	bound := make(map[string]bool)
+	order := make([]string, 0, len(all))
+	for len(order) < len(all) {
+		best, bestScore := "", -1
+		for _, v := range all {
+			if bound[v] {
+				continue
+			}
+			score := 0
+			for _, p := range patterns {
+				if _, ok := p.dirOf(v); !ok {
+					continue
+				}
+				for _, t := range p {
+					if t.Value != nil || bound[t.Var] {
+						score++
+					}
+				}
+			}
+			if score > bestScore {
+				best, bestScore = v, score
+			}
+		}
+		bound[best] = true
+		order = append(order, best)
+	}
+	return order
+}
+
+// TagVariable adds a tag for the values of the variable v.
+func (it *Leapfrog) TagVariable(v, tag string) {
+	it.varTags[v] = append(it.varTags[v], tag)
+}
+
+func (it *Leapfrog) UID() uint64 {
+	return it.uid
+}
+
+func (it *Leapfrog) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Leapfrog) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+
+	values := it.values
+	if it.contains != nil {
+		values = it.contains.values
+	}
+	for v, tags := range it.varTags {
+		val := values[v]
+		if v == it.result {
+			val = it.Result()
+		}
+		for _, tag := range tags {
+			dst[tag] = val
+		}
+	}
+}
+
+func (it *Leapfrog) Clone() graph.Iterator {
+	out := NewLeapfrog(it.qs, it.patterns, it.result)
+	for v, tags := range it.varTags {
+		out.varTags[v] = append([]string(nil), tags...)
+	}
+	out.tags.CopyFrom(it)
+	return out
+}
+
+// links returns the linkages of the pattern given the fixed nodes and the
+// variables bound before level i.
+func (it *Leapfrog) links(p Pattern, i int) []graph.Linkage {
+	var links []graph.Linkage
+	for _, d := range patternDirs {
+		t := p[d]
+		switch {
+		case t.Value != nil:
+			links = append(links, graph.Linkage{Dir: d, Value: t.Value})
+		case t.Var != "" && it.order[t.Var] < i:
+			links = append(links, graph.Linkage{Dir: d, Value: it.values[t.Var]})
+		}
+	}
+	return links
+}
+
+// estimate returns an upper bound on the number of quads with links.
+func (it *Leapfrog) estimate(links []graph.Linkage) int64 {
+	size := it.qs.Size()
+	for _, l := range links {
+		sub := it.qs.QuadIterator(l.Dir, l.Value)
+		if s, _ := sub.Size(); s < size {
+			size = s
+		}
+		sub.Close()
+	}
+	return size
+}
+
+func (it *Leapfrog) key(s graph.Seeker) graph.Value {
+	return it.qs.QuadDirection(s.Result(), s.SortedBy())
+}
+
+// count returns the number of quads matching the pattern once the variables
+// bound before level i are fixed.
+func (it *Leapfrog) count(p Pattern, i int) int64 {
+	d := quad.Subject
+	for _, pd := range patternDirs {
+		if _, ok := p[pd]; !ok {
+			d = pd
+			break
+		}
+	}
+	s, _ := it.idx.SortedQuadIterator(it.links(p, i), d)
+	var n int64
+	for s.Next() {
+		n++
+	}
+	it.setErr(s.Err())
+	s.Close()
+	return n
+}
+
+// countFixed returns the number of combinations of quads matching the
+// patterns without variables.
+func (it *Leapfrog) countFixed() int64 {
+	n := int64(1)
+	for _, p := range it.patterns {
+		var vars bool
+		for _, t := range p {
+			vars = vars || t.Var != ""
+		}
+		if vars {
+			continue
+		}
+		n *= it.count(p, 0)
+		if n == 0 {
+			break
+		}
+	}
+	return n
+}
+
+// matches returns the number of combinations of quads matching the current
+// binding of the variables.
+func (it *Leapfrog) matches() int64 {
+	n := it.fixed
+	for j, p := range it.patterns {
+		if it.last[j] >= 0 {
+			n *= it.count(p, len(it.vars))
+		}
+	}
+	return n
+}
+
+// open starts the intersection of the seekers for the variable at level i.
+func (it *Leapfrog) open(i int) bool {
+	l := &it.levels[i]
+	v := it.vars[i]
+
+	type deferred struct {
+		links []graph.Linkage
+		dir   quad.Direction
+	}
+	var skipped []deferred
+	for j, p := range it.patterns {
+		d, ok := p.dirOf(v)
+		if !ok {
+			continue
+		}
+		links := it.links(p, i)
+		s, indexed := it.idx.SortedQuadIterator(links, d)
+		if !indexed && it.last[j] != i {
+			s.Close()
+			skipped = append(skipped, deferred{links, d})
+			continue
+		}
+		l.seekers = append(l.seekers, s)
+	}
+	if len(l.seekers) == 0 {
+		// Every pattern of the variable is deferred, so the smallest has to
+		// be sorted now.
+		best, bestSize := 0, int64(-1)
+		for j, sk := range skipped {
+			if size := it.estimate(sk.links); bestSize < 0 || size < bestSize {
+				best, bestSize = j, size
+			}
+		}
+		s, _ := it.idx.SortedQuadIterator(skipped[best].links, skipped[best].dir)
+		l.seekers = append(l.seekers, s)
+	}
+
+	for _, s := range l.seekers {
+		if !s.Next() {
+			it.setErr(s.Err())
+			return false
+		}
+	}
+	sort.Sort(bySeekerKey{it, l.seekers})
+	l.p = 0
+	return it.search(i)
+}
+
+type bySeekerKey struct {
+	it      *Leapfrog
+	seekers []graph.Seeker
+}
+
+func (s bySeekerKey) Len() int { return len(s.seekers) }
+func (s bySeekerKey) Less(i, j int) bool {
+	return s.it.idx.CompareValues(s.it.key(s.seekers[i]), s.it.key(s.seekers[j])) < 0
+}
+func (s bySeekerKey) Swap(i, j int) { s.seekers[i], s.seekers[j] = s.seekers[j], s.seekers[i] }
+
+// search leapfrogs the seekers at level i until they agree on a node.
+func (it *Leapfrog) search(i int) bool {
+	l := &it.levels[i]
+	k := len(l.seekers)
+	max := it.key(l.seekers[(l.p+k-1)%k])
+	for {
+		s := l.seekers[l.p]
+		x := it.key(s)
+		if it.idx.CompareValues(x, max) == 0 {
+			l.key = x
+			return true
+		}
+		if !s.Seek(max) {
+			it.setErr(s.Err())
+			return false
+		}
+		max = it.key(s)
+		l.p = (l.p + 1) % k
+	}
+}
+
+// advance moves level i on to its next node.
+func (it *Leapfrog) advance(i int) bool {
+	l := &it.levels[i]
+	s := l.seekers[l.p]
+	for {
+		if !s.Next() {
+			it.setErr(s.Err())
+			return false
+		}
+		if it.idx.CompareValues(it.key(s), l.key) != 0 {
+			break
+		}
+	}
+	l.p = (l.p + 1) % len(l.seekers)
+	return it.search(i)
+}
+
+func (it *Leapfrog) close(i int) {
+	l := &it.levels[i]
+	for _, s := range l.seekers {
+		s.Close()
+	}
+	l.seekers = l.seekers[:0]
+	l.key = nil
+}
+
+func (it *Leapfrog) setErr(err error) {
+	if it.err == nil {
+		it.err = err
+	}
+}
+
+// Next advances the iterator to the next binding of the variables.
+func (it *Leapfrog) Next() bool {
+	graph.NextLogIn(it)
+	it.closeContains()
+	if it.repeat > 0 {
+		it.repeat--
+		return graph.NextLogOut(it, it.Result(), true)
+	}
+	if it.done {
+		return graph.NextLogOut(it, nil, false)
+	}
+
+	n := len(it.vars)
+	i := n - 1
+	var ok bool
+	if !it.started {
+		it.started = true
+		it.fixed = it.countFixed()
+		if it.fixed == 0 {
+			it.done = true
+			return graph.NextLogOut(it, nil, false)
+		}
+		if n == 0 {
+			it.done = true
+			it.repeat = it.fixed - 1
+			return graph.NextLogOut(it, nil, true)
+		}
+		i = 0
+		ok = it.open(0)
+	} else {
+		ok = it.advance(i)
+	}
+	for {
+		if !ok {
+			it.close(i)
+			if i == 0 {
+				it.done = true
+				return graph.NextLogOut(it, nil, false)
+			}
+			i--
+			ok = it.advance(i)
+			continue
+		}
+		it.values[it.vars[i]] = it.levels[i].key
+		if i == n-1 {
+			break
+		}
+		i++
+		ok = it.open(i)
+	}
+	it.repeat = it.matches() - 1
+	return graph.NextLogOut(it, it.Result(), true)
+}
+
+func (it *Leapfrog) Err() error {
+	return it.err
+}
+
+func (it *Leapfrog) Result() graph.Value {
+	if it.contains != nil {
+		return it.containsValue
+	}
+	if it.result == "" {
+		return nil
+	}
+	return it.values[it.result]
+}
+
+// NextPath finds the other bindings of the variables, and the other quads
+// matching them, that gave the value most recently found by Contains. Those
+// found by Next are all returned by Next.
+func (it *Leapfrog) NextPath() bool {
+	if it.contains == nil {
+		return false
+	}
+	return it.contains.Next()
+}
+
+// Contains checks whether the value can be bound to the result variable, by
+// joining the patterns with it fixed.
+func (it *Leapfrog) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
+	it.closeContains()
+	if it.result == "" {
+		return graph.ContainsLogOut(it, v, false)
+	}
+	patterns := make([]Pattern, len(it.patterns))
+	for i, p := range it.patterns {
+		patterns[i] = make(Pattern, len(p))
+		for d, t := range p {
+			if t.Var == it.result {
+				t = Term{Value: v}
+			}
+			patterns[i][d] = t
+		}
+	}
+	sub := NewLeapfrog(it.qs, patterns, "")
+	if !sub.Next() {
+		it.setErr(sub.Err())
+		sub.Close()
+		return graph.ContainsLogOut(it, v, false)
+	}
+	it.contains, it.containsValue = sub, v
+	return graph.ContainsLogOut(it, v, true)
+}
+
+func (it *Leapfrog) closeContains() {
+	if it.contains != nil {
+		it.contains.Close()
+		it.contains = nil
+	}
+}
+
+func (it *Leapfrog) Reset() {
+	it.closeContains()
+	for i := range it.levels {
+		it.close(i)
+	}
+	it.values = make(map[string]graph.Value)
+	it.started = false
+	it.done = false
+	it.repeat = 0
+}
+
+func (it *Leapfrog) Close() error {
+	it.Reset()
+	it.done = true
+	return nil
+}
+
+// No subiterators; the patterns are read through the QuadStore.
+func (it *Leapfrog) SubIterators() []graph.Iterator {
+	return nil
+}
+
+// Size is bounded by the number of quads matching the most selective pattern,
+// which is a rough guess when the patterns share variables.
+func (it *Leapfrog) Size() (int64, bool) {
+	if it.size < 0 {
+		it.size = it.qs.Size()
+		for _, p := range it.patterns {
+			if s := it.estimate(it.links(p, -1)); s < it.size {
+				it.size = s
+			}
+		}
+	}
+	return it.size, false
+}
+
+func (it *Leapfrog) Describe() graph.Description {
+	size, _ := it.Size()
+	var parts []string
+	for _, p := range it.patterns {
+		var terms []string
+		for _, d := range patternDirs {
+			t, ok := p[d]
+			switch {
+			case !ok:
+				if d != quad.Label {
+					terms = append(terms, "_")
+				}
+			case t.Var != "":
+				terms = append(terms, t.Var)
+			default:
+				terms = append(terms, fmt.Sprintf("%q", it.qs.NameOf(t.Value)))
+			}
+		}
+		parts = append(parts, "("+strings.Join(terms, " ")+")")
+	}
+	return graph.Description{
+		UID:  it.UID(),
+		Name: strings.Join(parts, " "),
+		Type: it.Type(),
+		Tags: it.tags.Tags(),
+		Size: size,
+	}
+}
+
+func (it *Leapfrog) Type() graph.Type { return graph.Leapfrog }
+
+func (it *Leapfrog) Optimize() (graph.Iterator, bool) {
+	return it, false
+}
+
+// Each binding costs a seek or two for each pattern and a count of its quads,
+// and Contains runs the join again with the result fixed.
+func (it *Leapfrog) Stats() graph.IteratorStats {
+	size, _ := it.Size()
+	n := int64(len(it.patterns))
+	return graph.IteratorStats{
+		ContainsCost: n * 3,
+		NextCost:     n * 2,
+		Size:         size,
+	}
+}
+
+var _ graph.Nexter = &Leapfrog{}
@@ -0,0 +1,323 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// Recognizes the trees of HasA, LinksTo and And iterators that the query
+// languages build for patterns of more than one variable, and replaces them
+// with a Leapfrog join where that is cheaper.
+//
+// A node iterator in such a tree stands for a term: a Fixed with one value is
+// a fixed node, an All is a fresh variable, a HasA is the term in its direction
+// of the quad pattern below it, and an And makes the terms of its subiterators
+// the same. A quad iterator adds its terms to the pattern it belongs to.
+
+import (
+	"fmt"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/quad"
+)
+
+type joinPlan struct {
+	idx       graph.SortedIndexer
+	parent    []int
+	value     []graph.Value
+	tags      [][]string
+	fixedTags map[string]graph.Value
+	patterns  []map[quad.Direction]int
+}
+
+func (p *joinPlan) newTerm(v graph.Value) int {
+	p.parent = append(p.parent, len(p.parent))
+	p.value = append(p.value, v)
+	p.tags = append(p.tags, nil)
+	return len(p.parent) - 1
+}
+
+func (p *joinPlan) find(t int) int {
+	for p.parent[t] != t {
+		p.parent[t] = p.parent[p.parent[t]]
+		t = p.parent[t]
+	}
+	return t
+}
+
+// union makes two terms the same, failing if they are different fixed nodes.
+func (p *joinPlan) union(a, b int) bool {
+	a, b = p.find(a), p.find(b)
+	if a == b {
+		return true
+	}
+	va, vb := p.value[a], p.value[b]
+	if va != nil && vb != nil && p.idx.CompareValues(va, vb) != 0 {
+		return false
+	}
+	if va == nil {
+		p.value[a] = vb
+	}
+	p.parent[b] = a
+	return true
+}
+
+func (p *joinPlan) bind(pat int, d quad.Direction, t int) bool {
+	if u, ok := p.patterns[pat][d]; ok {
+		return p.union(u, t)
+	}
+	p.patterns[pat][d] = t
+	return true
+}
+
+func (p *joinPlan) addFixedTags(it graph.Iterator) {
+	for tag, v := range it.Tagger().Fixed() {
+		p.fixedTags[tag] = v
+	}
+}
+
+// node returns the term of a node iterator.
+func (p *joinPlan) node(it graph.Iterator) (int, bool) {
+	var t int
+	switch it := it.(type) {
+	case *Fixed:
+		if len(it.values) != 1 {
+			return 0, false
+		}
+		t = p.newTerm(it.values[0])
+	case *HasA:
+		pat := len(p.patterns)
+		p.patterns = append(p.patterns, make(map[quad.Direction]int))
+		if !p.quad(it.primaryIt, pat) {
+			return 0, false
+		}
+		var ok bool
+		t, ok = p.patterns[pat][it.dir]
+		if !ok {
+			t = p.newTerm(nil)
+			p.patterns[pat][it.dir] = t
+		}
+	case *And:
+		t = -1
+		for _, sub := range it.SubIterators() {
+			u, ok := p.node(sub)
+			if !ok {
+				return 0, false
+			}
+			if t < 0 {
+				t = u
+			} else if !p.union(t, u) {
+				return 0, false
+			}
+		}
+		if t < 0 {
+			return 0, false
+		}
+	case *Materialize:
+		var ok bool
+		t, ok = p.node(it.subIt)
+		if !ok {
+			return 0, false
+		}
+	case *Leapfrog:
+		var ok bool
+		t, ok = p.join(it)
+		if !ok {
+			return 0, false
+		}
+	default:
+		if it.Type() != graph.All || len(it.SubIterators()) != 0 {
+			return 0, false
+		}
+		t = p.newTerm(nil)
+	}
+	p.tags[t] = append(p.tags[t], it.Tagger().Tags()...)
+	p.addFixedTags(it)
+	return t, true
+}
+
+// quad adds the terms of a quad iterator to the pattern pat.
+func (p *joinPlan) quad(it graph.Iterator, pat int) bool {
+	if len(it.Tagger().Tags()) != 0 {
+		// Tagged quads can't be expressed in terms of nodes.
+		return false
+	}
+	p.addFixedTags(it)
+	switch it := it.(type) {
+	case *LinksTo:
+		t, ok := p.node(it.primaryIt)
+		return ok && p.bind(pat, it.dir, t)
+	case *And:
+		for _, sub := range it.SubIterators() {
+			if !p.quad(sub, pat) {
+				return false
+			}
+		}
+		return true
+	case *Materialize:
+		return p.quad(it.subIt, pat)
+	case graph.LinkageIterator:
+		l, ok := it.Linkage()
+		return ok && p.bind(pat, l.Dir, p.newTerm(l.Value))
+	}
+	return it.Type() == graph.All && len(it.SubIterators()) == 0
+}
+
+// join adds the patterns of a Leapfrog iterator, returning the term of its
+// result.
+func (p *joinPlan) join(it *Leapfrog) (int, bool) {
+	terms := make(map[string]int)
+	term := func(v string) int {
+		t, ok := terms[v]
+		if !ok {
+			t = p.newTerm(nil)
+			terms[v] = t
+		}
+		return t
+	}
+	for _, lp := range it.patterns {
+		pat := len(p.patterns)
+		p.patterns = append(p.patterns, make(map[quad.Direction]int))
+		for d, t := range lp {
+			var ok bool
+			if t.Var != "" {
+				ok = p.bind(pat, d, term(t.Var))
+			} else {
+				ok = p.bind(pat, d, p.newTerm(t.Value))
+			}
+			if !ok {
+				return 0, false
+			}
+		}
+	}
+	for v, tags := range it.varTags {
+		t := term(v)
+		p.tags[t] = append(p.tags[t], tags...)
+	}
+	return term(it.result), true
+}
+
+// cyclic returns whether the patterns are cyclic, or have a pattern of more
+// than two variables. Other patterns are chains and trees, which the nested
+// iterators already evaluate a link at a time without blowing up.
+func cyclic(patterns []Pattern) bool {
+	parent := make(map[string]string)
+	var find func(v string) string
+	find = func(v string) string {
+		if p, ok := parent[v]; ok && p != v {
+			parent[v] = find(p)
+			return parent[v]
+		}
+		parent[v] = v
+		return v
+	}
+	for _, p := range patterns {
+		var vars []string
+		for _, d := range patternDirs {
+			if v := p[d].Var; v != "" {
+				vars = append(vars, v)
+			}
+		}
+		if len(vars) > 2 {
+			return true
+		}
+		if len(vars) == 2 {
+			a, b := find(vars[0]), find(vars[1])
+			if a == b {
+				return true
+			}
+			parent[b] = a
+		}
+	}
+	return false
+}
+
+// newJoin returns a Leapfrog iterator to replace it with, if the tree below it
+// is a cyclic pattern, qs can seek, and the join is estimated to be cheaper
+// than the tree.
+func newJoin(qs graph.QuadStore, it graph.Iterator) (*Leapfrog, bool) {
+	idx, ok := qs.(graph.SortedIndexer)
+	if !ok {
+		return nil, false
+	}
+	p := &joinPlan{
+		idx:       idx,
+		fixedTags: make(map[string]graph.Value),
+	}
+	result, ok := p.node(it)
+	if !ok {
+		return nil, false
+	}
+
+	names := make(map[int]string)
+	termOf := func(t int) Term {
+		r := p.find(t)
+		if p.value[r] != nil {
+			return Term{Value: p.value[r]}
+		}
+		name, ok := names[r]
+		if !ok {
+			name = fmt.Sprintf("$%d", len(names))
+			names[r] = name
+		}
+		return Term{Var: name}
+	}
+	patterns := make([]Pattern, len(p.patterns))
+	for i, pat := range p.patterns {
+		patterns[i] = make(Pattern, len(pat))
+		for _, d := range patternDirs {
+			t, ok := pat[d]
+			if !ok {
+				continue
+			}
+			term := termOf(t)
+			if term.Var != "" {
+				if _, seen := patterns[i].dirOf(term.Var); seen {
+					// The same node in two directions of a quad is
+					// left to the iterators it came from.
+					return nil, false
+				}
+			}
+			patterns[i][d] = term
+		}
+	}
+	if len(names) < 2 || !cyclic(patterns) {
+		return nil, false
+	}
+	res := termOf(result)
+	if res.Var == "" {
+		return nil, false
+	}
+
+	lf := NewLeapfrog(qs, patterns, res.Var)
+	for t, tags := range p.tags {
+		if len(tags) == 0 {
+			continue
+		}
+		term := termOf(t)
+		for _, tag := range tags {
+			if term.Var == "" {
+				lf.tags.AddFixed(tag, term.Value)
+			} else {
+				lf.TagVariable(term.Var, tag)
+			}
+		}
+	}
+	for tag, v := range p.fixedTags {
+		lf.tags.AddFixed(tag, v)
+	}
+	joined, nested := lf.Stats(), it.Stats()
+	if joined.NextCost*joined.Size >= nested.NextCost*nested.Size {
+		return nil, false
+	}
+	return lf, true
+}
@@ -146,9 +146,42 @@ func (it *LinksTo) Optimize() (graph.Iterator, bool) {
 		it.Close()
 		return newReplacement, true
 	}
+	if fixed, ok := it.resolve(); ok {
+		it.Close()
+		return fixed, true
+	}
 	return it, false
 }
 
+// resolve returns a Fixed iterator of the quads of the LinksTo, if its
+// subiterator is small and untagged and there are few of them. It is the dual
+// of HasA's.
+func (it *LinksTo) resolve() (graph.Iterator, bool) {
+	size, exact := it.primaryIt.Size()
+	if !exact || size > maxResolvedSize || tagged(it.primaryIt) {
+		return nil, false
+	}
+	fixed := it.qs.FixedIterator()
+	n := 0
+	ok := true
+	for ok && graph.Next(it.primaryIt) {
+		quads := it.qs.QuadIterator(it.dir, it.primaryIt.Result())
+		for ok && graph.Next(quads) {
+			n++
+			ok = n <= maxResolvedSize
+			fixed.Add(quads.Result())
+		}
+		ok = ok && quads.Err() == nil
+		quads.Close()
+	}
+	if !ok || it.primaryIt.Err() != nil {
+		it.primaryIt.Reset()
+		return nil, false
+	}
+	fixed.Tagger().CopyFrom(it)
+	return fixed, true
+}
+
 // Next()ing a LinksTo operates as described above.
 func (it *LinksTo) Next() bool {
 	graph.NextLogIn(it)
@@ -180,6 +213,28 @@ func (it *LinksTo) Next() bool {
 	return it.Next()
 }
 
+// NextBatch fills dst with quads linked to the current node of the
+// subiterator, moving on to the next node once they run out.
+func (it *LinksTo) NextBatch(dst []graph.Value) int {
+	for {
+		if n := graph.NextBatch(it.nextIt, dst); n > 0 {
+			it.runstats.Next += int64(n)
+			it.runstats.ContainsNext += int64(n)
+			return n
+		}
+		it.err = it.nextIt.Err()
+		if it.err != nil {
+			return 0
+		}
+		if !graph.Next(it.primaryIt) {
+			it.err = it.primaryIt.Err()
+			return 0
+		}
+		it.nextIt.Close()
+		it.nextIt = it.qs.QuadIterator(it.dir, it.primaryIt.Result())
+	}
+}
+
 func (it *LinksTo) Err() error {
 	return it.err
 }
@@ -220,18 +275,32 @@ func (it *LinksTo) Stats() graph.IteratorStats {
 	fanoutFactor := int64(20)
 	checkConstant := int64(1)
 	nextConstant := int64(2)
+	size := fanoutFactor * subitStats.Size
+	if s, ok := it.predicateSize(); ok {
+		size = s
+	}
 	return graph.IteratorStats{
 		NextCost:     nextConstant + subitStats.NextCost,
 		ContainsCost: checkConstant + subitStats.ContainsCost,
-		Size:         fanoutFactor * subitStats.Size,
+		Size:         size,
 		Next:         it.runstats.Next,
 		Contains:     it.runstats.Contains,
 		ContainsNext: it.runstats.ContainsNext,
 	}
 }
 
+// Linkage returns the linkage of the quads held by the LinksTo if it links to
+// a single fixed node.
+func (it *LinksTo) Linkage() (graph.Linkage, bool) {
+	fixed, ok := it.primaryIt.(*Fixed)
+	if !ok || len(fixed.values) != 1 {
+		return graph.Linkage{}, false
+	}
+	return graph.Linkage{Dir: it.dir, Value: fixed.values[0]}, true
+}
+
 func (it *LinksTo) Size() (int64, bool) {
 	return it.Stats().Size, false
 }
 
-var _ graph.Nexter = &LinksTo{}
+var _ graph.BatchNexter = &LinksTo{}
@@ -15,8 +15,10 @@
 package iterator
 
 import (
+	"reflect"
 	"testing"
 
+	"github.com/google/cayley/graph"
 	"github.com/google/cayley/quad"
 )
 
@@ -41,3 +43,48 @@ func TestLinksTo(t *testing.T) {
 		t.Errorf("Quad index 2, such as %s, should match %s", qs.Quad(2), qs.Quad(val))
 	}
 }
+
+func TestLinksToResolve(t *testing.T) {
+	qs := &store{
+		data: []string{1: "cool"},
+		iter: NewFixed(Identity),
+	}
+	qs.iter.(*Fixed).Add(2)
+	qs.iter.(*Fixed).Add(3)
+	fixed := NewFixed(Identity)
+	fixed.Add(1)
+	lto := NewLinksTo(qs, fixed, quad.Object)
+	lto.Tagger().Add("a")
+	newIt, changed := lto.Optimize()
+	if !changed {
+		t.Error("Iterator didn't optimize")
+	}
+	if newIt.Type() != graph.Fixed {
+		t.Fatal("Expected fixed iterator, got ", newIt.Type())
+	}
+	if tags := newIt.Tagger().Tags(); !reflect.DeepEqual(tags, []string{"a"}) {
+		t.Errorf("Tags don't match: got:%v", tags)
+	}
+	if got := iterated(newIt); !reflect.DeepEqual(got, []int{2, 3}) {
+		t.Errorf("Unexpected results: got:%v", got)
+	}
+
+	tagged := NewFixed(Identity)
+	tagged.Add(1)
+	tagged.Tagger().Add("cool")
+	qs.iter.Reset()
+	if newIt, _ := NewLinksTo(qs, tagged, quad.Object).Optimize(); newIt.Type() != graph.LinksTo {
+		t.Error("Expected a tagged subiterator to be kept, got ", newIt.Type())
+	}
+
+	// Too many quads to resolve.
+	many := NewFixed(Identity)
+	for i := 0; i <= maxResolvedSize; i++ {
+		many.Add(i)
+	}
+	qs.iter = many
+	fixed.Reset()
+	if newIt, _ := NewLinksTo(qs, fixed, quad.Object).Optimize(); newIt.Type() != graph.LinksTo {
+		t.Error("Expected a LinksTo to too many quads to be kept, got ", newIt.Type())
+	}
+}
@@ -17,13 +17,15 @@ package iterator
 // A simple iterator that, when first called Contains() or Next() upon, materializes the whole subiterator, stores it locally, and responds. Essentially a cache.
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+
 	"github.com/barakmich/glog"
 
 	"github.com/google/cayley/graph"
 )
 
-var abortMaterializeAt = 1000
-
 type result struct {
 	id   graph.Value
 	tags map[string]graph.Value
@@ -37,17 +39,25 @@ type Keyer interface {
 	Key() interface{}
 }
 
+// A Materialize holds its results in memory until they outgrow its budget, and
+// then in a spill, as the results of each value, keyed by the index of the
+// value, and the index of each value, keyed by the value.
 type Materialize struct {
 	uid         uint64
 	tags        graph.Tagger
 	containsMap map[graph.Value]int
 	values      [][]result
+	count       int
+	size        int64
+	budget      int64
+	spill       *spill
+	noSpill     bool
+	current     []result
 	actualSize  int64
 	index       int
 	subindex    int
 	subIt       graph.Iterator
 	hasRun      bool
-	aborted     bool
 	runstats    graph.IteratorStats
 	err         error
 }
@@ -58,9 +68,16 @@ func NewMaterialize(sub graph.Iterator) *Materialize {
 		containsMap: make(map[graph.Value]int),
 		subIt:       sub,
 		index:       -1,
+		budget:      DefaultSpillBudget,
 	}
 }
 
+// SetSpillBudget sets roughly the number of bytes of results the iterator
+// holds in memory before spilling them to disk.
+func (it *Materialize) SetSpillBudget(n int64) {
+	it.budget = n
+}
+
 func (it *Materialize) UID() uint64 {
 	return it.uid
 }
@@ -68,13 +85,22 @@ func (it *Materialize) UID() uint64 {
 func (it *Materialize) Reset() {
 	it.subIt.Reset()
 	it.index = -1
+	it.current = nil
 }
 
 func (it *Materialize) Close() error {
+	err := it.subIt.Close()
+	if it.spill != nil {
+		if serr := it.spill.close(); err == nil {
+			err = serr
+		}
+		it.spill = nil
+	}
 	it.containsMap = nil
 	it.values = nil
+	it.current = nil
 	it.hasRun = false
-	return it.subIt.Close()
+	return err
 }
 
 func (it *Materialize) Tagger() *graph.Tagger {
@@ -85,20 +111,13 @@ func (it *Materialize) TagResults(dst map[string]graph.Value) {
 	if !it.hasRun {
 		return
 	}
-	if it.aborted {
-		it.subIt.TagResults(dst)
-		for _, tag := range it.tags.Tags() {
-			dst[tag] = it.Result()
-		}
-		return
-	}
 	if it.Result() == nil {
 		return
 	}
 	for _, tag := range it.tags.Tags() {
 		dst[tag] = it.Result()
 	}
-	for tag, value := range it.values[it.index][it.subindex].tags {
+	for tag, value := range it.current[it.subindex].tags {
 		dst[tag] = value
 	}
 }
@@ -106,12 +125,15 @@ func (it *Materialize) TagResults(dst map[string]graph.Value) {
 func (it *Materialize) Clone() graph.Iterator {
 	out := NewMaterialize(it.subIt.Clone())
 	out.tags.CopyFrom(it)
-	if it.hasRun {
+	out.budget = it.budget
+	// A spill belongs to one iterator, so clones of a spilled Materialize
+	// materialize their own.
+	if it.hasRun && it.spill == nil {
 		out.hasRun = true
-		out.aborted = it.aborted
 		out.err = it.err
 		out.values = it.values
 		out.containsMap = it.containsMap
+		out.count = it.count
 		out.actualSize = it.actualSize
 	}
 	return out
@@ -123,7 +145,7 @@ func (it *Materialize) Describe() graph.Description {
 		UID:      it.UID(),
 		Type:     it.Type(),
 		Tags:     it.tags.Tags(),
-		Size:     int64(len(it.values)),
+		Size:     int64(it.count),
 		Iterator: &primary,
 	}
 }
@@ -132,19 +154,13 @@ func (it *Materialize) Describe() graph.Description {
 func (it *Materialize) Type() graph.Type { return graph.Materialize }
 
 func (it *Materialize) Result() graph.Value {
-	if it.aborted {
-		return it.subIt.Result()
-	}
-	if len(it.values) == 0 {
-		return nil
-	}
 	if it.index == -1 {
 		return nil
 	}
-	if it.index >= len(it.values) {
+	if it.index >= it.count || len(it.current) == 0 {
 		return nil
 	}
-	return it.values[it.index][it.subindex].id
+	return it.current[it.subindex].id
 }
 
 func (it *Materialize) SubIterators() []graph.Iterator {
@@ -165,7 +181,7 @@ func (it *Materialize) Optimize() (graph.Iterator, bool) {
 // Size is the number of values stored, if we've got them all.
 // Otherwise, guess based on the size of the subiterator.
 func (it *Materialize) Size() (int64, bool) {
-	if it.hasRun && !it.aborted {
+	if it.hasRun && it.err == nil {
 		glog.V(2).Infoln("returning size", it.actualSize)
 		return it.actualSize, true
 	}
@@ -197,15 +213,13 @@ func (it *Materialize) Next() bool {
 	if it.err != nil {
 		return false
 	}
-	if it.aborted {
-		n := graph.Next(it.subIt)
-		it.err = it.subIt.Err()
-		return n
-	}
 
-	it.index++
-	it.subindex = 0
-	if it.index >= len(it.values) {
+	if it.index+1 >= it.count {
+		it.index = it.count
+		it.current = nil
+		return graph.NextLogOut(it, nil, false)
+	}
+	if !it.load(it.index + 1) {
 		return graph.NextLogOut(it, nil, false)
 	}
 	return graph.NextLogOut(it, it.Result(), true)
@@ -224,16 +238,12 @@ func (it *Materialize) Contains(v graph.Value) bool {
 	if it.err != nil {
 		return false
 	}
-	if it.aborted {
-		return it.subIt.Contains(v)
-	}
-	key := v
-	if h, ok := v.(Keyer); ok {
-		key = h.Key()
+	i, ok, err := it.indexOf(keyOf(v))
+	if err != nil {
+		it.err = err
+		return graph.ContainsLogOut(it, v, false)
 	}
-	if i, ok := it.containsMap[key]; ok {
-		it.index = i
-		it.subindex = 0
+	if ok && it.load(i) {
 		return graph.ContainsLogOut(it, v, true)
 	}
 	return graph.ContainsLogOut(it, v, false)
@@ -246,12 +256,9 @@ func (it *Materialize) NextPath() bool {
 	if it.err != nil {
 		return false
 	}
-	if it.aborted {
-		return it.subIt.NextPath()
-	}
 
 	it.subindex++
-	if it.subindex >= len(it.values[it.index]) {
+	if it.subindex >= len(it.current) {
 		// Don't go off the end of the world
 		it.subindex--
 		return false
@@ -259,50 +266,194 @@ func (it *Materialize) NextPath() bool {
 	return true
 }
 
+// load makes the results of the value at index i the current ones.
+func (it *Materialize) load(i int) bool {
+	it.index = i
+	it.subindex = 0
+	if it.spill == nil {
+		it.current = it.values[i]
+		return true
+	}
+	rs, _, err := it.spilledResults(i)
+	if err != nil {
+		it.err = err
+		it.current = nil
+		return false
+	}
+	it.current = rs
+	return true
+}
+
+// indexOf returns the index of the value with the given key.
+func (it *Materialize) indexOf(key interface{}) (int, bool, error) {
+	if it.spill == nil {
+		i, ok := it.containsMap[key]
+		return i, ok, nil
+	}
+	k, err := valueKey(key)
+	if err == errUnspillable {
+		// Only values with spillable keys are held.
+		return 0, false, nil
+	}
+	b, ok, err := it.spill.get(k)
+	if !ok || err != nil {
+		return 0, false, err
+	}
+	i, _ := binary.Uvarint(b)
+	return int(i), true, nil
+}
+
 func (it *Materialize) materializeSet() {
-	i := 0
 	for graph.Next(it.subIt) {
-		i++
-		if i > abortMaterializeAt {
-			it.aborted = true
-			break
-		}
 		id := it.subIt.Result()
-		val := id
-		if h, ok := id.(Keyer); ok {
-			val = h.Key()
+		var rs []result
+		for {
+			tags := make(map[string]graph.Value)
+			it.subIt.TagResults(tags)
+			rs = append(rs, result{id: id, tags: tags})
+			if !it.subIt.NextPath() {
+				break
+			}
 		}
-		if _, ok := it.containsMap[val]; !ok {
-			it.containsMap[val] = len(it.values)
-			it.values = append(it.values, nil)
+		if err := it.add(id, rs); err != nil {
+			it.err = err
+			break
 		}
-		index := it.containsMap[val]
-		tags := make(map[string]graph.Value)
-		it.subIt.TagResults(tags)
-		it.values[index] = append(it.values[index], result{id: id, tags: tags})
-		it.actualSize += 1
-		for it.subIt.NextPath() {
-			i++
-			if i > abortMaterializeAt {
-				it.aborted = true
+	}
+	if it.err == nil {
+		it.err = it.subIt.Err()
+	}
+	it.hasRun = true
+}
+
+// add adds the results of a value, spilling everything held so far if they
+// take it past its budget.
+func (it *Materialize) add(id graph.Value, rs []result) error {
+	it.actualSize += int64(len(rs))
+	key := keyOf(id)
+	if it.spill != nil {
+		return it.addSpilled(key, rs)
+	}
+	i, ok := it.containsMap[key]
+	if !ok {
+		i = len(it.values)
+		it.containsMap[key] = i
+		it.values = append(it.values, nil)
+		it.count++
+	}
+	it.values[i] = append(it.values[i], rs...)
+	for _, r := range rs {
+		it.size += entrySize + tagSize*int64(len(r.tags))
+	}
+	if it.budget > 0 && it.size > it.budget && !it.noSpill {
+		it.spillAll()
+	}
+	return nil
+}
+
+// spillAll moves the results held in memory to a new spill. If they cannot be
+// spilled, they stay in memory.
+func (it *Materialize) spillAll() {
+	s, err := newSpill()
+	if err == nil {
+		it.spill = s
+		for key, i := range it.containsMap {
+			if err = it.putSpilled(key, i, it.values[i]); err != nil {
 				break
 			}
-			tags := make(map[string]graph.Value)
-			it.subIt.TagResults(tags)
-			it.values[index] = append(it.values[index], result{id: id, tags: tags})
-			it.actualSize += 1
 		}
 	}
-	it.err = it.subIt.Err()
-	if it.err == nil && it.aborted {
-		if glog.V(2) {
-			glog.V(2).Infoln("Aborting subiterator")
+	if err != nil {
+		glog.Errorf("Could not spill materialized results, keeping them in memory: %v", err)
+		if it.spill != nil {
+			it.spill.close()
+			it.spill = nil
 		}
-		it.values = nil
-		it.containsMap = nil
-		it.subIt.Reset()
+		it.noSpill = true
+		return
 	}
-	it.hasRun = true
+	glog.V(2).Infof("Spilled %d materialized values to %s", it.count, s.dir)
+	it.containsMap = nil
+	it.values = nil
+	it.size = 0
+}
+
+func (it *Materialize) addSpilled(key interface{}, rs []result) error {
+	i, ok, err := it.indexOf(key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		old, _, err := it.spilledResults(i)
+		if err != nil {
+			return err
+		}
+		rs = append(old, rs...)
+	} else {
+		i = it.count
+		it.count++
+	}
+	return it.putSpilled(key, i, rs)
+}
+
+// A spilledResult is a result as it is encoded in a spill.
+type spilledResult struct {
+	ID   graph.Value
+	Tags map[string]graph.Value
+}
+
+func (it *Materialize) putSpilled(key interface{}, i int, rs []result) error {
+	enc := make([]spilledResult, len(rs))
+	for j, r := range rs {
+		enc[j] = spilledResult{ID: r.id, Tags: r.tags}
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(enc); err != nil {
+		return err
+	}
+	if err := it.spill.put(indexKey(i), buf.Bytes()); err != nil {
+		return err
+	}
+	k, err := valueKey(key)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, binary.MaxVarintLen64)
+	return it.spill.put(k, b[:binary.PutUvarint(b, uint64(i))])
+}
+
+func (it *Materialize) spilledResults(i int) ([]result, bool, error) {
+	b, ok, err := it.spill.get(indexKey(i))
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	var dec []spilledResult
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&dec); err != nil {
+		return nil, false, err
+	}
+	rs := make([]result, len(dec))
+	for j, r := range dec {
+		rs[j] = result{id: r.ID, tags: r.Tags}
+	}
+	return rs, true, nil
+}
+
+// indexKey returns the spill key of the results of the value at index i. Keys
+// are in index order.
+func indexKey(i int) []byte {
+	k := make([]byte, 9)
+	k[0] = 'r'
+	binary.BigEndian.PutUint64(k[1:], uint64(i))
+	return k
+}
+
+// valueKey returns the spill key of the index of the value with the given key.
+func valueKey(key interface{}) ([]byte, error) {
+	k, err := spillKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{'v'}, k...), nil
 }
 
 var _ graph.Nexter = &Materialize{}
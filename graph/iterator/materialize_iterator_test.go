@@ -16,7 +16,11 @@ package iterator
 
 import (
 	"errors"
+	"os"
+	"reflect"
 	"testing"
+
+	"github.com/google/cayley/graph"
 )
 
 func TestMaterializeIteratorError(t *testing.T) {
@@ -35,36 +39,99 @@ func TestMaterializeIteratorError(t *testing.T) {
 	}
 }
 
-func TestMaterializeIteratorErrorAbort(t *testing.T) {
+func TestMaterializeIteratorErrorSpilled(t *testing.T) {
 	wantErr := errors.New("unique")
 	errIt := newTestIterator(false, wantErr)
 
 	// This tests that we properly return 0 results and the error when the
-	// underlying iterator is larger than our 'abort at' value, and then
-	// returns an error.
+	// underlying iterator is large enough to be spilled, and then returns an
+	// error.
 	or := NewOr()
-	or.AddSubIterator(NewInt64(1, int64(abortMaterializeAt+1)))
+	or.AddSubIterator(NewInt64(1, 100))
 	or.AddSubIterator(errIt)
 
 	mIt := NewMaterialize(or)
+	mIt.SetSpillBudget(10 * entrySize)
+
+	if mIt.Next() != false {
+		t.Errorf("Materialize iterator did not pass through underlying 'false'")
+	}
+	if mIt.Err() != wantErr {
+		t.Errorf("Materialize iterator did not pass through underlying Err")
+	}
+	mIt.Close()
+}
+
+func TestMaterializeIteratorSpill(t *testing.T) {
+	or := NewOr()
+	all := NewFixed(Identity)
+	for i := 1; i <= 1000; i++ {
+		all.Add(i)
+	}
+	all.Tagger().Add("all")
+	or.AddSubIterator(all)
+	some := NewFixed(Identity)
+	for i := 1; i <= 300; i++ {
+		some.Add(i)
+	}
+	some.Tagger().Add("some")
+	or.AddSubIterator(some)
+
+	mIt := NewMaterialize(or)
+	mIt.SetSpillBudget(100 * entrySize)
+	defer mIt.Close()
 
-	// We should get all the underlying values...
-	for i := 0; i < abortMaterializeAt+1; i++ {
+	for i := 1; i <= 1000; i++ {
 		if !mIt.Next() {
-			t.Errorf("Materialize iterator returned spurious 'false' on iteration %d", i)
-			return
+			t.Fatalf("Materialize iterator returned spurious 'false' on iteration %d", i)
+		}
+		if got := mIt.Result(); got != i {
+			t.Fatalf("Unexpected result on iteration %d: got:%v", i, got)
 		}
-		if mIt.Err() != nil {
-			t.Errorf("Materialize iterator returned non-nil Err on iteration %d", i)
-			return
+		paths := 1
+		for mIt.NextPath() {
+			paths++
 		}
+		if want := 1 + btoi(i <= 300); paths != want {
+			t.Errorf("Unexpected number of paths for %d: got:%d expected:%d", i, paths, want)
+		}
+	}
+	if mIt.Next() {
+		t.Errorf("Materialize iterator returned more than its results")
+	}
+	if mIt.spill == nil {
+		t.Errorf("Materialize iterator did not spill its results")
+	}
+	if size, exact := mIt.Size(); size != 1300 || !exact {
+		t.Errorf("Unexpected size: got:%d exact:%t", size, exact)
 	}
 
-	// ... and then the error value.
-	if mIt.Next() != false {
-		t.Errorf("Materialize iterator did not pass through underlying 'false'")
+	if !mIt.Contains(250) {
+		t.Fatalf("Failed to find a spilled value")
 	}
-	if mIt.Err() != wantErr {
-		t.Errorf("Materialize iterator did not pass through underlying Err")
+	tags := make(map[string]graph.Value)
+	mIt.TagResults(tags)
+	if !mIt.NextPath() {
+		t.Fatalf("Failed to find the second path of a spilled value")
+	}
+	mIt.TagResults(tags)
+	if want := map[string]graph.Value{"all": 250, "some": 250}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("Unexpected tags: got:%v expected:%v", tags, want)
+	}
+	if mIt.Contains(1001) {
+		t.Errorf("Found a value that was not materialized")
+	}
+
+	dir := mIt.spill.dir
+	mIt.Close()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Spill was not removed on Close: %v", err)
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
 	}
+	return 0
 }
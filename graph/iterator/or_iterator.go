@@ -22,6 +22,8 @@ package iterator
 // May return the same value twice -- once for each branch.
 
 import (
+	"sync"
+
 	"github.com/google/cayley/graph"
 )
 
@@ -34,6 +36,15 @@ type Or struct {
 	currentIterator   int
 	result            graph.Value
 	err               error
+
+	// The state of Next when the branches are iterated over in parallel.
+	parallelism int
+	branches    []chan branchResult
+	started     int
+	stop        chan struct{}
+	wg          sync.WaitGroup
+	paths       []map[string]graph.Value
+	path        int
 }
 
 func NewOr() *Or {
@@ -59,6 +70,7 @@ func (it *Or) UID() uint64 {
 
 // Reset all internal iterators
 func (it *Or) Reset() {
+	it.stopBranches()
 	for _, sub := range it.internalIterators {
 		sub.Reset()
 	}
@@ -79,6 +91,7 @@ func (it *Or) Clone() graph.Iterator {
 	for _, sub := range it.internalIterators {
 		or.AddSubIterator(sub.Clone())
 	}
+	or.parallelism = it.parallelism
 	or.tags.CopyFrom(it)
 	return or
 }
@@ -99,6 +112,12 @@ func (it *Or) TagResults(dst map[string]graph.Value) {
 		dst[tag] = value
 	}
 
+	if it.paths != nil {
+		for tag, value := range it.paths[it.path] {
+			dst[tag] = value
+		}
+		return
+	}
 	it.internalIterators[it.currentIterator].TagResults(dst)
 }
 
@@ -125,6 +144,9 @@ func (it *Or) AddSubIterator(sub graph.Iterator) {
 // subiterators, it must produce from all subiterators -- unless it it
 // shortcircuiting, in which case, it is the first one that returns anything.
 func (it *Or) Next() bool {
+	if it.isParallel() {
+		return it.nextParallel()
+	}
 	graph.NextLogIn(it)
 	var first bool
 	for {
@@ -185,6 +207,7 @@ func (it *Or) subItsContain(val graph.Value) (bool, error) {
 // Check a value against the entire graph.iterator, in order.
 func (it *Or) Contains(val graph.Value) bool {
 	graph.ContainsLogIn(it, val)
+	it.stopBranches()
 	anyGood, err := it.subItsContain(val)
 	if err != nil {
 		it.err = err
@@ -229,6 +252,13 @@ func (it *Or) Size() (int64, bool) {
 // subiterators might, however, so just pass the call recursively. In the case of
 // shortcircuiting, only allow new results from the currently checked graph.iterator
 func (it *Or) NextPath() bool {
+	if it.paths != nil {
+		if it.path+1 < len(it.paths) {
+			it.path++
+			return true
+		}
+		return false
+	}
 	if it.currentIterator != -1 {
 		currIt := it.internalIterators[it.currentIterator]
 		ok := currIt.NextPath()
@@ -240,8 +270,10 @@ func (it *Or) NextPath() bool {
 	return false
 }
 
-// Perform or-specific cleanup, of which there currently is none.
-func (it *Or) cleanUp() {}
+// Perform or-specific cleanup, stopping any branches running in parallel.
+func (it *Or) cleanUp() {
+	it.stopBranches()
+}
 
 // Close this graph.iterator, and, by extension, close the subiterators.
 // Close should be idempotent, and it follows that if it's subiterators
@@ -269,6 +301,7 @@ func (it *Or) Optimize() (graph.Iterator, bool) {
 	closeIteratorList(old, nil)
 	newOr := NewOr()
 	newOr.isShortCircuiting = it.isShortCircuiting
+	newOr.parallelism = it.parallelism
 
 	// Add the subiterators in order.
 	for _, o := range optIts {
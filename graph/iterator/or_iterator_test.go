@@ -192,3 +192,56 @@ func TestShortCircuitOrIteratorErr(t *testing.T) {
 		t.Errorf("Or iterator did not pass through underlying Err")
 	}
 }
+
+func TestParallelOr(t *testing.T) {
+	newOr := func() *Or {
+		or := NewOr()
+		for i := 0; i < 5; i++ {
+			sub := NewInt64(int64(10*i), int64(10*i+100))
+			sub.Tagger().Add("sub")
+			or.AddSubIterator(sub)
+		}
+		return or
+	}
+
+	var expect []int64
+	seq := newOr()
+	for graph.Next(seq) {
+		expect = append(expect, seq.Result().(int64))
+	}
+
+	or := newOr()
+	or.SetParallelism(2)
+	for i := 0; i < 2; i++ {
+		var got []int64
+		for graph.Next(or) {
+			tags := make(map[string]graph.Value)
+			or.TagResults(tags)
+			if tags["sub"] != or.Result() {
+				t.Errorf("Unexpected tag on repeat %d, got:%v expect:%v", i, tags["sub"], or.Result())
+			}
+			got = append(got, or.Result().(int64))
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("Failed to iterate parallel Or correctly on repeat %d, got:%v expect:%v", i, got, expect)
+		}
+		or.Reset()
+	}
+	or.Close()
+
+	wantErr := errors.New("unique")
+	or = NewOr()
+	fix1 := NewFixed(Identity)
+	fix1.Add(1)
+	or.AddSubIterator(fix1)
+	or.AddSubIterator(newTestIterator(false, wantErr))
+	or.AddSubIterator(NewInt64(1, 5))
+	or.SetParallelism(2)
+	if got := iterated(or); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("Failed to iterate parallel Or correctly, got:%v expect:[1]", got)
+	}
+	if or.Err() != wantErr {
+		t.Errorf("Parallel Or iterator did not pass through underlying Err")
+	}
+	or.Close()
+}
@@ -0,0 +1,207 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// Defines the concurrent evaluation of iterator trees.
+//
+// An Or can Next its branches on goroutines of their own, each running ahead
+// of the Or by a few results. The results are still taken from the branches in
+// order, along with the tags of all their paths, so the Or returns what it
+// would have returned on one goroutine. To make use of this for trees without
+// an Or, the iterator that drives a tree -- the primary of an And, or the
+// subiterator of a HasA or LinksTo, down to an All -- is split into parts, and
+// the tree is replaced by a parallel Or of copies of it over each part.
+
+import (
+	"github.com/google/cayley/graph"
+)
+
+// branchBuffer is the number of results a branch may run ahead of the Or.
+const branchBuffer = 16
+
+// A branchResult is a result of a branch of an Or, along with the tags of each
+// of its paths.
+type branchResult struct {
+	val   graph.Value
+	paths []map[string]graph.Value
+	err   error
+}
+
+// SetParallelism sets the number of branches of the Or that are iterated over
+// at once by Next. It has no effect on a short-circuiting Or. Calling Contains
+// stops the branches, so it must not be mixed with Next without a Reset in
+// between.
+func (it *Or) SetParallelism(n int) {
+	it.parallelism = n
+}
+
+func (it *Or) isParallel() bool {
+	return it.parallelism > 1 && !it.isShortCircuiting
+}
+
+// runBranch sends the results of sub on out until it is done, or stop is
+// closed.
+func runBranch(sub graph.Iterator, out chan<- branchResult, stop <-chan struct{}) {
+	defer close(out)
+	for graph.Next(sub) {
+		r := branchResult{val: sub.Result()}
+		for {
+			tags := make(map[string]graph.Value)
+			sub.TagResults(tags)
+			r.paths = append(r.paths, tags)
+			if !sub.NextPath() {
+				break
+			}
+		}
+		select {
+		case out <- r:
+		case <-stop:
+			return
+		}
+	}
+	if err := sub.Err(); err != nil {
+		select {
+		case out <- branchResult{err: err}:
+		case <-stop:
+		}
+	}
+}
+
+// startBranches starts the branches that are no further ahead of the current
+// one than the parallelism allows. As they are read in order, this keeps the
+// current branch running.
+func (it *Or) startBranches() {
+	if it.branches == nil {
+		it.branches = make([]chan branchResult, it.itCount)
+		it.stop = make(chan struct{})
+	}
+	for ; it.started < it.itCount && it.started < it.currentIterator+it.parallelism; it.started++ {
+		out := make(chan branchResult, branchBuffer)
+		it.branches[it.started] = out
+		it.wg.Add(1)
+		go func(sub graph.Iterator) {
+			defer it.wg.Done()
+			runBranch(sub, out, it.stop)
+		}(it.internalIterators[it.started])
+	}
+}
+
+// stopBranches stops any running branches, discarding their results.
+func (it *Or) stopBranches() {
+	if it.branches == nil {
+		return
+	}
+	close(it.stop)
+	it.wg.Wait()
+	it.branches = nil
+	it.started = 0
+	it.paths = nil
+}
+
+func (it *Or) nextParallel() bool {
+	graph.NextLogIn(it)
+	if it.currentIterator == -1 {
+		it.currentIterator = 0
+	}
+	for it.currentIterator < it.itCount {
+		it.startBranches()
+		r, ok := <-it.branches[it.currentIterator]
+		if !ok {
+			it.currentIterator++
+			continue
+		}
+		if r.err != nil {
+			it.err = r.err
+			return graph.NextLogOut(it, nil, false)
+		}
+		it.result = r.val
+		it.paths = r.paths
+		it.path = 0
+		return graph.NextLogOut(it, it.result, true)
+	}
+	it.paths = nil
+	return graph.NextLogOut(it, nil, false)
+}
+
+// Parallel returns an iterator that holds the same results as it, with the
+// same tags, evaluated on up to n goroutines where the tree allows it. It is
+// meant for optimized trees that are about to be iterated over with Next.
+func Parallel(it graph.Iterator, n int) graph.Iterator {
+	if n <= 1 {
+		return it
+	}
+	if or, ok := it.(*Or); ok && !or.isShortCircuiting {
+		or.SetParallelism(n)
+		return or
+	}
+	parts, ok := partition(it, n)
+	if !ok {
+		return it
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	or := NewOr()
+	for _, part := range parts {
+		or.AddSubIterator(part)
+	}
+	or.SetParallelism(n)
+	return or
+}
+
+// partition splits the iterator that drives it into up to n parts, returning
+// a copy of it over each.
+func partition(it graph.Iterator, n int) ([]graph.Iterator, bool) {
+	if p, ok := it.(graph.Partitioner); ok {
+		parts := p.Partition(n)
+		it.Close()
+		return parts, true
+	}
+	var (
+		parts []graph.Iterator
+		ok    bool
+	)
+	switch it := it.(type) {
+	case *And:
+		parts, ok = partition(it.primaryIt, n)
+		for i, part := range parts {
+			and := NewAnd(it.qs)
+			and.AddSubIterator(part)
+			for _, sub := range it.internalIterators {
+				and.AddSubIterator(sub.Clone())
+			}
+			and.tags.CopyFrom(it)
+			parts[i] = and
+		}
+	case *HasA:
+		parts, ok = partition(it.primaryIt, n)
+		for i, part := range parts {
+			hasa := NewHasA(it.qs, part, it.dir)
+			hasa.tags.CopyFrom(it)
+			parts[i] = hasa
+		}
+	case *LinksTo:
+		parts, ok = partition(it.primaryIt, n)
+		for i, part := range parts {
+			lto := NewLinksTo(it.qs, part, it.dir)
+			lto.tags.CopyFrom(it)
+			parts[i] = lto
+		}
+	}
+	if ok {
+		it.Close()
+	}
+	return parts, ok
+}
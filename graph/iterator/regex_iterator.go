@@ -0,0 +1,225 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// "Regex" is a unary operator -- a filter across the values in the relevant
+// subiterator, keeping those whose names match a regular expression.
+//
+// A regular expression anchored to the start of the name with a literal
+// prefix, such as one built by NewPrefix, can be answered from a value index
+// ordered by name. Quad stores that have one replace a Regex over all of their
+// nodes with a scan of the range of names with that prefix.
+
+import (
+	"regexp"
+	"regexp/syntax"
+
+	"github.com/google/cayley/graph"
+)
+
+type Regex struct {
+	uid      uint64
+	tags     graph.Tagger
+	subIt    graph.Iterator
+	re       *regexp.Regexp
+	prefix   string
+	complete bool
+	qs       graph.QuadStore
+	result   graph.Value
+	err      error
+}
+
+// NewRegex returns an iterator of the values of sub with names matched by re.
+func NewRegex(sub graph.Iterator, re *regexp.Regexp, qs graph.QuadStore) *Regex {
+	prefix, complete := literalPrefix(re)
+	return &Regex{
+		uid:      NextUID(),
+		subIt:    sub,
+		re:       re,
+		prefix:   prefix,
+		complete: complete,
+		qs:       qs,
+	}
+}
+
+// NewPrefix returns an iterator of the values of sub with names starting with
+// prefix.
+func NewPrefix(sub graph.Iterator, prefix string, qs graph.QuadStore) *Regex {
+	return NewRegex(sub, regexp.MustCompile("^"+regexp.QuoteMeta(prefix)), qs)
+}
+
+// literalPrefix returns the literal text that any name matched by re must
+// start with, and whether re matches every name starting with it.
+func literalPrefix(re *regexp.Regexp) (prefix string, complete bool) {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	parsed = parsed.Simplify()
+	if parsed.Op != syntax.OpConcat || len(parsed.Sub) < 2 {
+		return "", false
+	}
+	begin, lit := parsed.Sub[0], parsed.Sub[1]
+	if begin.Op != syntax.OpBeginText || lit.Op != syntax.OpLiteral || lit.Flags&syntax.FoldCase != 0 {
+		return "", false
+	}
+	return string(lit.Rune), len(parsed.Sub) == 2
+}
+
+func (it *Regex) UID() uint64 {
+	return it.uid
+}
+
+// Regexp returns the regular expression names are matched against.
+func (it *Regex) Regexp() *regexp.Regexp {
+	return it.re
+}
+
+// Prefix returns the literal prefix of every name the iterator matches, and
+// whether the iterator matches every name with that prefix.
+func (it *Regex) Prefix() (prefix string, complete bool) {
+	return it.prefix, it.complete
+}
+
+func (it *Regex) matches(val graph.Value) bool {
+	return it.re.MatchString(it.qs.NameOf(val))
+}
+
+func (it *Regex) Close() error {
+	return it.subIt.Close()
+}
+
+func (it *Regex) Reset() {
+	it.subIt.Reset()
+}
+
+func (it *Regex) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Regex) Clone() graph.Iterator {
+	out := NewRegex(it.subIt.Clone(), it.re, it.qs)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *Regex) Next() bool {
+	for graph.Next(it.subIt) {
+		val := it.subIt.Result()
+		if it.matches(val) {
+			it.result = val
+			return true
+		}
+	}
+	it.err = it.subIt.Err()
+	return false
+}
+
+func (it *Regex) Err() error {
+	return it.err
+}
+
+func (it *Regex) Result() graph.Value {
+	return it.result
+}
+
+func (it *Regex) NextPath() bool {
+	for {
+		hasNext := it.subIt.NextPath()
+		if !hasNext {
+			it.err = it.subIt.Err()
+			return false
+		}
+		if it.matches(it.subIt.Result()) {
+			break
+		}
+	}
+	it.result = it.subIt.Result()
+	return true
+}
+
+// SubIterators returns a slice of the sub iterators.
+func (it *Regex) SubIterators() []graph.Iterator {
+	return []graph.Iterator{it.subIt}
+}
+
+func (it *Regex) Contains(val graph.Value) bool {
+	if !it.matches(val) {
+		return false
+	}
+	ok := it.subIt.Contains(val)
+	if !ok {
+		it.err = it.subIt.Err()
+	}
+	return ok
+}
+
+func (it *Regex) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+
+	it.subIt.TagResults(dst)
+}
+
+func (it *Regex) Type() graph.Type { return graph.Regex }
+
+func (it *Regex) Describe() graph.Description {
+	primary := it.subIt.Describe()
+	return graph.Description{
+		UID:      it.UID(),
+		Name:     it.re.String(),
+		Type:     it.Type(),
+		Tags:     it.tags.Tags(),
+		Iterator: &primary,
+	}
+}
+
+// Optimize optimizes the subiterator, and then gives the quad store the chance
+// to answer the match from an index.
+func (it *Regex) Optimize() (graph.Iterator, bool) {
+	newSub, changed := it.subIt.Optimize()
+	if changed {
+		it.subIt.Close()
+		it.subIt = newSub
+	}
+	newIt, ok := it.qs.OptimizeIterator(it)
+	if ok {
+		it.Close()
+		return newIt, true
+	}
+	return it, false
+}
+
+// We're only as expensive as our subiterator, plus a name lookup for each
+// value.
+func (it *Regex) Stats() graph.IteratorStats {
+	stats := it.subIt.Stats()
+	stats.NextCost++
+	stats.ContainsCost++
+	return stats
+}
+
+// Size is at most the size of the subiterator.
+func (it *Regex) Size() (int64, bool) {
+	size, _ := it.subIt.Size()
+	return size, false
+}
+
+var _ graph.Nexter = &Regex{}
@@ -0,0 +1,118 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var regexTests = []struct {
+	message  string
+	expr     string
+	expect   []string
+	prefix   string
+	complete bool
+}{
+	{
+		message:  "prefix",
+		expr:     "^ba",
+		expect:   []string{"bar", "baz"},
+		prefix:   "ba",
+		complete: true,
+	},
+	{
+		message: "prefix and suffix",
+		expr:    "^ba.*z$",
+		expect:  []string{"baz"},
+		prefix:  "ba",
+	},
+	{
+		message: "unanchored",
+		expr:    "o",
+		expect:  []string{"foo", "echo"},
+	},
+	{
+		message: "case folded prefix",
+		expr:    "(?i)^FO",
+		expect:  []string{"foo"},
+	},
+	{
+		message: "alternatives",
+		expr:    "^f|^e",
+		expect:  []string{"foo", "echo"},
+	},
+	{
+		message:  "no match",
+		expr:     "^qux",
+		expect:   nil,
+		prefix:   "qux",
+		complete: true,
+	},
+}
+
+func TestRegex(t *testing.T) {
+	for _, test := range regexTests {
+		qs := stringStore
+		it := NewRegex(stringFixedIterator(), regexp.MustCompile(test.expr), qs)
+
+		var got []string
+		for it.Next() {
+			got = append(got, qs.NameOf(it.Result()))
+		}
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to show %s, got:%q expect:%q", test.message, got, test.expect)
+		}
+		prefix, complete := it.Prefix()
+		if prefix != test.prefix || complete != test.complete {
+			t.Errorf("Unexpected prefix for %s, got:%q, %t expect:%q, %t", test.message, prefix, complete, test.prefix, test.complete)
+		}
+		for _, name := range stringStore.data {
+			want := false
+			for _, e := range test.expect {
+				want = want || e == name
+			}
+			if it.Contains(name) != want {
+				t.Errorf("Unexpected result for %s containing %q, expect:%t", test.message, name, want)
+			}
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	it := NewPrefix(stringFixedIterator(), "b.", stringStore)
+	if it.Next() {
+		t.Errorf("Prefix matched %q as a regular expression", stringStore.NameOf(it.Result()))
+	}
+	prefix, complete := it.Prefix()
+	if prefix != "b." || !complete {
+		t.Errorf("Unexpected prefix, got:%q, %t expect:%q, true", prefix, complete, "b.")
+	}
+}
+
+func TestRegexIteratorErr(t *testing.T) {
+	wantErr := errors.New("unique")
+	errIt := newTestIterator(false, wantErr)
+
+	it := NewRegex(errIt, regexp.MustCompile("^a"), stringStore)
+	if it.Next() != false {
+		t.Errorf("Regex iterator did not pass through initial 'false'")
+	}
+	if it.Err() != wantErr {
+		t.Errorf("Regex iterator did not pass through underlying Err")
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// Defines the Sorted iterator, a graph.Seeker that sorts the quads it holds in
+// memory. It serves QuadStores that have no index giving the order asked for.
+
+import (
+	"sort"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/quad"
+)
+
+// Ordering orders two values, as graph.SortedIndexer.CompareValues does.
+type Ordering func(a, b graph.Value) int
+
+// A Sorted iterator holds the quads with a set of linkages, ordered by their
+// node in one direction.
+type Sorted struct {
+	uid    uint64
+	tags   graph.Tagger
+	qs     graph.QuadStore
+	cmp    Ordering
+	links  []graph.Linkage
+	dir    quad.Direction
+	quads  []graph.Value
+	loaded bool
+	index  int
+	result graph.Value
+	err    error
+}
+
+// NewSorted returns a Sorted iterator over the quads in qs with all of links,
+// ordered by cmp on their node in direction d. The quads are read and sorted
+// when first needed.
+func NewSorted(qs graph.QuadStore, cmp Ordering, links []graph.Linkage, d quad.Direction) *Sorted {
+	return &Sorted{
+		uid:   NextUID(),
+		qs:    qs,
+		cmp:   cmp,
+		links: links,
+		dir:   d,
+	}
+}
+
+func (it *Sorted) UID() uint64 {
+	return it.uid
+}
+
+// load reads the quads from the smallest index of the linkages.
+func (it *Sorted) load() {
+	if it.loaded {
+		return
+	}
+	it.loaded = true
+
+	var src graph.Iterator
+	for _, l := range it.links {
+		sub := it.qs.QuadIterator(l.Dir, l.Value)
+		if src == nil || sub.Stats().Size < src.Stats().Size {
+			if src != nil {
+				src.Close()
+			}
+			src = sub
+		} else {
+			sub.Close()
+		}
+	}
+	if src == nil {
+		src = it.qs.QuadsAllIterator()
+	}
+	batch := make([]graph.Value, 100)
+	for {
+		n := graph.NextBatch(src, batch)
+		if n == 0 {
+			break
+		}
+		for _, q := range batch[:n] {
+			if it.matches(q) {
+				it.quads = append(it.quads, q)
+			}
+		}
+	}
+	it.err = src.Err()
+	src.Close()
+
+	sort.Stable(byNode{it})
+}
+
+func (it *Sorted) matches(q graph.Value) bool {
+	for _, l := range it.links {
+		if it.cmp(it.qs.QuadDirection(q, l.Dir), l.Value) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (it *Sorted) node(i int) graph.Value {
+	return it.qs.QuadDirection(it.quads[i], it.dir)
+}
+
+type byNode struct{ it *Sorted }
+
+func (s byNode) Len() int           { return len(s.it.quads) }
+func (s byNode) Less(i, j int) bool { return s.it.cmp(s.it.node(i), s.it.node(j)) < 0 }
+func (s byNode) Swap(i, j int)      { s.it.quads[i], s.it.quads[j] = s.it.quads[j], s.it.quads[i] }
+
+func (it *Sorted) Reset() {
+	it.index = 0
+	it.result = nil
+}
+
+func (it *Sorted) Close() error {
+	return nil
+}
+
+func (it *Sorted) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Sorted) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+}
+
+func (it *Sorted) Clone() graph.Iterator {
+	out := NewSorted(it.qs, it.cmp, it.links, it.dir)
+	out.quads, out.loaded, out.err = it.quads, it.loaded, it.err
+	out.tags.CopyFrom(it)
+	return out
+}
+
+// SortedBy returns the direction the quads are ordered by.
+func (it *Sorted) SortedBy() quad.Direction {
+	return it.dir
+}
+
+// Next advances the iterator to the next quad.
+func (it *Sorted) Next() bool {
+	graph.NextLogIn(it)
+	it.load()
+	if it.index >= len(it.quads) {
+		it.result = nil
+		return graph.NextLogOut(it, nil, false)
+	}
+	it.result = it.quads[it.index]
+	it.index++
+	return graph.NextLogOut(it, it.result, true)
+}
+
+// Seek advances the iterator to the first remaining quad whose node is not
+// less than v.
+func (it *Sorted) Seek(v graph.Value) bool {
+	it.load()
+	rest := it.quads[it.index:]
+	it.index += sort.Search(len(rest), func(i int) bool {
+		return it.cmp(it.qs.QuadDirection(rest[i], it.dir), v) >= 0
+	})
+	return it.Next()
+}
+
+func (it *Sorted) Err() error {
+	return it.err
+}
+
+func (it *Sorted) Result() graph.Value {
+	return it.result
+}
+
+func (it *Sorted) NextPath() bool {
+	return false
+}
+
+// No subiterators.
+func (it *Sorted) SubIterators() []graph.Iterator {
+	return nil
+}
+
+// Contains checks that the quad has all the linkages of the iterator.
+func (it *Sorted) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
+	if it.matches(v) {
+		it.result = v
+		return graph.ContainsLogOut(it, v, true)
+	}
+	return graph.ContainsLogOut(it, v, false)
+}
+
+func (it *Sorted) Size() (int64, bool) {
+	it.load()
+	return int64(len(it.quads)), true
+}
+
+func (it *Sorted) Describe() graph.Description {
+	size, _ := it.Size()
+	return graph.Description{
+		UID:       it.UID(),
+		Type:      it.Type(),
+		Tags:      it.tags.Tags(),
+		Size:      size,
+		Direction: it.dir,
+	}
+}
+
+func (it *Sorted) Type() graph.Type { return graph.Sorted }
+
+func (it *Sorted) Optimize() (graph.Iterator, bool) {
+	return it, false
+}
+
+func (it *Sorted) Stats() graph.IteratorStats {
+	size, _ := it.Size()
+	return graph.IteratorStats{
+		ContainsCost: int64(len(it.links)),
+		NextCost:     1,
+		Size:         size,
+	}
+}
+
+var _ graph.Seeker = &Sorted{}
@@ -0,0 +1,132 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// Defines the temporary on-disk store that Materialize and Unique iterators
+// move what they hold to once it outgrows their memory budget.
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/google/cayley/graph"
+)
+
+// DefaultSpillBudget is roughly the number of bytes of results a Materialize
+// or Unique iterator holds in memory before moving them to a temporary on-disk
+// store, unless given another budget. Values can only be spilled if their key
+// is an integer or a string, so values that are not comparable must implement
+// Keyer with a string key. A Materialize also needs the values it holds, and
+// their tags, to be encoded with encoding/gob; quad stores register their
+// value types for this. A budget of zero or less keeps everything in memory.
+const DefaultSpillBudget int64 = 64 << 20
+
+// Rough costs in memory of a value held by an iterator, and of each of its
+// tags, used to tell when the budget is reached.
+const (
+	entrySize = 64
+	tagSize   = 48
+)
+
+// A spill is a temporary, sorted, on-disk map of byte keys to byte values.
+type spill struct {
+	dir string
+	db  *leveldb.DB
+}
+
+func newSpill() (*spill, error) {
+	dir, err := ioutil.TempDir("", "cayley-spill")
+	if err != nil {
+		return nil, err
+	}
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &spill{dir: dir, db: db}, nil
+}
+
+func (s *spill) get(key []byte) ([]byte, bool, error) {
+	val, err := s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+func (s *spill) put(key, val []byte) error {
+	return s.db.Put(key, val, nil)
+}
+
+// close closes the store and removes its files.
+func (s *spill) close() error {
+	err := s.db.Close()
+	if rerr := os.RemoveAll(s.dir); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// keyOf returns a comparable key for v, which is the same for equal values.
+func keyOf(v graph.Value) interface{} {
+	if h, ok := v.(Keyer); ok {
+		return h.Key()
+	}
+	return v
+}
+
+var errUnspillable = errors.New("iterator: value has no key that can be spilled")
+
+// spillKey returns the bytes under which a key returned by keyOf is spilled.
+func spillKey(key interface{}) ([]byte, error) {
+	var n int64
+	switch key := key.(type) {
+	case string:
+		return append([]byte{'s'}, key...), nil
+	case int:
+		n = int64(key)
+	case int64:
+		n = key
+	default:
+		return nil, errUnspillable
+	}
+	b := make([]byte, 9)
+	b[0] = 'i'
+	binary.BigEndian.PutUint64(b[1:], uint64(n))
+	return b, nil
+}
+
+// SpillBudget sets the budget of the Materialize and Unique iterators of the
+// tree rooted at it to n bytes. It is meant for optimized trees, as
+// optimization may add Materialize iterators.
+func SpillBudget(it graph.Iterator, n int64) {
+	switch it := it.(type) {
+	case *Materialize:
+		it.SetSpillBudget(n)
+	case *Unique:
+		it.SetSpillBudget(n)
+	}
+	for _, sub := range it.SubIterators() {
+		SpillBudget(sub, n)
+	}
+}
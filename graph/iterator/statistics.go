@@ -0,0 +1,142 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// Helpers for refining the Stats() guesses of iterators with the predicate
+// statistics kept by a graph.Statistician QuadStore.
+
+import (
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/quad"
+)
+
+// predicateStats returns the statistics of the single predicate of the quads
+// held by it, if qs keeps statistics and it is known to hold only quads with
+// that predicate.
+func predicateStats(qs graph.QuadStore, it graph.Iterator) (graph.PredicateStats, bool) {
+	st, ok := qs.(graph.Statistician)
+	if !ok {
+		return graph.PredicateStats{}, false
+	}
+	switch it := it.(type) {
+	case graph.LinkageIterator:
+		l, ok := it.Linkage()
+		if ok && l.Dir == quad.Predicate {
+			return st.PredicateStats(l.Value)
+		}
+	case *And:
+		for _, sub := range it.SubIterators() {
+			if stats, ok := predicateStats(qs, sub); ok {
+				return stats, true
+			}
+		}
+	}
+	return graph.PredicateStats{}, false
+}
+
+// predicateSize returns the number of quads held by it, if it links to a fixed
+// set of predicates and its QuadStore keeps statistics.
+func (it *LinksTo) predicateSize() (int64, bool) {
+	st, ok := it.qs.(graph.Statistician)
+	fixed, isFixed := it.primaryIt.(*Fixed)
+	if !ok || !isFixed || it.dir != quad.Predicate {
+		return 0, false
+	}
+	var size int64
+	for _, v := range fixed.values {
+		stats, ok := st.PredicateStats(v)
+		if !ok {
+			return 0, false
+		}
+		size += stats.Quads
+	}
+	return size, true
+}
+
+// containsFanout estimates the number of quads a HasA iterates over to check
+// a node, if its quads have a single predicate whose statistics are known.
+// The nodes checked are usually reached by following links, which favours
+// the nodes with the most quads, so the estimate is weighted by the fanout
+// histogram of the predicate rather than its mean fanout.
+func (it *HasA) containsFanout() (int64, bool) {
+	stats, ok := predicateStats(it.qs, it.primaryIt)
+	if !ok {
+		return 0, false
+	}
+	fanout := stats.WeightedFanout(it.dir)
+	if fanout < 1 {
+		fanout = 1
+	}
+	return fanout, true
+}
+
+// linkedSize estimates the number of quads with the predicate described by
+// stats that are also held by it, a set of quads linked to nodes in a single
+// direction. It returns false if no estimate can be made.
+func linkedSize(stats graph.PredicateStats, it graph.Iterator) (int64, bool) {
+	var (
+		dir   quad.Direction
+		nodes int64
+	)
+	switch it := it.(type) {
+	case *LinksTo:
+		dir = it.dir
+		nodes = it.primaryIt.Stats().Size
+	case graph.LinkageIterator:
+		l, ok := it.Linkage()
+		if !ok {
+			return 0, false
+		}
+		dir, nodes = l.Dir, 1
+	default:
+		return 0, false
+	}
+	fanout := stats.Fanout(dir)
+	if fanout < 0 || dir == quad.Predicate {
+		return 0, false
+	}
+	if distinct := stats.Distinct(dir); nodes > distinct {
+		nodes = distinct
+	}
+	return nodes * fanout, true
+}
+
+// andSize estimates the size of the intersection of its, the subiterators of
+// an And, using the predicate statistics of qs where one of them holds the
+// quads of a single predicate. The fallback is the size of the smallest.
+func andSize(qs graph.QuadStore, its []graph.Iterator) int64 {
+	var size int64 = -1
+	for _, sub := range its {
+		if s := sub.Stats().Size; size < 0 || s < size {
+			size = s
+		}
+	}
+	for _, sub := range its {
+		stats, ok := predicateStats(qs, sub)
+		if !ok {
+			continue
+		}
+		for _, other := range its {
+			if other == sub {
+				continue
+			}
+			if s, ok := linkedSize(stats, other); ok && s < size {
+				size = s
+			}
+		}
+		break
+	}
+	return size
+}
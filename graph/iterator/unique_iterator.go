@@ -1,10 +1,14 @@
 package iterator
 
 import (
+	"github.com/barakmich/glog"
+
 	"github.com/google/cayley/graph"
 )
 
-// Unique iterator removes duplicate values from it's subiterator.
+// Unique iterator removes duplicate values from it's subiterator. The values
+// it has seen are kept in memory until they outgrow its budget, and then in a
+// spill.
 type Unique struct {
 	uid      uint64
 	tags     graph.Tagger
@@ -12,17 +16,28 @@ type Unique struct {
 	result   graph.Value
 	runstats graph.IteratorStats
 	err      error
-	seen     map[graph.Value]bool
+	seen     map[interface{}]bool
+	size     int64
+	budget   int64
+	spill    *spill
+	noSpill  bool
 }
 
 func NewUnique(subIt graph.Iterator) *Unique {
 	return &Unique{
-		uid:   NextUID(),
-		subIt: subIt,
-		seen:  make(map[graph.Value]bool),
+		uid:    NextUID(),
+		subIt:  subIt,
+		seen:   make(map[interface{}]bool),
+		budget: DefaultSpillBudget,
 	}
 }
 
+// SetSpillBudget sets roughly the number of bytes of values seen the iterator
+// holds in memory before spilling them to disk.
+func (it *Unique) SetSpillBudget(n int64) {
+	it.budget = n
+}
+
 func (it *Unique) UID() uint64 {
 	return it.uid
 }
@@ -31,7 +46,21 @@ func (it *Unique) UID() uint64 {
 func (it *Unique) Reset() {
 	it.result = nil
 	it.subIt.Reset()
-	it.seen = make(map[graph.Value]bool)
+	it.forget()
+	it.seen = make(map[interface{}]bool)
+}
+
+// forget drops the values seen so far.
+func (it *Unique) forget() error {
+	var err error
+	if it.spill != nil {
+		err = it.spill.close()
+		it.spill = nil
+	}
+	it.seen = nil
+	it.size = 0
+	it.noSpill = false
+	return err
 }
 
 func (it *Unique) Tagger() *graph.Tagger {
@@ -55,6 +84,7 @@ func (it *Unique) TagResults(dst map[string]graph.Value) {
 func (it *Unique) Clone() graph.Iterator {
 	uniq := NewUnique(it.subIt.Clone())
 	uniq.tags.CopyFrom(it)
+	uniq.budget = it.budget
 	return uniq
 }
 
@@ -72,9 +102,13 @@ func (it *Unique) Next() bool {
 
 	for graph.Next(it.subIt) {
 		curr := it.subIt.Result()
-		if ok := it.seen[curr]; !ok {
+		seen, err := it.see(keyOf(curr))
+		if err != nil {
+			it.err = err
+			return graph.NextLogOut(it, nil, false)
+		}
+		if !seen {
 			it.result = curr
-			it.seen[curr] = true
 			return graph.NextLogOut(it, it.result, true)
 		}
 	}
@@ -82,6 +116,58 @@ func (it *Unique) Next() bool {
 	return graph.NextLogOut(it, nil, false)
 }
 
+// see records that the value with the given key has been seen, and returns
+// whether it had been before.
+func (it *Unique) see(key interface{}) (bool, error) {
+	if it.spill != nil {
+		k, err := spillKey(key)
+		if err != nil {
+			return false, err
+		}
+		_, ok, err := it.spill.get(k)
+		if ok || err != nil {
+			return ok, err
+		}
+		return false, it.spill.put(k, nil)
+	}
+	if it.seen[key] {
+		return true, nil
+	}
+	it.seen[key] = true
+	it.size += entrySize
+	if it.budget > 0 && it.size > it.budget && !it.noSpill {
+		it.spillSeen()
+	}
+	return false, nil
+}
+
+// spillSeen moves the values seen so far to a new spill. If they cannot be
+// spilled, they stay in memory.
+func (it *Unique) spillSeen() {
+	s, err := newSpill()
+	if err == nil {
+		for key := range it.seen {
+			var k []byte
+			if k, err = spillKey(key); err == nil {
+				err = s.put(k, nil)
+			}
+			if err != nil {
+				s.close()
+				break
+			}
+		}
+	}
+	if err != nil {
+		glog.Errorf("Could not spill unique values, keeping them in memory: %v", err)
+		it.noSpill = true
+		return
+	}
+	glog.V(2).Infof("Spilled %d unique values to %s", len(it.seen), s.dir)
+	it.spill = s
+	it.seen = nil
+	it.size = 0
+}
+
 func (it *Unique) Err() error {
 	return it.err
 }
@@ -107,8 +193,11 @@ func (it *Unique) NextPath() bool {
 
 // Close closes the primary iterators.
 func (it *Unique) Close() error {
-	it.seen = nil
-	return it.subIt.Close()
+	err := it.subIt.Close()
+	if ferr := it.forget(); err == nil {
+		err = ferr
+	}
+	return err
 }
 
 func (it *Unique) Type() graph.Type { return graph.Unique }
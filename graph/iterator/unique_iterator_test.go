@@ -1,8 +1,11 @@
 package iterator
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
+
+	"github.com/google/cayley/graph"
 )
 
 func TestUniqueIteratorBasics(t *testing.T) {
@@ -29,3 +32,75 @@ func TestUniqueIteratorBasics(t *testing.T) {
 		}
 	}
 }
+
+func TestUniqueIteratorSpill(t *testing.T) {
+	var expect []int
+	allIt := NewFixed(Identity)
+	for i := 0; i < 1000; i++ {
+		allIt.Add(i)
+		allIt.Add(i / 2)
+		expect = append(expect, i)
+	}
+
+	u := NewUnique(allIt)
+	u.SetSpillBudget(100 * entrySize)
+	defer u.Close()
+
+	for i := 0; i < 2; i++ {
+		if got := iterated(u); !reflect.DeepEqual(got, expect) {
+			t.Errorf("Failed to iterate spilled Unique correctly on repeat %d: got %d values", i, len(got))
+		}
+		if u.spill == nil {
+			t.Errorf("Unique iterator did not spill on repeat %d", i)
+		}
+		u.Reset()
+	}
+}
+
+// keyed is a value that is only equal to another through its key.
+type keyed struct{ name string }
+
+func (k *keyed) Key() interface{} { return k.name }
+
+func TestUniqueIteratorSpillKeys(t *testing.T) {
+	count := func(it graph.Iterator) int {
+		var n int
+		for graph.Next(it) {
+			n++
+		}
+		return n
+	}
+
+	// Keyed values are spilled under their key, and the budget is set
+	// through the tree.
+	keys := NewFixed(Identity)
+	for i := 0; i < 1000; i++ {
+		keys.Add(&keyed{fmt.Sprint(i / 2)})
+	}
+	u := NewUnique(keys)
+	defer u.Close()
+	or := NewOr()
+	or.AddSubIterator(u)
+	SpillBudget(or, 100*entrySize)
+	if n := count(or); n != 500 {
+		t.Errorf("Unexpected number of keyed values, got:%d expect:500", n)
+	}
+	if u.spill == nil {
+		t.Error("Unique iterator did not spill keyed values")
+	}
+
+	// Values without a key that can be spilled are kept in memory.
+	floats := NewFixed(Identity)
+	for i := 0; i < 1000; i++ {
+		floats.Add(float64(i / 2))
+	}
+	u = NewUnique(floats)
+	u.SetSpillBudget(100 * entrySize)
+	defer u.Close()
+	if n := count(u); n != 500 {
+		t.Errorf("Unexpected number of unspillable values, got:%d expect:500", n)
+	}
+	if u.spill != nil {
+		t.Error("Unique iterator spilled values without a spillable key")
+	}
+}
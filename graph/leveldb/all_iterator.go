@@ -29,6 +29,8 @@ type AllIterator struct {
 	uid    uint64
 	tags   graph.Tagger
 	prefix []byte
+	start  []byte
+	end    []byte
 	dir    quad.Direction
 	open   bool
 	iter   ldbit.Iterator
@@ -38,6 +40,12 @@ type AllIterator struct {
 }
 
 func NewAllIterator(prefix string, d quad.Direction, qs *QuadStore) *AllIterator {
+	return newAllIterator([]byte(prefix), []byte(prefix), nil, d, qs)
+}
+
+// newAllIterator returns an iterator over the keys with the prefix from start
+// up to, but not including, end. A nil end is no limit.
+func newAllIterator(prefix, start, end []byte, d quad.Direction, qs *QuadStore) *AllIterator {
 	opts := &opt.ReadOptions{
 		DontFillCache: true,
 	}
@@ -45,14 +53,16 @@ func NewAllIterator(prefix string, d quad.Direction, qs *QuadStore) *AllIterator
 	it := AllIterator{
 		uid:    iterator.NextUID(),
 		ro:     opts,
-		iter:   qs.db.NewIterator(nil, opts),
-		prefix: []byte(prefix),
+		iter:   qs.reader.NewIterator(nil, opts),
+		prefix: prefix,
+		start:  start,
+		end:    end,
 		dir:    d,
 		open:   true,
 		qs:     qs,
 	}
 
-	it.iter.Seek(it.prefix)
+	it.iter.Seek(it.start)
 	if !it.iter.Valid() {
 		// FIXME(kortschak) What are the semantics here? Is this iterator usable?
 		// If not, we should return nil *Iterator and an error.
@@ -69,10 +79,10 @@ func (it *AllIterator) UID() uint64 {
 
 func (it *AllIterator) Reset() {
 	if !it.open {
-		it.iter = it.qs.db.NewIterator(nil, it.ro)
+		it.iter = it.qs.reader.NewIterator(nil, it.ro)
 		it.open = true
 	}
-	it.iter.Seek(it.prefix)
+	it.iter.Seek(it.start)
 	if !it.iter.Valid() {
 		it.open = false
 		it.iter.Release()
@@ -94,29 +104,91 @@ func (it *AllIterator) TagResults(dst map[string]graph.Value) {
 }
 
 func (it *AllIterator) Clone() graph.Iterator {
-	out := NewAllIterator(string(it.prefix), it.dir, it.qs)
+	out := newAllIterator(it.prefix, it.start, it.end, it.dir, it.qs)
 	out.tags.CopyFrom(it)
 	return out
 }
 
 func (it *AllIterator) Next() bool {
-	if !it.open {
-		it.result = nil
-		return false
+	for {
+		if !it.open {
+			it.result = nil
+			return false
+		}
+		var out []byte
+		out = make([]byte, len(it.iter.Key()))
+		copy(out, it.iter.Key())
+		// Quad indexes keep entries for deleted quads; skip them.
+		live := it.dir == quad.Any || isLiveValue(it.iter.Value())
+		it.iter.Next()
+		if !it.iter.Valid() {
+			it.Close()
+		}
+		if !it.inRange(out) {
+			it.Close()
+			return false
+		}
+		if live {
+			it.result = Token(out)
+			return true
+		}
 	}
-	var out []byte
-	out = make([]byte, len(it.iter.Key()))
-	copy(out, it.iter.Key())
-	it.iter.Next()
-	if !it.iter.Valid() {
-		it.Close()
+}
+
+// NextBatch fills dst with the following keys, sharing one allocation between
+// them.
+func (it *AllIterator) NextBatch(dst []graph.Value) int {
+	var (
+		n   int
+		buf []byte
+	)
+	for n < len(dst) && it.open {
+		key := it.iter.Key()
+		if !it.inRange(key) {
+			it.Close()
+			break
+		}
+		if it.dir == quad.Any || isLiveValue(it.iter.Value()) {
+			dst[n], buf = copyKey(key, buf, len(dst)-n)
+			n++
+		}
+		it.iter.Next()
+		if !it.iter.Valid() {
+			it.Close()
+		}
+	}
+	return n
+}
+
+func (it *AllIterator) inRange(key []byte) bool {
+	return bytes.HasPrefix(key, it.prefix) && (it.end == nil || bytes.Compare(key, it.end) < 0)
+}
+
+// Partition splits the keys into ranges by the first byte of their hash.
+func (it *AllIterator) Partition(n int) []graph.Iterator {
+	if n > 256 {
+		n = 256
 	}
-	if !bytes.HasPrefix(out, it.prefix) {
-		it.Close()
-		return false
+	if n < 1 {
+		n = 1
 	}
-	it.result = Token(out)
-	return true
+	bound := func(i int) []byte {
+		return append(append([]byte(nil), it.prefix...), byte(256*i/n))
+	}
+	parts := make([]graph.Iterator, n)
+	for i := range parts {
+		start, end := it.prefix, []byte(nil)
+		if i > 0 {
+			start = bound(i)
+		}
+		if i < n-1 {
+			end = bound(i + 1)
+		}
+		out := newAllIterator(it.prefix, start, end, it.dir, it.qs)
+		out.tags.CopyFrom(it)
+		parts[i] = out
+	}
+	return parts
 }
 
 func (it *AllIterator) Err() error {
@@ -185,4 +257,5 @@ func (it *AllIterator) Stats() graph.IteratorStats {
 	}
 }
 
-var _ graph.Nexter = &AllIterator{}
+var _ graph.BatchNexter = &AllIterator{}
+var _ graph.Partitioner = &AllIterator{}
@@ -0,0 +1,304 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leveldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/barakmich/glog"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/internal/extsort"
+	"github.com/google/cayley/quad"
+)
+
+var (
+	// bulkMemory is the memory used by each sort while bulk loading.
+	bulkMemory = 64 << 20
+	// bulkBatchSize is the number of writes in each bulk load batch.
+	bulkBatchSize = 10000
+)
+
+// BulkLoad loads the quads read from dec into an empty QuadStore. The quads
+// are sorted on disk so that duplicates can be dropped and value reference
+// counts and predicate statistics computed without reading from the
+// database, and are then written in large batches. Each quad is recorded in
+// the log as an added delta with an ID given by its position in the input.
+func (qs *QuadStore) BulkLoad(dec quad.Unmarshaler) error {
+	if qs.snap != nil {
+		return errReadOnly
+	}
+	if qs.size != 0 || qs.horizon != 0 {
+		return graph.ErrCannotBulkLoad
+	}
+	_, err := qs.db.Get([]byte("__bulk"), qs.readopts)
+	if err == nil {
+		return graph.ErrIncompleteBulkLoad
+	} else if err != leveldb.ErrNotFound {
+		return err
+	}
+
+	// The marker is removed with the write of the size and horizon, so a
+	// load that fails part way leaves a database that will not open.
+	err = qs.db.Put([]byte("__bulk"), nil, &opt.WriteOptions{Sync: true})
+	if err != nil {
+		return err
+	}
+
+	// Quads are sorted by their spo key followed by their position in the
+	// input, so that the first of any duplicates is kept.
+	keySize := 2 + hashSize*4
+	quads := extsort.New("", bulkMemory)
+	defer quads.Close()
+	var id int64
+	for {
+		q, err := dec.Unmarshal()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		id++
+		b, err := json.Marshal(q)
+		if err != nil {
+			return err
+		}
+		rec := make([]byte, keySize+8, keySize+8+len(b))
+		copy(rec, qs.createKeyFor(spo, q))
+		binary.BigEndian.PutUint64(rec[keySize:], uint64(id))
+		err = quads.Add(append(rec, b...))
+		if err != nil {
+			return err
+		}
+	}
+
+	batch := &leveldb.Batch{}
+	nodes := extsort.New("", bulkMemory)
+	defer nodes.Close()
+	// Objects are counted by sorting predicate and object pairs; subjects
+	// are counted as they go by, since the quads are sorted by subject and
+	// predicate.
+	objects := extsort.New("", bulkMemory)
+	defer objects.Close()
+	stats := make(map[string]*graph.PredicateStats)
+	statsFor := func(p []byte) *graph.PredicateStats {
+		st, ok := stats[string(p)]
+		if !ok {
+			st = &graph.PredicateStats{}
+			stats[string(p)] = st
+		}
+		return st
+	}
+	var (
+		run  []byte
+		runN int64
+	)
+	// putRun records that n quads have the predicate and the node in
+	// direction dir given by pair, the hashes of both.
+	putRun := func(dir quad.Direction, pair []byte, n int64) {
+		statsFor(pair[:hashSize]).UpdateFanout(dir, 0, n)
+		putCount(batch, append([]byte{'f', dir.Prefix()}, pair...), n)
+	}
+	now := time.Now()
+	write := func() error {
+		err := qs.db.Write(batch, qs.writeopts)
+		batch.Reset()
+		return err
+	}
+	var (
+		last    []byte
+		size    int64
+		horizon int64
+	)
+	err = quads.Each(func(rec []byte) error {
+		if bytes.Equal(rec[:keySize], last) {
+			return nil
+		}
+		last = rec[:keySize]
+		id := int64(binary.BigEndian.Uint64(rec[keySize:]))
+		var q quad.Quad
+		err := json.Unmarshal(rec[keySize+8:], &q)
+		if err != nil {
+			return err
+		}
+
+		d := graph.Delta{
+			ID:        graph.NewSequentialKey(id),
+			Quad:      q,
+			Action:    graph.Add,
+			Timestamp: now,
+		}
+		b, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		batch.Put(keyFor(d), b)
+		b, err = json.Marshal(IndexEntry{Quad: q, History: []int64{id}})
+		if err != nil {
+			return err
+		}
+		for _, index := range [][4]quad.Direction{spo, osp, pos, cps} {
+			if index == cps && q.Label == "" {
+				continue
+			}
+			batch.Put(qs.createKeyFor(index, q), b)
+		}
+		for _, dir := range []quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label} {
+			name := q.Get(dir)
+			if dir == quad.Label && name == "" {
+				continue
+			}
+			err = nodes.Add(append(qs.createValueKeyFor(name), name...))
+			if err != nil {
+				return err
+			}
+		}
+
+		// The quads are in subject and predicate order, so a run of
+		// them with the same pair gives the fanout of the subject.
+		s, p, o := rec[2:2+hashSize], rec[2+hashSize:2+2*hashSize], rec[2+2*hashSize:2+3*hashSize]
+		ps := append(append(make([]byte, 0, 2*hashSize), p...), s...)
+		if !bytes.Equal(ps, run) {
+			if runN > 0 {
+				putRun(quad.Subject, run, runN)
+			}
+			run, runN = ps, 0
+		}
+		runN++
+		statsFor(p).Quads++
+		err = objects.Add(append(append(make([]byte, 0, 2*hashSize), p...), o...))
+		if err != nil {
+			return err
+		}
+
+		size++
+		if id > horizon {
+			horizon = id
+		}
+		if batch.Len() >= bulkBatchSize {
+			if glog.V(2) {
+				glog.V(2).Infof("Bulk loaded %d quads.", size)
+			}
+			return write()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if runN > 0 {
+		putRun(quad.Subject, run, runN)
+	}
+	run, runN = nil, 0
+	err = objects.Each(func(rec []byte) error {
+		if bytes.Equal(rec, run) {
+			runN++
+			return nil
+		}
+		if runN > 0 {
+			putRun(quad.Object, run, runN)
+			if batch.Len() >= bulkBatchSize {
+				err := write()
+				if err != nil {
+					return err
+				}
+			}
+		}
+		run, runN = rec, 1
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if runN > 0 {
+		putRun(quad.Object, run, runN)
+	}
+	for p, st := range stats {
+		err = putStats(batch, createStatsKeyFor([]byte(p)), st)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Equal node records share a name, so a run of them gives its count.
+	var value *ValueData
+	putValue := func() error {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		batch.Put(qs.createValueKeyFor(value.Name), b)
+		batch.Put(qs.createNameKeyFor(value.Name), nil)
+		if qs.search {
+			qs.writeTerms(batch, value.Name, true)
+		}
+		if batch.Len() >= bulkBatchSize {
+			return write()
+		}
+		return nil
+	}
+	last = nil
+	err = nodes.Each(func(rec []byte) error {
+		if bytes.Equal(rec, last) {
+			value.Size++
+			return nil
+		}
+		if value != nil {
+			err := putValue()
+			if err != nil {
+				return err
+			}
+		}
+		last = rec
+		value = &ValueData{Name: string(rec[1+hashSize:]), Size: 1}
+		return nil
+	})
+	if err == nil && value != nil {
+		err = putValue()
+	}
+	if err != nil {
+		return err
+	}
+
+	qs.size = size
+	qs.horizon = horizon
+	putInt64(batch, "__size", size)
+	putInt64(batch, "__horizon", horizon)
+	batch.Put([]byte("__names"), nil)
+	batch.Put([]byte("__stats"), nil)
+	batch.Delete([]byte("__bulk"))
+	err = write()
+	if err != nil {
+		return err
+	}
+	qs.names = true
+	qs.stats = true
+	return nil
+}
+
+func putInt64(batch *leveldb.Batch, key string, n int64) {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, n)
+	batch.Put([]byte(key), buf.Bytes())
+}
+
+var _ graph.BulkLoader = &QuadStore{}
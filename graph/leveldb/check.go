@@ -0,0 +1,439 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leveldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/quad"
+)
+
+// indexKey rearranges key, an entry in the index ordered by from, into the
+// key of the same quad in the index ordered by to.
+func indexKey(key []byte, from, to [4]quad.Direction) []byte {
+	out := make([]byte, 0, 2+(hashSize*4))
+	out = append(out, to[0].Prefix(), to[1].Prefix())
+	for _, d := range to {
+		for i, f := range from {
+			if f == d {
+				out = append(out, key[2+i*hashSize:2+(i+1)*hashSize]...)
+			}
+		}
+	}
+	return out
+}
+
+// checker holds the state of a single consistency check.
+type checker struct {
+	qs     *QuadStore
+	repair bool
+	report func(error)
+	batch  *leveldb.Batch
+
+	// refs holds the expected value data, keyed by value key.
+	refs    map[string]*ValueData
+	size    int64
+	horizon int64
+
+	// names holds the names of the value entries, as repaired.
+	names map[string]bool
+
+	// fanout holds the expected fanout counts, keyed by fanout key.
+	fanout map[string]int64
+
+	// corrupt holds primary keys that could not be decoded; restored holds
+	// primary keys rebuilt from one of the other indexes.
+	corrupt  map[string]bool
+	restored map[string]bool
+}
+
+// Check verifies that every quad is present in all of its indexes, that
+// value reference counts match the live quads, and that the stored size and
+// horizon agree with the indexes. The spo index is treated as authoritative,
+// except that a quad found only in the other indexes is restored.
+func (qs *QuadStore) Check(repair bool, fn func(error)) error {
+	if repair && qs.snap != nil {
+		return errReadOnly
+	}
+	c := &checker{
+		qs:       qs,
+		repair:   repair,
+		report:   fn,
+		batch:    &leveldb.Batch{},
+		refs:     make(map[string]*ValueData),
+		names:    make(map[string]bool),
+		fanout:   make(map[string]int64),
+		corrupt:  make(map[string]bool),
+		restored: make(map[string]bool),
+	}
+	err := c.checkPrimary()
+	if err != nil {
+		return err
+	}
+	for _, index := range [][4]quad.Direction{osp, pos, cps} {
+		err = c.checkSecondary(index)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.checkValues()
+	if err != nil {
+		return err
+	}
+	err = c.checkNames()
+	if err != nil {
+		return err
+	}
+	err = c.checkStats()
+	if err != nil {
+		return err
+	}
+	c.checkMetadata()
+	if !repair || c.batch.Len() == 0 {
+		return nil
+	}
+	return qs.db.Write(c.batch, qs.writeopts)
+}
+
+func (c *checker) prefixOf(index [4]quad.Direction) []byte {
+	return []byte{index[0].Prefix(), index[1].Prefix()}
+}
+
+func (c *checker) each(prefix []byte, fn func(k, v []byte) error) error {
+	it := c.qs.reader.NewIterator(util.BytesPrefix(prefix), c.qs.readopts)
+	defer it.Release()
+	for it.Next() {
+		err := fn(it.Key(), it.Value())
+		if err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// count adds a quad's contribution to the expected size, horizon, value
+// reference counts and fanout counts.
+func (c *checker) count(entry IndexEntry) {
+	for _, id := range entry.History {
+		if id > c.horizon {
+			c.horizon = id
+		}
+	}
+	if len(entry.History)%2 == 0 {
+		return
+	}
+	c.size++
+	for _, d := range []quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label} {
+		name := entry.Quad.Get(d)
+		if d == quad.Label && name == "" {
+			continue
+		}
+		key := string(c.qs.createValueKeyFor(name))
+		v, ok := c.refs[key]
+		if !ok {
+			v = &ValueData{Name: name}
+			c.refs[key] = v
+		}
+		v.Size++
+	}
+	for _, d := range []quad.Direction{quad.Subject, quad.Object} {
+		k := graph.FanoutKey{Predicate: entry.Quad.Predicate, Dir: d, Node: entry.Quad.Get(d)}
+		c.fanout[string(c.qs.createFanoutKeyFor(k))]++
+	}
+}
+
+func (c *checker) checkPrimary() error {
+	return c.each(c.prefixOf(spo), func(k, v []byte) error {
+		var entry IndexEntry
+		err := json.Unmarshal(v, &entry)
+		if err != nil {
+			c.report(fmt.Errorf("leveldb: corrupt spo entry %x: %v", k, err))
+			c.corrupt[string(k)] = true
+			if c.repair {
+				c.batch.Delete(k)
+			}
+			return nil
+		}
+		for _, index := range [][4]quad.Direction{osp, pos, cps} {
+			if index == cps && entry.Quad.Label == "" {
+				continue
+			}
+			sk := indexKey(k, spo, index)
+			sv, err := c.qs.reader.Get(sk, c.qs.readopts)
+			if err == leveldb.ErrNotFound {
+				c.report(fmt.Errorf("leveldb: missing %s entry for quad %v", c.prefixOf(index), entry.Quad))
+			} else if err != nil {
+				return err
+			} else if !bytes.Equal(sv, v) {
+				c.report(fmt.Errorf("leveldb: %s entry for quad %v does not match spo entry", c.prefixOf(index), entry.Quad))
+			} else {
+				continue
+			}
+			if c.repair {
+				c.batch.Put(sk, v)
+			}
+		}
+		c.count(entry)
+		return nil
+	})
+}
+
+func (c *checker) checkSecondary(index [4]quad.Direction) error {
+	return c.each(c.prefixOf(index), func(k, v []byte) error {
+		pk := indexKey(k, index, spo)
+		if !c.corrupt[string(pk)] {
+			_, err := c.qs.reader.Get(pk, c.qs.readopts)
+			if err == nil {
+				return nil
+			} else if err != leveldb.ErrNotFound {
+				return err
+			}
+		}
+		if c.restored[string(pk)] {
+			return nil
+		}
+		var entry IndexEntry
+		err := json.Unmarshal(v, &entry)
+		if err != nil {
+			c.report(fmt.Errorf("leveldb: corrupt %s entry %x: %v", c.prefixOf(index), k, err))
+			if c.repair {
+				c.batch.Delete(k)
+			}
+			return nil
+		}
+		c.report(fmt.Errorf("leveldb: missing spo entry for quad %v", entry.Quad))
+		c.restored[string(pk)] = true
+		if c.repair {
+			for _, d := range [][4]quad.Direction{spo, osp, pos, cps} {
+				if d == cps && entry.Quad.Label == "" {
+					continue
+				}
+				c.batch.Put(c.qs.createKeyFor(d, entry.Quad), v)
+			}
+		}
+		c.count(entry)
+		return nil
+	})
+}
+
+func (c *checker) checkValues() error {
+	err := c.each([]byte("z"), func(k, v []byte) error {
+		var got ValueData
+		err := json.Unmarshal(v, &got)
+		if err != nil {
+			c.report(fmt.Errorf("leveldb: corrupt value entry %x: %v", k, err))
+			if c.repair {
+				c.batch.Delete(k)
+			}
+			return nil
+		}
+		want, ok := c.refs[string(k)]
+		if !ok {
+			want = &ValueData{Name: got.Name}
+		}
+		delete(c.refs, string(k))
+		c.names[want.Name] = true
+		if got == *want {
+			return nil
+		}
+		if got.Name != want.Name {
+			c.report(fmt.Errorf("leveldb: value entry %x holds %q, expected %q", k, got.Name, want.Name))
+		} else {
+			c.report(fmt.Errorf("leveldb: value %q has reference count %d, expected %d", got.Name, got.Size, want.Size))
+		}
+		return c.putValue(k, want)
+	})
+	if err != nil {
+		return err
+	}
+	for k, want := range c.refs {
+		c.report(fmt.Errorf("leveldb: missing value entry for %q", want.Name))
+		c.names[want.Name] = true
+		err = c.putValue([]byte(k), want)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkNames verifies that the name index holds exactly the names of the
+// value entries. A database without a name index has one built on repair.
+func (c *checker) checkNames() error {
+	if !c.qs.names {
+		c.report(fmt.Errorf("leveldb: name index is missing"))
+		if !c.repair {
+			return nil
+		}
+		for name := range c.names {
+			c.batch.Put(c.qs.createNameKeyFor(name), nil)
+		}
+		c.batch.Put([]byte("__names"), nil)
+		c.qs.names = true
+		return nil
+	}
+	err := c.each([]byte("n"), func(k, v []byte) error {
+		name := string(k[1:])
+		if c.names[name] {
+			delete(c.names, name)
+			return nil
+		}
+		c.report(fmt.Errorf("leveldb: name index holds %q, which has no value entry", name))
+		if c.repair {
+			c.batch.Delete(k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for name := range c.names {
+		c.report(fmt.Errorf("leveldb: name index is missing %q", name))
+		if c.repair {
+			c.batch.Put(c.qs.createNameKeyFor(name), nil)
+		}
+	}
+	return nil
+}
+
+// checkStats verifies the fanout counts and the predicate statistics computed
+// from them. A database without statistics has them collected on repair.
+func (c *checker) checkStats() error {
+	stats := make(map[string]*graph.PredicateStats)
+	for k, n := range c.fanout {
+		p := k[2 : 2+hashSize]
+		st, ok := stats[p]
+		if !ok {
+			st = &graph.PredicateStats{}
+			stats[p] = st
+		}
+		dir := quad.Subject
+		if k[1] == quad.Object.Prefix() {
+			dir = quad.Object
+		} else {
+			st.Quads += n
+		}
+		st.UpdateFanout(dir, 0, n)
+	}
+	if !c.qs.stats {
+		c.report(fmt.Errorf("leveldb: predicate statistics are missing"))
+		if !c.repair {
+			return nil
+		}
+		for k, n := range c.fanout {
+			putCount(c.batch, []byte(k), n)
+		}
+		for p, st := range stats {
+			err := putStats(c.batch, createStatsKeyFor([]byte(p)), st)
+			if err != nil {
+				return err
+			}
+		}
+		c.batch.Put([]byte("__stats"), nil)
+		c.qs.stats = true
+		return nil
+	}
+	err := c.each([]byte("f"), func(k, v []byte) error {
+		want := c.fanout[string(k)]
+		delete(c.fanout, string(k))
+		if len(v) == 8 && int64(binary.LittleEndian.Uint64(v)) == want {
+			return nil
+		}
+		c.report(fmt.Errorf("leveldb: fanout count %x is wrong, expected %d", k, want))
+		if c.repair {
+			putCount(c.batch, k, want)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for k, n := range c.fanout {
+		c.report(fmt.Errorf("leveldb: missing fanout count %x", k))
+		if c.repair {
+			putCount(c.batch, []byte(k), n)
+		}
+	}
+	err = c.each([]byte("r"), func(k, v []byte) error {
+		want, ok := stats[string(k[1:])]
+		delete(stats, string(k[1:]))
+		var got graph.PredicateStats
+		if json.Unmarshal(v, &got) == nil && ok && reflect.DeepEqual(got, *want) {
+			return nil
+		}
+		if !ok {
+			c.report(fmt.Errorf("leveldb: statistics entry %x is for a predicate without quads", k))
+			want = &graph.PredicateStats{}
+		} else {
+			c.report(fmt.Errorf("leveldb: statistics entry %x is wrong", k))
+		}
+		if c.repair {
+			return putStats(c.batch, k, want)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for p, st := range stats {
+		c.report(fmt.Errorf("leveldb: missing statistics entry %x", createStatsKeyFor([]byte(p))))
+		if c.repair {
+			err = putStats(c.batch, createStatsKeyFor([]byte(p)), st)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *checker) putValue(k []byte, v *ValueData) error {
+	if !c.repair {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.batch.Put(k, b)
+	return nil
+}
+
+func (c *checker) checkMetadata() {
+	if c.qs.size != c.size {
+		c.report(fmt.Errorf("leveldb: size is %d, expected %d", c.qs.size, c.size))
+		if c.repair {
+			c.qs.size = c.size
+			putInt64(c.batch, "__size", c.size)
+		}
+	}
+	if c.qs.horizon < c.horizon {
+		c.report(fmt.Errorf("leveldb: horizon is %d, but quads reference delta %d", c.qs.horizon, c.horizon))
+		if c.repair {
+			c.qs.horizon = c.horizon
+			putInt64(c.batch, "__horizon", c.horizon)
+		}
+	}
+}
+
+var _ graph.Checker = &QuadStore{}
@@ -39,13 +39,34 @@ type Iterator struct {
 	ro             *opt.ReadOptions
 	originalPrefix string
 	result         graph.Value
+
+	// links holds the linkages fixed by the prefix, in index order, and by
+	// is the direction whose nodes follow them in the keys.
+	links []graph.Linkage
+	by    quad.Direction
 }
 
 func NewIterator(prefix string, d quad.Direction, value graph.Value, qs *QuadStore) *Iterator {
-	vb := value.(Token)
-	p := make([]byte, 0, 2+hashSize)
+	return newIterator(prefix, []graph.Linkage{{Dir: d, Value: value}}, qs)
+}
+
+// newIterator returns an iterator over the keys of the index with the prefix
+// that have all of links, which must be for the leading directions of the
+// index.
+func newIterator(prefix string, links []graph.Linkage, qs *QuadStore) *Iterator {
+	order := indexOrder(prefix)
+	p := make([]byte, 0, 2+hashSize*len(links))
 	p = append(p, []byte(prefix)...)
-	p = append(p, []byte(vb[1:])...)
+	sorted := make([]graph.Linkage, 0, len(links))
+	for _, d := range order[:len(links)] {
+		for _, l := range links {
+			if l.Dir == d {
+				p = append(p, []byte(l.Value.(Token)[1:])...)
+				sorted = append(sorted, l)
+				break
+			}
+		}
+	}
 
 	opts := &opt.ReadOptions{
 		DontFillCache: true,
@@ -54,14 +75,21 @@ func NewIterator(prefix string, d quad.Direction, value graph.Value, qs *QuadSto
 	it := Iterator{
 		uid:            iterator.NextUID(),
 		nextPrefix:     p,
-		checkID:        vb,
-		dir:            d,
+		dir:            quad.Any,
 		originalPrefix: prefix,
+		links:          sorted,
 		ro:             opts,
-		iter:           qs.db.NewIterator(nil, opts),
+		iter:           qs.reader.NewIterator(nil, opts),
 		open:           true,
 		qs:             qs,
 	}
+	if len(links) > 0 {
+		it.checkID = sorted[0].Value.(Token)
+		it.dir = sorted[0].Dir
+	}
+	if len(links) < len(order) {
+		it.by = order[len(links)]
+	}
 
 	ok := it.iter.Seek(it.nextPrefix)
 	if !ok {
@@ -79,7 +107,7 @@ func (it *Iterator) UID() uint64 {
 
 func (it *Iterator) Reset() {
 	if !it.open {
-		it.iter = it.qs.db.NewIterator(nil, it.ro)
+		it.iter = it.qs.reader.NewIterator(nil, it.ro)
 		it.open = true
 	}
 	ok := it.iter.Seek(it.nextPrefix)
@@ -104,11 +132,42 @@ func (it *Iterator) TagResults(dst map[string]graph.Value) {
 }
 
 func (it *Iterator) Clone() graph.Iterator {
-	out := NewIterator(it.originalPrefix, it.dir, Token(it.checkID), it.qs)
+	out := newIterator(it.originalPrefix, it.links, it.qs)
 	out.tags.CopyFrom(it)
 	return out
 }
 
+// Linkage returns the direction and value the iterator was created for.
+func (it *Iterator) Linkage() (graph.Linkage, bool) {
+	if len(it.links) != 1 {
+		return graph.Linkage{}, false
+	}
+	return it.links[0], true
+}
+
+// SortedBy returns the direction whose nodes the quads are ordered by.
+func (it *Iterator) SortedBy() quad.Direction {
+	return it.by
+}
+
+// Seek advances the iterator to the first quad whose node in the SortedBy
+// direction is not less than v.
+func (it *Iterator) Seek(v graph.Value) bool {
+	if !it.open {
+		it.iter = it.qs.reader.NewIterator(nil, it.ro)
+		it.open = true
+	}
+	key := make([]byte, 0, len(it.nextPrefix)+hashSize)
+	key = append(key, it.nextPrefix...)
+	key = append(key, []byte(v.(Token)[1:])...)
+	if !it.iter.Seek(key) {
+		it.Close()
+		it.result = nil
+		return false
+	}
+	return it.Next()
+}
+
 func (it *Iterator) Close() error {
 	if it.open {
 		it.iter.Release()
@@ -117,7 +176,7 @@ func (it *Iterator) Close() error {
 	return nil
 }
 
-func (it *Iterator) isLiveValue(val []byte) bool {
+func isLiveValue(val []byte) bool {
 	var entry IndexEntry
 	json.Unmarshal(val, &entry)
 	return len(entry.History)%2 != 0
@@ -138,7 +197,7 @@ func (it *Iterator) Next() bool {
 		return false
 	}
 	if bytes.HasPrefix(it.iter.Key(), it.nextPrefix) {
-		if !it.isLiveValue(it.iter.Value()) {
+		if !isLiveValue(it.iter.Value()) {
 			ok := it.iter.Next()
 			if !ok {
 				it.Close()
@@ -160,6 +219,44 @@ func (it *Iterator) Next() bool {
 	return false
 }
 
+// NextBatch fills dst with the following keys of the index, sharing one
+// allocation between them.
+func (it *Iterator) NextBatch(dst []graph.Value) int {
+	if it.iter == nil {
+		return 0
+	}
+	var (
+		n   int
+		buf []byte
+	)
+	for n < len(dst) && it.open {
+		if !it.iter.Valid() || !bytes.HasPrefix(it.iter.Key(), it.nextPrefix) {
+			it.Close()
+			break
+		}
+		if isLiveValue(it.iter.Value()) {
+			dst[n], buf = copyKey(it.iter.Key(), buf, len(dst)-n)
+			n++
+		}
+		if !it.iter.Next() {
+			it.Close()
+		}
+	}
+	return n
+}
+
+// copyKey returns a Token holding a copy of key, taken from the start of buf,
+// and what is left of buf. If buf is too short, it is replaced with room for
+// n keys.
+func copyKey(key, buf []byte, n int) (Token, []byte) {
+	if len(buf) < len(key) {
+		buf = make([]byte, len(key)*n)
+	}
+	out := buf[:len(key):len(key)]
+	copy(out, key)
+	return Token(out), buf[len(key):]
+}
+
 func (it *Iterator) Err() error {
 	return it.iter.Error()
 }
@@ -234,8 +331,12 @@ func (it *Iterator) Contains(v graph.Value) bool {
 	if val[0] == 'z' {
 		return false
 	}
-	offset := PositionOf(val[0:2], it.dir, it.qs)
-	if bytes.HasPrefix(val[offset:], it.checkID[1:]) {
+	matches := true
+	for _, l := range it.links {
+		offset := PositionOf(val[0:2], l.Dir, it.qs)
+		matches = matches && bytes.HasPrefix(val[offset:], l.Value.(Token)[1:])
+	}
+	if matches {
 		// You may ask, why don't we check to see if it's a valid (not deleted) quad
 		// again?
 		//
@@ -251,7 +352,11 @@ func (it *Iterator) Contains(v graph.Value) bool {
 }
 
 func (it *Iterator) Size() (int64, bool) {
-	return it.qs.SizeOf(Token(it.checkID)), true
+	if len(it.links) == 0 {
+		return it.qs.Size(), true
+	}
+	// Further linkages can only make it smaller.
+	return it.qs.SizeOf(Token(it.checkID)), len(it.links) == 1
 }
 
 func (it *Iterator) Describe() graph.Description {
@@ -290,4 +395,6 @@ func (it *Iterator) Stats() graph.IteratorStats {
 	}
 }
 
-var _ graph.Nexter = &Iterator{}
+var _ graph.BatchNexter = &Iterator{}
+var _ graph.LinkageIterator = &Iterator{}
+var _ graph.Seeker = &Iterator{}
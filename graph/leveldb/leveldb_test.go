@@ -15,14 +15,20 @@
 package leveldb
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"sort"
 	"testing"
 
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/graph/search"
 	"github.com/google/cayley/quad"
 	"github.com/google/cayley/writer"
 )
@@ -464,3 +470,667 @@ func TestOptimize(t *testing.T) {
 		t.Errorf("Discordant tag results, new:%v old:%v", newResults, oldResults)
 	}
 }
+
+func TestNameIndex(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	t.Log(tmpDir)
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create leveldb QuadStore.")
+	}
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+
+	prefix := func() (graph.Iterator, bool) {
+		return iterator.NewPrefix(qs.NodesAllIterator(), "st", qs).Optimize()
+	}
+	it, ok := prefix()
+	if !ok || it.Type() != namesType {
+		t.Errorf("Failed to optimize prefix to a name scan, got:%v", it.Type())
+	}
+	expect := []string{"status", "status_graph"}
+	if got := iteratedNames(qs, it); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+	if it.Contains(qs.ValueOf("cool")) || !it.Contains(qs.ValueOf("status")) {
+		t.Error("Unexpected result checking names against the prefix")
+	}
+	it, ok = iterator.NewRegex(qs.NodesAllIterator(), regexp.MustCompile("^st.*h$"), qs).Optimize()
+	if !ok || it.Type() != graph.Regex || it.SubIterators()[0].Type() != namesType {
+		t.Errorf("Failed to optimize regex to a filtered name scan, got:%v", it.Type())
+	}
+	expect = []string{"status_graph"}
+	if got := iteratedNames(qs, it); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+
+	// A database without a name index falls back to filtering all nodes,
+	// until the index is built by a repair.
+	ls := qs.(*QuadStore)
+	ls.db.Delete([]byte("__names"), nil)
+	for _, name := range []string{"A", "status", "status_graph"} {
+		ls.db.Delete(ls.createNameKeyFor(name), nil)
+	}
+	qs.Close()
+	qs, err = newQuadStore(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen leveldb QuadStore: %v", err)
+	}
+	defer qs.Close()
+	if it, ok := prefix(); ok {
+		t.Errorf("Unexpected optimization without a name index to %v", it.Type())
+	}
+	var problems []error
+	err = qs.(graph.Checker).Check(true, func(err error) {
+		problems = append(problems, err)
+	})
+	if err != nil || len(problems) != 1 {
+		t.Errorf("Unexpected repair of the name index, got:%v (%v)", problems, err)
+	}
+	err = qs.(graph.Checker).Check(false, func(err error) {
+		t.Errorf("Unexpected problem after repair: %v", err)
+	})
+	if err != nil {
+		t.Errorf("Failed to check database: %v", err)
+	}
+	it, ok = prefix()
+	if !ok {
+		t.Error("Failed to optimize prefix after repair")
+	}
+	expect = []string{"status", "status_graph"}
+	if got := iteratedNames(qs, it); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	t.Log(tmpDir)
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create leveldb QuadStore.")
+	}
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+	it := search.NewSearch(qs, "cool")
+	if graph.Next(it) || it.Err() != search.ErrNoIndex {
+		t.Errorf("Unexpected search without an index, got error:%v", it.Err())
+	}
+
+	// Opening the database with the search option builds the index.
+	qs.Close()
+	qs, err = newQuadStore(tmpDir, graph.Options{"search": true})
+	if err != nil {
+		t.Fatalf("Failed to reopen leveldb QuadStore: %v", err)
+	}
+	expect := []string{"status", "status_graph"}
+	if got := iteratedNames(qs, search.NewSearch(qs, "st")); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+
+	// The index is kept once built, and nodes are removed from it with their
+	// last quad.
+	qs.Close()
+	qs, err = newQuadStore(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen leveldb QuadStore: %v", err)
+	}
+	defer qs.Close()
+	w, _ = writer.NewSingleReplication(qs, nil)
+	for _, q := range makeQuadSet() {
+		if q.Object == "cool" {
+			w.RemoveQuad(q)
+		}
+	}
+	w.AddQuad(quad.Quad{"E", "status", "cooler", ""})
+	expect = []string{"cooler"}
+	if got := iteratedNames(qs, search.NewSearch(qs, "coo")); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+	expect = []string{"status"}
+	if got := iteratedNames(qs, search.NewSearch(qs, "st")); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	t.Log(tmpDir)
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Error("Failed to create leveldb QuadStore.")
+	}
+	defer qs.Close()
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+
+	snap, err := qs.(graph.Snapshotter).Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	removed := quad.Quad{"E", "follows", "F", ""}
+	w.RemoveQuad(removed)
+
+	expect := makeQuadSet()
+	sort.Sort(ordered(expect))
+	if got := iteratedQuads(snap, snap.QuadsAllIterator()); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected snapshot results, got:%v expect:%v", got, expect)
+	}
+	if err := snap.ApplyDeltas(nil, graph.IgnoreOpts{}); err != errReadOnly {
+		t.Errorf("Unexpected error writing to snapshot, got:%v expect:%v", err, errReadOnly)
+	}
+	snap.Close()
+
+	expect = nil
+	for _, q := range makeQuadSet() {
+		if q != removed {
+			expect = append(expect, q)
+		}
+	}
+	sort.Sort(ordered(expect))
+	if got := iteratedQuads(qs, qs.QuadsAllIterator()); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	t.Log(tmpDir)
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Error("Failed to create leveldb QuadStore.")
+	}
+	defer qs.Close()
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+
+	check := func(repair bool) []error {
+		var problems []error
+		err := qs.(graph.Checker).Check(repair, func(err error) {
+			problems = append(problems, err)
+		})
+		if err != nil {
+			t.Fatalf("Failed to check database: %v", err)
+		}
+		return problems
+	}
+	if got := check(false); len(got) != 0 {
+		t.Errorf("Unexpected problems in a consistent database: %v", got)
+	}
+
+	ls := qs.(*QuadStore)
+	ls.db.Delete(ls.createKeyFor(osp, quad.Quad{"A", "follows", "B", ""}), nil)
+	ls.db.Delete(ls.createKeyFor(spo, quad.Quad{"B", "status", "cool", "status_graph"}), nil)
+	ls.UpdateValueKeyBy("follows", 3, nil)
+	ls.size += 2
+
+	if got := check(true); len(got) != 4 {
+		t.Errorf("Unexpected number of problems, got:%d expect:4 (%v)", len(got), got)
+	}
+	if got := check(false); len(got) != 0 {
+		t.Errorf("Unexpected problems after repair: %v", got)
+	}
+
+	expect := makeQuadSet()
+	sort.Sort(ordered(expect))
+	if got := iteratedQuads(qs, qs.QuadsAllIterator()); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+	if s := ls.SizeOf(qs.ValueOf("follows")); s != 8 {
+		t.Errorf("Unexpected size for follows, got:%d expect:8", s)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quad store size, got:%d expect:11", s)
+	}
+}
+
+// quadSlice is a quad.Unmarshaler reading from a slice.
+type quadSlice []quad.Quad
+
+func (s *quadSlice) Unmarshal() (quad.Quad, error) {
+	if len(*s) == 0 {
+		return quad.Quad{}, io.EOF
+	}
+	q := (*s)[0]
+	*s = (*s)[1:]
+	return q, nil
+}
+
+func TestBulkLoad(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	t.Log(tmpDir)
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Error("Failed to create leveldb QuadStore.")
+	}
+	defer qs.Close()
+
+	// Force the sorts to spill to disk.
+	defer func(n int) { bulkMemory = n }(bulkMemory)
+	bulkMemory = 1 << 10
+
+	set := append(makeQuadSet(), quad.Quad{"A", "follows", "B", ""})
+	dec := quadSlice(set)
+	err = qs.(graph.BulkLoader).BulkLoad(&dec)
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+
+	expect := makeQuadSet()
+	sort.Sort(ordered(expect))
+	if got := iteratedQuads(qs, qs.QuadsAllIterator()); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quad store size, got:%d expect:11", s)
+	}
+	if h := qs.Horizon(); h.Int() != 11 {
+		t.Errorf("Unexpected horizon, got:%d expect:11", h.Int())
+	}
+	err = qs.(graph.Checker).Check(false, func(err error) {
+		t.Errorf("Unexpected problem after bulk load: %v", err)
+	})
+	if err != nil {
+		t.Errorf("Failed to check database: %v", err)
+	}
+
+	dec = quadSlice(makeQuadSet())
+	if err := qs.(graph.BulkLoader).BulkLoad(&dec); err != graph.ErrCannotBulkLoad {
+		t.Errorf("Unexpected error bulk loading into a non-empty store, got:%v expect:%v", err, graph.ErrCannotBulkLoad)
+	}
+}
+
+func TestBulkLoadSearch(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	t.Log(tmpDir)
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, graph.Options{"search": true})
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create leveldb QuadStore.")
+	}
+	defer qs.Close()
+
+	dec := quadSlice(makeQuadSet())
+	err = qs.(graph.BulkLoader).BulkLoad(&dec)
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	expect := []string{"status", "status_graph"}
+	if got := iteratedNames(qs, search.NewSearch(qs, "st")); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected search results, got:%v expect:%v", got, expect)
+	}
+	err = qs.(graph.Checker).Check(false, func(err error) {
+		t.Errorf("Unexpected problem after bulk load: %v", err)
+	})
+	if err != nil {
+		t.Errorf("Failed to check database: %v", err)
+	}
+}
+
+// failingSlice is a quadSlice whose input fails once the quads are read.
+type failingSlice struct{ quadSlice }
+
+var errInput = errors.New("input failed")
+
+func (s *failingSlice) Unmarshal() (quad.Quad, error) {
+	q, err := s.quadSlice.Unmarshal()
+	if err == io.EOF {
+		err = errInput
+	}
+	return q, err
+}
+
+func TestIncompleteBulkLoad(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	t.Log(tmpDir)
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create leveldb QuadStore.")
+	}
+
+	dec := &failingSlice{makeQuadSet()}
+	if err := qs.(graph.BulkLoader).BulkLoad(dec); err != errInput {
+		t.Errorf("Unexpected error from a failing bulk load, got:%v expect:%v", err, errInput)
+	}
+	again := quadSlice(makeQuadSet())
+	if err := qs.(graph.BulkLoader).BulkLoad(&again); err != graph.ErrIncompleteBulkLoad {
+		t.Errorf("Unexpected error bulk loading again, got:%v expect:%v", err, graph.ErrIncompleteBulkLoad)
+	}
+	qs.Close()
+	if _, err := newQuadStore(tmpDir, nil); err != graph.ErrIncompleteBulkLoad {
+		t.Errorf("Unexpected error opening after a failed bulk load, got:%v expect:%v", err, graph.ErrIncompleteBulkLoad)
+	}
+}
+
+func predicateStats(t *testing.T, qs graph.QuadStore) map[string]graph.PredicateStats {
+	stats := make(map[string]graph.PredicateStats)
+	for _, p := range []string{"follows", "status"} {
+		st, ok := qs.(graph.Statistician).PredicateStats(qs.ValueOf(p))
+		if !ok {
+			t.Fatalf("Expected statistics for %q", p)
+		}
+		stats[p] = st
+	}
+	return stats
+}
+
+func TestPredicateStats(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	t.Log(tmpDir)
+	defer os.RemoveAll(tmpDir)
+	for _, dir := range []string{"written", "loaded"} {
+		err := createNewLevelDB(filepath.Join(tmpDir, dir), nil)
+		if err != nil {
+			t.Fatalf("Failed to create working directory")
+		}
+	}
+	qs, err := newQuadStore(filepath.Join(tmpDir, "written"), nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create leveldb QuadStore.")
+	}
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(append(makeQuadSet(), quad.Quad{"G", "follows", "A", ""}))
+	w.RemoveQuad(quad.Quad{"G", "follows", "A", ""})
+	got := predicateStats(t, qs)
+
+	expect := graph.PredicateStats{
+		Quads:         8,
+		Subjects:      6,
+		Objects:       4,
+		SubjectFanout: graph.Histogram{4, 2},
+		ObjectFanout:  graph.Histogram{1, 3},
+	}
+	if !reflect.DeepEqual(got["follows"], expect) {
+		t.Errorf("Unexpected predicate stats, got:%+v expect:%+v", got["follows"], expect)
+	}
+
+	loaded, err := newQuadStore(filepath.Join(tmpDir, "loaded"), nil)
+	if loaded == nil || err != nil {
+		t.Fatal("Failed to create leveldb QuadStore.")
+	}
+	defer loaded.Close()
+	dec := quadSlice(makeQuadSet())
+	err = loaded.(graph.BulkLoader).BulkLoad(&dec)
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	if stats := predicateStats(t, loaded); !reflect.DeepEqual(stats, got) {
+		t.Errorf("Bulk loaded stats differ from written stats, got:%+v expect:%+v", stats, got)
+	}
+
+	// A database without statistics has them collected by a repair.
+	qs.(*QuadStore).db.Delete([]byte("__stats"), nil)
+	qs.Close()
+	qs, err = newQuadStore(filepath.Join(tmpDir, "written"), nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to reopen leveldb QuadStore.")
+	}
+	defer qs.Close()
+	if _, ok := qs.(graph.Statistician).PredicateStats(qs.ValueOf("follows")); ok {
+		t.Error("Unexpected statistics without the stats marker")
+	}
+	check := func(repair bool) []error {
+		var problems []error
+		err := qs.(graph.Checker).Check(repair, func(err error) {
+			problems = append(problems, err)
+		})
+		if err != nil {
+			t.Fatalf("Failed to check database: %v", err)
+		}
+		return problems
+	}
+	if got := check(true); len(got) != 1 {
+		t.Errorf("Unexpected number of problems, got:%d expect:1 (%v)", len(got), got)
+	}
+	if got := check(false); len(got) != 0 {
+		t.Errorf("Unexpected problems after repair: %v", got)
+	}
+	if stats := predicateStats(t, qs); !reflect.DeepEqual(stats, got) {
+		t.Errorf("Repaired stats differ from written stats, got:%+v expect:%+v", stats, got)
+	}
+}
+
+func TestLeapfrogJoin(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	t.Log(tmpDir)
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create leveldb QuadStore.")
+	}
+	defer qs.Close()
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+
+	follows := qs.ValueOf("follows")
+	idx := qs.(graph.SortedIndexer)
+	sorted, indexed := idx.SortedQuadIterator([]graph.Linkage{{Dir: quad.Predicate, Value: follows}}, quad.Object)
+	if !indexed {
+		t.Error("Expected the quads of a predicate to be read in object order from an index")
+	}
+	var last graph.Value
+	for graph.Next(sorted) {
+		obj := qs.QuadDirection(sorted.Result(), quad.Object)
+		if last != nil && idx.CompareValues(last, obj) > 0 {
+			t.Errorf("Quads out of order, %q before %q", qs.NameOf(last), qs.NameOf(obj))
+		}
+		last = obj
+	}
+	sorted.Reset()
+	if !sorted.Seek(qs.ValueOf("G")) {
+		t.Fatal("Failed to seek to an object")
+	}
+	if got := qs.NameOf(qs.QuadDirection(sorted.Result(), quad.Object)); got != "G" {
+		t.Errorf("Unexpected object after seeking, got:%q expect:%q", got, "G")
+	}
+
+	// The only triangle in the graph is C->D->B with C->B.
+	join := iterator.NewLeapfrog(qs, []iterator.Pattern{
+		{quad.Subject: {Var: "a"}, quad.Predicate: {Value: follows}, quad.Object: {Var: "b"}},
+		{quad.Subject: {Var: "b"}, quad.Predicate: {Value: follows}, quad.Object: {Var: "c"}},
+		{quad.Subject: {Var: "a"}, quad.Predicate: {Value: follows}, quad.Object: {Var: "c"}},
+	}, "c")
+	for _, v := range []string{"a", "b", "c"} {
+		join.TagVariable(v, v)
+	}
+	var got []string
+	for graph.Next(join) {
+		tags := make(map[string]graph.Value)
+		join.TagResults(tags)
+		got = append(got, qs.NameOf(tags["a"])+qs.NameOf(tags["b"])+qs.NameOf(tags["c"]))
+	}
+	if expect := []string{"CDB"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected triangles, got:%v expect:%v", got, expect)
+	}
+}
+
+// followers returns an iterator over the nodes that follow someone, once for
+// each node they follow.
+func followers(qs graph.QuadStore) graph.Iterator {
+	fixed := qs.FixedIterator()
+	fixed.Add(qs.ValueOf("follows"))
+	and := iterator.NewAnd(qs)
+	and.AddSubIterator(iterator.NewLinksTo(qs, fixed, quad.Predicate))
+	and.AddSubIterator(iterator.NewLinksTo(qs, qs.NodesAllIterator(), quad.Object))
+	return iterator.NewHasA(qs, and, quad.Subject)
+}
+
+func nexted(it graph.Iterator) []graph.Value {
+	var out []graph.Value
+	for graph.Next(it) {
+		out = append(out, it.Result())
+	}
+	return out
+}
+
+func batched(it graph.Iterator, size int) []graph.Value {
+	var out []graph.Value
+	batch := make([]graph.Value, size)
+	for {
+		n := graph.NextBatch(it, batch)
+		if n == 0 {
+			return out
+		}
+		out = append(out, batch[:n]...)
+	}
+}
+
+func TestNextBatch(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	t.Log(tmpDir)
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create leveldb QuadStore.")
+	}
+	defer qs.Close()
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+	w.RemoveQuad(quad.Quad{"E", "follows", "F", ""})
+
+	for _, test := range []struct {
+		name string
+		it   func() graph.Iterator
+	}{
+		{"nodes", qs.NodesAllIterator},
+		{"quads", qs.QuadsAllIterator},
+		{"index", func() graph.Iterator { return qs.QuadIterator(quad.Predicate, qs.ValueOf("follows")) }},
+		{"followers", func() graph.Iterator { return followers(qs) }},
+	} {
+		expect := nexted(test.it())
+		for _, size := range []int{1, 3, 100} {
+			got := batched(test.it(), size)
+			if !reflect.DeepEqual(got, expect) {
+				t.Errorf("Unexpected %s in batches of %d, got:%v expect:%v", test.name, size, got, expect)
+			}
+		}
+	}
+}
+
+func benchmarkNext(b *testing.B, size int) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		b.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		b.Fatal("Failed to create leveldb QuadStore.")
+	}
+	defer qs.Close()
+
+	var data []quad.Quad
+	for i := 0; i < 10000; i++ {
+		data = append(data, quad.Quad{
+			Subject:   fmt.Sprint("n", i%1000),
+			Predicate: "follows",
+			Object:    fmt.Sprint("n", (i*7+i/1000)%1000),
+		})
+	}
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(data)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		it := followers(qs)
+		if size == 0 {
+			nexted(it)
+		} else {
+			batched(it, size)
+		}
+		it.Close()
+	}
+}
+
+func BenchmarkNext(b *testing.B)      { benchmarkNext(b, 0) }
+func BenchmarkNextBatch(b *testing.B) { benchmarkNext(b, 100) }
+
+func TestPartition(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	t.Log(tmpDir)
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create leveldb QuadStore.")
+	}
+	defer qs.Close()
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+
+	for _, all := range []func() graph.Iterator{qs.NodesAllIterator, qs.QuadsAllIterator} {
+		var expect []graph.Value
+		it := all()
+		for graph.Next(it) {
+			expect = append(expect, it.Result())
+		}
+		it.Close()
+		for _, n := range []int{1, 3, 1000} {
+			parts := all().(graph.Partitioner).Partition(n)
+			if len(parts) > n || len(parts) > 256 {
+				t.Errorf("Unexpected number of parts, got:%d for %d", len(parts), n)
+			}
+			var got []graph.Value
+			for _, part := range parts {
+				for graph.Next(part) {
+					got = append(got, part.Result())
+				}
+				part.Close()
+			}
+			if !reflect.DeepEqual(got, expect) {
+				t.Errorf("Unexpected values in %d parts, got:%v expect:%v", n, got, expect)
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leveldb
+
+import (
+	"fmt"
+	"strings"
+
+	ldbit "github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+)
+
+var namesType graph.Type
+
+func init() {
+	namesType = graph.RegisterIterator("leveldb-names")
+}
+
+// NameIterator iterates over the nodes with names starting with a prefix, in
+// order of their names, by scanning the range of the name index they are in.
+type NameIterator struct {
+	uid    uint64
+	tags   graph.Tagger
+	prefix string
+	qs     *QuadStore
+	ro     *opt.ReadOptions
+	iter   ldbit.Iterator
+	result graph.Value
+	err    error
+}
+
+func NewNameIterator(prefix string, qs *QuadStore) *NameIterator {
+	return &NameIterator{
+		uid:    iterator.NextUID(),
+		prefix: prefix,
+		qs:     qs,
+		ro:     &opt.ReadOptions{DontFillCache: true},
+	}
+}
+
+func (it *NameIterator) UID() uint64 {
+	return it.uid
+}
+
+func (it *NameIterator) Reset() {
+	it.Close()
+	it.result = nil
+}
+
+func (it *NameIterator) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *NameIterator) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+}
+
+func (it *NameIterator) Clone() graph.Iterator {
+	out := NewNameIterator(it.prefix, it.qs)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *NameIterator) Next() bool {
+	if it.iter == nil {
+		it.iter = it.qs.reader.NewIterator(util.BytesPrefix(it.qs.createNameKeyFor(it.prefix)), it.ro)
+	}
+	if !it.iter.Next() {
+		it.err = it.iter.Error()
+		it.result = nil
+		return false
+	}
+	it.result = Token(it.qs.createValueKeyFor(string(it.iter.Key()[1:])))
+	return true
+}
+
+func (it *NameIterator) Err() error {
+	return it.err
+}
+
+func (it *NameIterator) Result() graph.Value {
+	return it.result
+}
+
+func (it *NameIterator) NextPath() bool {
+	return false
+}
+
+// No subiterators.
+func (it *NameIterator) SubIterators() []graph.Iterator {
+	return nil
+}
+
+func (it *NameIterator) Contains(v graph.Value) bool {
+	if !strings.HasPrefix(it.qs.NameOf(v), it.prefix) {
+		return false
+	}
+	it.result = v
+	return true
+}
+
+func (it *NameIterator) Close() error {
+	if it.iter != nil {
+		it.iter.Release()
+		it.iter = nil
+	}
+	return nil
+}
+
+func (it *NameIterator) Size() (int64, bool) {
+	size, err := it.qs.SizeOfPrefix(it.qs.createNameKeyFor(it.prefix))
+	if err == nil {
+		return size, false
+	}
+	// INT64_MAX
+	return int64(^uint64(0) >> 1), false
+}
+
+func (it *NameIterator) Describe() graph.Description {
+	size, _ := it.Size()
+	return graph.Description{
+		UID:  it.UID(),
+		Name: fmt.Sprintf("%q", it.prefix),
+		Type: it.Type(),
+		Tags: it.tags.Tags(),
+		Size: size,
+	}
+}
+
+func (it *NameIterator) Type() graph.Type { return namesType }
+func (it *NameIterator) Sorted() bool     { return false }
+
+func (it *NameIterator) Optimize() (graph.Iterator, bool) {
+	return it, false
+}
+
+func (it *NameIterator) Stats() graph.IteratorStats {
+	s, _ := it.Size()
+	return graph.IteratorStats{
+		ContainsCost: 2,
+		NextCost:     1,
+		Size:         s,
+	}
+}
+
+var _ graph.Nexter = &NameIterator{}
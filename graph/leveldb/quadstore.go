@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
+	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,16 +27,19 @@ import (
 
 	"github.com/barakmich/glog"
 	"github.com/syndtr/goleveldb/leveldb"
+	ldbit "github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
 
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/graph/search"
 	"github.com/google/cayley/quad"
 )
 
 func init() {
 	graph.RegisterQuadStore(QuadStoreType, true, newQuadStore, createNewLevelDB, nil)
+	gob.Register(Token(nil))
 }
 
 const (
@@ -49,6 +53,8 @@ var (
 		New: func() interface{} { return sha1.New() },
 	}
 	hashSize = sha1.Size
+
+	errReadOnly = errors.New("leveldb: snapshot is read-only")
 )
 
 type Token []byte
@@ -57,18 +63,34 @@ func (t Token) Key() interface{} {
 	return string(t)
 }
 
+// reader is the read interface shared by leveldb.DB and leveldb.Snapshot.
+type reader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) ldbit.Iterator
+}
+
 type QuadStore struct {
 	dbOpts    *opt.Options
 	db        *leveldb.DB
+	reader    reader
+	snap      *leveldb.Snapshot
 	path      string
 	open      bool
 	size      int64
 	horizon   int64
+	names     bool        // whether the name index is complete
+	search    bool        // whether the search index is maintained
+	writeMu   *sync.Mutex // serializes ApplyDeltas
+	stats     bool        // whether predicate statistics are maintained
 	writeopts *opt.WriteOptions
 	readopts  *opt.ReadOptions
 }
 
-func createNewLevelDB(path string, _ graph.Options) error {
+func createNewLevelDB(path string, options graph.Options) error {
+	indexSearch, _, err := options.BoolKey("search")
+	if err != nil {
+		return err
+	}
 	opts := &opt.Options{}
 	db, err := leveldb.OpenFile(path, opts)
 	if err != nil {
@@ -81,6 +103,17 @@ func createNewLevelDB(path string, _ graph.Options) error {
 	qs.writeopts = &opt.WriteOptions{
 		Sync: true,
 	}
+	err = db.Put([]byte("__names"), nil, qs.writeopts)
+	if err == nil {
+		err = db.Put([]byte("__stats"), nil, qs.writeopts)
+	}
+	if err == nil && indexSearch {
+		err = db.Put([]byte("__search"), nil, qs.writeopts)
+	}
+	if err != nil {
+		glog.Errorf("Error: could not create database: %v", err)
+		return err
+	}
 	qs.Close()
 	return nil
 }
@@ -109,6 +142,10 @@ func newQuadStore(path string, options graph.Options) (graph.QuadStore, error) {
 		writeBufferSize = val
 	}
 	qs.dbOpts.WriteBuffer = writeBufferSize * opt.MiB
+	indexSearch, _, err := options.BoolKey("search")
+	if err != nil {
+		return nil, err
+	}
 	qs.writeopts = &opt.WriteOptions{
 		Sync: false,
 	}
@@ -119,11 +156,20 @@ func newQuadStore(path string, options graph.Options) (graph.QuadStore, error) {
 		return nil, err
 	}
 	qs.db = db
+	qs.reader = db
+	qs.writeMu = &sync.Mutex{}
 	glog.Infoln(qs.GetStats())
 	err = qs.getMetadata()
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
+	if indexSearch && !qs.search {
+		err = qs.buildSearchIndex()
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &qs, nil
 }
 
@@ -173,6 +219,45 @@ func (qs *QuadStore) createValueKeyFor(s string) []byte {
 	return key
 }
 
+// createNameKeyFor returns the key of s in the name index, which orders the
+// nodes by name.
+func (qs *QuadStore) createNameKeyFor(s string) []byte {
+	key := make([]byte, 0, 1+len(s))
+	key = append(key, 'n')
+	key = append(key, s...)
+	return key
+}
+
+// createFanoutKeyFor returns the key of the number of quads with the predicate
+// and the node of k.
+func (qs *QuadStore) createFanoutKeyFor(k graph.FanoutKey) []byte {
+	key := make([]byte, 0, 2+hashSize*2)
+	key = append(key, 'f', k.Dir.Prefix())
+	key = append(key, hashOf(k.Predicate)...)
+	key = append(key, hashOf(k.Node)...)
+	return key
+}
+
+// createStatsKeyFor returns the key of the statistics of the predicate whose
+// name has the hash h.
+func createStatsKeyFor(h []byte) []byte {
+	key := make([]byte, 0, 1+hashSize)
+	key = append(key, 'r')
+	key = append(key, h...)
+	return key
+}
+
+// createSearchKeyFor returns the key of the node named s in the postings of
+// term in the search index.
+func (qs *QuadStore) createSearchKeyFor(term, s string) []byte {
+	key := make([]byte, 0, 2+len(term)+hashSize)
+	key = append(key, 'w')
+	key = append(key, term...)
+	key = append(key, 0)
+	key = append(key, hashOf(s)...)
+	return key
+}
+
 type IndexEntry struct {
 	quad.Quad
 	History []int64
@@ -187,9 +272,28 @@ var (
 )
 
 func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, ignoreOpts graph.IgnoreOpts) error {
+	return qs.ApplyDeltasIf(deltas, nil, ignoreOpts)
+}
+
+// ApplyDeltasIf applies deltas if all of conds hold, checking them under the
+// same lock as the write.
+func (qs *QuadStore) ApplyDeltasIf(deltas []graph.Delta, conds []graph.Precondition, ignoreOpts graph.IgnoreOpts) error {
+	if qs.snap != nil {
+		return errReadOnly
+	}
+	qs.writeMu.Lock()
+	defer qs.writeMu.Unlock()
+	err := graph.CheckPreconditions(conds, qs.Horizon(), qs.quadExists)
+	if err != nil {
+		return err
+	}
 	batch := &leveldb.Batch{}
 	resizeMap := make(map[string]int64)
 	sizeChange := int64(0)
+	horizon := qs.horizon
+	stats := graph.NewPredicateStatsUpdate(qs.getFanout, func(p string) (graph.PredicateStats, error) {
+		return qs.getPredicateStats(createStatsKeyFor(hashOf(p)))
+	})
 	for _, d := range deltas {
 		if d.Action != graph.Add && d.Action != graph.Delete {
 			return errors.New("leveldb: invalid action")
@@ -220,7 +324,13 @@ func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, ignoreOpts graph.IgnoreOp
 			resizeMap[d.Quad.Label] += delta
 		}
 		sizeChange += delta
-		qs.horizon = d.ID.Int()
+		horizon = d.ID.Int()
+		if qs.stats {
+			err = stats.Add(d.Quad, delta)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	for k, v := range resizeMap {
 		if v != 0 {
@@ -230,15 +340,38 @@ func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, ignoreOpts graph.IgnoreOp
 			}
 		}
 	}
-	err := qs.db.Write(batch, qs.writeopts)
+	err = qs.writeStats(batch, stats)
+	if err != nil {
+		return err
+	}
+	err = qs.db.Write(batch, qs.writeopts)
 	if err != nil {
 		glog.Error("could not write to DB for quadset.")
 		return err
 	}
 	qs.size += sizeChange
+	qs.horizon = horizon
 	return nil
 }
 
+// Deltas calls fn with each delta in the QuadStore's log, in order.
+func (qs *QuadStore) Deltas(fn func(graph.Delta) error) error {
+	it := qs.reader.NewIterator(&util.Range{Start: []byte("d"), Limit: []byte("e")}, qs.readopts)
+	defer it.Release()
+	for it.Next() {
+		var d graph.Delta
+		err := json.Unmarshal(it.Value(), &d)
+		if err != nil {
+			return err
+		}
+		err = fn(d)
+		if err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
 func keyFor(d graph.Delta) []byte {
 	key := make([]byte, 0, 19)
 	key = append(key, 'd')
@@ -246,9 +379,25 @@ func keyFor(d graph.Delta) []byte {
 	return key
 }
 
+// quadExists returns whether q is in the store.
+func (qs *QuadStore) quadExists(q quad.Quad) (bool, error) {
+	data, err := qs.reader.Get(qs.createKeyFor(spo, q), qs.readopts)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	var entry IndexEntry
+	err = json.Unmarshal(data, &entry)
+	if err != nil {
+		return false, err
+	}
+	return len(entry.History)%2 == 1, nil
+}
+
 func (qs *QuadStore) buildQuadWrite(batch *leveldb.Batch, q quad.Quad, id int64, isAdd bool) error {
 	var entry IndexEntry
-	data, err := qs.db.Get(qs.createKeyFor(spo, q), qs.readopts)
+	data, err := qs.reader.Get(qs.createKeyFor(spo, q), qs.readopts)
 	if err != nil && err != leveldb.ErrNotFound {
 		glog.Error("could not access DB to prepare index: ", err)
 		return err
@@ -296,7 +445,8 @@ type ValueData struct {
 func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, batch *leveldb.Batch) error {
 	value := &ValueData{name, amount}
 	key := qs.createValueKeyFor(name)
-	b, err := qs.db.Get(key, qs.readopts)
+	b, err := qs.reader.Get(key, qs.readopts)
+	var old int64
 
 	// Error getting the node from the database.
 	if err != nil && err != leveldb.ErrNotFound {
@@ -311,6 +461,7 @@ func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, batch *leveldb.
 			glog.Errorf("Error: could not reconstruct value: %v", err)
 			return err
 		}
+		old = value.Size
 		value.Size += amount
 	}
 
@@ -325,15 +476,189 @@ func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, batch *leveldb.
 		glog.Errorf("could not write to buffer for value %s: %s", name, err)
 		return err
 	}
+	// A new node is also added to the name index, and a node coming into or
+	// falling out of use is added to or removed from the search index.
+	w := batch
+	if w == nil {
+		w = &leveldb.Batch{}
+	}
+	w.Put(key, bytes)
+	if b == nil {
+		w.Put(qs.createNameKeyFor(name), nil)
+	}
+	if qs.search && (old == 0) != (value.Size == 0) {
+		qs.writeTerms(w, name, value.Size != 0)
+	}
 	if batch == nil {
-		qs.db.Put(key, bytes, qs.writeopts)
-	} else {
-		batch.Put(key, bytes)
+		return qs.db.Write(w, qs.writeopts)
 	}
 	return nil
 }
 
+// writeTerms adds the postings of the terms of the name of a node to the
+// search index, or deletes them.
+func (qs *QuadStore) writeTerms(batch *leveldb.Batch, name string, add bool) {
+	for term, n := range search.Terms(name) {
+		key := qs.createSearchKeyFor(term, name)
+		if !add {
+			batch.Delete(key)
+			continue
+		}
+		buf := make([]byte, binary.MaxVarintLen64)
+		batch.Put(key, buf[:binary.PutUvarint(buf, uint64(n))])
+	}
+}
+
+// buildSearchIndex adds the nodes in use to a new search index.
+func (qs *QuadStore) buildSearchIndex() error {
+	glog.Infoln("leveldb: building search index")
+	it := qs.db.NewIterator(util.BytesPrefix([]byte("z")), qs.readopts)
+	defer it.Release()
+	batch := &leveldb.Batch{}
+	for it.Next() {
+		var value ValueData
+		err := json.Unmarshal(it.Value(), &value)
+		if err != nil {
+			return err
+		}
+		if value.Size > 0 {
+			qs.writeTerms(batch, value.Name, true)
+		}
+		if batch.Len() >= bulkBatchSize {
+			err = qs.db.Write(batch, qs.writeopts)
+			if err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	batch.Put([]byte("__search"), nil)
+	err := qs.db.Write(batch, qs.writeopts)
+	if err != nil {
+		return err
+	}
+	qs.search = true
+	return nil
+}
+
+// Postings calls fn with the nodes with the term, or with terms starting with
+// it, in the search index.
+func (qs *QuadStore) Postings(term string, prefix bool, fn func(graph.Value, int)) error {
+	if !qs.search {
+		return search.ErrNoIndex
+	}
+	start := make([]byte, 0, 2+len(term))
+	start = append(start, 'w')
+	start = append(start, term...)
+	if !prefix {
+		start = append(start, 0)
+	}
+	it := qs.reader.NewIterator(util.BytesPrefix(start), qs.readopts)
+	defer it.Release()
+	for it.Next() {
+		k := it.Key()
+		n, _ := binary.Uvarint(it.Value())
+		fn(Token(append([]byte("z"), k[len(k)-hashSize:]...)), int(n))
+	}
+	return it.Error()
+}
+
+// Snapshot returns a read-only view of the QuadStore as it is at the time of
+// the call. Closing the returned QuadStore releases the snapshot.
+func (qs *QuadStore) Snapshot() (graph.QuadStore, error) {
+	snap, err := qs.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	out := *qs
+	out.reader = snap
+	out.snap = snap
+	return &out, nil
+}
+
+// PredicateStats returns the statistics of the quads with the predicate p.
+func (qs *QuadStore) PredicateStats(p graph.Value) (graph.PredicateStats, bool) {
+	if !qs.stats {
+		return graph.PredicateStats{}, false
+	}
+	stats, err := qs.getPredicateStats(createStatsKeyFor(p.(Token)[1:]))
+	if err != nil {
+		glog.Errorf("Error reading predicate statistics: %v", err)
+		return graph.PredicateStats{}, false
+	}
+	return stats, true
+}
+
+func (qs *QuadStore) getPredicateStats(key []byte) (graph.PredicateStats, error) {
+	var stats graph.PredicateStats
+	b, err := qs.reader.Get(key, qs.readopts)
+	if err == leveldb.ErrNotFound {
+		return stats, nil
+	} else if err != nil {
+		return stats, err
+	}
+	err = json.Unmarshal(b, &stats)
+	return stats, err
+}
+
+func (qs *QuadStore) getFanout(k graph.FanoutKey) (int64, error) {
+	b, err := qs.reader.Get(qs.createFanoutKeyFor(k), qs.readopts)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b)), nil
+}
+
+// writeStats adds the statistics and fanout counts changed by u to batch.
+func (qs *QuadStore) writeStats(batch *leveldb.Batch, u *graph.PredicateStatsUpdate) error {
+	for k, n := range u.Counts {
+		putCount(batch, qs.createFanoutKeyFor(k), n)
+	}
+	for p, stats := range u.Stats {
+		err := putStats(batch, createStatsKeyFor(hashOf(p)), stats)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putCount writes the fanout count n to key, deleting it if it is zero.
+func putCount(batch *leveldb.Batch, key []byte, n int64) {
+	if n == 0 {
+		batch.Delete(key)
+		return
+	}
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(n))
+	batch.Put(key, b)
+}
+
+// putStats writes the statistics of a predicate to key, deleting them if it
+// has no quads.
+func putStats(batch *leveldb.Batch, key []byte, stats *graph.PredicateStats) error {
+	if stats.Quads == 0 {
+		batch.Delete(key)
+		return nil
+	}
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	batch.Put(key, b)
+	return nil
+}
+
 func (qs *QuadStore) Close() {
+	if qs.snap != nil {
+		qs.snap.Release()
+		return
+	}
 	buf := new(bytes.Buffer)
 	err := binary.Write(buf, binary.LittleEndian, qs.size)
 	if err == nil {
@@ -360,7 +685,7 @@ func (qs *QuadStore) Close() {
 
 func (qs *QuadStore) Quad(k graph.Value) quad.Quad {
 	var q quad.Quad
-	b, err := qs.db.Get(k.(Token), qs.readopts)
+	b, err := qs.reader.Get(k.(Token), qs.readopts)
 	if err != nil && err != leveldb.ErrNotFound {
 		glog.Error("Error: could not get quad from DB.")
 		return quad.Quad{}
@@ -386,7 +711,7 @@ func (qs *QuadStore) valueData(key []byte) ValueData {
 	if glog.V(3) {
 		glog.V(3).Infof("%c %v", key[0], key)
 	}
-	b, err := qs.db.Get(key, qs.readopts)
+	b, err := qs.reader.Get(key, qs.readopts)
 	if err != nil && err != leveldb.ErrNotFound {
 		glog.Errorln("Error: could not get value from DB")
 		return out
@@ -418,7 +743,7 @@ func (qs *QuadStore) SizeOf(k graph.Value) int64 {
 
 func (qs *QuadStore) getInt64ForKey(key string, empty int64) (int64, error) {
 	var out int64
-	b, err := qs.db.Get([]byte(key), qs.readopts)
+	b, err := qs.reader.Get([]byte(key), qs.readopts)
 	if err != nil && err != leveldb.ErrNotFound {
 		glog.Errorln("could not read " + key + ": " + err.Error())
 		return 0, err
@@ -443,6 +768,35 @@ func (qs *QuadStore) getMetadata() error {
 		return err
 	}
 	qs.horizon, err = qs.getInt64ForKey("__horizon", 0)
+	if err != nil {
+		return err
+	}
+	_, err = qs.reader.Get([]byte("__bulk"), qs.readopts)
+	if err == nil {
+		return graph.ErrIncompleteBulkLoad
+	} else if err != leveldb.ErrNotFound {
+		return err
+	}
+	_, err = qs.reader.Get([]byte("__search"), qs.readopts)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	qs.search = err == nil
+	// Databases created before the predicate statistics or the name index
+	// have no marker for them.
+	_, err = qs.reader.Get([]byte("__stats"), qs.readopts)
+	if err == leveldb.ErrNotFound {
+		glog.Infoln("leveldb: no predicate statistics; run fsck with -repair to collect them")
+	} else if err != nil {
+		return err
+	}
+	qs.stats = err == nil
+	_, err = qs.reader.Get([]byte("__names"), qs.readopts)
+	if err == leveldb.ErrNotFound {
+		glog.Infoln("leveldb: no name index; run fsck with -repair to create one")
+		return nil
+	}
+	qs.names = err == nil
 	return err
 }
 
@@ -478,6 +832,50 @@ func (qs *QuadStore) QuadIterator(d quad.Direction, val graph.Value) graph.Itera
 	return NewIterator(prefix, d, val, qs)
 }
 
+// indexOrder returns the order of the directions in the keys of the index
+// with the prefix.
+func indexOrder(prefix string) [4]quad.Direction {
+	for _, order := range [][4]quad.Direction{spo, osp, pos, cps} {
+		if prefix == string([]byte{order[0].Prefix(), order[1].Prefix()}) {
+			return order
+		}
+	}
+	panic("unreachable " + prefix)
+}
+
+// SortedQuadIterator returns the quads with all of links ordered by their node
+// in direction d. An index can be read if its keys start with the linked
+// directions followed by d; the quads are otherwise sorted in memory.
+func (qs *QuadStore) SortedQuadIterator(links []graph.Linkage, d quad.Direction) (graph.Seeker, bool) {
+	linked := make(map[quad.Direction]bool, len(links))
+	for _, l := range links {
+		linked[l.Dir] = true
+	}
+	for _, order := range [][4]quad.Direction{spo, osp, pos, cps} {
+		if len(links) >= len(order) || order[len(links)] != d {
+			continue
+		}
+		// Quads without a label are missing from the cps index.
+		if order == cps && !linked[quad.Label] {
+			continue
+		}
+		ok := true
+		for _, od := range order[:len(links)] {
+			ok = ok && linked[od]
+		}
+		if ok && len(linked) == len(links) {
+			prefix := string([]byte{order[0].Prefix(), order[1].Prefix()})
+			return newIterator(prefix, links, qs), true
+		}
+	}
+	return iterator.NewSorted(qs, qs.CompareValues, links, d), false
+}
+
+// CompareValues orders nodes by the hashes of their names, as the indexes do.
+func (qs *QuadStore) CompareValues(a, b graph.Value) int {
+	return bytes.Compare(a.(Token), b.(Token))
+}
+
 func (qs *QuadStore) NodesAllIterator() graph.Iterator {
 	return NewAllIterator("z", quad.Any, qs)
 }
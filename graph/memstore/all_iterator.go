@@ -22,6 +22,10 @@ import (
 type AllIterator struct {
 	iterator.Int64
 	qs *QuadStore
+
+	// part is set on the iterators of a partition, which keep their range
+	// when cloned; others range over all the IDs in use when cloned.
+	part bool
 }
 
 type (
@@ -52,10 +56,48 @@ func (it *nodesAllIterator) Next() bool {
 	return true
 }
 
+// NextBatch fills dst with the IDs in use by nodes.
+func (it *nodesAllIterator) NextBatch(dst []graph.Value) int {
+	for {
+		n := it.Int64.NextBatch(dst)
+		if n == 0 {
+			return 0
+		}
+		var kept int
+		for _, v := range dst[:n] {
+			if _, ok := it.qs.revIDMap[v.(int64)]; ok {
+				dst[kept] = v
+				kept++
+			}
+		}
+		if kept > 0 {
+			return kept
+		}
+	}
+}
+
+// Partition splits the IDs into ranges.
+func (it *nodesAllIterator) Partition(n int) []graph.Iterator {
+	parts := it.Int64.Partition(n)
+	for i, part := range parts {
+		parts[i] = &nodesAllIterator{Int64: *part.(*iterator.Int64), qs: it.qs, part: true}
+	}
+	return parts
+}
+
 func (it *nodesAllIterator) Err() error {
 	return nil
 }
 
+func (it *nodesAllIterator) Clone() graph.Iterator {
+	out := newNodesAllIterator(it.qs)
+	if it.part {
+		out.Int64, out.part = *it.Int64.Clone().(*iterator.Int64), true
+	}
+	out.Tagger().CopyFrom(it)
+	return out
+}
+
 func newQuadsAllIterator(qs *QuadStore) *quadsAllIterator {
 	var out quadsAllIterator
 	out.Int64 = *iterator.NewInt64(1, qs.nextQuadID-1)
@@ -63,6 +105,15 @@ func newQuadsAllIterator(qs *QuadStore) *quadsAllIterator {
 	return &out
 }
 
+func (it *quadsAllIterator) Clone() graph.Iterator {
+	out := newQuadsAllIterator(it.qs)
+	if it.part {
+		out.Int64, out.part = *it.Int64.Clone().(*iterator.Int64), true
+	}
+	out.Tagger().CopyFrom(it)
+	return out
+}
+
 func (it *quadsAllIterator) Next() bool {
 	out := it.Int64.Next()
 	if out {
@@ -74,5 +125,37 @@ func (it *quadsAllIterator) Next() bool {
 	return out
 }
 
-var _ graph.Nexter = &nodesAllIterator{}
-var _ graph.Nexter = &quadsAllIterator{}
+// NextBatch fills dst with the IDs of quads that are in the store.
+func (it *quadsAllIterator) NextBatch(dst []graph.Value) int {
+	for {
+		n := it.Int64.NextBatch(dst)
+		if n == 0 {
+			return 0
+		}
+		var kept int
+		for _, v := range dst[:n] {
+			i64 := v.(int64)
+			if it.qs.log[i64].DeletedBy == 0 && it.qs.log[i64].Action != graph.Delete {
+				dst[kept] = v
+				kept++
+			}
+		}
+		if kept > 0 {
+			return kept
+		}
+	}
+}
+
+// Partition splits the IDs into ranges.
+func (it *quadsAllIterator) Partition(n int) []graph.Iterator {
+	parts := it.Int64.Partition(n)
+	for i, part := range parts {
+		parts[i] = &quadsAllIterator{Int64: *part.(*iterator.Int64), qs: it.qs, part: true}
+	}
+	return parts
+}
+
+var _ graph.BatchNexter = &nodesAllIterator{}
+var _ graph.BatchNexter = &quadsAllIterator{}
+var _ graph.Partitioner = &nodesAllIterator{}
+var _ graph.Partitioner = &quadsAllIterator{}
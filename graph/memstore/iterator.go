@@ -32,6 +32,9 @@ type Iterator struct {
 	data   string
 	result graph.Value
 	err    error
+
+	// linkage is the linkage of the quads in tree, if it is an index.
+	linkage *graph.Linkage
 }
 
 func NewIterator(tree *b.Tree, data string, qs *QuadStore) *Iterator {
@@ -96,12 +99,23 @@ func (it *Iterator) Clone() graph.Iterator {
 		tree: it.tree,
 		iter: iter,
 		data: it.data,
+
+		linkage: it.linkage,
 	}
 	m.tags.CopyFrom(it)
 
 	return m
 }
 
+// Linkage returns the direction and value the iterator was created for by
+// QuadIterator.
+func (it *Iterator) Linkage() (graph.Linkage, bool) {
+	if it.linkage == nil {
+		return graph.Linkage{}, false
+	}
+	return *it.linkage, true
+}
+
 func (it *Iterator) Close() error {
 	return nil
 }
@@ -130,6 +144,28 @@ func (it *Iterator) Next() bool {
 	return graph.NextLogOut(it, it.result, true)
 }
 
+// NextBatch fills dst with the following quads of the index.
+func (it *Iterator) NextBatch(dst []graph.Value) int {
+	if it.iter == nil {
+		return 0
+	}
+	var n int
+	for n < len(dst) {
+		result, _, err := it.iter.Next()
+		if err != nil {
+			if err != io.EOF {
+				it.err = err
+			}
+			break
+		}
+		if it.checkValid(result) {
+			dst[n] = result
+			n++
+		}
+	}
+	return n
+}
+
 func (it *Iterator) Err() error {
 	return it.err
 }
@@ -195,4 +231,5 @@ func (it *Iterator) Stats() graph.IteratorStats {
 	}
 }
 
-var _ graph.Nexter = &Iterator{}
+var _ graph.BatchNexter = &Iterator{}
+var _ graph.LinkageIterator = &Iterator{}
@@ -17,6 +17,9 @@ package memstore
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/barakmich/glog"
@@ -24,14 +27,23 @@ import (
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
 	"github.com/google/cayley/graph/memstore/b"
+	"github.com/google/cayley/graph/search"
 	"github.com/google/cayley/quad"
 )
 
 const QuadStoreType = "memstore"
 
 func init() {
-	graph.RegisterQuadStore(QuadStoreType, false, func(string, graph.Options) (graph.QuadStore, error) {
-		return newQuadStore(), nil
+	graph.RegisterQuadStore(QuadStoreType, false, func(_ string, options graph.Options) (graph.QuadStore, error) {
+		qs := newQuadStore()
+		enable, _, err := options.BoolKey("search")
+		if err != nil {
+			return nil, err
+		}
+		if enable {
+			qs.enableSearch()
+		}
+		return qs, nil
 	}, nil, nil)
 }
 
@@ -81,6 +93,10 @@ type LogEntry struct {
 }
 
 type QuadStore struct {
+	// mu serializes writes, so that preconditions are checked
+	// against the state the deltas are applied to.
+	mu sync.Mutex
+
 	nextID     int64
 	nextQuadID int64
 	idMap      map[string]int64
@@ -88,6 +104,28 @@ type QuadStore struct {
 	log        []LogEntry
 	size       int64
 	index      QuadDirectionIndex
+
+	// stats holds the statistics of each predicate, and fanout the number
+	// of quads of each FanoutKey; statsMu guards both.
+	statsMu sync.Mutex
+	stats   map[string]graph.PredicateStats
+	fanout  map[graph.FanoutKey]int64
+
+	// names holds the names of all nodes, of which the first sorted are in
+	// order; namesMu guards both.
+	namesMu sync.Mutex
+	names   []string
+	sorted  int
+
+	// postings is the search index, if enabled, of the terms in the names of
+	// the nodes in quads, which are counted in refs. Of the terms of the
+	// index, the first sortedTerms are in order, and any others may not be;
+	// searchMu guards all but refs.
+	searchMu    sync.Mutex
+	postings    map[string]map[int64]int
+	terms       []string
+	sortedTerms int
+	refs        map[int64]int
 	// vip_index map[string]map[int64]map[string]map[int64]*b.Tree
 }
 
@@ -100,55 +138,114 @@ func newQuadStore() *QuadStore {
 		log: make([]LogEntry, 1, 200),
 
 		index:      NewQuadDirectionIndex(),
+		stats:      make(map[string]graph.PredicateStats),
+		fanout:     make(map[graph.FanoutKey]int64),
 		nextID:     1,
 		nextQuadID: 1,
 	}
 }
 
 func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, ignoreOpts graph.IgnoreOpts) error {
-	// Precheck the whole transaction
-	for _, d := range deltas {
+	return qs.ApplyDeltasIf(deltas, nil, ignoreOpts)
+}
+
+// ApplyDeltasIf applies deltas if all of conds hold, checking them under the
+// same lock as the write.
+func (qs *QuadStore) ApplyDeltasIf(deltas []graph.Delta, conds []graph.Precondition, ignoreOpts graph.IgnoreOpts) error {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	err := graph.CheckPreconditions(conds, qs.Horizon(), func(q quad.Quad) (bool, error) {
+		_, exists := qs.indexOf(q)
+		return exists, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Precheck the whole transaction, tracking the effect of earlier deltas
+	// so that nothing is applied unless every delta can be.
+	apply := make([]bool, len(deltas))
+	pending := make(map[quad.Quad]bool)
+	for i, d := range deltas {
+		exists, ok := pending[d.Quad]
+		if !ok {
+			_, exists = qs.indexOf(d.Quad)
+		}
 		switch d.Action {
 		case graph.Add:
-			if !ignoreOpts.IgnoreDup {
-				if _, exists := qs.indexOf(d.Quad); exists {
+			if exists {
+				if !ignoreOpts.IgnoreDup {
 					return graph.ErrQuadExists
 				}
+				continue
 			}
 		case graph.Delete:
-			if !ignoreOpts.IgnoreMissing {
-				if _, exists := qs.indexOf(d.Quad); !exists {
+			if !exists {
+				if !ignoreOpts.IgnoreMissing {
 					return graph.ErrQuadNotExist
 				}
+				continue
 			}
 		default:
 			return errors.New("memstore: invalid action")
 		}
+		pending[d.Quad] = d.Action == graph.Add
+		apply[i] = true
 	}
 
-	for _, d := range deltas {
+	qs.statsMu.Lock()
+	defer qs.statsMu.Unlock()
+	stats := graph.NewPredicateStatsUpdate(func(k graph.FanoutKey) (int64, error) {
+		return qs.fanout[k], nil
+	}, func(p string) (graph.PredicateStats, error) {
+		return qs.stats[p], nil
+	})
+	for i, d := range deltas {
+		if !apply[i] {
+			continue
+		}
 		var err error
+		n := int64(1)
+		if d.Action == graph.Delete {
+			n = -1
+		}
+		stats.Add(d.Quad, n)
 		switch d.Action {
 		case graph.Add:
 			err = qs.AddDelta(d)
-			if err != nil && ignoreOpts.IgnoreDup {
-				err = nil
-			}
 		case graph.Delete:
 			err = qs.RemoveDelta(d)
-			if err != nil && ignoreOpts.IgnoreMissing {
-				err = nil
-			}
 		default:
 			panic("memstore: unexpected invalid action")
 		}
 		if err != nil {
-			return err
+			panic("memstore: unexpected error applying checked delta: " + err.Error())
+		}
+	}
+	for k, n := range stats.Counts {
+		if n == 0 {
+			delete(qs.fanout, k)
+		} else {
+			qs.fanout[k] = n
+		}
+	}
+	for p, st := range stats.Stats {
+		if st.Quads == 0 {
+			delete(qs.stats, p)
+		} else {
+			qs.stats[p] = *st
 		}
 	}
 	return nil
 }
 
+// PredicateStats returns the statistics of the quads with the predicate p.
+func (qs *QuadStore) PredicateStats(p graph.Value) (graph.PredicateStats, bool) {
+	qs.statsMu.Lock()
+	defer qs.statsMu.Unlock()
+	return qs.stats[qs.NameOf(p)], true
+}
+
 const maxInt = int(^uint(0) >> 1)
 
 func (qs *QuadStore) indexOf(t quad.Quad) (int64, bool) {
@@ -206,16 +303,41 @@ func (qs *QuadStore) AddDelta(d graph.Delta) error {
 			qs.idMap[sid] = qs.nextID
 			qs.revIDMap[qs.nextID] = sid
 			qs.nextID++
+			qs.namesMu.Lock()
+			qs.names = append(qs.names, sid)
+			qs.namesMu.Unlock()
 		}
 		id := qs.idMap[sid]
 		tree := qs.index.Tree(dir, id)
 		tree.Set(qid, struct{}{})
+		if qs.refs != nil {
+			qs.refs[id]++
+			if qs.refs[id] == 1 {
+				qs.indexTerms(id, sid, true)
+			}
+		}
 	}
 
 	// TODO(barakmich): Add VIP indexing
 	return nil
 }
 
+// nodesWithPrefix returns the IDs of the nodes with names starting with
+// prefix, in order of their names.
+func (qs *QuadStore) nodesWithPrefix(prefix string) []int64 {
+	qs.namesMu.Lock()
+	defer qs.namesMu.Unlock()
+	if qs.sorted < len(qs.names) {
+		sort.Strings(qs.names)
+		qs.sorted = len(qs.names)
+	}
+	var ids []int64
+	for i := sort.SearchStrings(qs.names, prefix); i < len(qs.names) && strings.HasPrefix(qs.names[i], prefix); i++ {
+		ids = append(ids, qs.idMap[qs.names[i]])
+	}
+	return ids
+}
+
 func (qs *QuadStore) RemoveDelta(d graph.Delta) error {
 	prevQuadID, exists := qs.indexOf(d.Quad)
 	if !exists {
@@ -231,6 +353,101 @@ func (qs *QuadStore) RemoveDelta(d graph.Delta) error {
 	qs.log[prevQuadID].DeletedBy = quadID
 	qs.size--
 	qs.nextQuadID++
+
+	if qs.refs != nil {
+		for dir := quad.Subject; dir <= quad.Label; dir++ {
+			sid := d.Quad.Get(dir)
+			if dir == quad.Label && sid == "" {
+				continue
+			}
+			id := qs.idMap[sid]
+			qs.refs[id]--
+			if qs.refs[id] == 0 {
+				delete(qs.refs, id)
+				qs.indexTerms(id, sid, false)
+			}
+		}
+	}
+	return nil
+}
+
+// enableSearch makes the QuadStore maintain a search index.
+func (qs *QuadStore) enableSearch() {
+	qs.postings = make(map[string]map[int64]int)
+	qs.refs = make(map[int64]int)
+}
+
+// indexTerms adds the terms of the name of node id to the search index, or
+// removes them.
+func (qs *QuadStore) indexTerms(id int64, name string, add bool) {
+	qs.searchMu.Lock()
+	defer qs.searchMu.Unlock()
+	for term, n := range search.Terms(name) {
+		nodes := qs.postings[term]
+		if !add {
+			delete(nodes, id)
+			if len(nodes) == 0 {
+				delete(qs.postings, term)
+			}
+			continue
+		}
+		if nodes == nil {
+			nodes = make(map[int64]int)
+			qs.postings[term] = nodes
+			qs.terms = append(qs.terms, term)
+		}
+		nodes[id] = n
+	}
+}
+
+// Postings calls fn with the nodes with the term, or with terms starting with
+// it, in the search index.
+func (qs *QuadStore) Postings(term string, prefix bool, fn func(graph.Value, int)) error {
+	qs.searchMu.Lock()
+	defer qs.searchMu.Unlock()
+	if qs.postings == nil {
+		return search.ErrNoIndex
+	}
+	if !prefix {
+		for id, n := range qs.postings[term] {
+			fn(id, n)
+		}
+		return nil
+	}
+	if qs.sortedTerms < len(qs.terms) {
+		// Terms that have been removed, or that have been added again since,
+		// are dropped as they are sorted.
+		sort.Strings(qs.terms)
+		terms := qs.terms[:0]
+		for _, t := range qs.terms {
+			if qs.postings[t] != nil && (len(terms) == 0 || t != terms[len(terms)-1]) {
+				terms = append(terms, t)
+			}
+		}
+		qs.terms = terms
+		qs.sortedTerms = len(terms)
+	}
+	for i := sort.SearchStrings(qs.terms, term); i < len(qs.terms) && strings.HasPrefix(qs.terms[i], term); i++ {
+		for id, n := range qs.postings[qs.terms[i]] {
+			fn(id, n)
+		}
+	}
+	return nil
+}
+
+// Deltas calls fn with each delta in the QuadStore's log, in order.
+func (qs *QuadStore) Deltas(fn func(graph.Delta) error) error {
+	for _, l := range qs.log[1:] {
+		err := fn(graph.Delta{
+			ID:        graph.NewSequentialKey(l.ID),
+			Quad:      l.Quad,
+			Action:    l.Action,
+			Timestamp: l.Timestamp,
+		})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -242,11 +459,25 @@ func (qs *QuadStore) QuadIterator(d quad.Direction, value graph.Value) graph.Ite
 	index, ok := qs.index.Get(d, value.(int64))
 	data := fmt.Sprintf("dir:%s val:%d", d, value.(int64))
 	if ok {
-		return NewIterator(index, data, qs)
+		it := NewIterator(index, data, qs)
+		it.linkage = &graph.Linkage{Dir: d, Value: value}
+		return it
 	}
 	return &iterator.Null{}
 }
 
+// SortedQuadIterator returns the quads with all of links ordered by their node
+// in direction d. The indexes of a memstore are ordered by quad, so the quads
+// are sorted in memory.
+func (qs *QuadStore) SortedQuadIterator(links []graph.Linkage, d quad.Direction) (graph.Seeker, bool) {
+	return iterator.NewSorted(qs, qs.CompareValues, links, d), false
+}
+
+// CompareValues orders nodes by their ID.
+func (qs *QuadStore) CompareValues(a, b graph.Value) int {
+	return cmp(a.(int64), b.(int64))
+}
+
 func (qs *QuadStore) Horizon() graph.PrimaryKey {
 	return graph.NewSequentialKey(qs.log[len(qs.log)-1].ID)
 }
@@ -23,6 +23,8 @@ func (qs *QuadStore) OptimizeIterator(it graph.Iterator) (graph.Iterator, bool)
 	switch it.Type() {
 	case graph.LinksTo:
 		return qs.optimizeLinksTo(it.(*iterator.LinksTo))
+	case graph.Regex:
+		return qs.optimizeRegex(it.(*iterator.Regex))
 
 	}
 	return it, false
@@ -53,3 +55,26 @@ func (qs *QuadStore) optimizeLinksTo(it *iterator.LinksTo) (graph.Iterator, bool
 	it.Close()
 	return it, false
 }
+
+// optimizeRegex replaces a Regex over all nodes with the nodes in the range of
+// names starting with its prefix, which are still matched against the Regex if
+// it asks for more than the prefix.
+func (qs *QuadStore) optimizeRegex(it *iterator.Regex) (graph.Iterator, bool) {
+	prefix, complete := it.Prefix()
+	all, ok := it.SubIterators()[0].(*nodesAllIterator)
+	if !ok || prefix == "" {
+		return it, false
+	}
+	fixed := qs.FixedIterator()
+	for _, id := range qs.nodesWithPrefix(prefix) {
+		fixed.Add(id)
+	}
+	fixed.Tagger().CopyFrom(all)
+	if !complete {
+		out := iterator.NewRegex(fixed, it.Regexp(), qs)
+		out.Tagger().CopyFrom(it)
+		return out, true
+	}
+	fixed.Tagger().CopyFrom(it)
+	return fixed, true
+}
@@ -15,12 +15,16 @@
 package memstore
 
 import (
+	"fmt"
 	"reflect"
+	"regexp"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/graph/search"
 	"github.com/google/cayley/quad"
 	"github.com/google/cayley/writer"
 )
@@ -177,6 +181,169 @@ func TestLinksToOptimization(t *testing.T) {
 	}
 }
 
+func names(qs graph.QuadStore, vals []graph.Value) []string {
+	var out []string
+	for _, v := range vals {
+		out = append(out, qs.NameOf(v))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func quads(qs graph.QuadStore, vals []graph.Value) []string {
+	var out []string
+	for _, v := range vals {
+		out = append(out, qs.Quad(v).String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestResolveToFixed(t *testing.T) {
+	qs, _, _ := makeTestStore(simpleGraph)
+
+	// The nodes that D follows.
+	hasa := func(tag string) graph.Iterator {
+		d := qs.FixedIterator()
+		d.Add(qs.ValueOf("D"))
+		if tag != "" {
+			d.Tagger().Add(tag)
+		}
+		pred := qs.FixedIterator()
+		pred.Add(qs.ValueOf("follows"))
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(iterator.NewLinksTo(qs, d, quad.Subject))
+		and.AddSubIterator(iterator.NewLinksTo(qs, pred, quad.Predicate))
+		it := iterator.NewHasA(qs, and, quad.Object)
+		it.Tagger().Add("followed")
+		return it
+	}
+	expect := names(qs, nexted(hasa("")))
+	it, changed := hasa("").Optimize()
+	if !changed || it.Type() != graph.Fixed {
+		t.Fatalf("Expected a fixed iterator, got:%v", it.Type())
+	}
+	if got := names(qs, nexted(it)); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected results, got:%v expect:%v", got, expect)
+	}
+	if !it.Contains(qs.ValueOf("G")) {
+		t.Error("Expected D to follow G")
+	}
+	tags := make(map[string]graph.Value)
+	it.TagResults(tags)
+	if got := qs.NameOf(tags["followed"]); got != "G" {
+		t.Errorf("Unexpected tag, got:%q expect:%q", got, "G")
+	}
+	if it, _ := hasa("d").Optimize(); it.Type() == graph.Fixed {
+		t.Error("Unexpected resolution of a tagged subiterator")
+	}
+
+	// The quads about B or D.
+	linksto := func() graph.Iterator {
+		nodes := qs.FixedIterator()
+		nodes.Add(qs.ValueOf("B"))
+		nodes.Add(qs.ValueOf("D"))
+		return iterator.NewLinksTo(qs, nodes, quad.Subject)
+	}
+	expect = quads(qs, nexted(linksto()))
+	it, changed = linksto().Optimize()
+	if !changed || it.Type() != graph.Fixed {
+		t.Fatalf("Expected a fixed iterator, got:%v", it.Type())
+	}
+	if got := quads(qs, nexted(it)); !reflect.DeepEqual(got, expect) || len(got) != 5 {
+		t.Errorf("Unexpected results, got:%v expect:%v", got, expect)
+	}
+}
+
+func TestRegexToRange(t *testing.T) {
+	qs, w, _ := makeTestStore(simpleGraph)
+
+	it, changed := iterator.NewPrefix(qs.NodesAllIterator(), "st", qs).Optimize()
+	if !changed || it.Type() != graph.Fixed {
+		t.Fatalf("Expected a fixed iterator, got:%v", it.Type())
+	}
+	expect := []string{"status", "status_graph"}
+	if got := names(qs, nexted(it)); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected results, got:%v expect:%v", got, expect)
+	}
+
+	re := iterator.NewRegex(qs.NodesAllIterator(), regexp.MustCompile("^st.*h$"), qs)
+	re.Tagger().Add("node")
+	it, changed = re.Optimize()
+	if !changed || it.Type() != graph.Regex || it.SubIterators()[0].Type() != graph.Fixed {
+		t.Fatalf("Expected a regex over a fixed iterator, got:%v", it.Type())
+	}
+	if !graph.Next(it) {
+		t.Fatal("Expected a match")
+	}
+	tags := make(map[string]graph.Value)
+	it.TagResults(tags)
+	if got := qs.NameOf(tags["node"]); got != "status_graph" {
+		t.Errorf("Unexpected tag, got:%q expect:%q", got, "status_graph")
+	}
+	if graph.Next(it) {
+		t.Errorf("Unexpected match %q", qs.NameOf(it.Result()))
+	}
+
+	// Nodes added after the names were sorted are found.
+	w.AddQuad(quad.Quad{"stella", "follows", "A", ""})
+	it, _ = iterator.NewPrefix(qs.NodesAllIterator(), "st", qs).Optimize()
+	expect = []string{"status", "status_graph", "stella"}
+	if got := names(qs, nexted(it)); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected results, got:%v expect:%v", got, expect)
+	}
+	if it, changed := iterator.NewRegex(qs.NodesAllIterator(), regexp.MustCompile("a$"), qs).Optimize(); changed {
+		t.Errorf("Unexpected optimization of an unanchored regex to %v", it.Type())
+	}
+}
+
+// searched returns the names of the results of a search, in order.
+func searched(qs graph.QuadStore, query string) []string {
+	var out []string
+	for _, v := range nexted(search.NewSearch(qs, query)) {
+		out = append(out, qs.NameOf(v))
+	}
+	return out
+}
+
+func TestSearch(t *testing.T) {
+	qs, _, _ := makeTestStore(simpleGraph)
+	it := search.NewSearch(qs, "cool")
+	if graph.Next(it) || it.Err() != search.ErrNoIndex {
+		t.Errorf("Unexpected search without an index, got error:%v", it.Err())
+	}
+
+	qs = newQuadStore()
+	qs.enableSearch()
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet([]quad.Quad{
+		{"alice", "says", `"Cats chase cats"`, ""},
+		{"bob", "says", `"A cat sleeps"`, ""},
+		{"bob", "name", `"Bob Catt"`, ""},
+	})
+	expect := []string{`"Cats chase cats"`, `"A cat sleeps"`, `"Bob Catt"`}
+	if got := searched(qs, "cat"); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected results, got:%v expect:%v", got, expect)
+	}
+
+	// Nodes are dropped from the index with their last quad.
+	w.RemoveQuad(quad.Quad{"alice", "says", `"Cats chase cats"`, ""})
+	w.RemoveQuad(quad.Quad{"bob", "says", `"A cat sleeps"`, ""})
+	expect = []string{`"Bob Catt"`}
+	if got := searched(qs, "cat"); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected results, got:%v expect:%v", got, expect)
+	}
+	if got := searched(qs, "says"); len(got) != 0 {
+		t.Errorf("Unexpected results, got:%v", got)
+	}
+
+	w.AddQuad(quad.Quad{"carol", "says", `"Cats chase cats"`, ""})
+	expect = []string{`"Cats chase cats"`, `"Bob Catt"`, "carol"}
+	if got := searched(qs, "ca"); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected results, got:%v expect:%v", got, expect)
+	}
+}
+
 func TestRemoveQuad(t *testing.T) {
 	qs, w, _ := makeTestStore(simpleGraph)
 
@@ -233,3 +400,471 @@ func TestTransaction(t *testing.T) {
 		t.Error("Appended a new quad in a failed transaction")
 	}
 }
+
+func TestTransactionInOrder(t *testing.T) {
+	qs, w, _ := makeTestStore(simpleGraph)
+	size := qs.Size()
+
+	// Replace a quad and then fail on a duplicate added in the same transaction.
+	tx := graph.NewTransaction()
+	tx.RemoveQuad(quad.Quad{"E", "follows", "F", ""})
+	tx.AddQuad(quad.Quad{"E", "follows", "G", ""})
+	tx.AddQuad(quad.Quad{"E", "follows", "G", ""})
+	err := w.ApplyTransaction(tx)
+	if err != graph.ErrQuadExists {
+		t.Errorf("Unexpected error for duplicate add, got:%v expect:%v", err, graph.ErrQuadExists)
+	}
+	if size != qs.Size() {
+		t.Error("Applied part of a failed transaction")
+	}
+	if _, exists := qs.indexOf(quad.Quad{"E", "follows", "F", ""}); !exists {
+		t.Error("Removed a quad in a failed transaction")
+	}
+
+	// A quad added and removed in the same transaction is not left behind.
+	tx = graph.NewTransaction()
+	tx.RemoveQuad(quad.Quad{"E", "follows", "F", ""})
+	tx.AddQuad(quad.Quad{"E", "follows", "G", ""})
+	tx.AddQuad(quad.Quad{"G", "follows", "E", ""})
+	tx.RemoveQuad(quad.Quad{"G", "follows", "E", ""})
+	err = w.ApplyTransaction(tx)
+	if err != nil {
+		t.Fatalf("Failed to apply transaction: %v", err)
+	}
+	if size != qs.Size() {
+		t.Errorf("Unexpected size after replacing a quad, got:%d expect:%d", qs.Size(), size)
+	}
+	if _, exists := qs.indexOf(quad.Quad{"E", "follows", "G", ""}); !exists {
+		t.Error("Failed to add quad in transaction")
+	}
+	if _, exists := qs.indexOf(quad.Quad{"G", "follows", "E", ""}); exists {
+		t.Error("Quad removed in transaction still exists")
+	}
+}
+
+func TestTransactionPreconditions(t *testing.T) {
+	qs, w, _ := makeTestStore(simpleGraph)
+	size := qs.Size()
+
+	for _, test := range []struct {
+		cond   graph.Precondition
+		expect error
+	}{
+		{graph.Precondition{Condition: graph.QuadExists, Quad: quad.Quad{"E", "follows", "F", ""}}, nil},
+		{graph.Precondition{Condition: graph.QuadExists, Quad: quad.Quad{"E", "follows", "G", ""}}, graph.ErrPreconditionFailed},
+		{graph.Precondition{Condition: graph.QuadNotExist, Quad: quad.Quad{"E", "follows", "G", ""}}, nil},
+		{graph.Precondition{Condition: graph.QuadNotExist, Quad: quad.Quad{"E", "follows", "F", ""}}, graph.ErrPreconditionFailed},
+		{graph.Precondition{Condition: graph.HorizonEquals, Horizon: graph.NewSequentialKey(0)}, graph.ErrPreconditionFailed},
+	} {
+		err := qs.ApplyDeltasIf([]graph.Delta{{Quad: quad.Quad{"E", "follows", "H", ""}, Action: graph.Add}},
+			[]graph.Precondition{test.cond}, graph.IgnoreOpts{})
+		if err != test.expect {
+			t.Errorf("Unexpected error for precondition %v, got:%v expect:%v", test.cond, err, test.expect)
+		}
+		if err == nil {
+			w.RemoveQuad(quad.Quad{"E", "follows", "H", ""})
+		}
+	}
+	if qs.Size() != size {
+		t.Errorf("Unexpected size after conditional transactions, got:%d expect:%d", qs.Size(), size)
+	}
+
+	tx := graph.NewTransaction()
+	tx.RequireHorizon(qs.Horizon())
+	tx.AddQuad(quad.Quad{"E", "follows", "H", ""})
+	err := w.ApplyTransaction(tx)
+	if err != nil {
+		t.Errorf("Failed to apply transaction at the current horizon: %v", err)
+	}
+
+	// Stores that cannot check preconditions refuse conditional writes.
+	uw, _ := writer.NewSingleReplication(struct{ graph.QuadStore }{qs}, nil)
+	tx = graph.NewTransaction()
+	tx.RequireQuad(quad.Quad{"E", "follows", "H", ""})
+	tx.RemoveQuad(quad.Quad{"E", "follows", "H", ""})
+	err = uw.ApplyTransaction(tx)
+	if err != graph.ErrPreconditionsUnsupported {
+		t.Errorf("Unexpected error for unsupported preconditions, got:%v expect:%v", err, graph.ErrPreconditionsUnsupported)
+	}
+
+	// They also refuse several changes, which they cannot apply atomically,
+	// but still apply a single one.
+	tx = graph.NewTransaction()
+	tx.RemoveQuad(quad.Quad{"E", "follows", "H", ""})
+	tx.AddQuad(quad.Quad{"E", "follows", "I", ""})
+	err = uw.ApplyTransaction(tx)
+	if err != graph.ErrAtomicityUnsupported {
+		t.Errorf("Unexpected error for several changes, got:%v expect:%v", err, graph.ErrAtomicityUnsupported)
+	}
+	tx = graph.NewTransaction()
+	tx.RemoveQuad(quad.Quad{"E", "follows", "H", ""})
+	err = uw.ApplyTransaction(tx)
+	if err != nil {
+		t.Errorf("Failed to apply a single change: %v", err)
+	}
+}
+
+func TestPredicateStats(t *testing.T) {
+	qs, w, _ := makeTestStore(simpleGraph)
+
+	got, ok := qs.PredicateStats(qs.ValueOf("follows"))
+	if !ok {
+		t.Fatal("Expected predicate stats to be maintained")
+	}
+	expect := graph.PredicateStats{
+		Quads:         8,
+		Subjects:      6,
+		Objects:       4,
+		SubjectFanout: graph.Histogram{4, 2},
+		ObjectFanout:  graph.Histogram{1, 3},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected predicate stats, got:%+v expect:%+v", got, expect)
+	}
+	if f := got.Fanout(quad.Object); f != 2 {
+		t.Errorf("Unexpected object fanout, got:%d expect:2", f)
+	}
+	if f := got.WeightedFanout(quad.Object); f != 3 {
+		t.Errorf("Unexpected weighted object fanout, got:%d expect:3", f)
+	}
+
+	err := w.RemoveQuad(quad.Quad{"E", "follows", "F", ""})
+	if err != nil {
+		t.Fatal("Couldn't remove quad", err)
+	}
+	got, _ = qs.PredicateStats(qs.ValueOf("follows"))
+	if got.Quads != 7 || got.Subjects != 5 || got.Objects != 4 {
+		t.Errorf("Stale predicate stats after removal, got:%+v", got)
+	}
+}
+
+func TestLeapfrogJoin(t *testing.T) {
+	qs, _, _ := makeTestStore(simpleGraph)
+	follows := qs.ValueOf("follows")
+
+	// The only triangle in the graph is C->D->B with C->B.
+	join := iterator.NewLeapfrog(qs, []iterator.Pattern{
+		{quad.Subject: {Var: "a"}, quad.Predicate: {Value: follows}, quad.Object: {Var: "b"}},
+		{quad.Subject: {Var: "b"}, quad.Predicate: {Value: follows}, quad.Object: {Var: "c"}},
+		{quad.Subject: {Var: "a"}, quad.Predicate: {Value: follows}, quad.Object: {Var: "c"}},
+	}, "c")
+	for _, v := range []string{"a", "b", "c"} {
+		join.TagVariable(v, v)
+	}
+	var got []string
+	for graph.Next(join) {
+		tags := make(map[string]graph.Value)
+		join.TagResults(tags)
+		got = append(got, qs.NameOf(tags["a"])+qs.NameOf(tags["b"])+qs.NameOf(tags["c"]))
+	}
+	if expect := []string{"CDB"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected triangles, got:%v expect:%v", got, expect)
+	}
+
+	// Two hops out from C are left to the nested iterators, as a chain, but
+	// the first hop is resolved on its own.
+	out := func(from graph.Iterator) graph.Iterator {
+		pred := qs.FixedIterator()
+		pred.Add(follows)
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(iterator.NewLinksTo(qs, from, quad.Subject))
+		and.AddSubIterator(iterator.NewLinksTo(qs, pred, quad.Predicate))
+		return iterator.NewHasA(qs, and, quad.Object)
+	}
+	start := qs.FixedIterator()
+	start.Add(qs.ValueOf("C"))
+	via := out(start)
+	via.Tagger().Add("via")
+	it, _ := out(via).Optimize()
+	if it.Type() != graph.HasA {
+		t.Fatalf("Expected a nested HasA, got:%v", it.Type())
+	}
+	if resolved := find(it, "via"); resolved == nil || resolved.Type() != graph.Fixed {
+		t.Errorf("Expected the first hop to be resolved, got:%v", resolved)
+	}
+	if got, expect := tagPaths(qs, it, "via"), []string{"B D", "F B", "G D"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected results, got:%v expect:%v", got, expect)
+	}
+
+	it.Reset()
+	if !it.Contains(qs.ValueOf("G")) {
+		t.Error("Expected G two hops from C")
+	}
+	tags := make(map[string]graph.Value)
+	it.TagResults(tags)
+	if via := qs.NameOf(tags["via"]); via != "D" {
+		t.Errorf("Unexpected path to G, got:%q expect:%q", via, "D")
+	}
+	if it.NextPath() {
+		t.Error("Unexpected second path to G")
+	}
+	if it.Contains(qs.ValueOf("D")) {
+		t.Error("Unexpected D two hops from C")
+	}
+
+	// The links of any predicate to a node with G's status are a pattern of
+	// three variables, so they are joined once G's status is resolved.
+	links := func() graph.Iterator {
+		g := qs.FixedIterator()
+		g.Add(qs.ValueOf("G"))
+		status := qs.FixedIterator()
+		status.Add(qs.ValueOf("status"))
+		ofG := iterator.NewAnd(qs)
+		ofG.AddSubIterator(iterator.NewLinksTo(qs, g, quad.Subject))
+		ofG.AddSubIterator(iterator.NewLinksTo(qs, status, quad.Predicate))
+		attr := qs.FixedIterator()
+		attr.Add(qs.ValueOf("status"))
+		attr.Tagger().Add("attr")
+		same := iterator.NewAnd(qs)
+		same.AddSubIterator(iterator.NewLinksTo(qs, attr, quad.Predicate))
+		same.AddSubIterator(iterator.NewLinksTo(qs, iterator.NewHasA(qs, ofG, quad.Object), quad.Object))
+		target := iterator.NewHasA(qs, same, quad.Subject)
+		target.Tagger().Add("target")
+		source := qs.NodesAllIterator()
+		source.Tagger().Add("source")
+		pred := qs.NodesAllIterator()
+		pred.Tagger().Add("pred")
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(iterator.NewLinksTo(qs, source, quad.Subject))
+		and.AddSubIterator(iterator.NewLinksTo(qs, pred, quad.Predicate))
+		and.AddSubIterator(iterator.NewLinksTo(qs, target, quad.Object))
+		return iterator.NewHasA(qs, and, quad.Subject)
+	}
+	expect := tagPaths(qs, links(), "pred", "target")
+	it, changed := links().Optimize()
+	if !changed || it.Type() != graph.Leapfrog {
+		t.Fatalf("Expected a leapfrog join, got:%v", it.Type())
+	}
+	if name := it.Describe().Name; !strings.Contains(name, `"cool"`) {
+		t.Errorf("Expected G's status to be resolved, got:%s", name)
+	}
+	if got := tagPaths(qs, it, "pred", "target"); !reflect.DeepEqual(got, expect) || len(got) != 6 {
+		t.Errorf("Unexpected join results, got:%v expect:%v", got, expect)
+	}
+}
+
+// followers returns an iterator over the nodes that follow someone, once for
+// each node they follow.
+func followers(qs graph.QuadStore) graph.Iterator {
+	fixed := qs.FixedIterator()
+	fixed.Add(qs.ValueOf("follows"))
+	and := iterator.NewAnd(qs)
+	and.AddSubIterator(iterator.NewLinksTo(qs, fixed, quad.Predicate))
+	and.AddSubIterator(iterator.NewLinksTo(qs, qs.NodesAllIterator(), quad.Object))
+	return iterator.NewHasA(qs, and, quad.Subject)
+}
+
+// tagPaths returns every path of it, found by Next and NextPath, as the names
+// of its result and the given tags.
+func tagPaths(qs graph.QuadStore, it graph.Iterator, tags ...string) []string {
+	var out []string
+	for graph.Next(it) {
+		for {
+			m := make(map[string]graph.Value)
+			it.TagResults(m)
+			path := qs.NameOf(it.Result())
+			for _, tag := range tags {
+				path += " " + qs.NameOf(m[tag])
+			}
+			out = append(out, path)
+			if !it.NextPath() {
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// find returns the iterator of the tree rooted at it with the given tag.
+func find(it graph.Iterator, tag string) graph.Iterator {
+	for _, t := range it.Tagger().Tags() {
+		if t == tag {
+			return it
+		}
+	}
+	for _, sub := range it.SubIterators() {
+		if found := find(sub, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func TestLeapfrogDuplicates(t *testing.T) {
+	qs, _, _ := makeTestStore(append(simpleGraph,
+		quad.Quad{"C", "follows", "B", "smart_graph"},
+		quad.Quad{"D", "follows", "G", "smart_graph"},
+	))
+
+	// The links of any predicate to a cool node, a pattern of three
+	// variables. Each node iterator is tagged, so that none is resolved.
+	links := func() graph.Iterator {
+		status := qs.FixedIterator()
+		status.Add(qs.ValueOf("status"))
+		cool := qs.FixedIterator()
+		cool.Add(qs.ValueOf("cool"))
+		cool.Tagger().Add("cool")
+		isCool := iterator.NewAnd(qs)
+		isCool.AddSubIterator(iterator.NewLinksTo(qs, status, quad.Predicate))
+		isCool.AddSubIterator(iterator.NewLinksTo(qs, cool, quad.Object))
+		target := iterator.NewHasA(qs, isCool, quad.Subject)
+		target.Tagger().Add("target")
+		source := qs.NodesAllIterator()
+		source.Tagger().Add("source")
+		pred := qs.NodesAllIterator()
+		pred.Tagger().Add("pred")
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(iterator.NewLinksTo(qs, source, quad.Subject))
+		and.AddSubIterator(iterator.NewLinksTo(qs, pred, quad.Predicate))
+		and.AddSubIterator(iterator.NewLinksTo(qs, target, quad.Object))
+		return iterator.NewHasA(qs, and, quad.Subject)
+	}
+	// The quads differing only in their label are both counted.
+	expect := tagPaths(qs, links(), "pred", "target")
+	if len(expect) != 8 {
+		t.Errorf("Unexpected number of nested results, got:%d expect:8 (%v)", len(expect), expect)
+	}
+	it, changed := links().Optimize()
+	if !changed || it.Type() != graph.Leapfrog {
+		t.Fatalf("Expected a leapfrog join, got:%v", it.Type())
+	}
+	if got := tagPaths(qs, it, "pred", "target"); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected join results, got:%v expect:%v", got, expect)
+	}
+
+	it.Reset()
+	if !it.Contains(qs.ValueOf("D")) {
+		t.Fatal("Expected D to link to a cool node")
+	}
+	n := 1
+	for it.NextPath() {
+		n++
+	}
+	if n != 3 {
+		t.Errorf("Unexpected number of paths from D, got:%d expect:3", n)
+	}
+}
+
+func nexted(it graph.Iterator) []graph.Value {
+	var out []graph.Value
+	for graph.Next(it) {
+		out = append(out, it.Result())
+	}
+	return out
+}
+
+func batched(it graph.Iterator, size int) []graph.Value {
+	var out []graph.Value
+	batch := make([]graph.Value, size)
+	for {
+		n := graph.NextBatch(it, batch)
+		if n == 0 {
+			return out
+		}
+		out = append(out, batch[:n]...)
+	}
+}
+
+func TestNextBatch(t *testing.T) {
+	qs, w, _ := makeTestStore(simpleGraph)
+	w.RemoveQuad(quad.Quad{"E", "follows", "F", ""})
+
+	for _, test := range []struct {
+		name string
+		it   func() graph.Iterator
+	}{
+		{"nodes", qs.NodesAllIterator},
+		{"quads", qs.QuadsAllIterator},
+		{"index", func() graph.Iterator { return qs.QuadIterator(quad.Predicate, qs.ValueOf("follows")) }},
+		{"followers", func() graph.Iterator { return followers(qs) }},
+	} {
+		expect := nexted(test.it())
+		for _, size := range []int{1, 3, 100} {
+			got := batched(test.it(), size)
+			if !reflect.DeepEqual(got, expect) {
+				t.Errorf("Unexpected %s in batches of %d, got:%v expect:%v", test.name, size, got, expect)
+			}
+		}
+	}
+}
+
+func benchmarkStore() *QuadStore {
+	var data []quad.Quad
+	for i := 0; i < 10000; i++ {
+		data = append(data, quad.Quad{
+			Subject:   fmt.Sprint("n", i%1000),
+			Predicate: "follows",
+			Object:    fmt.Sprint("n", (i*7+i/1000)%1000),
+		})
+	}
+	qs, _, _ := makeTestStore(data)
+	return qs
+}
+
+func benchmarkNext(b *testing.B, size int) {
+	qs := benchmarkStore()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		it := followers(qs)
+		if size == 0 {
+			nexted(it)
+		} else {
+			batched(it, size)
+		}
+		it.Close()
+	}
+}
+
+func BenchmarkNext(b *testing.B)      { benchmarkNext(b, 0) }
+func BenchmarkNextBatch(b *testing.B) { benchmarkNext(b, 100) }
+
+// rows returns the results of it along with the tags of each path, by name.
+func rows(qs graph.QuadStore, it graph.Iterator) []map[string]string {
+	var out []map[string]string
+	add := func() {
+		row := map[string]string{"": qs.NameOf(it.Result())}
+		tags := make(map[string]graph.Value)
+		it.TagResults(tags)
+		for k, v := range tags {
+			row[k] = qs.NameOf(v)
+		}
+		out = append(out, row)
+	}
+	for graph.Next(it) {
+		add()
+		for it.NextPath() {
+			add()
+		}
+	}
+	return out
+}
+
+func TestParallel(t *testing.T) {
+	qs, _, _ := makeTestStore(simpleGraph)
+
+	tree := func() graph.Iterator {
+		all := qs.NodesAllIterator()
+		all.Tagger().Add("target")
+		fixed := qs.FixedIterator()
+		fixed.Add(qs.ValueOf("follows"))
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(iterator.NewLinksTo(qs, all, quad.Object))
+		and.AddSubIterator(iterator.NewLinksTo(qs, fixed, quad.Predicate))
+		hasa := iterator.NewHasA(qs, and, quad.Subject)
+		hasa.Tagger().Add("source")
+		return hasa
+	}
+
+	expect := rows(qs, tree())
+	for _, n := range []int{2, 3, 16} {
+		it := iterator.Parallel(tree(), n)
+		if it.Type() != graph.Or {
+			t.Errorf("Unexpected type of parallel iterator, got:%v expect:%v", it.Type(), graph.Or)
+		}
+		if got := rows(qs, it); !reflect.DeepEqual(got, expect) {
+			t.Errorf("Unexpected results on %d goroutines, got:%v expect:%v", n, got, expect)
+		}
+		it.Close()
+	}
+}
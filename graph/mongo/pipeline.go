@@ -0,0 +1,369 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+// Defines the Pipeline iterator, which has MongoDB evaluate a HasA over a tree
+// of And and LinksTo iterators as an aggregation pipeline, rather than pulling
+// each quad into the tree to be checked.
+//
+// A pipeline yields documents of the form {_id: <node name>, n: <paths>}, one
+// per node, where n is the number of times the HasA would have returned it.
+// The quads a HasA starts from are matched and projected to {quad, n: 1}; a
+// LinksTo to the nodes of a nested pipeline looks up the quads of each node
+// into {quad, n}, and the quads are then filtered, and grouped by the node in
+// the direction of the HasA. Contains matches the quads on the node it checks
+// before they are grouped, so only that node's quads are fetched.
+
+import (
+	"fmt"
+
+	"github.com/barakmich/glog"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+)
+
+var pipelineType graph.Type
+
+func init() {
+	pipelineType = graph.RegisterIterator("mongo-pipeline")
+}
+
+// PipelineType returns the type of a Pipeline iterator.
+func PipelineType() graph.Type { return pipelineType }
+
+// A Pipeline is an iterator of the nodes produced by an aggregation pipeline
+// on the quads collection.
+type Pipeline struct {
+	uid      uint64
+	tags     graph.Tagger
+	qs       *QuadStore
+	stages   []bson.M
+	match    int
+	node     string
+	size     int64
+	iter     *mgo.Iter
+	result   graph.Value
+	left     int64
+	runstats graph.IteratorStats
+	err      error
+}
+
+// newPipeline returns a Pipeline of stages, where the quads of a node can be
+// matched on field node before stage match.
+func newPipeline(qs *QuadStore, stages []bson.M, match int, node string, size int64) *Pipeline {
+	return &Pipeline{
+		uid:    iterator.NextUID(),
+		qs:     qs,
+		stages: stages,
+		match:  match,
+		node:   node,
+		size:   size,
+	}
+}
+
+func (it *Pipeline) UID() uint64 {
+	return it.uid
+}
+
+func (it *Pipeline) Reset() {
+	it.Close()
+	it.iter = nil
+	it.left = 0
+}
+
+func (it *Pipeline) Close() error {
+	if it.iter != nil {
+		return it.iter.Close()
+	}
+	return nil
+}
+
+func (it *Pipeline) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Pipeline) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+}
+
+func (it *Pipeline) Clone() graph.Iterator {
+	m := newPipeline(it.qs, it.stages, it.match, it.node, it.size)
+	m.tags.CopyFrom(it)
+	return m
+}
+
+func (it *Pipeline) pipe(stages []bson.M) *mgo.Iter {
+	return it.qs.db.C("quads").Pipe(stages).AllowDiskUse().Iter()
+}
+
+// containsStages returns the stages of the pipeline restricted to the node
+// called name.
+func (it *Pipeline) containsStages(name string) []bson.M {
+	stages := make([]bson.M, 0, len(it.stages)+1)
+	stages = append(stages, it.stages[:it.match]...)
+	stages = append(stages, bson.M{"$match": bson.M{it.node: name}})
+	return append(stages, it.stages[it.match:]...)
+}
+
+type pipelineResult struct {
+	Name  string `bson:"_id"`
+	Paths int64  `bson:"n"`
+}
+
+// Next returns each node as many times as there are paths to it.
+func (it *Pipeline) Next() bool {
+	graph.NextLogIn(it)
+	it.runstats.Next += 1
+	if it.left > 0 {
+		it.left--
+		return graph.NextLogOut(it, it.result, true)
+	}
+	if it.iter == nil {
+		it.iter = it.pipe(it.stages)
+	}
+	var result pipelineResult
+	if !it.iter.Next(&result) {
+		if err := it.iter.Err(); err != nil {
+			it.err = err
+			glog.Errorln("Error Nexting Pipeline: ", err)
+		}
+		return graph.NextLogOut(it, nil, false)
+	}
+	it.result = hashOf(result.Name)
+	it.left = result.Paths - 1
+	return graph.NextLogOut(it, it.result, true)
+}
+
+func (it *Pipeline) Err() error {
+	return it.err
+}
+
+func (it *Pipeline) Result() graph.Value {
+	return it.result
+}
+
+func (it *Pipeline) NextPath() bool {
+	return false
+}
+
+// SubIterators returns no subiterators for a Pipeline iterator.
+func (it *Pipeline) SubIterators() []graph.Iterator {
+	return nil
+}
+
+// Contains checks whether the pipeline yields v, running it over the quads of v
+// alone.
+func (it *Pipeline) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
+	it.runstats.Contains += 1
+	iter := it.pipe(it.containsStages(it.qs.NameOf(v)))
+	var result pipelineResult
+	found := iter.Next(&result)
+	if err := iter.Close(); err != nil {
+		it.err = err
+		glog.Errorln("Error Containing Pipeline: ", err)
+		return graph.ContainsLogOut(it, v, false)
+	}
+	if found {
+		it.result = v
+		it.left = 0
+	}
+	return graph.ContainsLogOut(it, v, found)
+}
+
+// Size is the estimate of the size of the HasA the pipeline evaluates.
+func (it *Pipeline) Size() (int64, bool) {
+	return it.size, false
+}
+
+func (it *Pipeline) Type() graph.Type                 { return pipelineType }
+func (it *Pipeline) Optimize() (graph.Iterator, bool) { return it, false }
+
+func (it *Pipeline) Describe() graph.Description {
+	return graph.Description{
+		UID:  it.UID(),
+		Name: fmt.Sprint(it.stages),
+		Type: it.Type(),
+		Tags: it.tags.Tags(),
+		Size: it.size,
+	}
+}
+
+func (it *Pipeline) Stats() graph.IteratorStats {
+	return graph.IteratorStats{
+		ContainsCost: 1,
+		NextCost:     1,
+		Size:         it.size,
+		Next:         it.runstats.Next,
+		Contains:     it.runstats.Contains,
+	}
+}
+
+// A quadQuery collects what a tree of iterators of quads asks of them.
+type quadQuery struct {
+	conds   []bson.M
+	from    *Pipeline
+	fromDir quad.Direction
+}
+
+// optimizeHasA replaces a HasA over an untagged tree of And and LinksTo
+// iterators with a Pipeline.
+func (qs *QuadStore) optimizeHasA(it *iterator.HasA) (graph.Iterator, bool) {
+	var q quadQuery
+	if !qs.addQuads(&q, it.SubIterators()[0]) {
+		return it, false
+	}
+	var (
+		stages []bson.M
+		match  int
+		node   = "quad." + it.Direction().String()
+	)
+	if q.from == nil {
+		if len(q.conds) != 0 {
+			stages = append(stages, bson.M{"$match": matchAll(q.conds, "")})
+		}
+		// Match a node on the quads themselves, where it can use an index.
+		match, node = len(stages), it.Direction().String()
+		stages = append(stages, bson.M{"$project": bson.M{"quad": "$$ROOT", "n": bson.M{"$literal": 1}}})
+	} else {
+		stages = append(stages, q.from.stages...)
+		stages = append(stages,
+			bson.M{"$lookup": bson.M{"from": "quads", "localField": "_id", "foreignField": q.fromDir.String(), "as": "quad"}},
+			bson.M{"$unwind": "$quad"},
+		)
+		match = len(stages)
+		if len(q.conds) != 0 {
+			stages = append(stages, bson.M{"$match": matchAll(q.conds, "quad.")})
+		}
+	}
+	stages = append(stages,
+		bson.M{"$redact": bson.M{"$cond": []interface{}{
+			bson.M{"$gt": []interface{}{
+				bson.M{"$size": bson.M{"$ifNull": []interface{}{"$quad.Added", []interface{}{}}}},
+				bson.M{"$size": bson.M{"$ifNull": []interface{}{"$quad.Deleted", []interface{}{}}}},
+			}},
+			"$$KEEP",
+			"$$PRUNE",
+		}}},
+		bson.M{"$group": bson.M{"_id": "$quad." + it.Direction().String(), "n": bson.M{"$sum": "$n"}}},
+	)
+	size, _ := it.Size()
+	p := newPipeline(qs, stages, match, node, size)
+	p.tags.CopyFrom(it)
+	glog.V(3).Infoln("Pushed down HasA", it.UID(), "as", stages)
+	return p, true
+}
+
+// addQuads adds the conditions of an iterator of quads to q, returning false
+// if they cannot be expressed.
+func (qs *QuadStore) addQuads(q *quadQuery, it graph.Iterator) bool {
+	if tagged(it) {
+		return false
+	}
+	switch it := it.(type) {
+	case *Iterator:
+		if it.collection != "quads" {
+			return false
+		}
+		if !it.isAll {
+			q.conds = append(q.conds, it.constraint)
+		}
+		return true
+	case *iterator.Fixed:
+		var ids []string
+		for graph.Next(it) {
+			ids = append(ids, it.Result().(string))
+		}
+		it.Reset()
+		q.conds = append(q.conds, bson.M{"_id": bson.M{"$in": ids}})
+		return true
+	case *iterator.And:
+		for _, sub := range it.SubIterators() {
+			if !qs.addQuads(q, sub) {
+				return false
+			}
+		}
+		return true
+	case *iterator.LinksTo:
+		return qs.addLinks(q, it.SubIterators()[0], it.Direction())
+	case *LinksTo:
+		for _, link := range it.lset {
+			q.conds = append(q.conds, bson.M{link.Dir.String(): qs.NameOf(link.Value)})
+		}
+		return qs.addLinks(q, it.primaryIt, it.dir)
+	}
+	return false
+}
+
+// addLinks adds the condition that the node in direction d of the quads is
+// one of the nodes of it.
+func (qs *QuadStore) addLinks(q *quadQuery, it graph.Iterator, d quad.Direction) bool {
+	if tagged(it) {
+		return false
+	}
+	switch it := it.(type) {
+	case *Iterator:
+		return it.isAll && it.collection == "nodes"
+	case *iterator.Fixed:
+		var names []string
+		for graph.Next(it) {
+			names = append(names, qs.NameOf(it.Result()))
+		}
+		it.Reset()
+		q.conds = append(q.conds, bson.M{d.String(): bson.M{"$in": names}})
+		return true
+	case *Pipeline:
+		if q.from != nil {
+			return false
+		}
+		q.from, q.fromDir = it, d
+		return true
+	}
+	return false
+}
+
+// tagged returns whether it has tags of its own.
+func tagged(it graph.Iterator) bool {
+	return len(it.Tagger().Tags()) != 0 || len(it.Tagger().Fixed()) != 0
+}
+
+// matchAll returns a $match condition for all of conds, with the fields they
+// are on prefixed.
+func matchAll(conds []bson.M, prefix string) bson.M {
+	all := make([]interface{}, 0, len(conds))
+	for _, cond := range conds {
+		m := make(bson.M, len(cond))
+		for field, v := range cond {
+			m[prefix+field] = v
+		}
+		all = append(all, m)
+	}
+	if len(all) == 1 {
+		return all[0].(bson.M)
+	}
+	return bson.M{"$and": all}
+}
+
+var _ graph.Nexter = &Pipeline{}
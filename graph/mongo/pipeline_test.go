@@ -0,0 +1,125 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+)
+
+// newTestStore returns a QuadStore without a database, which knows the names
+// of nodes.
+func newTestStore(names ...string) *QuadStore {
+	qs := &QuadStore{ids: newCache(len(names))}
+	for _, name := range names {
+		qs.ids.Put(hashOf(name), name)
+	}
+	return qs
+}
+
+func fixedNodes(names ...string) *iterator.Fixed {
+	fixed := iterator.NewFixed(iterator.Identity)
+	for _, name := range names {
+		fixed.Add(hashOf(name))
+	}
+	return fixed
+}
+
+// links returns a HasA in direction d of the quads linking to each of the
+// nodes in sub in its direction.
+func links(qs *QuadStore, d quad.Direction, sub map[quad.Direction]graph.Iterator) *iterator.HasA {
+	and := iterator.NewAnd(qs)
+	for _, dir := range []quad.Direction{quad.Subject, quad.Predicate} {
+		if it, ok := sub[dir]; ok {
+			and.AddSubIterator(iterator.NewLinksTo(qs, it, dir))
+		}
+	}
+	return iterator.NewHasA(qs, and, d)
+}
+
+var live = bson.M{"$redact": bson.M{"$cond": []interface{}{
+	bson.M{"$gt": []interface{}{
+		bson.M{"$size": bson.M{"$ifNull": []interface{}{"$quad.Added", []interface{}{}}}},
+		bson.M{"$size": bson.M{"$ifNull": []interface{}{"$quad.Deleted", []interface{}{}}}},
+	}},
+	"$$KEEP",
+	"$$PRUNE",
+}}}
+
+func TestOptimizeHasA(t *testing.T) {
+	qs := newTestStore("alice", "follows", "status")
+
+	hasa := links(qs, quad.Object, map[quad.Direction]graph.Iterator{
+		quad.Subject:   fixedNodes("alice"),
+		quad.Predicate: fixedNodes("follows"),
+	})
+	it, ok := qs.optimizeHasA(hasa)
+	if !ok {
+		t.Fatal("HasA was not pushed down")
+	}
+	inner := it.(*Pipeline)
+	match := bson.M{"$match": bson.M{"$and": []interface{}{
+		bson.M{"subject": bson.M{"$in": []string{"alice"}}},
+		bson.M{"predicate": bson.M{"$in": []string{"follows"}}},
+	}}}
+	project := bson.M{"$project": bson.M{"quad": "$$ROOT", "n": bson.M{"$literal": 1}}}
+	group := bson.M{"$group": bson.M{"_id": "$quad.object", "n": bson.M{"$sum": "$n"}}}
+	want := []bson.M{match, project, live, group}
+	if !reflect.DeepEqual(inner.stages, want) {
+		t.Errorf("Unexpected stages,\n\tgot:%v\n\texpect:%v", inner.stages, want)
+	}
+	want = []bson.M{match, {"$match": bson.M{"object": "bob"}}, project, live, group}
+	if got := inner.containsStages("bob"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected contains stages,\n\tgot:%v\n\texpect:%v", got, want)
+	}
+
+	hasa = links(qs, quad.Object, map[quad.Direction]graph.Iterator{
+		quad.Subject:   inner,
+		quad.Predicate: fixedNodes("status"),
+	})
+	it, ok = qs.optimizeHasA(hasa)
+	if !ok {
+		t.Fatal("Nested HasA was not pushed down")
+	}
+	outer := it.(*Pipeline)
+	lookup := []bson.M{
+		{"$lookup": bson.M{"from": "quads", "localField": "_id", "foreignField": "subject", "as": "quad"}},
+		{"$unwind": "$quad"},
+	}
+	match = bson.M{"$match": bson.M{"quad.predicate": bson.M{"$in": []string{"status"}}}}
+	want = append(append(append([]bson.M(nil), inner.stages...), lookup...), match, live, group)
+	if !reflect.DeepEqual(outer.stages, want) {
+		t.Errorf("Unexpected nested stages,\n\tgot:%v\n\texpect:%v", outer.stages, want)
+	}
+	want = append(append(append([]bson.M(nil), inner.stages...), lookup...),
+		bson.M{"$match": bson.M{"quad.object": "cool"}}, match, live, group)
+	if got := outer.containsStages("cool"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected nested contains stages,\n\tgot:%v\n\texpect:%v", got, want)
+	}
+
+	tagged := links(qs, quad.Object, map[quad.Direction]graph.Iterator{
+		quad.Subject: fixedNodes("alice"),
+	})
+	tagged.SubIterators()[0].Tagger().Add("quads")
+	if _, ok := qs.optimizeHasA(tagged); ok {
+		t.Error("HasA over tagged quads was pushed down")
+	}
+}
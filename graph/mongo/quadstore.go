@@ -20,6 +20,7 @@ import (
 	"errors"
 	"hash"
 	"sync"
+	"time"
 
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -222,48 +223,99 @@ func (qs *QuadStore) updateLog(d graph.Delta) error {
 	return err
 }
 
+// Deltas calls fn with each delta in the QuadStore's log, in order.
+func (qs *QuadStore) Deltas(fn func(graph.Delta) error) error {
+	var entry MongoLogEntry
+	iter := qs.db.C("log").Find(nil).Sort("LogID").Iter()
+	for iter.Next(&entry) {
+		var q quad.Quad
+		err := qs.db.C("quads").FindId(entry.Key).One(&q)
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		action := graph.Add
+		if entry.Action == "Delete" {
+			action = graph.Delete
+		}
+		err = fn(graph.Delta{
+			ID:        graph.NewSequentialKey(entry.LogID),
+			Quad:      q,
+			Action:    action,
+			Timestamp: time.Unix(0, entry.Timestamp),
+		})
+		if err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
 func (qs *QuadStore) ApplyDeltas(in []graph.Delta, ignoreOpts graph.IgnoreOpts) error {
-	qs.session.SetSafe(nil)
-	ids := make(map[string]int)
-	// Pre-check the existence condition.
+	// Pre-check the existence condition, tracking the effect of earlier
+	// deltas, and keep only the deltas that change the store.
+	var deltas []graph.Delta
+	pending := make(map[string]bool)
 	for _, d := range in {
 		if d.Action != graph.Add && d.Action != graph.Delete {
 			return errors.New("mongo: invalid action")
 		}
 		key := qs.getIDForQuad(d.Quad)
+		valid, ok := pending[key]
+		if !ok {
+			valid = qs.checkValid(key)
+		}
 		switch d.Action {
 		case graph.Add:
-			if qs.checkValid(key) {
+			if valid {
 				if ignoreOpts.IgnoreDup {
 					continue
-				} else {
-					return graph.ErrQuadExists
 				}
+				return graph.ErrQuadExists
 			}
 		case graph.Delete:
-			if !qs.checkValid(key) {
+			if !valid {
 				if ignoreOpts.IgnoreMissing {
 					continue
-				} else {
-					return graph.ErrQuadNotExist
 				}
+				return graph.ErrQuadNotExist
 			}
 		}
+		pending[key] = d.Action == graph.Add
+		deltas = append(deltas, d)
 	}
 	if glog.V(2) {
 		glog.Infoln("Existence verified. Proceeding.")
 	}
-	for _, d := range in {
+
+	// Mongo has no multi-document transactions, so applying deltas is only
+	// atomic on a best-effort basis: the writes are acknowledged and any that
+	// were made are undone if a later one fails, but they are visible to
+	// readers meanwhile, and are left in place if the process stops.
+	var (
+		logged  int
+		updated int
+		ids     = make(map[string]int)
+		resized = make(map[string]int)
+	)
+	fail := func(err error) error {
+		qs.revertDeltas(deltas[:logged], deltas[:updated], resized)
+		return err
+	}
+	for _, d := range deltas {
 		err := qs.updateLog(d)
 		if err != nil {
-			return err
+			return fail(err)
 		}
+		logged++
 	}
-	for _, d := range in {
+	for _, d := range deltas {
 		err := qs.updateQuad(d.Quad, d.ID.Int(), d.Action)
 		if err != nil {
-			return err
+			return fail(err)
 		}
+		updated++
 		var countdelta int
 		if d.Action == graph.Add {
 			countdelta = 1
@@ -280,13 +332,41 @@ func (qs *QuadStore) ApplyDeltas(in []graph.Delta, ignoreOpts graph.IgnoreOpts)
 	for k, v := range ids {
 		err := qs.updateNodeBy(k, v)
 		if err != nil {
-			return err
+			return fail(err)
 		}
+		resized[k] = v
 	}
-	qs.session.SetSafe(&mgo.Safe{})
 	return nil
 }
 
+// revertDeltas undoes the log entries, quad updates and node resizes made by a
+// partially applied set of deltas.
+func (qs *QuadStore) revertDeltas(logged, updated []graph.Delta, resized map[string]int) {
+	for k, v := range resized {
+		qs.updateNodeBy(k, -v)
+	}
+	for _, d := range updated {
+		setname := "Added"
+		if d.Action == graph.Delete {
+			setname = "Deleted"
+		}
+		err := qs.db.C("quads").UpdateId(qs.getIDForQuad(d.Quad), bson.M{"$pull": bson.M{setname: d.ID.Int()}})
+		if err != nil {
+			glog.Errorf("Error reverting quad update: %v", err)
+		}
+	}
+	if len(logged) != 0 {
+		logIDs := make([]int64, len(logged))
+		for i, d := range logged {
+			logIDs[i] = d.ID.Int()
+		}
+		_, err := qs.db.C("log").RemoveAll(bson.M{"LogID": bson.M{"$in": logIDs}})
+		if err != nil {
+			glog.Errorf("Error reverting log entries: %v", err)
+		}
+	}
+}
+
 func (qs *QuadStore) Quad(val graph.Value) quad.Quad {
 	var q quad.Quad
 	err := qs.db.C("quads").FindId(val.(string)).One(&q)
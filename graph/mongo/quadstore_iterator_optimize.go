@@ -27,7 +27,8 @@ func (qs *QuadStore) OptimizeIterator(it graph.Iterator) (graph.Iterator, bool)
 		return qs.optimizeLinksTo(it.(*iterator.LinksTo))
 	case graph.And:
 		return qs.optimizeAndIterator(it.(*iterator.And))
-
+	case graph.HasA:
+		return qs.optimizeHasA(it.(*iterator.HasA))
 	}
 	return it, false
 }
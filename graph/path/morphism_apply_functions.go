@@ -15,6 +15,8 @@
 package path
 
 import (
+	"regexp"
+
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
 	"github.com/google/cayley/quad"
@@ -43,6 +45,32 @@ func isMorphism(nodes ...string) morphism {
 	}
 }
 
+func regexMorphism(re *regexp.Regexp) morphism {
+	return morphism{
+		Name:     "regex",
+		Reversal: func() morphism { return regexMorphism(re) },
+		Apply: func(qs graph.QuadStore, it graph.Iterator) graph.Iterator {
+			and := iterator.NewAnd(qs)
+			and.AddSubIterator(iterator.NewRegex(qs.NodesAllIterator(), re, qs))
+			and.AddSubIterator(it)
+			return and
+		},
+	}
+}
+
+func prefixMorphism(prefix string) morphism {
+	return morphism{
+		Name:     "prefix",
+		Reversal: func() morphism { return prefixMorphism(prefix) },
+		Apply: func(qs graph.QuadStore, it graph.Iterator) graph.Iterator {
+			and := iterator.NewAnd(qs)
+			and.AddSubIterator(iterator.NewPrefix(qs.NodesAllIterator(), prefix, qs))
+			and.AddSubIterator(it)
+			return and
+		},
+	}
+}
+
 func hasMorphism(via interface{}, nodes ...string) morphism {
 	return morphism{
 		Name:     "has",
@@ -14,7 +14,11 @@
 
 package path
 
-import "github.com/google/cayley/graph"
+import (
+	"regexp"
+
+	"github.com/google/cayley/graph"
+)
 
 type morphism struct {
 	Name     string
@@ -80,6 +84,25 @@ func (p *Path) Is(nodes ...string) *Path {
 	return p
 }
 
+// Regex declares that the current nodes in this path are only those with names
+// matched by re.
+//
+// For example:
+//  // Returns the nodes "bob" and "charlie" follow whose names start with "b".
+//  StartPath(qs, "bob", "charlie").Out("follows").Regex(regexp.MustCompile("^b"))
+func (p *Path) Regex(re *regexp.Regexp) *Path {
+	p.stack = append(p.stack, regexMorphism(re))
+	return p
+}
+
+// Prefix declares that the current nodes in this path are only those with
+// names starting with prefix. Quad stores with an index of their nodes by name
+// can find them without looking at every node.
+func (p *Path) Prefix(prefix string) *Path {
+	p.stack = append(p.stack, prefixMorphism(prefix))
+	return p
+}
+
 // Tag adds tag strings to the nodes at this point in the path for each result
 // path in the set.
 func (p *Path) Tag(tags ...string) *Path {
@@ -16,6 +16,7 @@ package path
 
 import (
 	"reflect"
+	"regexp"
 	"sort"
 	"testing"
 
@@ -182,6 +183,21 @@ func testSet(qs graph.QuadStore) []test {
 			path:    StartPath(qs).Has("status", "cool").Has("follows", "F"),
 			expect:  []string{"B"},
 		},
+		{
+			message: "use Prefix",
+			path:    StartPath(qs).Prefix("pre"),
+			expect:  []string{"predicates"},
+		},
+		{
+			message: "use Regex",
+			path:    StartPath(qs, "C").Out("follows").Regex(regexp.MustCompile("^[A-C]$")),
+			expect:  []string{"B"},
+		},
+		{
+			message: "use Regex with a prefix",
+			path:    StartPath(qs).Out("status").Regex(regexp.MustCompile("^co+l$")),
+			expect:  []string{"cool"},
+		},
 	}
 }
 
@@ -141,6 +141,11 @@ func (d Options) BoolKey(key string) (bool, bool, error) {
 
 var ErrCannotBulkLoad = errors.New("quadstore: cannot bulk load")
 
+// ErrIncompleteBulkLoad is returned when opening or bulk loading a QuadStore
+// that holds the partial contents of a bulk load that did not complete. The
+// database must be removed and initialized again before it can be used.
+var ErrIncompleteBulkLoad = errors.New("quadstore: database holds an incomplete bulk load, remove it and load again")
+
 type BulkLoader interface {
 	// BulkLoad loads Quads from a quad.Unmarshaler in bulk to the QuadStore.
 	// It returns ErrCannotBulkLoad if bulk loading is not possible. For example if
@@ -148,6 +153,31 @@ type BulkLoader interface {
 	BulkLoad(quad.Unmarshaler) error
 }
 
+// Snapshotter is implemented by QuadStores that can provide a consistent,
+// read-only view of their contents.
+type Snapshotter interface {
+	// Snapshot returns a read-only QuadStore reflecting the state of the store
+	// at the time of the call. Closing the returned QuadStore releases the
+	// snapshot without closing the store it was taken from.
+	Snapshot() (QuadStore, error)
+}
+
+// DeltaLogger is implemented by QuadStores that keep a log of every delta
+// applied to them, allowing their history to be replayed into another store.
+type DeltaLogger interface {
+	// Deltas calls fn with each logged delta in order of ID. Iteration stops
+	// at the first error returned by fn.
+	Deltas(fn func(Delta) error) error
+}
+
+// Checker is implemented by QuadStores that can verify the consistency of
+// their indexes, value reference counts and metadata.
+type Checker interface {
+	// Check calls fn with each inconsistency found. If repair is true, the
+	// problems found are also corrected before Check returns.
+	Check(repair bool, fn func(error)) error
+}
+
 type NewStoreFunc func(string, Options) (QuadStore, error)
 type InitStoreFunc func(string, Options) error
 type NewStoreForRequestFunc func(QuadStore, Options) (QuadStore, error)
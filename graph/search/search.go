@@ -0,0 +1,327 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/barakmich/glog"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+)
+
+// ErrNoIndex is returned by a search of a quad store without a search index.
+var ErrNoIndex = errors.New("search: quad store has no search index")
+
+// An Index is an inverted index of the terms in the names of the nodes of a
+// quad store. Only nodes that are in quads are indexed.
+type Index interface {
+	// Postings calls fn with each node with the term in its name, or, if
+	// prefix is set, with a term starting with it, and the number of times
+	// the term occurs. A node is passed once for each of its terms.
+	Postings(term string, prefix bool, fn func(node graph.Value, freq int)) error
+}
+
+var searchType graph.Type
+
+func init() {
+	searchType = graph.RegisterIterator("search")
+}
+
+// Type returns the type of a Search iterator.
+func Type() graph.Type { return searchType }
+
+type result struct {
+	node  graph.Value
+	name  string
+	score int
+}
+
+// A Search iterator returns the nodes matching a search query, best first.
+//
+// A node matches if its name has every term of the query. As the query is
+// likely still being typed, the last word of the query also matches any term
+// that starts with it, unless the query ends between words. The score of a
+// node is the number of times the query terms occur in its name; nodes with
+// the same score are returned in order of name.
+type Search struct {
+	uid     uint64
+	tags    graph.Tagger
+	qs      graph.QuadStore
+	query   string
+	results []result
+	index   map[interface{}]int
+	done    bool
+	offset  int
+	score   int
+	result  graph.Value
+	err     error
+}
+
+// NewSearch returns an iterator of the nodes of qs matching query, which is
+// run when the iterator is first used. If qs has no search index, the
+// iterator is empty and its Err is ErrNoIndex.
+func NewSearch(qs graph.QuadStore, query string) *Search {
+	return &Search{
+		uid:   iterator.NextUID(),
+		qs:    qs,
+		query: query,
+	}
+}
+
+// Query returns the search query.
+func (it *Search) Query() string {
+	return it.query
+}
+
+// clauses returns the terms of the query, each of which must be matched, and
+// the word to be matched as a prefix, if any.
+func clauses(query string) (terms []string, partial string) {
+	words := Words(query)
+	if len(words) == 0 {
+		return nil, ""
+	}
+	last, _ := utf8.DecodeLastRuneInString(query)
+	if unicode.IsLetter(last) || unicode.IsDigit(last) {
+		partial = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+	for _, w := range words {
+		terms = append(terms, Stem(w))
+	}
+	return terms, partial
+}
+
+// run evaluates the query.
+func (it *Search) run() error {
+	idx, ok := it.qs.(Index)
+	if !ok {
+		return ErrNoIndex
+	}
+	terms, partial := clauses(it.query)
+	if len(terms) == 0 && partial == "" {
+		return nil
+	}
+
+	// Each clause narrows the scores of the nodes matching every clause so
+	// far; nil matches everything.
+	var scores map[interface{}]*result
+	match := func(term string, prefix bool, hits map[interface{}]*result) error {
+		return idx.Postings(term, prefix, func(node graph.Value, freq int) {
+			key := keyOf(node)
+			if scores != nil && scores[key] == nil {
+				return
+			}
+			r := hits[key]
+			if r == nil {
+				r = &result{node: node}
+				if scores != nil {
+					r.score = scores[key].score
+				}
+				hits[key] = r
+			}
+			r.score += freq
+		})
+	}
+	for _, term := range terms {
+		hits := make(map[interface{}]*result)
+		err := match(term, false, hits)
+		if err != nil {
+			return err
+		}
+		scores = hits
+	}
+	if partial != "" {
+		hits := make(map[interface{}]*result)
+		err := match(partial, true, hits)
+		// A completed word may be indexed only by its stem.
+		if stem := Stem(partial); err == nil && stem != partial {
+			err = match(stem, false, hits)
+		}
+		if err != nil {
+			return err
+		}
+		scores = hits
+	}
+
+	it.results = make([]result, 0, len(scores))
+	it.index = make(map[interface{}]int, len(scores))
+	for _, r := range scores {
+		r.name = it.qs.NameOf(r.node)
+		it.results = append(it.results, *r)
+	}
+	sort.Sort(byScore(it.results))
+	for i, r := range it.results {
+		it.index[keyOf(r.node)] = i
+	}
+	return nil
+}
+
+// evaluate runs the query if it has not been run.
+func (it *Search) evaluate() {
+	if it.done {
+		return
+	}
+	it.done = true
+	it.err = it.run()
+	if it.err != nil {
+		glog.Errorln("Error searching for", it.query, ":", it.err)
+	}
+}
+
+func (it *Search) UID() uint64 {
+	return it.uid
+}
+
+func (it *Search) Reset() {
+	it.offset = 0
+	it.result = nil
+	it.score = 0
+}
+
+func (it *Search) Close() error {
+	it.results = nil
+	it.index = nil
+	it.done = false
+	it.Reset()
+	return nil
+}
+
+func (it *Search) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Search) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+}
+
+// Clone shares the results of the query, if it has been run.
+func (it *Search) Clone() graph.Iterator {
+	out := NewSearch(it.qs, it.query)
+	out.results, out.index, out.done, out.err = it.results, it.index, it.done, it.err
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *Search) Next() bool {
+	it.evaluate()
+	if it.offset >= len(it.results) {
+		it.result = nil
+		it.score = 0
+		return false
+	}
+	r := it.results[it.offset]
+	it.offset++
+	it.result, it.score = r.node, r.score
+	return true
+}
+
+func (it *Search) Err() error {
+	return it.err
+}
+
+func (it *Search) Result() graph.Value {
+	return it.result
+}
+
+// Score returns the score of the current result.
+func (it *Search) Score() int {
+	return it.score
+}
+
+func (it *Search) NextPath() bool {
+	return false
+}
+
+// No subiterators.
+func (it *Search) SubIterators() []graph.Iterator {
+	return nil
+}
+
+func (it *Search) Contains(v graph.Value) bool {
+	it.evaluate()
+	i, ok := it.index[keyOf(v)]
+	if !ok {
+		return false
+	}
+	it.result, it.score = v, it.results[i].score
+	return true
+}
+
+// Size runs the query to count its results.
+func (it *Search) Size() (int64, bool) {
+	it.evaluate()
+	return int64(len(it.results)), true
+}
+
+func (it *Search) Describe() graph.Description {
+	size, _ := it.Size()
+	return graph.Description{
+		UID:  it.UID(),
+		Name: fmt.Sprintf("%q", it.query),
+		Type: it.Type(),
+		Tags: it.tags.Tags(),
+		Size: size,
+	}
+}
+
+func (it *Search) Type() graph.Type { return searchType }
+func (it *Search) Sorted() bool     { return false }
+
+func (it *Search) Optimize() (graph.Iterator, bool) {
+	return it, false
+}
+
+func (it *Search) Stats() graph.IteratorStats {
+	s, _ := it.Size()
+	return graph.IteratorStats{
+		ContainsCost: 1,
+		NextCost:     1,
+		Size:         s,
+	}
+}
+
+// byScore orders results best first, and then by name.
+type byScore []result
+
+func (r byScore) Len() int      { return len(r) }
+func (r byScore) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byScore) Less(i, j int) bool {
+	if r[i].score != r[j].score {
+		return r[i].score > r[j].score
+	}
+	return r[i].name < r[j].name
+}
+
+// keyOf returns a comparable key for v, which is the same for equal values.
+func keyOf(v graph.Value) interface{} {
+	if h, ok := v.(iterator.Keyer); ok {
+		return h.Key()
+	}
+	return v
+}
+
+var _ graph.Nexter = &Search{}
@@ -0,0 +1,180 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/cayley/graph"
+)
+
+var stemTests = []struct {
+	word, stem string
+}{
+	{"cats", "cat"},
+	{"ponies", "pony"},
+	{"classes", "class"},
+	{"boxes", "box"},
+	{"wishes", "wish"},
+	{"bus", "bus"},
+	{"glass", "glass"},
+	{"running", "run"},
+	{"walking", "walk"},
+	{"falling", "fall"},
+	{"stopped", "stop"},
+	{"walked", "walk"},
+	{"agreed", "agreed"},
+	{"is", "is"},
+	{"sing", "sing"},
+}
+
+func TestStem(t *testing.T) {
+	for _, test := range stemTests {
+		if got := Stem(test.word); got != test.stem {
+			t.Errorf("Unexpected stem of %q, got:%q expect:%q", test.word, got, test.stem)
+		}
+	}
+}
+
+func TestTerms(t *testing.T) {
+	got := Terms(`"Running Cats, and more CATS!" <http://example.org/cat/` + strings.Repeat("0", MaxWordSize+1) + ">")
+	expect := map[string]int{"run": 1, "cat": 3, "and": 1, "more": 1, "http": 1, "example": 1, "org": 1}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected terms, got:%v expect:%v", got, expect)
+	}
+}
+
+// store is an index of the terms of a few names, which are their own values.
+type store struct {
+	graph.QuadStore
+	names []string
+}
+
+func (s store) NameOf(v graph.Value) string {
+	return v.(string)
+}
+
+func (s store) Postings(term string, prefix bool, fn func(graph.Value, int)) error {
+	for _, name := range s.names {
+		for t, n := range Terms(name) {
+			if t == term || (prefix && strings.HasPrefix(t, term)) {
+				fn(name, n)
+			}
+		}
+	}
+	return nil
+}
+
+var testStore = store{names: []string{
+	"cat",
+	"The cat sat on the cat mat",
+	"Cats and dogs",
+	"A dog",
+	"Catalogue",
+	"running dog",
+}}
+
+var searchTests = []struct {
+	query  string
+	expect []string
+	scores []int
+}{
+	{
+		query:  "cat",
+		expect: []string{"The cat sat on the cat mat", "Catalogue", "Cats and dogs", "cat"},
+		scores: []int{2, 1, 1, 1},
+	},
+	{
+		query:  "cat ",
+		expect: []string{"The cat sat on the cat mat", "Cats and dogs", "cat"},
+		scores: []int{2, 1, 1},
+	},
+	{
+		query:  "cats d",
+		expect: []string{"Cats and dogs"},
+		scores: []int{2},
+	},
+	{
+		query:  "DOGS",
+		expect: []string{"A dog", "Cats and dogs", "running dog"},
+		scores: []int{1, 1, 1},
+	},
+	{
+		query:  "ru",
+		expect: []string{"running dog"},
+		scores: []int{1},
+	},
+	{
+		query:  "running",
+		expect: []string{"running dog"},
+		scores: []int{1},
+	},
+	{
+		query: "bird",
+	},
+	{
+		query: " ... ",
+	},
+}
+
+func TestSearch(t *testing.T) {
+	for _, test := range searchTests {
+		it := NewSearch(testStore, test.query)
+		var (
+			got    []string
+			scores []int
+		)
+		for it.Next() {
+			got = append(got, it.Result().(string))
+			scores = append(scores, it.Score())
+		}
+		if it.Err() != nil {
+			t.Errorf("Unexpected error searching for %q: %v", test.query, it.Err())
+		}
+		if !reflect.DeepEqual(got, test.expect) || !reflect.DeepEqual(scores, test.scores) {
+			t.Errorf("Unexpected results for %q, got:%q %v expect:%q %v", test.query, got, scores, test.expect, test.scores)
+		}
+		if size, exact := it.Size(); size != int64(len(test.expect)) || !exact {
+			t.Errorf("Unexpected size for %q, got:%d expect:%d", test.query, size, len(test.expect))
+		}
+		for _, name := range testStore.names {
+			want := contains(test.expect, name)
+			if it.Contains(name) != want {
+				t.Errorf("Unexpected result for %q containing %q, expect:%t", test.query, name, want)
+			}
+		}
+	}
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSearchNoIndex(t *testing.T) {
+	it := NewSearch(struct{ graph.QuadStore }{}, "cat")
+	if it.Next() {
+		t.Error("Search without an index returned a result")
+	}
+	if it.Err() != ErrNoIndex {
+		t.Errorf("Unexpected error, got:%v expect:%v", it.Err(), ErrNoIndex)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package search provides full-text search over the names of the nodes of
+// quad stores that keep an inverted index of the terms in them.
+//
+// Names are split into words at any rune that is not a letter or a digit, and
+// each word is lowercased and stemmed by stripping the common English plural
+// and verb suffixes, giving the terms of the name.
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// MaxWordSize is the length in bytes of the longest word that is indexed.
+// Longer runs of letters and digits, such as hashes and encoded data, are
+// unlikely to be searched for.
+const MaxWordSize = 64
+
+// Words returns the lowercased words of s, in order.
+func Words(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := words[:0]
+	for _, w := range words {
+		if len(w) <= MaxWordSize {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// Terms returns the terms of s, with the number of times each occurs.
+func Terms(s string) map[string]int {
+	words := Words(s)
+	if len(words) == 0 {
+		return nil
+	}
+	terms := make(map[string]int, len(words))
+	for _, w := range words {
+		terms[Stem(w)]++
+	}
+	return terms
+}
+
+// Stem strips the common English plural and verb suffixes from a lowercased
+// word. It is deliberately simple: related words need only share a stem, not
+// reduce to a dictionary form.
+func Stem(w string) string {
+	switch {
+	case len(w) > 4 && strings.HasSuffix(w, "ies"):
+		return w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "sses"):
+		return w[:len(w)-2]
+	case len(w) > 3 && strings.HasSuffix(w, "es") && hasAnySuffix(w[:len(w)-2], "s", "x", "z", "ch", "sh"):
+		return w[:len(w)-2]
+	case len(w) > 3 && strings.HasSuffix(w, "s") && !hasAnySuffix(w, "ss", "us", "is"):
+		return w[:len(w)-1]
+	case len(w) > 5 && strings.HasSuffix(w, "ing"):
+		return undouble(w[:len(w)-3])
+	case len(w) > 4 && strings.HasSuffix(w, "ed") && !strings.HasSuffix(w, "eed"):
+		return undouble(w[:len(w)-2])
+	}
+	return w
+}
+
+func hasAnySuffix(s string, suffixes ...string) bool {
+	for _, suf := range suffixes {
+		if strings.HasSuffix(s, suf) {
+			return true
+		}
+	}
+	return false
+}
+
+// undouble drops the last letter of a stem ending in a doubled consonant, as
+// in "running" and "stopped", other than those that are usually doubled.
+func undouble(s string) string {
+	n := len(s)
+	if n < 3 || s[n-1] != s[n-2] || strings.IndexByte("aeioulsz", s[n-1]) >= 0 {
+		return s
+	}
+	return s[:n-1]
+}
@@ -0,0 +1,48 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+// Defines the ordered access to quads used by multiway joins.
+
+import "github.com/google/cayley/quad"
+
+// Seeker is implemented by iterators over quads that are in ascending order of
+// their node in one direction, and that can skip ahead in that order.
+type Seeker interface {
+	Nexter
+
+	// SortedBy returns the direction by whose node the quads are ordered.
+	SortedBy() quad.Direction
+
+	// Seek advances the iterator to the first quad whose node in the SortedBy
+	// direction is not less than v, which will then be available through the
+	// Result method. It returns false if there is no such quad.
+	Seek(v Value) bool
+}
+
+// SortedIndexer is implemented by QuadStores that can iterate over quads in
+// the order of their nodes.
+type SortedIndexer interface {
+	// SortedQuadIterator returns a Seeker over the quads with all of the given
+	// linkages, ordered by their node in direction d. It also returns whether
+	// the Seeker reads an index in that order; if not, the quads are sorted in
+	// memory when first needed, which is only cheap for a few of them.
+	SortedQuadIterator(links []Linkage, d quad.Direction) (Seeker, bool)
+
+	// CompareValues orders two nodes of the QuadStore, returning a negative
+	// number, zero or a positive number if a is less than, equal to or greater
+	// than b. Seekers of the QuadStore follow this order.
+	CompareValues(a, b Value) int
+}
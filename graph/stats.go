@@ -0,0 +1,238 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+// Defines the statistics a QuadStore can keep about its predicates for the
+// query optimizer.
+
+import (
+	"math"
+
+	"github.com/google/cayley/quad"
+)
+
+// Statistician is implemented by QuadStores that keep statistics about the
+// quads of each predicate, allowing the optimizer to estimate the size of
+// joins rather than guessing at them.
+type Statistician interface {
+	// PredicateStats returns the statistics of the quads with the predicate
+	// p, and whether the store holds statistics.
+	PredicateStats(p Value) (PredicateStats, bool)
+}
+
+// LinkageIterator is implemented by iterators that may hold exactly the quads
+// with a given linkage, such as those returned by QuadStore.QuadIterator.
+type LinkageIterator interface {
+	Iterator
+
+	// Linkage returns the linkage of the quads held by the iterator, and
+	// whether it has one.
+	Linkage() (Linkage, bool)
+}
+
+// Histogram counts values by their number of quads. Bucket i holds the
+// number of values with between 2^i and 2^(i+1)-1 quads.
+type Histogram []int64
+
+// bucket returns the bucket of a value with n quads.
+func bucket(n int64) int {
+	i := 0
+	for n > 1 {
+		n >>= 1
+		i++
+	}
+	return i
+}
+
+// Add counts a value with n quads.
+func (h *Histogram) Add(n int64) {
+	i := bucket(n)
+	for len(*h) <= i {
+		*h = append(*h, 0)
+	}
+	(*h)[i]++
+}
+
+// Remove uncounts a value with n quads.
+func (h *Histogram) Remove(n int64) {
+	i := bucket(n)
+	if i >= len(*h) || (*h)[i] == 0 {
+		return
+	}
+	(*h)[i]--
+	for len(*h) > 0 && (*h)[len(*h)-1] == 0 {
+		*h = (*h)[:len(*h)-1]
+	}
+	if len(*h) == 0 {
+		*h = nil
+	}
+}
+
+// PredicateStats holds statistics about the quads with a single predicate.
+type PredicateStats struct {
+	// Quads is the number of quads with the predicate.
+	Quads int64
+
+	// Subjects and Objects are the numbers of distinct subjects and objects
+	// of those quads.
+	Subjects int64
+	Objects  int64
+
+	// SubjectFanout and ObjectFanout are the histograms of the number of
+	// quads of each subject and object.
+	SubjectFanout Histogram
+	ObjectFanout  Histogram
+}
+
+// Distinct returns the number of distinct values in direction d of the quads
+// with the predicate, or -1 if it is not known.
+func (s PredicateStats) Distinct(d quad.Direction) int64 {
+	switch d {
+	case quad.Subject:
+		return s.Subjects
+	case quad.Predicate:
+		if s.Quads == 0 {
+			return 0
+		}
+		return 1
+	case quad.Object:
+		return s.Objects
+	}
+	return -1
+}
+
+// Fanout returns the mean number of quads with the predicate for each value
+// in direction d, or -1 if it is not known.
+func (s PredicateStats) Fanout(d quad.Direction) int64 {
+	n := s.Distinct(d)
+	if n < 0 {
+		return -1
+	}
+	if n == 0 {
+		return 0
+	}
+	return (s.Quads + n - 1) / n
+}
+
+// WeightedFanout returns the mean number of quads with the predicate for the
+// value in direction d of a quad picked at random, as when checking a node
+// reached by following a link, or -1 if it is not known. It exceeds Fanout
+// when a few values hold most of the quads.
+func (s PredicateStats) WeightedFanout(d quad.Direction) int64 {
+	var h Histogram
+	switch d {
+	case quad.Subject:
+		h = s.SubjectFanout
+	case quad.Object:
+		h = s.ObjectFanout
+	default:
+		return s.Fanout(d)
+	}
+	// Each bucket is taken to hold values with the mean of its bounds.
+	var quads, weighted float64
+	for i, n := range h {
+		m := float64(int64(3)<<uint(i)-1) / 2
+		quads += float64(n) * m
+		weighted += float64(n) * m * m
+	}
+	if quads == 0 {
+		return 0
+	}
+	return int64(math.Ceil(weighted / quads))
+}
+
+// UpdateFanout records that the number of quads with the predicate and a
+// single value in direction d, which must be the subject or the object, went
+// from old to n.
+func (s *PredicateStats) UpdateFanout(d quad.Direction, old, n int64) {
+	count, h := &s.Subjects, &s.SubjectFanout
+	if d == quad.Object {
+		count, h = &s.Objects, &s.ObjectFanout
+	}
+	if old > 0 {
+		h.Remove(old)
+		*count--
+	}
+	if n > 0 {
+		h.Add(n)
+		*count++
+	}
+}
+
+// A FanoutKey identifies the quads with a predicate and a single value in
+// the subject or object direction, whose number QuadStores keeping
+// statistics record.
+type FanoutKey struct {
+	Predicate string
+	Dir       quad.Direction
+	Node      string
+}
+
+// A PredicateStatsUpdate accumulates the changes that a set of deltas makes to
+// the statistics of their predicates, for a QuadStore to write along with the
+// deltas.
+type PredicateStatsUpdate struct {
+	// Counts holds the changed number of quads of each FanoutKey, and
+	// Stats the changed statistics of each predicate.
+	Counts map[FanoutKey]int64
+	Stats  map[string]*PredicateStats
+
+	count func(FanoutKey) (int64, error)
+	stats func(p string) (PredicateStats, error)
+}
+
+// NewPredicateStatsUpdate returns an update of the statistics held by a
+// QuadStore, which reads the number of quads of a FanoutKey with count, and
+// the statistics of a predicate with stats.
+func NewPredicateStatsUpdate(count func(FanoutKey) (int64, error), stats func(p string) (PredicateStats, error)) *PredicateStatsUpdate {
+	return &PredicateStatsUpdate{
+		Counts: make(map[FanoutKey]int64),
+		Stats:  make(map[string]*PredicateStats),
+		count:  count,
+		stats:  stats,
+	}
+}
+
+// Add records that n copies of q, where n is 1 or -1, were added to the
+// store.
+func (u *PredicateStatsUpdate) Add(q quad.Quad, n int64) error {
+	st, ok := u.Stats[q.Predicate]
+	if !ok {
+		s, err := u.stats(q.Predicate)
+		if err != nil {
+			return err
+		}
+		// The histograms may be shared with statistics already handed out.
+		s.SubjectFanout = append(Histogram(nil), s.SubjectFanout...)
+		s.ObjectFanout = append(Histogram(nil), s.ObjectFanout...)
+		st = &s
+		u.Stats[q.Predicate] = st
+	}
+	st.Quads += n
+	for _, d := range []quad.Direction{quad.Subject, quad.Object} {
+		k := FanoutKey{Predicate: q.Predicate, Dir: d, Node: q.Get(d)}
+		old, ok := u.Counts[k]
+		if !ok {
+			var err error
+			old, err = u.count(k)
+			if err != nil {
+				return err
+			}
+		}
+		u.Counts[k] = old + n
+		st.UpdateFanout(d, old, old+n)
+	}
+	return nil
+}
@@ -15,15 +15,49 @@
 package graph
 
 import (
+	"errors"
+
 	"github.com/google/cayley/quad"
 )
 
+var (
+	// ErrPreconditionFailed is returned when a transaction is not applied
+	// because one of its preconditions does not hold.
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	// ErrPreconditionsUnsupported is returned when a transaction with
+	// preconditions is applied to a QuadStore that cannot check them.
+	ErrPreconditionsUnsupported = errors.New("quadstore cannot check preconditions")
+
+	// ErrAtomicityUnsupported is returned when a transaction of more than one
+	// change is applied to a QuadStore that cannot apply them atomically.
+	ErrAtomicityUnsupported = errors.New("quadstore cannot apply several changes atomically")
+)
+
+type Condition int8
+
+// The different conditions a transaction can require of the store.
+const (
+	QuadExists Condition = iota
+	QuadNotExist
+	HorizonEquals
+)
+
+// A Precondition is a condition on the state of the store that must hold for
+// a transaction to be applied.
+type Precondition struct {
+	Condition Condition
+	Quad      quad.Quad
+	Horizon   PrimaryKey
+}
+
 type Transaction struct {
-	Deltas []Delta
+	Deltas        []Delta
+	Preconditions []Precondition
 }
 
 func NewTransaction() *Transaction {
-	return &Transaction{make([]Delta, 0, 5)}
+	return &Transaction{Deltas: make([]Delta, 0, 5)}
 }
 
 func (t *Transaction) AddQuad(q quad.Quad) {
@@ -41,3 +75,66 @@ func (t *Transaction) RemoveQuad(q quad.Quad) {
 			Action: Delete,
 		})
 }
+
+// RequireQuad makes the transaction conditional on q being in the store.
+func (t *Transaction) RequireQuad(q quad.Quad) {
+	t.Preconditions = append(t.Preconditions,
+		Precondition{
+			Condition: QuadExists,
+			Quad:      q,
+		})
+}
+
+// RequireNoQuad makes the transaction conditional on q not being in the store.
+func (t *Transaction) RequireNoQuad(q quad.Quad) {
+	t.Preconditions = append(t.Preconditions,
+		Precondition{
+			Condition: QuadNotExist,
+			Quad:      q,
+		})
+}
+
+// RequireHorizon makes the transaction conditional on the horizon of the store
+// being h, that is, on no other changes having been made since h.
+func (t *Transaction) RequireHorizon(h PrimaryKey) {
+	t.Preconditions = append(t.Preconditions,
+		Precondition{
+			Condition: HorizonEquals,
+			Horizon:   h,
+		})
+}
+
+// PreconditionApplier is implemented by QuadStores that apply deltas
+// atomically, and can apply them only if preconditions hold, checking them
+// atomically with the write.
+type PreconditionApplier interface {
+	// ApplyDeltasIf applies the deltas as ApplyDeltas does, if all of
+	// the preconditions hold, and returns ErrPreconditionFailed if not.
+	ApplyDeltasIf([]Delta, []Precondition, IgnoreOpts) error
+}
+
+// CheckPreconditions returns ErrPreconditionFailed if any of conds does not hold
+// for a store at horizon h, using exists to report whether a quad is in the
+// store. Backends call it while holding the lock or transaction their deltas
+// are applied under.
+func CheckPreconditions(conds []Precondition, h PrimaryKey, exists func(quad.Quad) (bool, error)) error {
+	for _, c := range conds {
+		var ok bool
+		switch c.Condition {
+		case QuadExists, QuadNotExist:
+			found, err := exists(c.Quad)
+			if err != nil {
+				return err
+			}
+			ok = found == (c.Condition == QuadExists)
+		case HorizonEquals:
+			ok = c.Horizon.String() == h.String()
+		default:
+			return errors.New("graph: invalid precondition")
+		}
+		if !ok {
+			return ErrPreconditionFailed
+		}
+	}
+	return nil
+}
@@ -26,6 +26,7 @@ import (
 	"time"
 
 	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/path"
 	"github.com/google/cayley/internal"
 	"github.com/google/cayley/internal/config"
 	"github.com/google/cayley/internal/db"
@@ -567,6 +568,81 @@ func checkQueries(t *testing.T) {
 	}
 }
 
+// pushdownPaths are untagged traversals, which backends may evaluate
+// themselves rather than through the iterators.
+var pushdownPaths = []struct {
+	message string
+	path    func(qs graph.QuadStore) *path.Path
+}{
+	{
+		message: "films of an actor",
+		path: func(qs graph.QuadStore) *path.Path {
+			return path.StartPath(qs, "Keanu Reeves").In("name").In("/film/performance/actor").In("/film/film/starring").Out("name")
+		},
+	},
+	{
+		message: "costars of an actor",
+		path: func(qs graph.QuadStore) *path.Path {
+			return path.StartPath(qs, "Keanu Reeves").In("name").In("/film/performance/actor").In("/film/film/starring").
+				Out("/film/film/starring").Out("/film/performance/actor").Out("name")
+		},
+	},
+	{
+		message: "directors of films of either actor",
+		path: func(qs graph.QuadStore) *path.Path {
+			return path.StartPath(qs, "Keanu Reeves", "Sandra Bullock").In("name").In("/film/performance/actor").In("/film/film/starring").
+				Out("/film/film/directed_by").Out("name")
+		},
+	},
+	{
+		message: "anything two links out",
+		path: func(qs graph.QuadStore) *path.Path {
+			return path.StartPath(qs, "Casablanca").In("name").Out().Out()
+		},
+	},
+}
+
+// TestPushdown checks the traversals of pushdownPaths on the backend against
+// memstore, loaded with the same data.
+func TestPushdown(t *testing.T) {
+	prepare(t)
+	mem, err := graph.NewQuadStore("memstore", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to open memstore: %v", err)
+	}
+	w, err := graph.NewQuadWriter("single", mem, nil)
+	if err != nil {
+		t.Fatalf("Failed to open memstore writer: %v", err)
+	}
+	err = internal.Load(w, cfg, "../data/30kmoviedata.nq.gz", "cquad")
+	if err != nil {
+		t.Fatalf("Failed to load memstore: %v", err)
+	}
+	for _, test := range pushdownPaths {
+		got := nodeNames(handle.QuadStore, test.path(handle.QuadStore))
+		expect := nodeNames(mem, test.path(mem))
+		if len(expect) == 0 {
+			t.Errorf("No results for %s.", test.message)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("Unexpected results for %s, got %d results, expect %d.", test.message, len(got), len(expect))
+		}
+	}
+}
+
+// nodeNames returns the sorted names of the nodes of the optimized iterator of
+// p, once for each time it returns them.
+func nodeNames(qs graph.QuadStore, p *path.Path) []string {
+	it, _ := p.BuildIterator().Optimize()
+	defer it.Close()
+	var names []string
+	for graph.Next(it) {
+		names = append(names, qs.NameOf(it.Result()))
+	}
+	sort.Strings(names)
+	return names
+}
+
 func unsortedEqual(got, expect []interface{}) bool {
 	gotList := convertToStringList(got)
 	expectList := convertToStringList(expect)
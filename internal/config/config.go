@@ -34,7 +34,22 @@ type Config struct {
 	ReadOnly                   bool
 	Timeout                    time.Duration
 	LoadSize                   int
+	IteratorMemory             int
 	RequiresHTTPRequestContext bool
+
+	// LoadLenient is whether loads skip statements that cannot be parsed
+	// rather than failing, writing them to the file LoadDeadLetter if it is
+	// not empty.
+	LoadLenient    bool
+	LoadDeadLetter string
+	// LoadMapping names the file describing how the rows of csv and tsv
+	// input are loaded as quads.
+	LoadMapping string
+	// SkolemPrefix is the start of the IRIs that loads rewrite blank nodes
+	// as, if not the default, unless PreserveBlankNodes is set, in which
+	// case they keep their labels.
+	SkolemPrefix       string
+	PreserveBlankNodes bool
 }
 
 type config struct {
@@ -48,6 +63,7 @@ type config struct {
 	ReadOnly                   bool                   `json:"read_only"`
 	Timeout                    duration               `json:"timeout"`
 	LoadSize                   int                    `json:"load_size"`
+	IteratorMemory             int                    `json:"iterator_memory"`
 	RequiresHTTPRequestContext bool                   `json:"http_request_context"`
 }
 
@@ -68,6 +84,7 @@ func (c *Config) UnmarshalJSON(data []byte) error {
 		ReadOnly:                   t.ReadOnly,
 		Timeout:                    time.Duration(t.Timeout),
 		LoadSize:                   t.LoadSize,
+		IteratorMemory:             t.IteratorMemory,
 		RequiresHTTPRequestContext: t.RequiresHTTPRequestContext,
 	}
 	return nil
@@ -85,6 +102,7 @@ func (c *Config) MarshalJSON() ([]byte, error) {
 		ReadOnly:           c.ReadOnly,
 		Timeout:            duration(c.Timeout),
 		LoadSize:           c.LoadSize,
+		IteratorMemory:     c.IteratorMemory,
 	})
 }
 
@@ -17,7 +17,6 @@ package db
 import (
 	"errors"
 	"fmt"
-	"io"
 
 	"github.com/barakmich/glog"
 
@@ -68,38 +67,70 @@ func OpenQuadWriter(qs graph.QuadStore, cfg *config.Config) (graph.QuadWriter, e
 	return w, nil
 }
 
-func Load(qw graph.QuadWriter, cfg *config.Config, dec quad.Unmarshaler) error {
-	block := make([]quad.Quad, 0, cfg.LoadSize)
+// Dump writes every quad in qs to enc. If label is not empty, only quads with
+// that label are written. Quads are read from a snapshot when the QuadStore
+// supports it, so that concurrent writes do not affect the output.
+func Dump(qs graph.QuadStore, enc quad.Marshaler, label string) error {
+	if s, ok := qs.(graph.Snapshotter); ok {
+		snap, err := s.Snapshot()
+		if err != nil {
+			return fmt.Errorf("db: failed to take snapshot: %v", err)
+		}
+		defer snap.Close()
+		qs = snap
+	}
+
+	var it graph.Iterator
+	if label == "" {
+		it = qs.QuadsAllIterator()
+	} else {
+		it = qs.QuadIterator(quad.Label, qs.ValueOf(label))
+	}
+	defer it.Close()
+
 	count := 0
+	batch := make([]graph.Value, 100)
 	for {
-		t, err := dec.Unmarshal()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
+		n := graph.NextBatch(it, batch)
+		if n == 0 {
+			break
 		}
-		block = append(block, t)
-		if len(block) == cap(block) {
-			count += len(block)
-			err := qw.AddQuadSet(block)
+		for _, v := range batch[:n] {
+			err := enc.Marshal(qs.Quad(v))
 			if err != nil {
-				return fmt.Errorf("db: failed to load data: %v", err)
+				return fmt.Errorf("db: failed to dump data: %v", err)
 			}
-			block = block[:0]
-			if glog.V(2) {
-				glog.V(2).Infof("Wrote %d quads.", count)
+			count++
+			if glog.V(2) && count%10000 == 0 {
+				glog.V(2).Infof("Dumped %d quads.", count)
 			}
 		}
 	}
-	count += len(block)
-	err := qw.AddQuadSet(block)
-	if err != nil {
-		return fmt.Errorf("db: failed to load data: %v", err)
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("db: failed to dump data: %v", err)
 	}
 	if glog.V(2) {
-		glog.V(2).Infof("Wrote %d quads.", count)
+		glog.V(2).Infof("Dumped %d quads.", count)
 	}
 
 	return nil
 }
+
+// Fsck checks the internal consistency of qs, logging each problem found, and
+// returns the number of problems. If repair is true, the problems are also
+// corrected.
+func Fsck(qs graph.QuadStore, repair bool) (int, error) {
+	c, ok := qs.(graph.Checker)
+	if !ok {
+		return 0, fmt.Errorf("db: %s does not support integrity checks", qs.Type())
+	}
+	n := 0
+	err := c.Check(repair, func(err error) {
+		n++
+		glog.Errorln(err)
+	})
+	if err != nil {
+		return n, fmt.Errorf("db: failed to check database: %v", err)
+	}
+	return n, nil
+}
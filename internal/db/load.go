@@ -0,0 +1,320 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/barakmich/glog"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/internal/config"
+	"github.com/google/cayley/quad"
+)
+
+// progressInterval is the minimum time between progress reports.
+var progressInterval = 10 * time.Second
+
+// progress reports the number of items processed by a long running operation.
+// If read is not nil, it reports the number of bytes of input consumed, which
+// together with size, the total number of bytes when known, gives an
+// estimate of the time remaining.
+type progress struct {
+	verb  string
+	noun  string
+	n     int64
+	start time.Time
+	last  time.Time
+	read  func() int64
+	size  int64
+}
+
+func newProgress(verb, noun string) *progress {
+	now := time.Now()
+	return &progress{verb: verb, noun: noun, start: now, last: now}
+}
+
+func (p *progress) add(n int) {
+	p.n += int64(n)
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		glog.Infof("%s %d %s (%.0f/s)%s.", p.verb, p.n, p.noun, p.rate(now), p.input(now))
+	}
+}
+
+func (p *progress) done() {
+	glog.Infof("%s %d %s in %v (%.0f/s).", p.verb, p.n, p.noun, time.Since(p.start), p.rate(time.Now()))
+}
+
+func (p *progress) rate(now time.Time) float64 {
+	d := now.Sub(p.start).Seconds()
+	if d == 0 {
+		return 0
+	}
+	return float64(p.n) / d
+}
+
+// input describes how much of the input has been read.
+func (p *progress) input(now time.Time) string {
+	if p.read == nil {
+		return ""
+	}
+	read := p.read()
+	if p.size <= 0 {
+		return fmt.Sprintf(", %s read", byteSize(read))
+	}
+	s := fmt.Sprintf(", %s of %s read", byteSize(read), byteSize(p.size))
+	if read > 0 && read < p.size {
+		elapsed := now.Sub(p.start)
+		eta := time.Duration(float64(elapsed) * float64(p.size-read) / float64(read))
+		s += fmt.Sprintf(", %v remaining", eta-eta%time.Second)
+	}
+	return s
+}
+
+type byteSize int64
+
+func (b byteSize) String() string {
+	const unit = 1 << 10
+	if b < unit {
+		return fmt.Sprintf("%dB", int64(b))
+	}
+	n, exp := float64(b)/unit, 0
+	for n >= unit && exp < 4 {
+		n /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", n, "KMGTP"[exp])
+}
+
+// loadQueue is the number of decoded blocks of quads that may wait to be
+// written while loading.
+var loadQueue = 4
+
+// LoadOptions holds optional parameters for loading quads.
+type LoadOptions struct {
+	// Skip is the number of quads at the start of the input that have
+	// already been loaded. They are decoded and discarded.
+	Skip int64
+
+	// Loaded is the number of quads loaded from a part of the input that
+	// is not read again, as when a load resumes from an offset.
+	Loaded int64
+
+	// Offset, if not nil, returns the position in the input of the end of
+	// the last quad decoded, or -1 if it is not known.
+	Offset func() int64
+
+	// Commit, if not nil, is called after each block of quads is written
+	// with the number of quads of the input loaded so far, including
+	// those skipped or loaded before, and the offset of the end of the
+	// block, or -1 if it is not known.
+	Commit func(n, offset int64) error
+
+	// Read, if not nil, returns the number of bytes of input consumed so
+	// far. Size is the total size of the input, or zero if it is unknown.
+	Read func() int64
+	Size int64
+}
+
+// Load writes the quads read from dec to qw in blocks of cfg.LoadSize.
+func Load(qw graph.QuadWriter, cfg *config.Config, dec quad.Unmarshaler) error {
+	return LoadWith(qw, cfg, dec, LoadOptions{})
+}
+
+// LoadWith writes the quads read from dec to qw in blocks of cfg.LoadSize,
+// as described by opts. Decoding runs concurrently with writing, and
+// progress is logged periodically.
+func LoadWith(qw graph.QuadWriter, cfg *config.Config, dec quad.Unmarshaler, opts LoadOptions) error {
+	blocks := make(chan block, loadQueue)
+	quit := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		defer close(blocks)
+		errc <- decodeBlocks(dec, cfg.LoadSize, opts.Skip, opts.Offset, blocks, quit)
+	}()
+
+	p := newProgress("Loaded", "quads")
+	p.read = opts.Read
+	p.size = opts.Size
+	n := opts.Loaded + opts.Skip
+	var err error
+	for b := range blocks {
+		err = qw.AddQuadSet(b.quads)
+		if err != nil {
+			err = fmt.Errorf("db: failed to load data: %v", err)
+			break
+		}
+		n += int64(len(b.quads))
+		p.add(len(b.quads))
+		if opts.Commit != nil {
+			err = opts.Commit(n, b.offset)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		close(quit)
+		for range blocks {
+		}
+		return err
+	}
+	err = <-errc
+	if err != nil {
+		return err
+	}
+	p.done()
+	return nil
+}
+
+// block is a group of quads decoded together, and the offset in the input of
+// the end of its last quad, or -1 if it is not known.
+type block struct {
+	quads  []quad.Quad
+	offset int64
+}
+
+// decodeBlocks sends the quads read from dec to blocks in groups of size,
+// after discarding the first skip quads. The offset of each block is given
+// by offset, if it is not nil. It returns early if quit is closed.
+func decodeBlocks(dec quad.Unmarshaler, size int, skip int64, offset func() int64, blocks chan<- block, quit <-chan struct{}) error {
+	if skip > 0 {
+		glog.Infof("Skipping %d quads already loaded.", skip)
+	}
+	for i := int64(0); i < skip; i++ {
+		_, err := dec.Unmarshal()
+		if err == io.EOF {
+			return fmt.Errorf("db: input ended after %d of %d previously loaded quads", i, skip)
+		} else if err != nil {
+			return err
+		}
+	}
+	end := func(quads []quad.Quad) block {
+		b := block{quads: quads, offset: -1}
+		if offset != nil {
+			b.offset = offset()
+		}
+		return b
+	}
+	quads := make([]quad.Quad, 0, size)
+	for {
+		q, err := dec.Unmarshal()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		quads = append(quads, q)
+		if len(quads) < size {
+			continue
+		}
+		select {
+		case blocks <- end(quads):
+		case <-quit:
+			return nil
+		}
+		quads = make([]quad.Quad, 0, size)
+	}
+	if len(quads) != 0 {
+		select {
+		case blocks <- end(quads):
+		case <-quit:
+		}
+	}
+	return nil
+}
+
+// progressUnmarshaler counts the quads read from a quad.Unmarshaler.
+type progressUnmarshaler struct {
+	dec quad.Unmarshaler
+	p   *progress
+}
+
+func (u progressUnmarshaler) Unmarshal() (quad.Quad, error) {
+	q, err := u.dec.Unmarshal()
+	if err == nil {
+		u.p.add(1)
+	}
+	return q, err
+}
+
+// BulkLoad loads the quads read from dec into h. If the QuadStore of h is a
+// BulkLoader and is able to load them, the quads are written directly to the
+// QuadStore, and the QuadWriter of h, whose horizon is then out of date, is
+// replaced by a new one. Otherwise the quads are written through the
+// QuadWriter as by LoadWith. Bulk loading is not resumable, so it is not
+// attempted when opts asks to skip quads or to be told of commits.
+//
+// A load that resumes after quads already loaded ignores duplicate quads, as
+// the block being written when it stopped may have been written in full.
+func BulkLoad(h *graph.Handle, cfg *config.Config, dec quad.Unmarshaler, opts LoadOptions) error {
+	qs := h.QuadStore
+	if opts.Skip > 0 || opts.Loaded > 0 {
+		return resume(h, cfg, dec, opts)
+	}
+	bl, ok := qs.(graph.BulkLoader)
+	if ok && opts.Commit == nil {
+		p := newProgress("Read", "quads")
+		p.read = opts.Read
+		p.size = opts.Size
+		err := bl.BulkLoad(progressUnmarshaler{dec: dec, p: p})
+		if err != graph.ErrCannotBulkLoad {
+			if err != nil {
+				return fmt.Errorf("db: failed to bulk load data: %v", err)
+			}
+			glog.Infof("Bulk loaded %d quads in %v.", qs.Size(), time.Since(p.start))
+			qw, err := OpenQuadWriter(qs, cfg)
+			if err != nil {
+				return err
+			}
+			h.QuadWriter.Close()
+			h.QuadWriter = qw
+			return nil
+		}
+		glog.Infoln("Database is not empty, loading quads through the writer.")
+	}
+	return LoadWith(h.QuadWriter, cfg, dec, opts)
+}
+
+// resume loads the rest of an interrupted load through a writer that ignores
+// duplicate quads, and then replaces the QuadWriter of h, whose horizon is
+// out of date.
+func resume(h *graph.Handle, cfg *config.Config, dec quad.Unmarshaler, opts LoadOptions) error {
+	rcfg := *cfg
+	rcfg.ReplicationOptions = make(graph.Options)
+	for k, v := range cfg.ReplicationOptions {
+		rcfg.ReplicationOptions[k] = v
+	}
+	rcfg.ReplicationOptions["ignore_duplicate"] = true
+	qw, err := OpenQuadWriter(h.QuadStore, &rcfg)
+	if err != nil {
+		return err
+	}
+	err = LoadWith(qw, cfg, dec, opts)
+	qw.Close()
+	if err != nil {
+		return err
+	}
+	qw, err = OpenQuadWriter(h.QuadStore, cfg)
+	if err != nil {
+		return err
+	}
+	h.QuadWriter.Close()
+	h.QuadWriter = qw
+	return nil
+}
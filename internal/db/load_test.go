@@ -0,0 +1,172 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/internal/config"
+	"github.com/google/cayley/quad"
+)
+
+// quadSlice is a quad.Unmarshaler reading from a slice.
+type quadSlice []quad.Quad
+
+func (s *quadSlice) Unmarshal() (quad.Quad, error) {
+	if len(*s) == 0 {
+		return quad.Quad{}, io.EOF
+	}
+	q := (*s)[0]
+	*s = (*s)[1:]
+	return q, nil
+}
+
+// recordingWriter records the blocks of quads written to it, failing once
+// fail blocks have been written if fail is positive.
+type recordingWriter struct {
+	blocks [][]quad.Quad
+	fail   int
+}
+
+var errWrite = errors.New("write failed")
+
+func (w *recordingWriter) AddQuadSet(set []quad.Quad) error {
+	if w.fail > 0 && len(w.blocks) == w.fail {
+		return errWrite
+	}
+	w.blocks = append(w.blocks, set)
+	return nil
+}
+
+func (w *recordingWriter) AddQuad(q quad.Quad) error                 { return w.AddQuadSet([]quad.Quad{q}) }
+func (w *recordingWriter) RemoveQuad(quad.Quad) error                { return nil }
+func (w *recordingWriter) ApplyTransaction(*graph.Transaction) error { return nil }
+func (w *recordingWriter) Close() error                              { return nil }
+
+func loadQuads(n int) []quad.Quad {
+	var quads []quad.Quad
+	for i := 0; i < n; i++ {
+		quads = append(quads, quad.Quad{Subject: fmt.Sprint("node", i), Predicate: "follows", Object: "root"})
+	}
+	return quads
+}
+
+var loadTests = []struct {
+	message string
+	skip    int64
+	fail    int
+	blocks  []int
+	commits []int64
+	err     bool
+}{
+	{
+		message: "load all quads",
+		blocks:  []int{3, 3, 3, 1},
+		commits: []int64{3, 6, 9, 10},
+	},
+	{
+		message: "resume a load",
+		skip:    6,
+		blocks:  []int{3, 1},
+		commits: []int64{9, 10},
+	},
+	{
+		message: "stop on a failed write",
+		fail:    2,
+		blocks:  []int{3, 3},
+		commits: []int64{3, 6},
+		err:     true,
+	},
+	{
+		message: "skip past the end of the input",
+		skip:    11,
+		err:     true,
+	},
+}
+
+func TestLoadWith(t *testing.T) {
+	defer func(n int) { loadQueue = n }(loadQueue)
+	loadQueue = 1
+	cfg := &config.Config{LoadSize: 3}
+	for _, test := range loadTests {
+		quads := loadQuads(10)
+		dec := quadSlice(quads)
+		w := &recordingWriter{fail: test.fail}
+		var commits []int64
+		err := LoadWith(w, cfg, &dec, LoadOptions{
+			Skip: test.skip,
+			Commit: func(n, _ int64) error {
+				commits = append(commits, n)
+				return nil
+			},
+		})
+		if (err != nil) != test.err {
+			t.Errorf("Unexpected error to %s, got:%v", test.message, err)
+		}
+		if !reflect.DeepEqual(commits, test.commits) {
+			t.Errorf("Unexpected commits to %s, got:%v expect:%v", test.message, commits, test.commits)
+		}
+		var sizes []int
+		var got []quad.Quad
+		for _, b := range w.blocks {
+			sizes = append(sizes, len(b))
+			got = append(got, b...)
+		}
+		if !reflect.DeepEqual(sizes, test.blocks) {
+			t.Errorf("Unexpected block sizes to %s, got:%v expect:%v", test.message, sizes, test.blocks)
+		}
+		if test.err {
+			continue
+		}
+		if expect := quads[test.skip:]; !reflect.DeepEqual(got, expect) {
+			t.Errorf("Unexpected quads to %s, got:%v expect:%v", test.message, got, expect)
+		}
+	}
+}
+
+func TestBulkLoadWriter(t *testing.T) {
+	cfg := &config.Config{ReplicationType: "single", LoadSize: 2}
+	qs, path := newBolt(t)
+	defer os.Remove(path)
+	qw, err := OpenQuadWriter(qs, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	h := &graph.Handle{QuadStore: qs, QuadWriter: qw}
+	defer h.Close()
+
+	dec := quadSlice(migrateQuads)
+	err = BulkLoad(h, cfg, &dec, LoadOptions{})
+	if err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	// The writer must continue from the horizon of the bulk load.
+	err = h.QuadWriter.AddQuad(quad.Quad{Subject: "E", Predicate: "follows", Object: "F"})
+	if err != nil {
+		t.Fatalf("Failed to write after bulk loading: %v", err)
+	}
+	if got, expect := qs.Size(), int64(len(migrateQuads)+1); got != expect {
+		t.Errorf("Unexpected size, got:%d expect:%d", got, expect)
+	}
+	if got, expect := countDeltas(t, qs), len(migrateQuads)+1; got != expect {
+		t.Errorf("Unexpected number of deltas, got:%d expect:%d", got, expect)
+	}
+}
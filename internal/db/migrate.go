@@ -0,0 +1,105 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/internal/config"
+	"github.com/google/cayley/quad"
+)
+
+// iteratorUnmarshaler presents the quads of an iterator as a quad.Unmarshaler.
+type iteratorUnmarshaler struct {
+	qs graph.QuadStore
+	it graph.Iterator
+}
+
+func (u *iteratorUnmarshaler) Unmarshal() (quad.Quad, error) {
+	if !graph.Next(u.it) {
+		if err := u.it.Err(); err != nil {
+			return quad.Quad{}, err
+		}
+		return quad.Quad{}, io.EOF
+	}
+	return u.qs.Quad(u.it.Result()), nil
+}
+
+// Migrate copies the contents of src to dst.
+//
+// If both QuadStores keep a delta log and dst is empty, the complete history
+// of src is replayed into dst, preserving delta IDs and timestamps. Otherwise
+// the current quads of src are written to dst, using bulk loading if dst
+// supports it.
+func Migrate(dst, src graph.QuadStore, cfg *config.Config) error {
+	if s, ok := src.(graph.Snapshotter); ok {
+		snap, err := s.Snapshot()
+		if err != nil {
+			return fmt.Errorf("db: failed to take snapshot: %v", err)
+		}
+		defer snap.Close()
+		src = snap
+	}
+
+	srcLog, ok := src.(graph.DeltaLogger)
+	_, dstLogs := dst.(graph.DeltaLogger)
+	horizon := dst.Horizon()
+	if ok && dstLogs && horizon.Int() == 0 {
+		return migrateHistory(dst, srcLog, cfg)
+	}
+
+	it := src.QuadsAllIterator()
+	defer it.Close()
+	qw, err := OpenQuadWriter(dst, cfg)
+	if err != nil {
+		return err
+	}
+	h := &graph.Handle{QuadStore: dst, QuadWriter: qw}
+	defer func() { h.QuadWriter.Close() }()
+	return BulkLoad(h, cfg, &iteratorUnmarshaler{qs: src, it: it}, LoadOptions{})
+}
+
+// migrateHistory replays every delta logged by src into dst.
+func migrateHistory(dst graph.QuadStore, src graph.DeltaLogger, cfg *config.Config) error {
+	// The log records deltas that were ignored as duplicates or missing
+	// when they were first applied, so they must be ignored again here.
+	ignore := graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true}
+	p := newProgress("Replayed", "deltas")
+	block := make([]graph.Delta, 0, cfg.LoadSize)
+	err := src.Deltas(func(d graph.Delta) error {
+		block = append(block, d)
+		if len(block) < cap(block) {
+			return nil
+		}
+		err := dst.ApplyDeltas(block, ignore)
+		if err != nil {
+			return err
+		}
+		p.add(len(block))
+		block = block[:0]
+		return nil
+	})
+	if err == nil && len(block) != 0 {
+		err = dst.ApplyDeltas(block, ignore)
+		p.add(len(block))
+	}
+	if err != nil {
+		return fmt.Errorf("db: failed to replay history: %v", err)
+	}
+	p.done()
+	return nil
+}
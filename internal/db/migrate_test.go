@@ -0,0 +1,140 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/cayley/graph"
+	_ "github.com/google/cayley/graph/bolt"
+	_ "github.com/google/cayley/graph/memstore"
+	"github.com/google/cayley/internal/config"
+	"github.com/google/cayley/quad"
+	_ "github.com/google/cayley/writer"
+)
+
+var migrateQuads = []quad.Quad{
+	{Subject: "A", Predicate: "follows", Object: "B"},
+	{Subject: "C", Predicate: "follows", Object: "B"},
+	{Subject: "B", Predicate: "status", Object: "cool", Label: "status_graph"},
+	{Subject: "D", Predicate: "follows", Object: "A"},
+}
+
+type ordered []quad.Quad
+
+func (o ordered) Len() int           { return len(o) }
+func (o ordered) Less(i, j int) bool { return o[i].NQuad() < o[j].NQuad() }
+func (o ordered) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+
+func allQuads(qs graph.QuadStore) []quad.Quad {
+	var res ordered
+	it := qs.QuadsAllIterator()
+	defer it.Close()
+	for graph.Next(it) {
+		res = append(res, qs.Quad(it.Result()))
+	}
+	sort.Sort(res)
+	return res
+}
+
+func countDeltas(t *testing.T, qs graph.QuadStore) int {
+	var n int
+	err := qs.(graph.DeltaLogger).Deltas(func(graph.Delta) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to read deltas: %v", err)
+	}
+	return n
+}
+
+func newBolt(t *testing.T) (graph.QuadStore, string) {
+	tmpFile, err := ioutil.TempFile(os.TempDir(), "cayley_test")
+	if err != nil {
+		t.Fatalf("Could not create working file: %v", err)
+	}
+	tmpFile.Close()
+	err = graph.InitQuadStore("bolt", tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create bolt database: %v", err)
+	}
+	qs, err := graph.NewQuadStore("bolt", tmpFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to open bolt database: %v", err)
+	}
+	return qs, tmpFile.Name()
+}
+
+func TestMigrate(t *testing.T) {
+	cfg := &config.Config{ReplicationType: "single", LoadSize: 2}
+
+	src, err := graph.NewQuadStore("memstore", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create memstore: %v", err)
+	}
+	w, err := graph.NewQuadWriter("single", src, nil)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	w.AddQuadSet(migrateQuads)
+	w.RemoveQuad(migrateQuads[3])
+	expect := allQuads(src)
+
+	// An empty destination receives the full history.
+	dst, path := newBolt(t)
+	defer os.Remove(path)
+	err = Migrate(dst, src, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error migrating history: %v", err)
+	}
+	if got := allQuads(dst); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected quads after migrating history, got:%v expect:%v", got, expect)
+	}
+	if got, want := dst.Horizon(), src.Horizon(); got.Int() != want.Int() {
+		t.Errorf("Unexpected horizon after migrating history, got:%d expect:%d", got.Int(), want.Int())
+	}
+	if got, want := countDeltas(t, dst), countDeltas(t, src); got != want {
+		t.Errorf("Unexpected number of deltas after migrating history, got:%d expect:%d", got, want)
+	}
+	if s := dst.Size(); s != int64(len(expect)) {
+		t.Errorf("Unexpected size after migrating history, got:%d expect:%d", s, len(expect))
+	}
+	dst.Close()
+
+	// A non-empty destination receives only the current quads.
+	dst, path = newBolt(t)
+	defer os.Remove(path)
+	extra := quad.Quad{Subject: "E", Predicate: "follows", Object: "F"}
+	dw, _ := graph.NewQuadWriter("single", dst, nil)
+	dw.AddQuad(extra)
+	err = Migrate(dst, src, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error migrating quads: %v", err)
+	}
+	want := append(ordered{extra}, expect...)
+	sort.Sort(want)
+	if got := allQuads(dst); !reflect.DeepEqual(got, []quad.Quad(want)) {
+		t.Errorf("Unexpected quads after migrating, got:%v expect:%v", got, want)
+	}
+	if got, expect := countDeltas(t, dst), len(want); got != expect {
+		t.Errorf("Unexpected number of deltas after migrating, got:%d expect:%d", got, expect)
+	}
+	dst.Close()
+}
@@ -87,6 +87,9 @@ func Repl(h *graph.Handle, queryLanguage string, cfg *config.Config) error {
 	default:
 		ses = gremlin.NewSession(h.QuadStore, cfg.Timeout, true)
 	}
+	if ses, ok := ses.(query.Spiller); ok {
+		ses.SetSpillBudget(int64(cfg.IteratorMemory) << 20)
+	}
 
 	term, err := terminal(history)
 	if os.IsNotExist(err) {
@@ -44,3 +44,9 @@ func Decompressor(r io.Reader) (io.Reader, error) {
 		return br, nil
 	}
 }
+
+// isCompressed returns whether data starting with head is compressed in a
+// format read by Decompressor.
+func isCompressed(head []byte) bool {
+	return bytes.HasPrefix(head, []byte(gzipMagic)) || bytes.HasPrefix(head, []byte(b2zipMagic))
+}
@@ -0,0 +1,130 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/cayley/export"
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/internal/db"
+	"github.com/google/cayley/quad"
+	"github.com/google/cayley/quad/bquads"
+	"github.com/google/cayley/quad/jsonld"
+	"github.com/google/cayley/quad/nquads"
+)
+
+// encoders holds the quad encoders available for dumping, keyed by format name.
+var encoders = map[string]func(io.Writer) quad.Marshaler{
+	// cquads is a superset of N-Quads, so both are written as N-Quads.
+	"cquad":  newNQuadEncoder,
+	"nquad":  newNQuadEncoder,
+	"jsonld": newJSONLDEncoder,
+	"bquad":  newBQuadEncoder,
+	// Property graph formats for visualization tools.
+	"graphml": newGraphMLEncoder,
+	"gexf":    newGEXFEncoder,
+	"dot":     newDOTEncoder,
+}
+
+func newNQuadEncoder(w io.Writer) quad.Marshaler {
+	return nquads.NewEncoder(w)
+}
+
+func newBQuadEncoder(w io.Writer) quad.Marshaler {
+	return bquads.NewEncoder(w)
+}
+
+func newJSONLDEncoder(w io.Writer) quad.Marshaler {
+	return jsonld.NewEncoder(w)
+}
+
+func newGraphMLEncoder(w io.Writer) quad.Marshaler {
+	return export.NewGraphMLEncoder(w)
+}
+
+func newGEXFEncoder(w io.Writer) quad.Marshaler {
+	return export.NewGEXFEncoder(w)
+}
+
+func newDOTEncoder(w io.Writer) quad.Marshaler {
+	return export.NewDOTEncoder(w)
+}
+
+// Dump writes the contents of qs to the file at path in the given format, or
+// in the format named by the path's extension if typ is empty. A path of "-"
+// writes to standard output. The output is gzip compressed if
+// compress is true or the path ends in ".gz". If label is not empty, only
+// quads with that label are written.
+func Dump(qs graph.QuadStore, path, typ, label string, compress bool) error {
+	if path == "" {
+		return fmt.Errorf("no dump path given")
+	}
+	if typ == "" {
+		typ = formatOf(path, nil, "")
+		if _, ok := encoders[typ]; !ok {
+			typ = "nquad"
+		}
+	}
+	newEncoder, ok := encoders[typ]
+	if !ok {
+		return fmt.Errorf("unknown quad format %q", typ)
+	}
+
+	var w io.Writer
+	if path == "-" {
+		w = os.Stdout
+	} else {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("could not create file %q: %v", path, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var gz *gzip.Writer
+	if compress || strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	bw := bufio.NewWriter(w)
+
+	enc := newEncoder(bw)
+	err := db.Dump(qs, enc, label)
+	if err != nil {
+		return err
+	}
+	// Encoders that write whole documents finish them when closed.
+	if c, ok := enc.(io.Closer); ok {
+		err = c.Close()
+		if err != nil {
+			return err
+		}
+	}
+	err = bw.Flush()
+	if err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/cayley/graph"
+	_ "github.com/google/cayley/graph/memstore"
+	"github.com/google/cayley/quad"
+	"github.com/google/cayley/quad/cquads"
+	_ "github.com/google/cayley/writer"
+)
+
+var dumpQuads = []quad.Quad{
+	{Subject: "<alice>", Predicate: "<follows>", Object: "<bob>"},
+	{Subject: "<bob>", Predicate: "<name>", Object: `"Bob "the builder""`, Label: "<people>"},
+	{Subject: "_:b0", Predicate: "<status>", Object: "cool person", Label: "<people>"},
+	{Subject: "<charlie>", Predicate: "<follows>", Object: "<alice>"},
+}
+
+type byString []quad.Quad
+
+func (o byString) Len() int           { return len(o) }
+func (o byString) Less(i, j int) bool { return o[i].NQuad() < o[j].NQuad() }
+func (o byString) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+
+var testDump = []struct {
+	message  string
+	file     string
+	label    string
+	compress bool
+	expect   []quad.Quad
+}{
+	{
+		message: "dump all quads",
+		file:    "dump.nq",
+		expect:  dumpQuads[:3],
+	},
+	{
+		message:  "dump all quads compressed",
+		file:     "dump.nq",
+		compress: true,
+		expect:   dumpQuads[:3],
+	},
+	{
+		message: "dump all quads compressed by file name",
+		file:    "dump.nq.gz",
+		expect:  dumpQuads[:3],
+	},
+	{
+		message: "dump labelled quads",
+		file:    "dump.nq",
+		label:   "<people>",
+		expect:  dumpQuads[1:3],
+	},
+}
+
+func TestDump(t *testing.T) {
+	qs, err := graph.NewQuadStore("memstore", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create memstore: %v", err)
+	}
+	w, err := graph.NewQuadWriter("single", qs, nil)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	w.AddQuadSet(dumpQuads)
+	w.RemoveQuad(dumpQuads[3])
+
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "cayley_test")
+	if err != nil {
+		t.Fatalf("Could not create working directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, test := range testDump {
+		path := filepath.Join(tmpDir, test.file)
+		err := Dump(qs, path, "nquad", test.label, test.compress)
+		if err != nil {
+			t.Errorf("Unexpected error when %s: %v", test.message, err)
+			continue
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open dump: %v", err)
+		}
+		r, err := Decompressor(f)
+		if err != nil {
+			t.Fatalf("Failed to read dump: %v", err)
+		}
+		var got []quad.Quad
+		dec := cquads.NewDecoder(r)
+		for {
+			q, err := dec.Unmarshal()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to decode dump when %s: %v", test.message, err)
+			}
+			got = append(got, q)
+		}
+		f.Close()
+
+		var expect []quad.Quad
+		for _, q := range test.expect {
+			// cquads removes IRI brackets and literal quotes.
+			for _, d := range []quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label} {
+				v := q.Get(d)
+				if len(v) >= 2 && (v[0] == '<' || v[0] == '"') {
+					v = v[1 : len(v)-1]
+				}
+				switch d {
+				case quad.Subject:
+					q.Subject = v
+				case quad.Predicate:
+					q.Predicate = v
+				case quad.Object:
+					q.Object = v
+				case quad.Label:
+					q.Label = v
+				}
+			}
+			expect = append(expect, q)
+		}
+		sort.Sort(byString(got))
+		sort.Sort(byString(expect))
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("Unexpected result when %s, got:%v expect:%v", test.message, got, expect)
+		}
+	}
+
+	if err := Dump(qs, filepath.Join(tmpDir, "bad"), "nope", "", false); err == nil {
+		t.Error("Expected error for unknown dump format")
+	}
+}
+
+func TestDumpBinary(t *testing.T) {
+	qs, err := graph.NewQuadStore("memstore", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create memstore: %v", err)
+	}
+	w, err := graph.NewQuadWriter("single", qs, nil)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	w.AddQuadSet(dumpQuads)
+
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "cayley_test")
+	if err != nil {
+		t.Fatalf("Could not create working directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "dump.bq.gz")
+	err = Dump(qs, path, "bquad", "", false)
+	if err != nil {
+		t.Fatalf("Unexpected error dumping binary quads: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open dump: %v", err)
+	}
+	defer f.Close()
+
+	// Binary quads are detected whatever the format asked for.
+	dec, err := newDecoder(f, path, "nquad")
+	if err != nil {
+		t.Fatalf("Failed to read dump: %v", err)
+	}
+	var got []quad.Quad
+	for {
+		q, err := dec.Unmarshal()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to decode dump: %v", err)
+		}
+		got = append(got, q)
+	}
+	expect := append([]quad.Quad(nil), dumpQuads...)
+	sort.Sort(byString(got))
+	sort.Sort(byString(expect))
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected binary dump, got:%v expect:%v", got, expect)
+	}
+}
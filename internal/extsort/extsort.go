@@ -0,0 +1,194 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package extsort implements an external merge sort of byte records, for
+// sorting more data than fits in memory.
+package extsort
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// recordOverhead approximates the memory used by a buffered record beyond
+// its contents.
+const recordOverhead = 24
+
+// Sorter sorts records in bytes.Compare order. Records are buffered in
+// memory until the buffer is full, at which point they are sorted and
+// spilled to a temporary file as a run. The runs are merged when the
+// records are read back.
+type Sorter struct {
+	dir  string
+	max  int
+	size int
+	buf  records
+	runs []*os.File
+}
+
+// New returns a Sorter that holds about max bytes of records in memory and
+// writes its runs to temporary files in dir. If dir is empty, the default
+// directory for temporary files is used.
+func New(dir string, max int) *Sorter {
+	return &Sorter{dir: dir, max: max}
+}
+
+// Add adds a copy of rec to the records to be sorted.
+func (s *Sorter) Add(rec []byte) error {
+	s.buf = append(s.buf, append([]byte(nil), rec...))
+	s.size += len(rec) + recordOverhead
+	if s.size < s.max {
+		return nil
+	}
+	return s.spill()
+}
+
+func (s *Sorter) spill() error {
+	sort.Sort(s.buf)
+	f, err := ioutil.TempFile(s.dir, "cayley-sort")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+	w := bufio.NewWriter(f)
+	var n [binary.MaxVarintLen64]byte
+	for _, rec := range s.buf {
+		_, err = w.Write(n[:binary.PutUvarint(n[:], uint64(len(rec)))])
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(rec)
+		if err != nil {
+			return err
+		}
+	}
+	s.buf = s.buf[:0]
+	s.size = 0
+	return w.Flush()
+}
+
+// Each calls fn with every record added to s, in sorted order. Each record
+// passed to fn is a distinct slice that fn may retain. Iteration stops at
+// the first error returned by fn.
+func (s *Sorter) Each(fn func(rec []byte) error) error {
+	if len(s.runs) == 0 {
+		sort.Sort(s.buf)
+		for _, rec := range s.buf {
+			err := fn(rec)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(s.buf) != 0 {
+		err := s.spill()
+		if err != nil {
+			return err
+		}
+	}
+
+	var m merger
+	for _, f := range s.runs {
+		_, err := f.Seek(0, 0)
+		if err != nil {
+			return err
+		}
+		r := &run{r: bufio.NewReader(f)}
+		err = r.next()
+		if err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		m = append(m, r)
+	}
+	heap.Init(&m)
+	for len(m) != 0 {
+		r := m[0]
+		err := fn(r.rec)
+		if err != nil {
+			return err
+		}
+		err = r.next()
+		if err == io.EOF {
+			heap.Pop(&m)
+			continue
+		} else if err != nil {
+			return err
+		}
+		heap.Fix(&m, 0)
+	}
+	return nil
+}
+
+// Close releases the records held by s and removes its temporary files.
+func (s *Sorter) Close() error {
+	var err error
+	for _, f := range s.runs {
+		f.Close()
+		if rerr := os.Remove(f.Name()); err == nil {
+			err = rerr
+		}
+	}
+	s.runs = nil
+	s.buf = nil
+	s.size = 0
+	return err
+}
+
+type records [][]byte
+
+func (r records) Len() int           { return len(r) }
+func (r records) Less(i, j int) bool { return bytes.Compare(r[i], r[j]) < 0 }
+func (r records) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// run reads the records of a spilled run.
+type run struct {
+	r   *bufio.Reader
+	rec []byte
+}
+
+func (r *run) next() error {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return err
+	}
+	r.rec = make([]byte, n)
+	_, err = io.ReadFull(r.r, r.rec)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// merger is a heap of runs ordered by their current record.
+type merger []*run
+
+func (m merger) Len() int            { return len(m) }
+func (m merger) Less(i, j int) bool  { return bytes.Compare(m[i].rec, m[j].rec) < 0 }
+func (m merger) Swap(i, j int)       { m[i], m[j] = m[j], m[i] }
+func (m *merger) Push(x interface{}) { *m = append(*m, x.(*run)) }
+func (m *merger) Pop() interface{} {
+	old := *m
+	r := old[len(old)-1]
+	*m = old[:len(old)-1]
+	return r
+}
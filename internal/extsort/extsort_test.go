@@ -0,0 +1,70 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extsort
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var sortTests = []struct {
+	name string
+	n    int
+	max  int
+}{
+	{name: "empty", n: 0, max: 1 << 10},
+	{name: "in memory", n: 100, max: 1 << 20},
+	{name: "one run", n: 100, max: 10 * (100 + recordOverhead)},
+	{name: "many runs", n: 1000, max: 100},
+}
+
+func TestSort(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, test := range sortTests {
+		var expect []string
+		s := New("", test.max)
+		for i := 0; i < test.n; i++ {
+			rec := fmt.Sprintf("%x", rnd.Int63n(int64(test.n)))
+			expect = append(expect, rec)
+			err := s.Add([]byte(rec))
+			if err != nil {
+				t.Fatalf("Unexpected error adding record for %s: %v", test.name, err)
+			}
+		}
+		sort.Strings(expect)
+
+		var got []string
+		err := s.Each(func(rec []byte) error {
+			got = append(got, string(rec))
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Unexpected error sorting %s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("Unexpected result for %s, got:%v expect:%v", test.name, got, expect)
+		}
+		if test.name == "many runs" && len(s.runs) < 2 {
+			t.Errorf("Expected %s to spill several runs, got %d", test.name, len(s.runs))
+		}
+		err = s.Close()
+		if err != nil {
+			t.Errorf("Unexpected error closing sorter for %s: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/google/cayley/export"
+	"github.com/google/cayley/query"
+	"github.com/google/cayley/query/gremlin"
+)
+
+// exportFormats holds the property graph formats available for export, keyed
+// by format name.
+var exportFormats = map[string]struct {
+	contentType string
+	newEncoder  func(io.Writer) *export.Encoder
+}{
+	"graphml": {"application/graphml+xml", export.NewGraphMLEncoder},
+	"gexf":    {"application/gexf+xml", export.NewGEXFEncoder},
+	"dot":     {"text/vnd.graphviz", export.NewDOTEncoder},
+}
+
+// resultNodes returns the nodes named in the results of a Gremlin query.
+func resultNodes(results []interface{}) []string {
+	var nodes []string
+	for _, r := range results {
+		switch r := r.(type) {
+		case map[string]string:
+			tags := make([]string, 0, len(r))
+			for k := range r {
+				tags = append(tags, k)
+			}
+			sort.Strings(tags)
+			for _, k := range tags {
+				nodes = append(nodes, r[k])
+			}
+		case string:
+			nodes = append(nodes, r)
+		}
+	}
+	return nodes
+}
+
+// ServeV1Export runs the Gremlin query in the request body and writes the
+// subgraph describing the nodes of its results, including tagged nodes, in the
+// format named by the request path.
+func (api *API) ServeV1Export(w http.ResponseWriter, r *http.Request, params httprouter.Params) int {
+	format, ok := exportFormats[params.ByName("format")]
+	if !ok {
+		return jsonResponse(w, 400, "Unknown export format.")
+	}
+	h, err := api.GetHandleForRequest(r)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	code := string(bodyBytes)
+	ses := gremlin.NewSession(h.QuadStore, api.config.Timeout, false)
+	ses.SetSpillBudget(int64(api.config.IteratorMemory) << 20)
+	if result, err := ses.Parse(code); result != query.Parsed {
+		return jsonResponse(w, 400, err)
+	}
+	output, err := Run(code, ses)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	results, _ := output.([]interface{})
+
+	var buf bytes.Buffer
+	enc := format.newEncoder(&buf)
+	err = export.Subgraph(h.QuadStore, resultNodes(results), enc)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		return jsonResponse(w, 500, err)
+	}
+	w.Header().Set("Content-Type", format.contentType)
+	w.Write(buf.Bytes())
+	return 200
+}
@@ -132,10 +132,14 @@ func (api *API) GetHandleForRequest(r *http.Request) (*graph.Handle, error) {
 func (api *API) APIv1(r *httprouter.Router) {
 	r.POST("/api/v1/query/:query_lang", LogRequest(api.ServeV1Query))
 	r.POST("/api/v1/shape/:query_lang", LogRequest(api.ServeV1Shape))
+	r.POST("/api/v1/export/:format", LogRequest(api.ServeV1Export))
+	r.GET("/api/v1/search", LogRequest(api.ServeV1Search))
 	r.POST("/api/v1/write", LogRequest(api.ServeV1Write))
 	r.POST("/api/v1/write/file/nquad", LogRequest(api.ServeV1WriteNQuad))
 	//TODO(barakmich): /write/text/nquad, which reads from request.body instead of HTML5 file form?
 	r.POST("/api/v1/delete", LogRequest(api.ServeV1Delete))
+	r.POST("/api/v1/transaction", LogRequest(api.ServeV1Transaction))
+	r.GET("/api/v1/horizon", LogRequest(api.ServeV1Horizon))
 }
 
 func SetupRoutes(handle *graph.Handle, cfg *config.Config) {
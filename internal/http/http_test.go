@@ -15,11 +15,19 @@
 package http
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/google/cayley/graph"
+	_ "github.com/google/cayley/graph/memstore"
+	"github.com/google/cayley/internal/config"
 	"github.com/google/cayley/quad"
+	_ "github.com/google/cayley/writer"
 )
 
 var parseTests = []struct {
@@ -71,3 +79,178 @@ func TestParseJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestParseJSONLD(t *testing.T) {
+	got, err := parseJSONLD(strings.NewReader(`{
+		"@context": {"@vocab": "http://example.com/"},
+		"@id": "http://example.com/foo",
+		"bar": {"@id": "http://example.com/baz"}
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error parsing JSON-LD: %v", err)
+	}
+	expect := []quad.Quad{{"<http://example.com/foo>", "<http://example.com/bar>", "<http://example.com/baz>", ""}}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to parse JSON-LD, got:%v expect:%v", got, expect)
+	}
+
+	_, err = parseJSONLD(strings.NewReader(`{"@context": "http://example.com/context"}`))
+	if err == nil {
+		t.Errorf("Expected error parsing JSON-LD with a remote context")
+	}
+}
+
+func TestParseJSONTransaction(t *testing.T) {
+	tx, err := ParseJSONToTransaction([]byte(`[
+		{"action": "delete", "subject": "foo", "predicate": "status", "object": "old"},
+		{"action": "add", "subject": "foo", "predicate": "status", "object": "new", "label": "graph"},
+		{"action": "require", "subject": "foo", "predicate": "status", "object": "old"},
+		{"action": "require_absent", "subject": "foo", "predicate": "status", "object": "new", "label": "graph"},
+		{"action": "require_horizon", "horizon": 42}
+	]`))
+	if err != nil {
+		t.Fatalf("Unexpected error parsing transaction: %v", err)
+	}
+	expect := []graph.Delta{
+		{Quad: quad.Quad{"foo", "status", "old", ""}, Action: graph.Delete},
+		{Quad: quad.Quad{"foo", "status", "new", "graph"}, Action: graph.Add},
+	}
+	if !reflect.DeepEqual(tx.Deltas, expect) {
+		t.Errorf("Failed to parse transaction, got:%v expect:%v", tx.Deltas, expect)
+	}
+	expectConds := []graph.Precondition{
+		{Condition: graph.QuadExists, Quad: quad.Quad{"foo", "status", "old", ""}},
+		{Condition: graph.QuadNotExist, Quad: quad.Quad{"foo", "status", "new", "graph"}},
+		{Condition: graph.HorizonEquals, Horizon: graph.NewSequentialKey(42)},
+	}
+	if !reflect.DeepEqual(tx.Preconditions, expectConds) {
+		t.Errorf("Failed to parse transaction preconditions, got:%v expect:%v", tx.Preconditions, expectConds)
+	}
+
+	for _, input := range []string{
+		`[{"action": "replace", "subject": "foo", "predicate": "bar", "object": "baz"}]`,
+		`[{"action": "add", "subject": "foo", "predicate": "bar"}]`,
+		`[{"action": "require_horizon"}]`,
+	} {
+		_, err = ParseJSONToTransaction([]byte(input))
+		if err == nil {
+			t.Errorf("Expected error parsing transaction %s", input)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	qs, _ := graph.NewQuadStore("memstore", "", graph.Options{"search": true})
+	w, _ := graph.NewQuadWriter("single", qs, nil)
+	w.AddQuadSet([]quad.Quad{
+		{"alice", "says", `"Cats chase cats"`, ""},
+		{"bob", "says", `"A cat sleeps"`, ""},
+		{"bob", "likes", "alice", ""},
+	})
+	api := &API{config: &config.Config{}, handle: &graph.Handle{QuadStore: qs, QuadWriter: w}}
+
+	var searchTests = []struct {
+		url    string
+		code   int
+		expect []searchResult
+	}{
+		{
+			url:    "/api/v1/search?q=cat",
+			code:   200,
+			expect: []searchResult{{`"Cats chase cats"`, 2}, {`"A cat sleeps"`, 1}},
+		},
+		{
+			url:    "/api/v1/search?q=cat&limit=1",
+			code:   200,
+			expect: []searchResult{{`"Cats chase cats"`, 2}},
+		},
+		{
+			url:    "/api/v1/search?q=dog",
+			code:   200,
+			expect: []searchResult{},
+		},
+		{
+			url:  "/api/v1/search?q=cat&limit=0",
+			code: 400,
+		},
+	}
+	for _, test := range searchTests {
+		req, _ := http.NewRequest("GET", test.url, nil)
+		rec := httptest.NewRecorder()
+		if code := api.ServeV1Search(rec, req, nil); code != test.code {
+			t.Errorf("Unexpected status for %s, got:%d expect:%d", test.url, code, test.code)
+		}
+		if test.code != 200 {
+			continue
+		}
+		var got struct {
+			Result []searchResult `json:"result"`
+		}
+		err := json.Unmarshal(rec.Body.Bytes(), &got)
+		if err != nil {
+			t.Errorf("Failed to decode results for %s: %v", test.url, err)
+		}
+		if !reflect.DeepEqual(got.Result, test.expect) {
+			t.Errorf("Unexpected results for %s, got:%v expect:%v", test.url, got.Result, test.expect)
+		}
+	}
+}
+
+func TestTransactionHorizon(t *testing.T) {
+	qs, _ := graph.NewQuadStore("memstore", "", nil)
+	w, _ := graph.NewQuadWriter("single", qs, nil)
+	w.AddQuad(quad.Quad{"alice", "follows", "bob", ""})
+	api := &API{config: &config.Config{}, handle: &graph.Handle{QuadStore: qs, QuadWriter: w}}
+
+	horizon := func() int64 {
+		req, _ := http.NewRequest("GET", "/api/v1/horizon", nil)
+		rec := httptest.NewRecorder()
+		if code := api.ServeV1Horizon(rec, req, nil); code != 200 {
+			t.Fatalf("Unexpected status for horizon, got:%d expect:200", code)
+		}
+		var got struct {
+			Horizon int64 `json:"horizon"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("Failed to decode horizon: %v", err)
+		}
+		return got.Horizon
+	}
+	apply := func(body string) (int, int64) {
+		req, _ := http.NewRequest("POST", "/api/v1/transaction", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		code := api.ServeV1Transaction(rec, req, nil)
+		if code != 200 {
+			return code, 0
+		}
+		var got struct {
+			Horizon int64 `json:"horizon"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("Failed to decode transaction result: %v", err)
+		}
+		return code, got.Horizon
+	}
+	tx := func(h int64) string {
+		return fmt.Sprintf(`[
+			{"action": "require_horizon", "horizon": %d},
+			{"action": "delete", "subject": "alice", "predicate": "follows", "object": "bob"},
+			{"action": "add", "subject": "alice", "predicate": "follows", "object": "charlie"}
+		]`, h)
+	}
+
+	seen := horizon()
+	code, after := apply(tx(seen))
+	if code != 200 {
+		t.Fatalf("Unexpected status for transaction at the horizon, got:%d expect:200", code)
+	}
+	if now := horizon(); after != now || after <= seen {
+		t.Errorf("Unexpected horizon after transaction, got:%d expect:%d (was %d)", after, now, seen)
+	}
+
+	seen = horizon()
+	w.AddQuad(quad.Quad{"bob", "follows", "alice", ""})
+	if code, _ := apply(tx(seen)); code != 409 {
+		t.Errorf("Unexpected status for transaction after a concurrent write, got:%d expect:409", code)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/julienschmidt/httprouter"
 
@@ -76,6 +77,18 @@ func (api *API) ServeV1Query(w http.ResponseWriter, r *http.Request, params http
 	default:
 		return jsonResponse(w, 400, "Need a query language.")
 	}
+	if p := r.URL.Query().Get("parallelism"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			return jsonResponse(w, 400, "Parallelism must be a positive integer.")
+		}
+		if ses, ok := ses.(query.Parallel); ok {
+			ses.SetParallelism(n)
+		}
+	}
+	if ses, ok := ses.(query.Spiller); ok {
+		ses.SetSpillBudget(int64(api.config.IteratorMemory) << 20)
+	}
 	bodyBytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return jsonResponse(w, 400, err)
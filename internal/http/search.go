@@ -0,0 +1,69 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/google/cayley/graph/search"
+)
+
+// defaultSearchLimit is the number of search results returned unless the
+// request asks for another.
+const defaultSearchLimit = 20
+
+type searchResult struct {
+	ID    string `json:"id"`
+	Score int    `json:"score"`
+}
+
+// ServeV1Search returns the nodes matching the search query in the q URL
+// parameter, best first, up to the number in the limit parameter.
+func (api *API) ServeV1Search(w http.ResponseWriter, r *http.Request, _ httprouter.Params) int {
+	h, err := api.GetHandleForRequest(r)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	limit := defaultSearchLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			return jsonResponse(w, 400, "Limit must be a positive integer.")
+		}
+		limit = n
+	}
+	it := search.NewSearch(h.QuadStore, r.URL.Query().Get("q"))
+	defer it.Close()
+	results := []searchResult{}
+	for len(results) < limit && it.Next() {
+		results = append(results, searchResult{
+			ID:    h.QuadStore.NameOf(it.Result()),
+			Score: it.Score(),
+		})
+	}
+	if err := it.Err(); err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	bytes, err := WrapResult(results)
+	if err != nil {
+		return jsonResponse(w, 500, err)
+	}
+	fmt.Fprint(w, string(bytes))
+	return 200
+}
@@ -19,15 +19,18 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"strconv"
 
 	"github.com/barakmich/glog"
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/google/cayley/graph"
 	"github.com/google/cayley/internal"
 	"github.com/google/cayley/quad"
 	"github.com/google/cayley/quad/cquads"
+	"github.com/google/cayley/quad/jsonld"
 )
 
 func ParseJSONToQuadList(jsonBody []byte) ([]quad.Quad, error) {
@@ -44,7 +47,109 @@ func ParseJSONToQuadList(jsonBody []byte) ([]quad.Quad, error) {
 	return quads, nil
 }
 
+// operation is a single entry in a transaction request: a change, "add" or
+// "delete", of a quad, or a precondition, "require" or "require_absent" for a
+// quad and "require_horizon" for the horizon of the store.
+type operation struct {
+	Action  string            `json:"action"`
+	Horizon *graph.PrimaryKey `json:"horizon,omitempty"`
+	quad.Quad
+}
+
+// horizonResult is the response of the transaction and horizon requests.
+type horizonResult struct {
+	Result  string            `json:"result,omitempty"`
+	Horizon *graph.PrimaryKey `json:"horizon"`
+}
+
+// ParseJSONToTransaction returns a transaction holding the operations of the
+// JSON list in jsonBody, in order.
+func ParseJSONToTransaction(jsonBody []byte) (*graph.Transaction, error) {
+	var ops []operation
+	err := json.Unmarshal(jsonBody, &ops)
+	if err != nil {
+		return nil, err
+	}
+	tx := graph.NewTransaction()
+	for i, op := range ops {
+		if op.Action == "require_horizon" {
+			if op.Horizon == nil {
+				return nil, fmt.Errorf("missing horizon at index %d", i)
+			}
+			tx.RequireHorizon(*op.Horizon)
+			continue
+		}
+		if !op.Quad.IsValid() {
+			return nil, fmt.Errorf("invalid quad at index %d. %s", i, op.Quad)
+		}
+		switch op.Action {
+		case "add":
+			tx.AddQuad(op.Quad)
+		case "delete":
+			tx.RemoveQuad(op.Quad)
+		case "require":
+			tx.RequireQuad(op.Quad)
+		case "require_absent":
+			tx.RequireNoQuad(op.Quad)
+		default:
+			return nil, fmt.Errorf("invalid action at index %d. %q", i, op.Action)
+		}
+	}
+	return tx, nil
+}
+
+// parseJSONLD returns the quads of the JSON-LD documents read from r.
+func parseJSONLD(r io.Reader) ([]quad.Quad, error) {
+	var quads []quad.Quad
+	dec := jsonld.NewDecoder(r)
+	for {
+		q, err := dec.Unmarshal()
+		if err == io.EOF {
+			return quads, nil
+		} else if err != nil {
+			return nil, err
+		}
+		quads = append(quads, q)
+	}
+}
+
 func (api *API) ServeV1Write(w http.ResponseWriter, r *http.Request, _ httprouter.Params) int {
+	if api.config.ReadOnly {
+		return jsonResponse(w, 400, "Database is read-only.")
+	}
+	var (
+		quads []quad.Quad
+		err   error
+	)
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/ld+json" {
+		quads, err = parseJSONLD(r.Body)
+	} else {
+		var bodyBytes []byte
+		bodyBytes, err = ioutil.ReadAll(r.Body)
+		if err == nil {
+			quads, err = ParseJSONToQuadList(bodyBytes)
+		}
+	}
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	h, err := api.GetHandleForRequest(r)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+
+	h.QuadWriter.AddQuadSet(quads)
+	fmt.Fprintf(w, "{\"result\": \"Successfully wrote %d quads.\"}", len(quads))
+	return 200
+}
+
+// ServeV1Transaction applies the add and delete operations in the request body
+// as a single transaction: either all of them are applied or none are.
+// Backends without transactions, such as mongo, refuse requests of more than
+// one change. If any of the preconditions in the request does not hold,
+// nothing is applied and the response is a 409 Conflict. On success the response holds the horizon
+// of the store after the transaction.
+func (api *API) ServeV1Transaction(w http.ResponseWriter, r *http.Request, _ httprouter.Params) int {
 	if api.config.ReadOnly {
 		return jsonResponse(w, 400, "Database is read-only.")
 	}
@@ -52,7 +157,7 @@ func (api *API) ServeV1Write(w http.ResponseWriter, r *http.Request, _ httproute
 	if err != nil {
 		return jsonResponse(w, 400, err)
 	}
-	quads, err := ParseJSONToQuadList(bodyBytes)
+	tx, err := ParseJSONToTransaction(bodyBytes)
 	if err != nil {
 		return jsonResponse(w, 400, err)
 	}
@@ -60,9 +165,46 @@ func (api *API) ServeV1Write(w http.ResponseWriter, r *http.Request, _ httproute
 	if err != nil {
 		return jsonResponse(w, 400, err)
 	}
+	err = h.QuadWriter.ApplyTransaction(tx)
+	switch err {
+	case nil:
+	case graph.ErrQuadExists, graph.ErrQuadNotExist, graph.ErrPreconditionsUnsupported,
+		graph.ErrAtomicityUnsupported:
+		return jsonResponse(w, 400, err)
+	case graph.ErrPreconditionFailed:
+		return jsonResponse(w, 409, err)
+	default:
+		return jsonResponse(w, 500, err)
+	}
+	res := horizonResult{Result: fmt.Sprintf("Successfully applied %d changes.", len(tx.Deltas))}
+	if n := len(tx.Deltas); n > 0 {
+		// The ID of the last change is the horizon the transaction left, even
+		// if other writes have been made since.
+		res.Horizon = &tx.Deltas[n-1].ID
+	} else {
+		horizon := h.QuadStore.Horizon()
+		res.Horizon = &horizon
+	}
+	return writeHorizon(w, res)
+}
 
-	h.QuadWriter.AddQuadSet(quads)
-	fmt.Fprintf(w, "{\"result\": \"Successfully wrote %d quads.\"}", len(quads))
+// ServeV1Horizon returns the horizon of the store, for use in the
+// require_horizon precondition of a later transaction.
+func (api *API) ServeV1Horizon(w http.ResponseWriter, r *http.Request, _ httprouter.Params) int {
+	h, err := api.GetHandleForRequest(r)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	horizon := h.QuadStore.Horizon()
+	return writeHorizon(w, horizonResult{Horizon: &horizon})
+}
+
+func writeHorizon(w http.ResponseWriter, res horizonResult) int {
+	bytes, err := json.Marshal(res)
+	if err != nil {
+		return jsonResponse(w, 500, err)
+	}
+	fmt.Fprint(w, string(bytes))
 	return 200
 }
 
@@ -0,0 +1,602 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	client "net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/barakmich/glog"
+
+	"github.com/google/cayley/internal/config"
+	"github.com/google/cayley/quad"
+	"github.com/google/cayley/quad/bquads"
+	"github.com/google/cayley/quad/cquads"
+	"github.com/google/cayley/quad/jsonld"
+	"github.com/google/cayley/quad/nquads"
+	"github.com/google/cayley/quad/tabular"
+	"github.com/google/cayley/quad/turtle"
+)
+
+// input is the sequence of quad files named by a load path, read as a single
+// stream of quads. Tar archives are read as the sequence of files they hold.
+type input struct {
+	files   []string
+	ids     []string
+	typ     string
+	size    int64
+	modTime time.Time
+	read    int64
+
+	next int
+	src  *source
+	tr   *tar.Reader
+	tar  string
+
+	dec  quad.Unmarshaler
+	name string
+	n    int64
+
+	// seekable is whether the file being opened is a single uncompressed
+	// local file, whose reading can resume from an offset. If it is, and
+	// its decoder reports offsets, off returns the offset of the end of
+	// the last statement read, relative to base.
+	seekable bool
+	resumeAt int64
+	base     int64
+	off      func() int64
+
+	// lenient is whether statements that cannot be parsed are skipped,
+	// and written to the file deadLetter if it is not empty.
+	lenient    bool
+	deadLetter string
+	rejected   int64
+	dead       *os.File
+	deadw      *bufio.Writer
+	closed     bool
+
+	// skolemPrefix is the start of the IRIs that blank nodes are
+	// rewritten as, or empty if they keep their labels.
+	skolemPrefix string
+	mapping      *tabular.Mapping
+}
+
+// openInput returns the input named by path, which may be a file or URL, a
+// directory, which is searched for files, or a glob pattern. If typ is empty
+// the format of each file is detected. The load options of cfg say how the
+// files are read.
+func openInput(cfg *config.Config, path, typ string) (*input, error) {
+	files, err := expandPath(path)
+	if err != nil {
+		return nil, err
+	}
+	in := &input{
+		files:        files,
+		typ:          typ,
+		lenient:      cfg.LoadLenient,
+		deadLetter:   cfg.LoadDeadLetter,
+		skolemPrefix: cfg.SkolemPrefix,
+	}
+	if in.skolemPrefix == "" {
+		in.skolemPrefix = DefaultSkolemPrefix
+	}
+	if cfg.PreserveBlankNodes {
+		in.skolemPrefix = ""
+	}
+	if cfg.LoadMapping != "" {
+		in.mapping, err = readMapping(cfg.LoadMapping)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range files {
+		p, ok := localPath(f)
+		if !ok {
+			// The size of a fetched resource is not known in advance.
+			in.size = -1
+			in.ids = append(in.ids, f)
+			continue
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("could not open file %q: %v", p, err)
+		}
+		in.ids = append(in.ids, fileID(p, fi.Size(), fi.ModTime()))
+		if in.size >= 0 {
+			in.size += fi.Size()
+		}
+		if fi.ModTime().After(in.modTime) {
+			in.modTime = fi.ModTime()
+		}
+	}
+	if in.size < 0 {
+		in.size = 0
+	}
+	return in, nil
+}
+
+// expandPath returns the files named by path.
+func expandPath(path string) ([]string, error) {
+	if _, ok := localPath(path); !ok {
+		return []string{path}, nil
+	}
+	matches := []string{path}
+	if strings.ContainsAny(path, "*?[") {
+		var err error
+		matches, err = filepath.Glob(path)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(filepath.Base(path), ".") {
+			// As in the shell, wildcards don't match hidden files.
+			visible := matches[:0]
+			for _, m := range matches {
+				if !strings.HasPrefix(filepath.Base(m), ".") {
+					visible = append(visible, m)
+				}
+			}
+			matches = visible
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", path)
+		}
+	}
+	var files []string
+	for _, m := range matches {
+		p, _ := localPath(m)
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("could not open file %q: %v", p, err)
+		}
+		if !fi.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.Walk(p, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// Hidden files and directories are skipped.
+			if path != p && strings.HasPrefix(fi.Name(), ".") {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if fi.Mode().IsRegular() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// multiple returns whether the input holds more than one file, in which case
+// the progress of each is logged.
+func (in *input) multiple() bool {
+	return len(in.files) > 1 || in.tr != nil
+}
+
+func (in *input) bytesRead() int64 {
+	return atomic.LoadInt64(&in.read)
+}
+
+// offset returns the position in the input of the end of the last statement
+// read, or -1 if reading cannot resume from it.
+func (in *input) offset() int64 {
+	if in.off == nil {
+		return -1
+	}
+	return in.base + in.off()
+}
+
+// resume makes reading start at offset, as returned by a previous offset.
+func (in *input) resume(offset int64) {
+	in.resumeAt = offset
+}
+
+// Unmarshal returns the next quad of the input.
+func (in *input) Unmarshal() (quad.Quad, error) {
+	for {
+		if in.dec == nil {
+			err := in.open()
+			if err != nil {
+				return quad.Quad{}, err
+			}
+			continue
+		}
+		q, err := in.dec.Unmarshal()
+		if err == io.EOF {
+			if in.multiple() {
+				glog.Infof("Read %d quads from %s.", in.n, in.name)
+			}
+			in.dec = nil
+			continue
+		} else if err != nil {
+			if perr, ok := err.(*quad.ParseError); ok && in.lenient {
+				err = in.reject(perr)
+				if err != nil {
+					return quad.Quad{}, err
+				}
+				continue
+			}
+			return quad.Quad{}, fmt.Errorf("%s: %v", in.name, err)
+		}
+		in.n++
+		return q, nil
+	}
+}
+
+// open starts reading the next file of the input, returning io.EOF if there
+// are none left. Empty files are skipped, leaving no decoder set.
+func (in *input) open() error {
+	if in.tr != nil {
+		hdr, err := in.tr.Next()
+		if err == io.EOF {
+			in.closeFile()
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", in.tar, err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return nil
+		}
+		r, head, err := decompress(in.tr)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+		name := in.tar + "/" + strings.TrimPrefix(hdr.Name, "./")
+		return in.start(r, head, name, fileID(name, hdr.Size, hdr.ModTime))
+	}
+
+	in.closeFile()
+	if in.next == len(in.files) {
+		return io.EOF
+	}
+	path, id := in.files[in.next], in.ids[in.next]
+	in.next++
+	src, err := openSource(path, &in.read)
+	if err != nil {
+		return err
+	}
+	in.src = src
+	raw := bufio.NewReader(src)
+	magic, _ := raw.Peek(3)
+	f, ok := src.ReadCloser.(*os.File)
+	in.seekable = ok && len(in.files) == 1 && !isCompressed(magic)
+	r, head, err := decompress(raw)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if isTar(head) {
+		in.seekable = false
+		in.tr = tar.NewReader(r)
+		in.tar = path
+		return nil
+	}
+	if in.resumeAt > 0 {
+		// The format is detected from the start of the file before
+		// seeking to where the last load stopped.
+		if !in.seekable {
+			return fmt.Errorf("%s: cannot resume reading at offset %d", path, in.resumeAt)
+		}
+		_, err = f.Seek(in.resumeAt, os.SEEK_SET)
+		if err != nil {
+			return err
+		}
+		atomic.StoreInt64(&in.read, in.resumeAt)
+		r = bufio.NewReader(src)
+		in.base, in.resumeAt = in.resumeAt, 0
+	}
+	return in.start(r, head, path, id)
+}
+
+// offsetter is implemented by decoders that report how much of their input
+// they have read.
+type offsetter interface {
+	Offset() int64
+}
+
+// fileID identifies a version of the file called name, as the scope of its
+// blank nodes.
+func fileID(name string, size int64, modTime time.Time) string {
+	return fmt.Sprintf("%s\x00%d\x00%d", name, size, modTime.UnixNano())
+}
+
+// start starts decoding the file called name, identified by id, read from r.
+func (in *input) start(r io.Reader, head []byte, name, id string) error {
+	typ := formatOf(name, head, in.typ)
+	dec, err := decoderFor(r, typ, in.mapping)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if o, ok := dec.(offsetter); ok && in.seekable {
+		in.off = o.Offset
+	}
+	if in.skolemPrefix != "" {
+		// Blank nodes are scoped to the version of the file they are
+		// read from, so loading it again gives the same IRIs.
+		h := sha1.Sum([]byte(id))
+		s := quad.Skolemizer{Prefix: in.skolemPrefix + hex.EncodeToString(h[:8]) + "/", Bare: typ == "cquad"}
+		dec = s.Unmarshaler(dec)
+	}
+	if in.multiple() {
+		glog.Infof("Reading %s.", name)
+	}
+	in.dec = dec
+	in.name = name
+	in.n = 0
+	return nil
+}
+
+func (in *input) closeFile() {
+	if in.src != nil {
+		in.src.Close()
+		in.src = nil
+	}
+	in.tr = nil
+}
+
+// maxLoggedRejects is the number of statements skipped by a lenient load
+// that are logged individually.
+const maxLoggedRejects = 10
+
+// reject records a statement skipped by a lenient load, writing it to the
+// dead-letter file if there is one.
+func (in *input) reject(perr *quad.ParseError) error {
+	in.rejected++
+	if in.rejected <= maxLoggedRejects {
+		glog.Warningf("Skipping statement of %s: %v", in.name, perr)
+		if in.rejected == maxLoggedRejects {
+			glog.Warningln("Further skipped statements are not logged.")
+		}
+	}
+	if in.deadLetter == "" {
+		return nil
+	}
+	if in.dead == nil {
+		f, err := os.Create(in.deadLetter)
+		if err != nil {
+			return fmt.Errorf("could not create dead-letter file: %v", err)
+		}
+		in.dead = f
+		in.deadw = bufio.NewWriter(f)
+	}
+	// The reason is written as a comment, so the file can be loaded once
+	// its statements are fixed.
+	_, err := fmt.Fprintf(in.deadw, "# %s:%d:%d: %v\n%s\n", in.name, perr.Line, perr.Column, perr.Err, perr.Statement)
+	return err
+}
+
+// Close closes the file being read and the dead-letter file, and reports the
+// number of statements skipped.
+func (in *input) Close() error {
+	if in.closed {
+		return nil
+	}
+	in.closed = true
+	in.closeFile()
+	if in.rejected == 0 {
+		return nil
+	}
+	if in.dead == nil {
+		glog.Warningf("Skipped %d statements that could not be parsed.", in.rejected)
+		return nil
+	}
+	glog.Warningf("Skipped %d statements that could not be parsed, written to %s.", in.rejected, in.deadLetter)
+	err := in.deadw.Flush()
+	if cerr := in.dead.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// source is a quad file or fetched resource that counts the bytes read from
+// it.
+type source struct {
+	io.ReadCloser
+	read *int64
+}
+
+func (s *source) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	atomic.AddInt64(s.read, int64(n))
+	return n, err
+}
+
+// localPath returns the file path named by path, and whether it names a
+// file rather than a URL to fetch.
+func localPath(path string) (string, bool) {
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme == "" {
+		// Don't alter relative URL path or non-URL path parameter.
+		return path, true
+	}
+	if u.Scheme == "file" {
+		// Recovery heuristic for mistyping "file://path/to/file".
+		return filepath.Join(u.Host, u.Path), true
+	}
+	return path, false
+}
+
+// openSource opens the file or URL at path, adding the number of bytes read
+// from it to read.
+func openSource(path string, read *int64) (*source, error) {
+	if p, ok := localPath(path); ok {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, fmt.Errorf("could not open file %q: %v", p, err)
+		}
+		return &source{ReadCloser: f, read: read}, nil
+	}
+	res, err := client.Get(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not get resource <%s>: %v", path, err)
+	}
+	return &source{ReadCloser: res.Body, read: read}, nil
+}
+
+// sniffSize is the number of bytes of a file examined to detect its format.
+const sniffSize = 512
+
+// decompress returns a reader of the decompressed content of r and the first
+// bytes of that content. It returns io.EOF if r is empty.
+func decompress(r io.Reader) (*bufio.Reader, []byte, error) {
+	r, err := Decompressor(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	br := bufio.NewReader(r)
+	head, err := br.Peek(sniffSize)
+	if err == io.EOF && len(head) == 0 {
+		return nil, nil, err
+	} else if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	return br, head, nil
+}
+
+func isTar(head []byte) bool {
+	return len(head) >= 262 && string(head[257:262]) == "ustar"
+}
+
+// formatOf returns the format of the quad file name whose content starts
+// with head. Binary quads are recognised whatever format is given; otherwise
+// typ is used if it is not empty, then the file name's extension, ignoring
+// any compression extension, and then the content itself.
+func formatOf(name string, head []byte, typ string) string {
+	if bytes.HasPrefix(head, []byte(bquads.Magic)) {
+		return "bquad"
+	}
+	if typ != "" {
+		return typ
+	}
+	if ext := filepath.Ext(name); ext == ".gz" || ext == ".bz2" {
+		name = strings.TrimSuffix(name, ext)
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".nq", ".nt":
+		// cquads is a superset of N-Quads, so it is the default for them.
+		return "cquad"
+	case ".bq":
+		return "bquad"
+	case ".jsonld", ".json":
+		return "jsonld"
+	case ".ttl", ".n3":
+		// N3 is a superset of Turtle, and its files are mostly Turtle.
+		return "turtle"
+	case ".trig":
+		return "trig"
+	case ".csv":
+		return "csv"
+	case ".tsv", ".tab":
+		return "tsv"
+	case ".graphml":
+		return "graphml"
+	case ".gexf":
+		return "gexf"
+	case ".dot", ".gv":
+		return "dot"
+	}
+	head = bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	switch {
+	case bytes.HasPrefix(head, []byte("{")) || bytes.HasPrefix(head, []byte("[")):
+		return "jsonld"
+	case hasPrefixFold(head, "@prefix") || hasPrefixFold(head, "@base") ||
+		hasPrefixFold(head, "prefix ") || hasPrefixFold(head, "base "):
+		return "turtle"
+	}
+	return "cquad"
+}
+
+func hasPrefixFold(b []byte, prefix string) bool {
+	return len(b) >= len(prefix) && strings.EqualFold(string(b[:len(prefix)]), prefix)
+}
+
+// newDecoder returns a decoder for the quads in r, which may be compressed,
+// as for a file called name. It returns io.EOF if r is empty.
+func newDecoder(r io.Reader, name, typ string) (quad.Unmarshaler, error) {
+	br, head, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	return decoderFor(br, formatOf(name, head, typ), nil)
+}
+
+// DefaultSkolemPrefix is the start of the IRIs that blank nodes are rewritten
+// as when loading, unless another is configured. Each file loaded has its own
+// blank nodes, so the IRIs of a file's blank nodes are the prefix, followed by
+// an identifier of the file and the blank node's label.
+const DefaultSkolemPrefix = "urn:cayley:genid:"
+
+// readMapping reads the mapping used to load the csv and tsv formats from the
+// file at path. See the tabular package for details of the mapping.
+func readMapping(path string) (*tabular.Mapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return tabular.ParseMapping(f)
+}
+
+// decoderFor returns a decoder for the quad format typ reading from r, with
+// mapping describing the rows of the csv and tsv formats.
+func decoderFor(r io.Reader, typ string, mapping *tabular.Mapping) (quad.Unmarshaler, error) {
+	switch typ {
+	case "bquad":
+		return bquads.NewDecoder(r), nil
+	case "cquad":
+		return cquads.NewDecoder(r), nil
+	case "nquad":
+		return nquads.NewDecoder(r), nil
+	case "jsonld":
+		return jsonld.NewDecoder(r), nil
+	case "turtle":
+		return turtle.NewDecoder(r), nil
+	case "trig":
+		return turtle.NewTriGDecoder(r), nil
+	case "csv", "tsv":
+		if mapping == nil {
+			return nil, fmt.Errorf("no mapping given for the %s format", typ)
+		}
+		comma := ','
+		if typ == "tsv" {
+			comma = '\t'
+		}
+		return tabular.NewDecoder(r, comma, mapping), nil
+	}
+	return nil, fmt.Errorf("unknown quad format %q", typ)
+}
@@ -0,0 +1,272 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/internal/config"
+	"github.com/google/cayley/quad"
+	"github.com/google/cayley/quad/bquads"
+)
+
+var formatTests = []struct {
+	name   string
+	head   string
+	typ    string
+	expect string
+}{
+	{"data.nq.gz", "", "", "cquad"},
+	{"data.NT", "", "", "cquad"},
+	{"data.jsonld", "", "", "jsonld"},
+	{"data.ttl.bz2", "", "", "turtle"},
+	{"data.n3", "<a> <b> <c> .", "", "turtle"},
+	{"data.trig", "", "", "trig"},
+	{"data.tsv", "", "", "tsv"},
+	{"data.bq", "", "", "bquad"},
+	{"data", bquads.Magic + "\x01", "", "bquad"},
+	{"data.nq", bquads.Magic + "\x01", "nquad", "bquad"},
+	{"data.ttl", "", "nquad", "nquad"},
+	{"data", "\n  {\"@id\": \"a\"}", "", "jsonld"},
+	{"data", "[{\"@id\": \"a\"}]", "", "jsonld"},
+	{"data", "@prefix ex: <http://example.com/> .", "", "turtle"},
+	{"data", "PREFIX ex: <http://example.com/>", "", "turtle"},
+	{"data", "<a> <b> <c> .", "", "cquad"},
+}
+
+func TestFormatOf(t *testing.T) {
+	for _, test := range formatTests {
+		got := formatOf(test.name, []byte(test.head), test.typ)
+		if got != test.expect {
+			t.Errorf("Unexpected format for %q starting %q, got:%q expect:%q", test.name, test.head, got, test.expect)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatalf("Could not create directory: %v", err)
+	}
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatalf("Could not write %q: %v", path, err)
+	}
+}
+
+func tarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))})
+		if err == nil {
+			_, err = tw.Write([]byte(files[name]))
+		}
+		if err != nil {
+			t.Fatalf("Could not write tar entry %q: %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Could not write tar archive: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Could not compress tar archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func loadAll(cfg *config.Config, path, typ string) ([]quad.Quad, error) {
+	var got []quad.Quad
+	err := DecompressAndLoad(nil, cfg, path, typ, func(_ graph.QuadWriter, _ *config.Config, dec quad.Unmarshaler) error {
+		for {
+			q, err := dec.Unmarshal()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			got = append(got, q)
+		}
+	})
+	return got, err
+}
+
+func TestLoadInputs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cayley_test")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	alice := quad.Quad{Subject: "alice", Predicate: "follows", Object: "bob"}
+	bob := quad.Quad{Subject: "<http://example.com/bob>", Predicate: "<http://example.com/follows>", Object: "<http://example.com/alice>"}
+	charlie := quad.Quad{Subject: "charlie", Predicate: "status", Object: "cool", Label: "people"}
+
+	dir := filepath.Join(tmpDir, "data")
+	writeFile(t, filepath.Join(dir, "a.nq"), []byte(alice.NQuad()+"\n"))
+	writeFile(t, filepath.Join(dir, "b", "b.json"), []byte(`{"@id": "http://example.com/bob", "http://example.com/follows": {"@id": "http://example.com/alice"}}`))
+	writeFile(t, filepath.Join(dir, "c.nq"), nil)
+	writeFile(t, filepath.Join(dir, ".hidden.nq"), []byte("not quads"))
+	writeFile(t, filepath.Join(tmpDir, "archive.tar.gz"), tarball(t, map[string]string{
+		"a.nq":     alice.NQuad() + "\n",
+		"c/c.ttl":  "@prefix ex: <http://example.com/> .\n",
+		"c/c2.txt": charlie.NQuad() + "\n",
+	}))
+
+	for _, test := range []struct {
+		message string
+		path    string
+		expect  []quad.Quad
+	}{
+		{"load a directory", dir, []quad.Quad{alice, bob}},
+		{"load a glob", filepath.Join(dir, "*.nq"), []quad.Quad{alice}},
+		{"load a tar archive", filepath.Join(tmpDir, "archive.tar.gz"), []quad.Quad{alice, charlie}},
+	} {
+		got, err := loadAll(&config.Config{}, test.path, "")
+		if err != nil {
+			t.Errorf("Unexpected error to %s: %v", test.message, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to %s, got:%v expect:%v", test.message, got, test.expect)
+		}
+	}
+
+	if _, err := loadAll(&config.Config{}, filepath.Join(dir, "*.ttl"), ""); err == nil {
+		t.Error("Expected error for a glob matching no files")
+	}
+	_, err = loadAll(&config.Config{}, dir, "turtle")
+	if err == nil || !bytes.Contains([]byte(err.Error()), []byte("a.nq")) {
+		t.Errorf("Expected error naming the file that failed, got:%v", err)
+	}
+}
+
+func TestLenientLoad(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cayley_test")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "quads.nq")
+	writeFile(t, path, []byte("alice follows bob .\nalice follows .\nbob follows charlie .\n"))
+	expect := []quad.Quad{
+		{Subject: "alice", Predicate: "follows", Object: "bob"},
+		{Subject: "bob", Predicate: "follows", Object: "charlie"},
+	}
+
+	_, err = loadAll(&config.Config{}, path, "cquad")
+	if err == nil || !bytes.Contains([]byte(err.Error()), []byte("line 2, column 16")) {
+		t.Errorf("Expected error giving the position of the bad statement, got:%v", err)
+	}
+
+	deadLetter := filepath.Join(tmpDir, "rejected.nq")
+	cfg := &config.Config{LoadLenient: true, LoadDeadLetter: deadLetter}
+	got, err := loadAll(cfg, path, "cquad")
+	if err != nil {
+		t.Fatalf("Unexpected error for lenient load: %v", err)
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to skip bad statement, got:%v expect:%v", got, expect)
+	}
+	b, err := ioutil.ReadFile(deadLetter)
+	if err != nil {
+		t.Fatalf("Could not read dead-letter file: %v", err)
+	}
+	if !bytes.HasSuffix(b, []byte("\nalice follows .\n")) || !bytes.Contains(b, []byte("quads.nq:2:16:")) {
+		t.Errorf("Unexpected dead-letter file content: %q", b)
+	}
+}
+
+func TestSkolemize(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cayley_test")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeFile(t, filepath.Join(tmpDir, "a.nq"), []byte("_:b0 <follows> _:b1 .\n"))
+	writeFile(t, filepath.Join(tmpDir, "b.nq"), []byte("_:b0 <follows> <alice> .\n"))
+
+	// Blank nodes keep their labels only if asked to.
+	got, err := loadAll(&config.Config{PreserveBlankNodes: true}, tmpDir, "nquad")
+	if err != nil {
+		t.Fatalf("Unexpected error loading blank nodes: %v", err)
+	}
+	expect := []quad.Quad{
+		{Subject: "_:b0", Predicate: "<follows>", Object: "_:b1"},
+		{Subject: "_:b0", Predicate: "<follows>", Object: "<alice>"},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to keep blank node labels, got:%v expect:%v", got, expect)
+	}
+
+	cfg := &config.Config{}
+	first, err := loadAll(cfg, tmpDir, "nquad")
+	if err != nil {
+		t.Fatalf("Unexpected error loading blank nodes: %v", err)
+	}
+	if len(first) != 2 {
+		t.Fatalf("Unexpected number of quads, got:%d expect:2", len(first))
+	}
+	a, b := first[0], first[1]
+	if !strings.HasPrefix(a.Subject, "<"+DefaultSkolemPrefix) || !strings.HasSuffix(a.Subject, "/b0>") {
+		t.Errorf("Unexpected skolem IRI, got:%q", a.Subject)
+	}
+	if a.Subject == b.Subject {
+		t.Errorf("Blank nodes of different files were merged as %q", a.Subject)
+	}
+	if a.Predicate != "<follows>" || b.Object != "<alice>" {
+		t.Errorf("Unexpected rewriting of IRIs, got:%v and %v", a, b)
+	}
+	again, err := loadAll(cfg, tmpDir, "nquad")
+	if err != nil {
+		t.Fatalf("Unexpected error loading blank nodes again: %v", err)
+	}
+	if !reflect.DeepEqual(again, first) {
+		t.Errorf("Skolem IRIs are not stable, got:%v expect:%v", again, first)
+	}
+	again, err = loadAll(&config.Config{SkolemPrefix: "urn:x:"}, tmpDir, "nquad")
+	if err != nil {
+		t.Fatalf("Unexpected error loading blank nodes with a prefix: %v", err)
+	}
+	if !strings.HasPrefix(again[0].Subject, "<urn:x:") {
+		t.Errorf("Unexpected skolem IRI with a prefix, got:%q", again[0].Subject)
+	}
+	got, err = loadAll(cfg, filepath.Join(tmpDir, "a.nq"), "cquad")
+	if err != nil {
+		t.Fatalf("Unexpected error loading cquads: %v", err)
+	}
+	if got[0].Subject != a.Subject[1:len(a.Subject)-1] {
+		t.Errorf("Unexpected cquads skolem IRI, got:%q expect:%q", got[0].Subject, a.Subject[1:len(a.Subject)-1])
+	}
+}
@@ -1,19 +1,18 @@
 package internal
 
 import (
+	"encoding/json"
 	"fmt"
-	"io"
-	client "net/http"
-	"net/url"
+	"io/ioutil"
 	"os"
-	"path/filepath"
+	"time"
+
+	"github.com/barakmich/glog"
 
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/internal/config"
 	"github.com/google/cayley/internal/db"
 	"github.com/google/cayley/quad"
-	"github.com/google/cayley/quad/cquads"
-	"github.com/google/cayley/quad/nquads"
 )
 
 // Load loads a graph from the given path and write it to qw.  See
@@ -22,61 +21,138 @@ func Load(qw graph.QuadWriter, cfg *config.Config, path, typ string) error {
 	return DecompressAndLoad(qw, cfg, path, typ, db.Load)
 }
 
-// DecompressAndLoad will load or fetch a graph from the given path, decompress
-// it, and then call the given load function to process the decompressed graph.
-// If no loadFn is provided, db.Load is called.
-func DecompressAndLoad(qw graph.QuadWriter, cfg *config.Config, path, typ string, loadFn func(graph.QuadWriter, *config.Config, quad.Unmarshaler) error) error {
-	var r io.Reader
-
+// BulkLoad loads a graph from the given path into h, using the bulk loader of
+// the QuadStore when it has one and is empty. See db.BulkLoad and
+// DecompressAndLoad for more information.
+//
+// If checkpoint is not empty, it names a file in which the number of quads
+// written is recorded after each block, along with the offset of the end of
+// the block for a single uncompressed N-Quads file, so that an interrupted
+// load can be resumed by running it again with the same checkpoint. Resumed
+// loads ignore duplicate quads. The file is removed once the load completes.
+// Loads with a checkpoint do not use bulk loading.
+func BulkLoad(h *graph.Handle, cfg *config.Config, path, typ, checkpoint string) error {
 	if path == "" {
 		path = cfg.DatabasePath
 	}
 	if path == "" {
 		return nil
 	}
-	u, err := url.Parse(path)
-	if err != nil || u.Scheme == "file" || u.Scheme == "" {
-		// Don't alter relative URL path or non-URL path parameter.
-		if u.Scheme != "" && err == nil {
-			// Recovery heuristic for mistyping "file://path/to/file".
-			path = filepath.Join(u.Host, u.Path)
-		}
-		f, err := os.Open(path)
+	in, err := openInput(cfg, path, typ)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	opts := db.LoadOptions{Read: in.bytesRead, Size: in.size, Offset: in.offset}
+	if checkpoint != "" {
+		cp := &loadCheckpoint{Source: path, Size: in.size, ModTime: in.modTime}
+		last, err := readCheckpoint(checkpoint)
 		if err != nil {
-			return fmt.Errorf("could not open file %q: %v", path, err)
+			return err
 		}
-		defer f.Close()
-		r = f
-	} else {
-		res, err := client.Get(path)
-		if err != nil {
-			return fmt.Errorf("could not get resource <%s>: %v", u, err)
+		if last != nil {
+			if last.Source != cp.Source || last.Size != cp.Size || !last.ModTime.Equal(cp.ModTime) {
+				return fmt.Errorf("checkpoint %q is for a different input, remove it to start a new load", checkpoint)
+			}
+			if last.Offset > 0 {
+				glog.Infof("Resuming load of %s after %d quads, at byte %d.", path, last.Quads, last.Offset)
+				in.resume(last.Offset)
+				opts.Loaded = last.Quads
+			} else {
+				glog.Infof("Resuming load of %s after %d quads.", path, last.Quads)
+				opts.Skip = last.Quads
+			}
+		}
+		opts.Commit = func(n, offset int64) error {
+			cp.Quads = n
+			cp.Offset = 0
+			if offset > 0 {
+				cp.Offset = offset
+			}
+			return cp.write(checkpoint)
 		}
-		defer res.Body.Close()
-		r = res.Body
 	}
 
-	r, err = Decompressor(r)
+	err = db.BulkLoad(h, cfg, in, opts)
+	if cerr := in.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || checkpoint == "" {
+		return err
+	}
+	return os.Remove(checkpoint)
+}
+
+// DecompressAndLoad will load or fetch a graph from the given path, decompress
+// it, and then call the given load function to process the decompressed graph.
+// If no loadFn is provided, db.Load is called.
+//
+// The path may name a file or URL, a directory, which is searched for files,
+// a glob pattern, or a tar archive; their files are read in sequence as one
+// graph. If typ is empty, the format of each file is detected from its name
+// and content. The load options of cfg say how malformed statements and blank
+// nodes are handled.
+func DecompressAndLoad(qw graph.QuadWriter, cfg *config.Config, path, typ string, loadFn func(graph.QuadWriter, *config.Config, quad.Unmarshaler) error) error {
+	if path == "" {
+		path = cfg.DatabasePath
+	}
+	if path == "" {
+		return nil
+	}
+	in, err := openInput(cfg, path, typ)
 	if err != nil {
-		if err == io.EOF {
-			return nil
-		}
 		return err
 	}
+	defer in.Close()
 
-	var dec quad.Unmarshaler
-	switch typ {
-	case "cquad":
-		dec = cquads.NewDecoder(r)
-	case "nquad":
-		dec = nquads.NewDecoder(r)
-	default:
-		return fmt.Errorf("unknown quad format %q", typ)
+	if loadFn == nil {
+		loadFn = db.Load
+	}
+	err = loadFn(qw, cfg, in)
+	if cerr := in.Close(); err == nil {
+		err = cerr
 	}
+	return err
+}
 
-	if loadFn != nil {
-		return loadFn(qw, cfg, dec)
+// loadCheckpoint records how much of an input has been loaded.
+type loadCheckpoint struct {
+	Source  string    `json:"source"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Quads   int64     `json:"quads"`
+	Offset  int64     `json:"offset,omitempty"`
+}
+
+// readCheckpoint reads the checkpoint in file, returning nil if the file
+// does not exist.
+func readCheckpoint(file string) (*loadCheckpoint, error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cp loadCheckpoint
+	err = json.Unmarshal(b, &cp)
+	if err != nil {
+		return nil, fmt.Errorf("could not read checkpoint %q: %v", file, err)
 	}
+	return &cp, nil
+}
 
-	return db.Load(qw, cfg, dec)
+// write replaces file with the checkpoint, so that an interrupted write
+// leaves the previous checkpoint in place.
+func (cp *loadCheckpoint) write(file string) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
 }
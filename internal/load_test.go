@@ -0,0 +1,170 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/internal/config"
+	"github.com/google/cayley/internal/db"
+	"github.com/google/cayley/quad"
+)
+
+var checkpointQuads = []quad.Quad{
+	{Subject: "alice", Predicate: "follows", Object: "bob"},
+	{Subject: "bob", Predicate: "follows", Object: "charlie"},
+	{Subject: "charlie", Predicate: "follows", Object: "alice"},
+	{Subject: "charlie", Predicate: "status", Object: "cool", Label: "people"},
+}
+
+func TestLoadCheckpoint(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cayley_test")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "quads.nq")
+	var data []byte
+	for _, q := range checkpointQuads {
+		data = append(data, q.NQuad()+"\n"...)
+	}
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatalf("Could not write quads: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Could not stat quads: %v", err)
+	}
+
+	cfg := &config.Config{DatabaseType: "memstore", ReplicationType: "single", LoadSize: 1}
+	checkpoint := filepath.Join(tmpDir, "load.checkpoint")
+	cp := &loadCheckpoint{Source: path, Size: fi.Size(), ModTime: fi.ModTime(), Quads: 2}
+	err = cp.write(checkpoint)
+	if err != nil {
+		t.Fatalf("Could not write checkpoint: %v", err)
+	}
+
+	h, err := db.Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open memstore: %v", err)
+	}
+	defer h.Close()
+	err = BulkLoad(h, cfg, path, "cquad", checkpoint)
+	if err != nil {
+		t.Fatalf("Failed to resume load: %v", err)
+	}
+	var got []quad.Quad
+	it := h.QuadStore.QuadsAllIterator()
+	for graph.Next(it) {
+		got = append(got, h.QuadStore.Quad(it.Result()))
+	}
+	it.Close()
+	sort.Sort(byString(got))
+	expect := append([]quad.Quad(nil), checkpointQuads[2:]...)
+	sort.Sort(byString(expect))
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected quads after resuming, got:%v expect:%v", got, expect)
+	}
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("Checkpoint was not removed after the load completed: %v", err)
+	}
+
+	cp.Size++
+	err = cp.write(checkpoint)
+	if err != nil {
+		t.Fatalf("Could not write checkpoint: %v", err)
+	}
+	err = BulkLoad(h, cfg, path, "cquad", checkpoint)
+	if err == nil {
+		t.Errorf("Expected error resuming from a checkpoint for different input")
+	}
+}
+
+func TestLoadCheckpointOffset(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cayley_test")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "quads.nq")
+	var data []byte
+	for _, q := range checkpointQuads {
+		data = append(data, q.NQuad()+"\n"...)
+	}
+	offset := len(checkpointQuads[0].NQuad()) + len(checkpointQuads[1].NQuad()) + 2
+	// The quads before the offset must not be read again, so they are
+	// replaced by a comment of the same length.
+	copy(data, "#"+strings.Repeat(" ", offset-2)+"\n")
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatalf("Could not write quads: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Could not stat quads: %v", err)
+	}
+
+	cfg := &config.Config{DatabaseType: "memstore", ReplicationType: "single", LoadSize: 1}
+	checkpoint := filepath.Join(tmpDir, "load.checkpoint")
+	cp := &loadCheckpoint{Source: path, Size: fi.Size(), ModTime: fi.ModTime(), Quads: 2, Offset: int64(offset)}
+	err = cp.write(checkpoint)
+	if err != nil {
+		t.Fatalf("Could not write checkpoint: %v", err)
+	}
+
+	h, err := db.Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open memstore: %v", err)
+	}
+	defer h.Close()
+	// The load stopped after writing a block, but before recording it.
+	err = h.QuadWriter.AddQuadSet(checkpointQuads[:3])
+	if err != nil {
+		t.Fatalf("Failed to write quads: %v", err)
+	}
+	err = BulkLoad(h, cfg, path, "cquad", checkpoint)
+	if err != nil {
+		t.Fatalf("Failed to resume load: %v", err)
+	}
+	var got []quad.Quad
+	it := h.QuadStore.QuadsAllIterator()
+	for graph.Next(it) {
+		got = append(got, h.QuadStore.Quad(it.Result()))
+	}
+	it.Close()
+	sort.Sort(byString(got))
+	expect := append([]quad.Quad(nil), checkpointQuads...)
+	sort.Sort(byString(expect))
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected quads after resuming, got:%v expect:%v", got, expect)
+	}
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("Checkpoint was not removed after the load completed: %v", err)
+	}
+	err = h.QuadWriter.AddQuad(checkpointQuads[0])
+	if err == nil {
+		t.Errorf("Expected error adding a duplicate quad after the load")
+	}
+}
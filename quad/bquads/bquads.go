@@ -0,0 +1,255 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bquads implements a compact binary quad format.
+//
+// A stream starts with Magic followed by a version byte, and holds a sequence
+// of blocks. Each block is written as its length as a uvarint, the block
+// itself and its CRC-32 (Castagnoli) checksum in little-endian order. A
+// block holds a dictionary of the strings it uses, written as a uvarint count
+// followed by each string's length as a uvarint and its bytes, then a uvarint
+// count of quads, each written as the uvarint dictionary indexes of its
+// subject, predicate, object and label. Index zero is the empty string, and
+// index i is the ith string of the dictionary.
+//
+// Blocks are independent, so values are stored once per block rather than
+// once per quad, and decoding needs no parsing beyond reading integers.
+package bquads
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/google/cayley/quad"
+)
+
+// Magic is the start of every binary quad stream.
+const Magic = "\x89CQB"
+
+const version = 1
+
+var (
+	// blockQuads is the number of quads written in each block.
+	blockQuads = 4096
+	// maxBlockSize is the size of the largest block that will be read.
+	maxBlockSize uint64 = 1 << 28
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errCorrupt = errors.New("bquads: corrupt block")
+)
+
+// Encoder implements binary quad stream generation. Quads are written in
+// blocks, so the Encoder must be closed to write the last of them.
+type Encoder struct {
+	w      io.Writer
+	header bool
+
+	index map[string]uint64
+	dict  []string
+	ids   []uint64
+	buf   []byte
+}
+
+// NewEncoder returns a binary quad encoder that writes its output to the
+// provided io.Writer.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, index: make(map[string]uint64)}
+}
+
+// Marshal adds q to the current block, writing the block if it is full.
+func (enc *Encoder) Marshal(q quad.Quad) error {
+	if !q.IsValid() {
+		return quad.ErrInvalid
+	}
+	for _, v := range []string{q.Subject, q.Predicate, q.Object, q.Label} {
+		var id uint64
+		if v != "" {
+			var ok bool
+			id, ok = enc.index[v]
+			if !ok {
+				enc.dict = append(enc.dict, v)
+				id = uint64(len(enc.dict))
+				enc.index[v] = id
+			}
+		}
+		enc.ids = append(enc.ids, id)
+	}
+	if len(enc.ids) < 4*blockQuads {
+		return nil
+	}
+	return enc.flush()
+}
+
+// Close writes any buffered quads. It does not close the underlying writer.
+func (enc *Encoder) Close() error {
+	return enc.flush()
+}
+
+func (enc *Encoder) flush() error {
+	if !enc.header {
+		_, err := io.WriteString(enc.w, Magic+string(rune(version)))
+		if err != nil {
+			return err
+		}
+		enc.header = true
+	}
+	if len(enc.ids) == 0 {
+		return nil
+	}
+
+	var tmp [binary.MaxVarintLen64]byte
+	b := enc.buf[:0]
+	b = append(b, tmp[:binary.PutUvarint(tmp[:], uint64(len(enc.dict)))]...)
+	for _, s := range enc.dict {
+		b = append(b, tmp[:binary.PutUvarint(tmp[:], uint64(len(s)))]...)
+		b = append(b, s...)
+	}
+	b = append(b, tmp[:binary.PutUvarint(tmp[:], uint64(len(enc.ids)/4))]...)
+	for _, id := range enc.ids {
+		b = append(b, tmp[:binary.PutUvarint(tmp[:], id)]...)
+	}
+	enc.buf = b
+
+	_, err := enc.w.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(b)))])
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Write(b)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(tmp[:4], crc32.Checksum(b, crcTable))
+	_, err = enc.w.Write(tmp[:4])
+	if err != nil {
+		return err
+	}
+
+	enc.index = make(map[string]uint64, len(enc.index))
+	enc.dict = enc.dict[:0]
+	enc.ids = enc.ids[:0]
+	return nil
+}
+
+// Decoder implements binary quad stream parsing.
+type Decoder struct {
+	r      *bufio.Reader
+	header bool
+	buf    []byte
+	quads  []quad.Quad
+}
+
+// NewDecoder returns a binary quad decoder that takes its input from the
+// provided io.Reader.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Unmarshal returns the next quad of the stream as a quad.Quad, or an error.
+func (dec *Decoder) Unmarshal() (quad.Quad, error) {
+	if !dec.header {
+		var h [len(Magic) + 1]byte
+		_, err := io.ReadFull(dec.r, h[:])
+		if err == io.EOF {
+			return quad.Quad{}, err
+		} else if err != nil || string(h[:len(Magic)]) != Magic {
+			return quad.Quad{}, fmt.Errorf("bquads: not a binary quad stream")
+		}
+		if h[len(Magic)] != version {
+			return quad.Quad{}, fmt.Errorf("bquads: unsupported version %d", h[len(Magic)])
+		}
+		dec.header = true
+	}
+	for len(dec.quads) == 0 {
+		err := dec.readBlock()
+		if err != nil {
+			return quad.Quad{}, err
+		}
+	}
+	q := dec.quads[0]
+	dec.quads = dec.quads[1:]
+	return q, nil
+}
+
+func (dec *Decoder) readBlock() error {
+	n, err := binary.ReadUvarint(dec.r)
+	if err == io.EOF {
+		return err
+	} else if err != nil {
+		return errCorrupt
+	}
+	if n > maxBlockSize {
+		return fmt.Errorf("bquads: block of %d bytes is too large", n)
+	}
+	if uint64(cap(dec.buf)) < n+4 {
+		dec.buf = make([]byte, n+4)
+	}
+	b := dec.buf[:n+4]
+	_, err = io.ReadFull(dec.r, b)
+	if err != nil {
+		return fmt.Errorf("bquads: truncated block")
+	}
+	b, sum := b[:n], b[n:]
+	if crc32.Checksum(b, crcTable) != binary.LittleEndian.Uint32(sum) {
+		return fmt.Errorf("bquads: block checksum mismatch")
+	}
+
+	uvarint := func() (uint64, bool) {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, false
+		}
+		b = b[n:]
+		return v, true
+	}
+	count, ok := uvarint()
+	if !ok || count > uint64(len(b)) {
+		return errCorrupt
+	}
+	// Strings are copied out of the block, since its buffer is reused.
+	dict := make([]string, count+1)
+	for i := uint64(1); i <= count; i++ {
+		l, ok := uvarint()
+		if !ok || l > uint64(len(b)) {
+			return errCorrupt
+		}
+		dict[i] = string(b[:l])
+		b = b[l:]
+	}
+	count, ok = uvarint()
+	if !ok || count > uint64(len(b)) {
+		return errCorrupt
+	}
+	quads := make([]quad.Quad, count)
+	for i := range quads {
+		var v [4]string
+		for j := range v {
+			id, ok := uvarint()
+			if !ok || id >= uint64(len(dict)) {
+				return errCorrupt
+			}
+			v[j] = dict[id]
+		}
+		quads[i] = quad.Quad{Subject: v[0], Predicate: v[1], Object: v[2], Label: v[3]}
+	}
+	if len(b) != 0 {
+		return errCorrupt
+	}
+	dec.quads = quads
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bquads
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/cayley/quad"
+	"github.com/google/cayley/quad/cquads"
+)
+
+func testQuads(n int) []quad.Quad {
+	var quads []quad.Quad
+	for i := 0; i < n; i++ {
+		q := quad.Quad{
+			Subject:   fmt.Sprintf("<http://example.com/node/%d>", i),
+			Predicate: "<http://example.com/follows>",
+			Object:    fmt.Sprintf("<http://example.com/node/%d>", (i+1)%n),
+		}
+		if i%3 == 0 {
+			q.Label = "<http://example.com/graph>"
+		}
+		quads = append(quads, q)
+	}
+	return quads
+}
+
+func encode(t testing.TB, quads []quad.Quad) []byte {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, q := range quads {
+		err := enc.Marshal(q)
+		if err != nil {
+			t.Fatalf("Unexpected error encoding %v: %v", q, err)
+		}
+	}
+	err := enc.Close()
+	if err != nil {
+		t.Fatalf("Unexpected error closing encoder: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decode(b []byte) ([]quad.Quad, error) {
+	var quads []quad.Quad
+	dec := NewDecoder(bytes.NewReader(b))
+	for {
+		q, err := dec.Unmarshal()
+		if err == io.EOF {
+			return quads, nil
+		} else if err != nil {
+			return quads, err
+		}
+		quads = append(quads, q)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	defer func(n int) { blockQuads = n }(blockQuads)
+	blockQuads = 4
+	for _, n := range []int{0, 1, 4, 10} {
+		quads := testQuads(n)
+		b := encode(t, quads)
+		if !bytes.HasPrefix(b, []byte(Magic)) {
+			t.Errorf("Encoding of %d quads does not start with the magic bytes", n)
+		}
+		got, err := decode(b)
+		if err != nil {
+			t.Errorf("Unexpected error decoding %d quads: %v", n, err)
+		}
+		if !reflect.DeepEqual(got, quads) {
+			t.Errorf("Failed to round trip %d quads, got:%v expect:%v", n, got, quads)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Marshal(quad.Quad{Subject: "a"}); err != quad.ErrInvalid {
+		t.Errorf("Unexpected error for invalid quad, got:%v expect:%v", err, quad.ErrInvalid)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	b := encode(t, testQuads(10))
+	for _, test := range []struct {
+		message string
+		input   []byte
+	}{
+		{"reject text input", []byte("<a> <b> <c> .\n")},
+		{"reject an unknown version", append([]byte(Magic+"\x02"), b[len(Magic)+1:]...)},
+		{"reject a truncated block", b[:len(b)-1]},
+		{"reject a changed block", append(append(append([]byte(nil), b[:len(b)/2]...), b[len(b)/2]^1), b[len(b)/2+1:]...)},
+	} {
+		_, err := decode(test.input)
+		if err == nil {
+			t.Errorf("Expected error to %s", test.message)
+		}
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	data := encode(b, testQuads(10000))
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := decode(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCQuadsDecoder(b *testing.B) {
+	var buf bytes.Buffer
+	for _, q := range testQuads(10000) {
+		buf.WriteString(q.NQuad() + "\n")
+	}
+	data := buf.String()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dec := cquads.NewDecoder(strings.NewReader(data))
+		for {
+			_, err := dec.Unmarshal()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -658,6 +658,51 @@ func TestDecoder(t *testing.T) {
 	}
 }
 
+func TestDecoderErrors(t *testing.T) {
+	const input = "<a> <b> <c> .\n\n  <a> <b> .\n<d> <e> <f> .\n\t<a> <b> <c> <d> <e> .\n"
+	dec := NewDecoder(strings.NewReader(input))
+	var (
+		subjects []string
+		errs     [][2]int
+	)
+	for {
+		q, err := dec.Unmarshal()
+		if err == io.EOF {
+			break
+		} else if perr, ok := err.(*quad.ParseError); ok {
+			errs = append(errs, [2]int{perr.Line, perr.Column})
+			continue
+		} else if err != nil {
+			t.Fatalf("Unexpected error reading document: %v", err)
+		}
+		subjects = append(subjects, q.Subject)
+	}
+	if expect := []string{"a", "d"}; !reflect.DeepEqual(subjects, expect) {
+		t.Errorf("Failed to continue after errors, got:%q expect:%q", subjects, expect)
+	}
+	if expect := [][2]int{{3, 12}, {5, 18}}; !reflect.DeepEqual(errs, expect) {
+		t.Errorf("Unexpected error positions, got:%v expect:%v", errs, expect)
+	}
+}
+
+func TestDecoderOffset(t *testing.T) {
+	const input = "# c\n<a> <b> <c> .\r\n\n<d> <e> <f> ."
+	dec := NewDecoder(strings.NewReader(input))
+	var offsets []int64
+	for {
+		_, err := dec.Unmarshal()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error reading document: %v", err)
+		}
+		offsets = append(offsets, dec.Offset())
+	}
+	if expect := []int64{19, int64(len(input))}; !reflect.DeepEqual(offsets, expect) {
+		t.Errorf("Unexpected offsets, got:%v expect:%v", offsets, expect)
+	}
+}
+
 func TestRDFWorkingGroupSuit(t *testing.T) {
 	// Tests that are not passable by cquads parsing from the RDF
 	// Working Group Suite:
@@ -17,9 +17,6 @@
 package cquads
 
 import (
-	"fmt"
-	"unicode"
-
 	"github.com/google/cayley/quad"
 )
 
@@ -0,0 +1,292 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonld
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// term is a term definition of an active context.
+type term struct {
+	id        string
+	typ       string // "@id", "@vocab" or a datatype IRI.
+	container string // "@list", "@set", "@language" or "@index".
+	reverse   bool
+
+	// language is the default language of string values of the term; when
+	// hasLanguage is false the context's default language applies.
+	language    string
+	hasLanguage bool
+}
+
+// context is an active context.
+type context struct {
+	base     string
+	vocab    string
+	language string
+	terms    map[string]*term
+}
+
+func (c *context) clone() *context {
+	n := *c
+	n.terms = make(map[string]*term, len(c.terms))
+	for k, v := range c.terms {
+		n.terms[k] = v
+	}
+	return &n
+}
+
+// parse returns the context resulting from applying the local context v,
+// which may be an object, a null or an array of these, to c.
+func (c *context) parse(v interface{}, base string) (*context, error) {
+	switch v := v.(type) {
+	case nil:
+		return &context{base: base, terms: make(map[string]*term)}, nil
+	case []interface{}:
+		var err error
+		for _, v := range v {
+			c, err = c.parse(v, base)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
+	case string:
+		return nil, fmt.Errorf("remote context %q is not supported", v)
+	case map[string]interface{}:
+		c = c.clone()
+		if b, ok := v["@base"]; ok {
+			switch b := b.(type) {
+			case nil:
+				c.base = ""
+			case string:
+				c.base = resolve(c.base, b)
+			default:
+				return nil, fmt.Errorf("invalid @base %v", b)
+			}
+		}
+		if vocab, ok := v["@vocab"]; ok {
+			switch vocab := vocab.(type) {
+			case nil:
+				c.vocab = ""
+			case string:
+				c.vocab = vocab
+			default:
+				return nil, fmt.Errorf("invalid @vocab %v", vocab)
+			}
+		}
+		if lang, ok := v["@language"]; ok {
+			switch lang := lang.(type) {
+			case nil:
+				c.language = ""
+			case string:
+				c.language = strings.ToLower(lang)
+			default:
+				return nil, fmt.Errorf("invalid @language %v", lang)
+			}
+		}
+		defined := make(map[string]bool)
+		for _, k := range sortedKeys(v) {
+			err := c.define(v, k, defined)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("invalid context %v", v)
+}
+
+// define creates the term definition of key in local, first defining any
+// terms its definition depends on.
+func (c *context) define(local map[string]interface{}, key string, defined map[string]bool) error {
+	if isKeyword(key) {
+		return nil
+	}
+	if done, ok := defined[key]; ok {
+		if !done {
+			return fmt.Errorf("cyclic definition of term %q", key)
+		}
+		return nil
+	}
+	defined[key] = false
+	defer func() { defined[key] = true }()
+
+	var def map[string]interface{}
+	switch v := local[key].(type) {
+	case nil:
+		delete(c.terms, key)
+		return nil
+	case string:
+		def = map[string]interface{}{"@id": v}
+	case map[string]interface{}:
+		def = v
+	default:
+		return fmt.Errorf("invalid definition of term %q", key)
+	}
+
+	t := &term{}
+	id, ok := def["@id"]
+	if r, isReverse := def["@reverse"]; isReverse {
+		id, ok = r, true
+		t.reverse = true
+	}
+	switch id := id.(type) {
+	case string:
+		iri, err := c.expand(id, false, true, local, defined)
+		if err != nil {
+			return err
+		}
+		t.id = iri
+	case nil:
+		if ok {
+			// An explicitly null term is not mapped to an IRI.
+			c.terms[key] = t
+			return nil
+		}
+		if i := strings.Index(key, ":"); i > 0 {
+			iri, err := c.expand(key, false, true, local, defined)
+			if err != nil {
+				return err
+			}
+			t.id = iri
+		} else if c.vocab != "" {
+			t.id = c.vocab + key
+		} else {
+			return fmt.Errorf("term %q is not mapped to an IRI", key)
+		}
+	default:
+		return fmt.Errorf("invalid @id for term %q", key)
+	}
+
+	if typ, ok := def["@type"]; ok {
+		s, ok := typ.(string)
+		if !ok {
+			return fmt.Errorf("invalid @type for term %q", key)
+		}
+		if s != "@id" && s != "@vocab" {
+			var err error
+			s, err = c.expand(s, false, true, local, defined)
+			if err != nil {
+				return err
+			}
+		}
+		t.typ = s
+	}
+	if container, ok := def["@container"]; ok {
+		s, _ := container.(string)
+		switch s {
+		case "@list", "@set", "@language", "@index":
+			t.container = s
+		default:
+			return fmt.Errorf("invalid @container for term %q", key)
+		}
+	}
+	if lang, ok := def["@language"]; ok {
+		switch lang := lang.(type) {
+		case nil:
+		case string:
+			t.language = strings.ToLower(lang)
+		default:
+			return fmt.Errorf("invalid @language for term %q", key)
+		}
+		t.hasLanguage = true
+	}
+	c.terms[key] = t
+	return nil
+}
+
+// expand returns the IRI that value expands to. Relative IRIs are resolved
+// against the base IRI if relative is true, and terms and the vocabulary
+// mapping are used if vocab is true. Terms in local that have not yet been
+// defined are defined first.
+func (c *context) expand(value string, relative, vocab bool, local map[string]interface{}, defined map[string]bool) (string, error) {
+	if isKeyword(value) {
+		return value, nil
+	}
+	if local != nil {
+		if _, ok := local[value]; ok {
+			err := c.define(local, value, defined)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	if t, ok := c.terms[value]; ok && vocab {
+		return t.id, nil
+	}
+	if i := strings.Index(value, ":"); i >= 0 {
+		prefix, suffix := value[:i], value[i+1:]
+		if prefix == "_" || strings.HasPrefix(suffix, "//") {
+			return value, nil
+		}
+		if local != nil {
+			if _, ok := local[prefix]; ok {
+				err := c.define(local, prefix, defined)
+				if err != nil {
+					return "", err
+				}
+			}
+		}
+		if t, ok := c.terms[prefix]; ok && t.id != "" {
+			return t.id + suffix, nil
+		}
+		return value, nil
+	}
+	if vocab && c.vocab != "" {
+		return c.vocab + value, nil
+	}
+	if relative {
+		return resolve(c.base, value), nil
+	}
+	return value, nil
+}
+
+// resolve resolves the IRI ref against base.
+func resolve(base, ref string) string {
+	if base == "" {
+		return ref
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
+
+func isKeyword(s string) bool {
+	switch s {
+	case "@context", "@id", "@type", "@value", "@language", "@list", "@set",
+		"@graph", "@reverse", "@index", "@base", "@vocab", "@container":
+		return true
+	}
+	return false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,186 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonld
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/cayley/quad"
+)
+
+// Encoder implements JSON-LD document generation. Quads are held in memory
+// until the Encoder is closed, when they are written as a single document
+// with the properties of each subject grouped into one node object per graph.
+type Encoder struct {
+	w       io.Writer
+	context map[string]string
+
+	// graphs holds the values of each property of each subject, keyed by
+	// graph, subject and property.
+	graphs map[string]map[string]map[string][]interface{}
+}
+
+// NewEncoder returns a JSON-LD encoder that writes its output to the
+// provided io.Writer.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, graphs: make(map[string]map[string]map[string][]interface{})}
+}
+
+// SetContext sets the context used to compact the IRIs of the output. Each
+// key of ctx is a term or prefix for the IRI it maps to. The context is
+// written as the document's @context.
+func (enc *Encoder) SetContext(ctx map[string]string) {
+	enc.context = ctx
+}
+
+// Marshal adds q to the document.
+func (enc *Encoder) Marshal(q quad.Quad) error {
+	if !q.IsValid() {
+		return quad.ErrInvalid
+	}
+	nodes, ok := enc.graphs[q.Label]
+	if !ok {
+		nodes = make(map[string]map[string][]interface{})
+		enc.graphs[q.Label] = nodes
+	}
+	props, ok := nodes[q.Subject]
+	if !ok {
+		props = make(map[string][]interface{})
+		nodes[q.Subject] = props
+	}
+	p := iri(q.Predicate)
+	if p == rdfNS+"type" && isIRI(q.Object) {
+		props["@type"] = append(props["@type"], enc.compact(iri(q.Object), true))
+		return nil
+	}
+	p = enc.compact(p, true)
+	props[p] = append(props[p], enc.value(q.Object))
+	return nil
+}
+
+// Close writes the document. It does not close the underlying writer.
+func (enc *Encoder) Close() error {
+	doc := struct {
+		Context map[string]string `json:"@context,omitempty"`
+		Graph   []interface{}     `json:"@graph"`
+	}{Context: enc.context, Graph: enc.nodes(enc.graphs[""])}
+	var labels []string
+	for label := range enc.graphs {
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		doc.Graph = append(doc.Graph, map[string]interface{}{
+			"@id":    enc.id(label),
+			"@graph": enc.nodes(enc.graphs[label]),
+		})
+	}
+	if doc.Graph == nil {
+		doc.Graph = []interface{}{}
+	}
+	enc.graphs = make(map[string]map[string]map[string][]interface{})
+	return json.NewEncoder(enc.w).Encode(doc)
+}
+
+// nodes returns the node objects of a graph, ordered by subject.
+func (enc *Encoder) nodes(graph map[string]map[string][]interface{}) []interface{} {
+	subjects := make([]string, 0, len(graph))
+	for s := range graph {
+		subjects = append(subjects, s)
+	}
+	sort.Strings(subjects)
+	var nodes []interface{}
+	for _, s := range subjects {
+		node := map[string]interface{}{"@id": enc.id(s)}
+		for p, values := range graph[s] {
+			if len(values) == 1 {
+				node[p] = values[0]
+			} else {
+				node[p] = values
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// id returns the @id of the node with the quad value s.
+func (enc *Encoder) id(s string) string {
+	if isIRI(s) {
+		return enc.compact(iri(s), false)
+	}
+	return s
+}
+
+// value returns the JSON-LD value of the quad object o. Values that are not
+// literals are written as node references.
+func (enc *Encoder) value(o string) interface{} {
+	if !strings.HasPrefix(o, `"`) {
+		return map[string]interface{}{"@id": enc.id(o)}
+	}
+	end := strings.LastIndex(o, `"`)
+	if end == 0 {
+		return map[string]interface{}{"@id": o}
+	}
+	lex, suffix := o[1:end], o[end+1:]
+	switch {
+	case suffix == "" || suffix == "^^<"+xsdNS+"string>":
+		return lex
+	case strings.HasPrefix(suffix, "@"):
+		return map[string]interface{}{"@value": lex, "@language": suffix[1:]}
+	case strings.HasPrefix(suffix, "^^<") && strings.HasSuffix(suffix, ">"):
+		return map[string]interface{}{"@value": lex, "@type": enc.compact(suffix[3:len(suffix)-1], true)}
+	}
+	return map[string]interface{}{"@id": o}
+}
+
+// compact returns the shortest form of iri given the context. Terms that
+// match iri exactly are only used if vocab is true, since values of @id are
+// not expanded as terms.
+func (enc *Encoder) compact(iri string, vocab bool) string {
+	best := iri
+	for k, v := range enc.context {
+		var c string
+		switch {
+		case v == iri && vocab:
+			c = k
+		case strings.HasPrefix(iri, v) && len(iri) > len(v) && !strings.HasPrefix(iri[len(v):], "//"):
+			c = k + ":" + iri[len(v):]
+		default:
+			continue
+		}
+		if len(c) < len(best) || len(c) == len(best) && c < best {
+			best = c
+		}
+	}
+	return best
+}
+
+func isIRI(s string) bool {
+	return len(s) >= 2 && s[0] == '<' && s[len(s)-1] == '>'
+}
+
+// iri returns the IRI held by the quad value s, or s if it is not an IRI.
+func iri(s string) string {
+	if isIRI(s) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,506 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jsonld implements reading and writing JSON-LD documents.
+//
+// Documents are expanded into quads as described by the JSON-LD 1.0
+// specification, http://www.w3.org/TR/json-ld/, with the exception that
+// remote contexts are not fetched; contexts must be given inline. Quad values
+// are written using N-Quad term syntax, so IRIs are written as <iri>, blank
+// nodes as _:label and literals as "value", "value"@lang or
+// "value"^^<datatype>.
+package jsonld
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/google/cayley/quad"
+)
+
+const (
+	rdfNS = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xsdNS = "http://www.w3.org/2001/XMLSchema#"
+)
+
+// Decoder implements JSON-LD document parsing. The input may hold a sequence
+// of documents, each of which is expanded in turn.
+type Decoder struct {
+	dec   *json.Decoder
+	base  string
+	quads []quad.Quad
+
+	// blanks maps the blank node labels of the current document to the
+	// labels they are written with, which are unique over the input.
+	blanks map[string]string
+	n      int
+}
+
+// NewDecoder returns a JSON-LD decoder that takes its input from the
+// provided io.Reader.
+func NewDecoder(r io.Reader) *Decoder {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &Decoder{dec: dec}
+}
+
+// SetBase sets the IRI that relative IRIs in the input are resolved against.
+func (dec *Decoder) SetBase(base string) {
+	dec.base = base
+}
+
+// Unmarshal returns the next quad of the input as a quad.Quad, or an error.
+func (dec *Decoder) Unmarshal() (quad.Quad, error) {
+	for len(dec.quads) == 0 {
+		var doc interface{}
+		err := dec.dec.Decode(&doc)
+		if err == io.EOF {
+			return quad.Quad{}, err
+		} else if err != nil {
+			return quad.Quad{}, fmt.Errorf("jsonld: failed to parse document: %v", err)
+		}
+		dec.blanks = make(map[string]string)
+		err = dec.document(doc)
+		if err != nil {
+			return quad.Quad{}, fmt.Errorf("jsonld: %v", err)
+		}
+	}
+	q := dec.quads[0]
+	dec.quads = dec.quads[1:]
+	return q, nil
+}
+
+func (dec *Decoder) document(doc interface{}) error {
+	ctx := &context{base: dec.base, terms: make(map[string]*term)}
+	if m, ok := doc.(map[string]interface{}); ok {
+		// An object holding only a context and a graph is a container
+		// for the nodes of the default graph, not a node itself.
+		g, ok := m["@graph"]
+		_, hasContext := m["@context"]
+		if ok && (len(m) == 1 || len(m) == 2 && hasContext) {
+			var err error
+			ctx, err = ctx.parse(m["@context"], dec.base)
+			if err != nil {
+				return err
+			}
+			return dec.nodes(ctx, g, "")
+		}
+	}
+	return dec.nodes(ctx, doc, "")
+}
+
+// nodes expands v, a node object or an array of them, into graph.
+func (dec *Decoder) nodes(ctx *context, v interface{}, graph string) error {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, v := range v {
+			err := dec.nodes(ctx, v, graph)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		_, err := dec.values(ctx, nil, v, graph)
+		return err
+	}
+	return fmt.Errorf("invalid node %v", v)
+}
+
+// keywords returns the entries of obj whose keys are keywords or aliases of
+// them, keyed by keyword, and the remaining keys in order.
+func keywords(ctx *context, obj map[string]interface{}) (map[string]interface{}, []string, error) {
+	kw := make(map[string]interface{})
+	var keys []string
+	for _, k := range sortedKeys(obj) {
+		if k == "@context" {
+			continue
+		}
+		iri, err := ctx.expand(k, false, true, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isKeyword(iri) {
+			kw[iri] = obj[k]
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return kw, keys, nil
+}
+
+// node expands the node object obj into graph, returning the node's term.
+func (dec *Decoder) node(ctx *context, obj map[string]interface{}, graph string) (string, error) {
+	if local, ok := obj["@context"]; ok {
+		var err error
+		ctx, err = ctx.parse(local, dec.base)
+		if err != nil {
+			return "", err
+		}
+	}
+	kw, keys, err := keywords(ctx, obj)
+	if err != nil {
+		return "", err
+	}
+
+	var s string
+	if id, ok := kw["@id"]; ok {
+		str, ok := id.(string)
+		if !ok {
+			return "", fmt.Errorf("invalid @id %v", id)
+		}
+		iri, err := ctx.expand(str, true, false, nil, nil)
+		if err != nil {
+			return "", err
+		}
+		s = dec.term(iri)
+	} else {
+		s = dec.blank("")
+	}
+
+	if types, ok := kw["@type"]; ok {
+		for _, typ := range array(types) {
+			str, ok := typ.(string)
+			if !ok {
+				return "", fmt.Errorf("invalid @type %v", typ)
+			}
+			iri, err := ctx.expand(str, true, true, nil, nil)
+			if err != nil {
+				return "", err
+			}
+			dec.emit(s, rdfNS+"type", dec.term(iri), graph)
+		}
+	}
+	if g, ok := kw["@graph"]; ok {
+		err = dec.nodes(ctx, g, s)
+		if err != nil {
+			return "", err
+		}
+	}
+	if r, ok := kw["@reverse"]; ok {
+		m, ok := r.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("invalid @reverse %v", r)
+		}
+		for _, k := range sortedKeys(m) {
+			iri, err := ctx.expand(k, false, true, nil, nil)
+			if err != nil {
+				return "", err
+			}
+			err = dec.reverse(ctx, ctx.terms[k], s, iri, m[k], graph)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	for _, k := range keys {
+		iri, err := ctx.expand(k, false, true, nil, nil)
+		if err != nil {
+			return "", err
+		}
+		// Properties that do not expand to an absolute IRI are dropped.
+		if !strings.Contains(iri, ":") {
+			continue
+		}
+		t := ctx.terms[k]
+		if t != nil && t.reverse {
+			err = dec.reverse(ctx, t, s, iri, obj[k], graph)
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+		objects, err := dec.property(ctx, t, obj[k], graph)
+		if err != nil {
+			return "", err
+		}
+		for _, o := range objects {
+			dec.emit(s, iri, o, graph)
+		}
+	}
+	return s, nil
+}
+
+// reverse expands the values v of the reverse property iri of the node s.
+func (dec *Decoder) reverse(ctx *context, t *term, s, iri string, v interface{}, graph string) error {
+	for _, v := range array(v) {
+		if _, ok := v.(map[string]interface{}); !ok {
+			return fmt.Errorf("invalid reverse property value %v", v)
+		}
+		subjects, err := dec.values(ctx, t, v, graph)
+		if err != nil {
+			return err
+		}
+		for _, o := range subjects {
+			dec.emit(o, iri, s, graph)
+		}
+	}
+	return nil
+}
+
+// property returns the objects of a property defined by t with the value v.
+func (dec *Decoder) property(ctx *context, t *term, v interface{}, graph string) ([]string, error) {
+	if t == nil {
+		return dec.values(ctx, nil, v, graph)
+	}
+	switch t.container {
+	case "@list":
+		if m, ok := v.(map[string]interface{}); ok {
+			if _, ok := m["@list"]; ok {
+				return dec.values(ctx, t, v, graph)
+			}
+		}
+		l, err := dec.list(ctx, t, v, graph)
+		if err != nil {
+			return nil, err
+		}
+		return []string{l}, nil
+	case "@language":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			break
+		}
+		var objects []string
+		for _, lang := range sortedKeys(m) {
+			for _, s := range array(m[lang]) {
+				if s == nil {
+					continue
+				}
+				str, ok := s.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid language map value %v", s)
+				}
+				objects = append(objects, literal(str, "@"+strings.ToLower(lang)))
+			}
+		}
+		return objects, nil
+	case "@index":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			break
+		}
+		var objects []string
+		for _, k := range sortedKeys(m) {
+			o, err := dec.values(ctx, t, m[k], graph)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, o...)
+		}
+		return objects, nil
+	}
+	return dec.values(ctx, t, v, graph)
+}
+
+// values returns the objects of a property defined by t with the value v,
+// expanding any node objects into graph.
+func (dec *Decoder) values(ctx *context, t *term, v interface{}, graph string) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		var objects []string
+		for _, v := range v {
+			o, err := dec.values(ctx, t, v, graph)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, o...)
+		}
+		return objects, nil
+	case map[string]interface{}:
+		kw, _, err := keywords(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		if value, ok := kw["@value"]; ok {
+			o, err := dec.valueObject(ctx, value, kw)
+			if o == "" || err != nil {
+				return nil, err
+			}
+			return []string{o}, nil
+		}
+		if l, ok := kw["@list"]; ok {
+			o, err := dec.list(ctx, t, l, graph)
+			if err != nil {
+				return nil, err
+			}
+			return []string{o}, nil
+		}
+		if set, ok := kw["@set"]; ok {
+			return dec.values(ctx, t, set, graph)
+		}
+		s, err := dec.node(ctx, v, graph)
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	case string:
+		if t != nil && (t.typ == "@id" || t.typ == "@vocab") {
+			iri, err := ctx.expand(v, true, t.typ == "@vocab", nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			return []string{dec.term(iri)}, nil
+		}
+		if t != nil && t.typ != "" {
+			return []string{literal(v, "^^<"+t.typ+">")}, nil
+		}
+		lang := ctx.language
+		if t != nil && t.hasLanguage {
+			lang = t.language
+		}
+		if lang != "" {
+			return []string{literal(v, "@"+lang)}, nil
+		}
+		return []string{literal(v, "")}, nil
+	case json.Number, bool:
+		if t != nil && t.typ != "" && t.typ != "@id" && t.typ != "@vocab" {
+			return []string{literal(fmt.Sprint(v), "^^<"+t.typ+">")}, nil
+		}
+		return []string{native(v)}, nil
+	}
+	return nil, fmt.Errorf("invalid value %v", v)
+}
+
+// valueObject returns the literal described by a value object, or the empty
+// string if its value is null.
+func (dec *Decoder) valueObject(ctx *context, value interface{}, kw map[string]interface{}) (string, error) {
+	var suffix string
+	if typ, ok := kw["@type"]; ok {
+		str, ok := typ.(string)
+		if !ok {
+			return "", fmt.Errorf("invalid value object @type %v", typ)
+		}
+		iri, err := ctx.expand(str, true, true, nil, nil)
+		if err != nil {
+			return "", err
+		}
+		suffix = "^^<" + iri + ">"
+	} else if lang, ok := kw["@language"]; ok {
+		str, ok := lang.(string)
+		if !ok {
+			return "", fmt.Errorf("invalid value object @language %v", lang)
+		}
+		suffix = "@" + strings.ToLower(str)
+	}
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return literal(value, suffix), nil
+	case json.Number, bool:
+		if strings.HasPrefix(suffix, "^^") {
+			return literal(fmt.Sprint(value), suffix), nil
+		}
+		return native(value), nil
+	}
+	return "", fmt.Errorf("invalid @value %v", value)
+}
+
+// list returns the head of an RDF collection holding the values v.
+func (dec *Decoder) list(ctx *context, t *term, v interface{}, graph string) (string, error) {
+	var objects []string
+	for _, v := range array(v) {
+		o, err := dec.values(ctx, t, v, graph)
+		if err != nil {
+			return "", err
+		}
+		objects = append(objects, o...)
+	}
+	head := "<" + rdfNS + "nil>"
+	for i := len(objects) - 1; i >= 0; i-- {
+		b := dec.blank("")
+		dec.emit(b, rdfNS+"first", objects[i], graph)
+		dec.emit(b, rdfNS+"rest", head, graph)
+		head = b
+	}
+	return head, nil
+}
+
+func (dec *Decoder) emit(s, p, o, graph string) {
+	dec.quads = append(dec.quads, quad.Quad{Subject: s, Predicate: dec.term(p), Object: o, Label: graph})
+}
+
+// term returns the quad value of the expanded IRI or blank node iri.
+func (dec *Decoder) term(iri string) string {
+	if strings.HasPrefix(iri, "_:") {
+		return dec.blank(iri[2:])
+	}
+	return "<" + iri + ">"
+}
+
+// blank returns the blank node with the given label, or a new blank node if
+// label is empty.
+func (dec *Decoder) blank(label string) string {
+	if b, ok := dec.blanks[label]; ok && label != "" {
+		return b
+	}
+	b := fmt.Sprintf("_:b%d", dec.n)
+	dec.n++
+	if label != "" {
+		dec.blanks[label] = b
+	}
+	return b
+}
+
+func literal(s, suffix string) string {
+	return `"` + s + `"` + suffix
+}
+
+// native returns the literal for a JSON number or boolean.
+func native(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		return literal(strconv.FormatBool(v), "^^<"+xsdNS+"boolean>")
+	case json.Number:
+		s := string(v)
+		f, err := v.Float64()
+		if err != nil || f != math.Trunc(f) || math.Abs(f) >= 1e21 {
+			return literal(canonicalDouble(f), "^^<"+xsdNS+"double>")
+		}
+		if strings.ContainsAny(s, ".eE") {
+			s = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return literal(s, "^^<"+xsdNS+"integer>")
+	}
+	panic("jsonld: invalid native value")
+}
+
+// canonicalDouble returns the canonical lexical form of an xsd:double.
+func canonicalDouble(f float64) string {
+	s := strconv.FormatFloat(f, 'E', -1, 64)
+	i := strings.Index(s, "E")
+	if i < 0 {
+		return s
+	}
+	mantissa, exp := s[:i], s[i+1:]
+	if !strings.Contains(mantissa, ".") {
+		mantissa += ".0"
+	}
+	e, _ := strconv.Atoi(exp)
+	return mantissa + "E" + strconv.Itoa(e)
+}
+
+func array(v interface{}) []interface{} {
+	if a, ok := v.([]interface{}); ok {
+		return a
+	}
+	return []interface{}{v}
+}
@@ -0,0 +1,212 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonld
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/cayley/quad"
+)
+
+func q(s, p, o, l string) quad.Quad {
+	return quad.Quad{Subject: s, Predicate: p, Object: o, Label: l}
+}
+
+var decodeTests = []struct {
+	message string
+	input   string
+	expect  []quad.Quad
+	err     bool
+}{
+	{
+		message: "expand a node with a context",
+		input: `{
+			"@context": {"name": "http://schema.org/name", "knows": {"@id": "http://schema.org/knows", "@type": "@id"}},
+			"@id": "http://example.com/alice",
+			"name": "Alice",
+			"knows": "http://example.com/bob"
+		}`,
+		expect: []quad.Quad{
+			q("<http://example.com/alice>", "<http://schema.org/knows>", "<http://example.com/bob>", ""),
+			q("<http://example.com/alice>", "<http://schema.org/name>", `"Alice"`, ""),
+		},
+	},
+	{
+		message: "expand compact IRIs, types and native values",
+		input: `{
+			"@context": {"s": "http://schema.org/", "xsd": "http://www.w3.org/2001/XMLSchema#"},
+			"@id": "_:x",
+			"@type": "s:Person",
+			"s:age": 42,
+			"s:height": 1.5,
+			"s:member": true,
+			"s:born": {"@value": "1970", "@type": "xsd:gYear"},
+			"s:motto": {"@value": "carpe diem", "@language": "LA"}
+		}`,
+		expect: []quad.Quad{
+			q("_:b0", "<http://schema.org/age>", `"42"^^<`+xsdNS+`integer>`, ""),
+			q("_:b0", "<http://schema.org/born>", `"1970"^^<`+xsdNS+`gYear>`, ""),
+			q("_:b0", "<http://schema.org/height>", `"1.5E0"^^<`+xsdNS+`double>`, ""),
+			q("_:b0", "<http://schema.org/member>", `"true"^^<`+xsdNS+`boolean>`, ""),
+			q("_:b0", "<http://schema.org/motto>", `"carpe diem"@la`, ""),
+			q("_:b0", "<"+rdfNS+"type>", "<http://schema.org/Person>", ""),
+		},
+	},
+	{
+		message: "expand nested nodes with a vocabulary and base",
+		input: `{
+			"@context": {"@vocab": "http://schema.org/", "@base": "http://example.com/", "@language": "en"},
+			"@id": "alice",
+			"name": "Alice",
+			"knows": {"@id": "bob", "name": "Bob"},
+			"undefined:term": "kept"
+		}`,
+		expect: []quad.Quad{
+			q("<http://example.com/alice>", "<http://schema.org/knows>", "<http://example.com/bob>", ""),
+			q("<http://example.com/alice>", "<http://schema.org/name>", `"Alice"@en`, ""),
+			q("<http://example.com/alice>", "<undefined:term>", `"kept"@en`, ""),
+			q("<http://example.com/bob>", "<http://schema.org/name>", `"Bob"@en`, ""),
+		},
+	},
+	{
+		message: "expand a list",
+		input: `{
+			"@context": {"items": {"@id": "http://example.com/items", "@container": "@list"}},
+			"@id": "http://example.com/l",
+			"items": ["a", "b"]
+		}`,
+		expect: []quad.Quad{
+			q("<http://example.com/l>", "<http://example.com/items>", "_:b1", ""),
+			q("_:b0", "<"+rdfNS+"first>", `"b"`, ""),
+			q("_:b0", "<"+rdfNS+"rest>", "<"+rdfNS+"nil>", ""),
+			q("_:b1", "<"+rdfNS+"first>", `"a"`, ""),
+			q("_:b1", "<"+rdfNS+"rest>", "_:b0", ""),
+		},
+	},
+	{
+		message: "expand named graphs",
+		input: `{
+			"@context": {"ex": "http://example.com/"},
+			"@graph": [
+				{"@id": "ex:a", "ex:p": {"@id": "ex:b"}},
+				{"@id": "ex:g", "@graph": {"@id": "ex:c", "ex:p": "d"}}
+			]
+		}`,
+		expect: []quad.Quad{
+			q("<http://example.com/a>", "<http://example.com/p>", "<http://example.com/b>", ""),
+			q("<http://example.com/c>", "<http://example.com/p>", `"d"`, "<http://example.com/g>"),
+		},
+	},
+	{
+		message: "drop properties that are not IRIs",
+		input:   `{"@id": "http://example.com/a", "name": "dropped"}`,
+	},
+	{
+		message: "reject remote contexts",
+		input:   `{"@context": "http://schema.org/", "name": "x"}`,
+		err:     true,
+	},
+	{
+		message: "reject invalid JSON",
+		input:   `{"@id": `,
+		err:     true,
+	},
+}
+
+type byQuad []quad.Quad
+
+func (o byQuad) Len() int           { return len(o) }
+func (o byQuad) Less(i, j int) bool { return o[i].NQuad() < o[j].NQuad() }
+func (o byQuad) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+
+func readAll(dec *Decoder) ([]quad.Quad, error) {
+	var quads []quad.Quad
+	for {
+		q, err := dec.Unmarshal()
+		if err == io.EOF {
+			return quads, nil
+		} else if err != nil {
+			return quads, err
+		}
+		quads = append(quads, q)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	for _, test := range decodeTests {
+		got, err := readAll(NewDecoder(strings.NewReader(test.input)))
+		if (err != nil) != test.err {
+			t.Errorf("Unexpected error to %s, got:%v", test.message, err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		sort.Sort(byQuad(got))
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to %s, got:%v expect:%v", test.message, got, test.expect)
+		}
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	quads := []quad.Quad{
+		q("<http://example.com/alice>", "<"+rdfNS+"type>", "<http://schema.org/Person>", ""),
+		q("<http://example.com/alice>", "<http://schema.org/name>", `"Alice"@en`, ""),
+		q("<http://example.com/alice>", "<http://schema.org/knows>", "<http://example.com/bob>", ""),
+		q("<http://example.com/alice>", "<http://schema.org/knows>", "_:b0", ""),
+		q("_:b0", "<http://schema.org/age>", `"42"^^<`+xsdNS+`integer>`, "<http://example.com/g>"),
+		q("_:b0", "<http://schema.org/name>", `"Carol"`, "<http://example.com/g>"),
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetContext(map[string]string{"s": "http://schema.org/", "name": "http://schema.org/name"})
+	for _, q := range quads {
+		err := enc.Marshal(q)
+		if err != nil {
+			t.Fatalf("Unexpected error encoding %v: %v", q, err)
+		}
+	}
+	err := enc.Close()
+	if err != nil {
+		t.Fatalf("Unexpected error closing encoder: %v", err)
+	}
+	expect := `{"@context":{"name":"http://schema.org/name","s":"http://schema.org/"},"@graph":[` +
+		`{"@id":"http://example.com/alice","@type":"s:Person","name":{"@language":"en","@value":"Alice"},"s:knows":[{"@id":"http://example.com/bob"},{"@id":"_:b0"}]},` +
+		`{"@graph":[{"@id":"_:b0","name":"Carol","s:age":{"@type":"http://www.w3.org/2001/XMLSchema#integer","@value":"42"}}],"@id":"http://example.com/g"}]}` + "\n"
+	if buf.String() != expect {
+		t.Errorf("Unexpected document, got:%s expect:%s", buf.String(), expect)
+	}
+
+	// Decoding the document gives back the quads, with blank nodes relabelled.
+	got, err := readAll(NewDecoder(&buf))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding document: %v", err)
+	}
+	sort.Sort(byQuad(got))
+	sort.Sort(byQuad(quads))
+	if !reflect.DeepEqual(got, quads) {
+		t.Errorf("Failed to round trip quads, got:%v expect:%v", got, quads)
+	}
+
+	if err := enc.Marshal(quad.Quad{Subject: "a"}); err != quad.ErrInvalid {
+		t.Errorf("Unexpected error for invalid quad, got:%v expect:%v", err, quad.ErrInvalid)
+	}
+}
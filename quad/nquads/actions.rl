@@ -76,11 +76,7 @@
 
 	action Error {
 		if p < len(data) {
-			if r := data[p]; r < unicode.MaxASCII {
-				return q, fmt.Errorf("%v: unexpected rune %q at %d", quad.ErrInvalid, data[p], p)
-			} else {
-				return q, fmt.Errorf("%v: unexpected rune %q (\\u%04x) at %d", quad.ErrInvalid, data[p], data[p], p)
-			}
+			return q, runeError{r: data[p], pos: p}
 		}
 		return q, quad.ErrIncomplete
 	}
@@ -28,6 +28,8 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/cayley/quad"
 )
@@ -37,6 +39,8 @@ import (
 type Decoder struct {
 	r    *bufio.Reader
 	line []byte
+	n    int
+	off  int64
 }
 
 // NewDecoder returns an N-Quad decoder that takes its input from the
@@ -50,15 +54,9 @@ func (dec *Decoder) Unmarshal() (quad.Quad, error) {
 	dec.line = dec.line[:0]
 	var line []byte
 	for {
-		for {
-			l, pre, err := dec.r.ReadLine()
-			if err != nil {
-				return quad.Quad{}, err
-			}
-			dec.line = append(dec.line, l...)
-			if !pre {
-				break
-			}
+		err := dec.readLine()
+		if err != nil {
+			return quad.Quad{}, err
 		}
 		if line = bytes.TrimSpace(dec.line); len(line) != 0 && line[0] != '#' {
 			break
@@ -67,7 +65,7 @@ func (dec *Decoder) Unmarshal() (quad.Quad, error) {
 	}
 	q, err := Parse(string(line))
 	if err != nil {
-		return quad.Quad{}, fmt.Errorf("failed to parse %q: %v", dec.line, err)
+		return quad.Quad{}, dec.parseError(line, err)
 	}
 	if !q.IsValid() {
 		return dec.Unmarshal()
@@ -75,6 +73,86 @@ func (dec *Decoder) Unmarshal() (quad.Quad, error) {
 	return q, nil
 }
 
+// readLine appends the next line of input to dec.line, without its line
+// ending.
+func (dec *Decoder) readLine() error {
+	for {
+		l, err := dec.r.ReadSlice('\n')
+		dec.off += int64(len(l))
+		dec.line = append(dec.line, l...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(dec.line) != 0 {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		dec.n++
+		if n := len(dec.line); n != 0 && dec.line[n-1] == '\n' {
+			dec.line = dec.line[:n-1]
+			if n > 1 && dec.line[n-2] == '\r' {
+				dec.line = dec.line[:n-2]
+			}
+		}
+		return nil
+	}
+}
+
+// Offset returns the number of bytes of input read up to the end of the
+// line holding the statement last returned by Unmarshal.
+func (dec *Decoder) Offset() int64 {
+	return dec.off
+}
+
+// parseError returns the error for the statement at the end of dec.line that
+// failed to parse with err.
+func (dec *Decoder) parseError(statement []byte, err error) error {
+	lead := bytes.TrimRightFunc(dec.line, unicode.IsSpace)
+	col := utf8.RuneCount(lead[:len(lead)-len(statement)]) + 1
+	if e, ok := err.(runeError); ok {
+		col += e.pos
+	} else {
+		col += utf8.RuneCount(statement)
+	}
+	return &quad.ParseError{Line: dec.n, Column: col, Statement: string(dec.line), Err: err}
+}
+
+// runeError is the error for an unexpected rune at offset pos of a statement.
+type runeError struct {
+	r   rune
+	pos int
+}
+
+func (e runeError) Error() string {
+	if e.r < unicode.MaxASCII {
+		return fmt.Sprintf("%v: unexpected rune %q at %d", quad.ErrInvalid, e.r, e.pos)
+	}
+	return fmt.Sprintf("%v: unexpected rune %q (\\u%04x) at %d", quad.ErrInvalid, e.r, e.r, e.pos)
+}
+
+// Encoder implements N-Quad document generation according to the RDF
+// 1.1 N-Quads specification.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an N-Quad encoder that writes its output to the
+// provided io.Writer.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Marshal writes q to the Encoder's output as a single N-Quad line.
+func (enc *Encoder) Marshal(q quad.Quad) error {
+	if !q.IsValid() {
+		return quad.ErrInvalid
+	}
+	_, err := io.WriteString(enc.w, q.NQuad()+"\n")
+	return err
+}
+
 func unEscape(r []rune, isEscaped bool) string {
 	if !isEscaped {
 		return string(r)
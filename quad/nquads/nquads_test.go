@@ -16,6 +16,7 @@ package nquads
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
@@ -497,6 +498,51 @@ func TestDecoder(t *testing.T) {
 	}
 }
 
+func TestDecoderErrors(t *testing.T) {
+	const input = "<a> <b> <c> .\n\n  <a> <b> .\n<d> <e> <f> .\n\t<a> <b> <c> <d> <e> .\n"
+	dec := NewDecoder(strings.NewReader(input))
+	var (
+		subjects []string
+		errs     [][2]int
+	)
+	for {
+		q, err := dec.Unmarshal()
+		if err == io.EOF {
+			break
+		} else if perr, ok := err.(*quad.ParseError); ok {
+			errs = append(errs, [2]int{perr.Line, perr.Column})
+			continue
+		} else if err != nil {
+			t.Fatalf("Unexpected error reading document: %v", err)
+		}
+		subjects = append(subjects, q.Subject)
+	}
+	if expect := []string{"<a>", "<d>"}; !reflect.DeepEqual(subjects, expect) {
+		t.Errorf("Failed to continue after errors, got:%q expect:%q", subjects, expect)
+	}
+	if expect := [][2]int{{3, 11}, {5, 18}}; !reflect.DeepEqual(errs, expect) {
+		t.Errorf("Unexpected error positions, got:%v expect:%v", errs, expect)
+	}
+}
+
+func TestDecoderOffset(t *testing.T) {
+	const input = "# c\n<a> <b> <c> .\r\n\n<d> <e> <f> ."
+	dec := NewDecoder(strings.NewReader(input))
+	var offsets []int64
+	for {
+		_, err := dec.Unmarshal()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error reading document: %v", err)
+		}
+		offsets = append(offsets, dec.Offset())
+	}
+	if expect := []int64{19, int64(len(input))}; !reflect.DeepEqual(offsets, expect) {
+		t.Errorf("Unexpected offsets, got:%v expect:%v", offsets, expect)
+	}
+}
+
 func TestRDFWorkingGroupSuit(t *testing.T) {
 	// These tests erroneously pass because the parser does not
 	// perform semantic testing on the URI in the IRIRef as required
@@ -593,6 +639,93 @@ func TestUnescape(t *testing.T) {
 	}
 }
 
+var encodeTests = []struct {
+	message string
+	input   quad.Quad
+	expect  string
+}{
+	{
+		message: "encode quad with IRIs and literal",
+		input: quad.Quad{
+			Subject:   "<http://example/s>",
+			Predicate: "<http://example/p>",
+			Object:    `"a "quoted" value"@en`,
+			Label:     "<http://example/g>",
+		},
+		expect: `<http://example/s> <http://example/p> "a \"quoted\" value"@en <http://example/g> .` + "\n",
+	},
+	{
+		message: "encode quad with blank nodes and typed literal",
+		input: quad.Quad{
+			Subject:   "_:b0",
+			Predicate: "<http://example/p>",
+			Object:    `"1"^^<http://www.w3.org/2001/XMLSchema#integer>`,
+		},
+		expect: `_:b0 <http://example/p> "1"^^<http://www.w3.org/2001/XMLSchema#integer> .` + "\n",
+	},
+	{
+		message: "encode quad with bare values",
+		input: quad.Quad{
+			Subject:   "alice",
+			Predicate: "follows",
+			Object:    "bob\nsmith\\",
+		},
+		expect: `"alice" "follows" "bob\nsmith\\" .` + "\n",
+	},
+	{
+		message: "encode quad with IRI needing escapes",
+		input: quad.Quad{
+			Subject:   "<http://example/a b>",
+			Predicate: "<http://example/p>",
+			Object:    "_:not a blank",
+		},
+		expect: `<http://example/a\u0020b> <http://example/p> "_:not a blank" .` + "\n",
+	},
+}
+
+func TestEncoder(t *testing.T) {
+	for _, test := range encodeTests {
+		var buf bytes.Buffer
+		err := NewEncoder(&buf).Marshal(test.input)
+		if err != nil {
+			t.Errorf("Unexpected error when %s: %v", test.message, err)
+			continue
+		}
+		if buf.String() != test.expect {
+			t.Errorf("Failed to %s, got:%q expect:%q", test.message, buf.String(), test.expect)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	var want []quad.Quad
+	for _, test := range testNQuads {
+		if test.err != nil || !test.expect.IsValid() {
+			continue
+		}
+		want = append(want, test.expect)
+		err := enc.Marshal(test.expect)
+		if err != nil {
+			t.Errorf("Unexpected error encoding %v: %v", test.expect, err)
+		}
+	}
+	dec := NewDecoder(&buf)
+	for _, q := range want {
+		got, err := dec.Unmarshal()
+		if err != nil {
+			t.Errorf("Unexpected error decoding encoded %v: %v", q, err)
+			continue
+		}
+		if got != q {
+			t.Errorf("Failed to round trip quad, got:%#v expect:%#v", got, q)
+		}
+	}
+
+	if err := enc.Marshal(quad.Quad{Subject: "a"}); err != quad.ErrInvalid {
+		t.Errorf("Unexpected error for invalid quad, got:%v expect:%v", err, quad.ErrInvalid)
+	}
+}
+
 var result quad.Quad
 
 func BenchmarkParser(b *testing.B) {
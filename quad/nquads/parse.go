@@ -19,9 +19,6 @@
 package nquads
 
 import (
-	"fmt"
-	"unicode"
-
 	"github.com/google/cayley/quad"
 )
 
@@ -282,11 +279,7 @@ tr0:
 
 
 		if p < len(data) {
-			if r := data[p]; r < unicode.MaxASCII {
-				return q, fmt.Errorf("%v: unexpected rune %q at %d", quad.ErrInvalid, data[p], p)
-			} else {
-				return q, fmt.Errorf("%v: unexpected rune %q (\\u%04x) at %d", quad.ErrInvalid, data[p], data[p], p)
-			}
+			return q, runeError{r: data[p], pos: p}
 		}
 		return q, quad.ErrIncomplete
 	
@@ -3642,11 +3635,7 @@ tr3:
 
 
 		if p < len(data) {
-			if r := data[p]; r < unicode.MaxASCII {
-				return q, fmt.Errorf("%v: unexpected rune %q at %d", quad.ErrInvalid, data[p], p)
-			} else {
-				return q, fmt.Errorf("%v: unexpected rune %q (\\u%04x) at %d", quad.ErrInvalid, data[p], data[p], p)
-			}
+			return q, runeError{r: data[p], pos: p}
 		}
 		return q, quad.ErrIncomplete
 	
@@ -17,9 +17,6 @@
 package nquads
 
 import (
-	"fmt"
-	"unicode"
-
 	"github.com/google/cayley/quad"
 )
 
@@ -37,8 +37,10 @@ package quad
 // the consequences are not to be taken lightly. But do suggest cool features!
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 var (
@@ -46,6 +48,20 @@ var (
 	ErrIncomplete = errors.New("incomplete N-Quad")
 )
 
+// ParseError describes a statement of a line-based quad document that could
+// not be parsed. Decoders returning a ParseError have consumed the statement,
+// so reading may continue with the next.
+type ParseError struct {
+	Line      int // Line of the statement, counting from 1.
+	Column    int // Column at which parsing failed, counting from 1.
+	Statement string
+	Err       error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: failed to parse %q: %v", e.Line, e.Column, e.Statement, e.Err)
+}
+
 // Our quad struct, used throughout.
 type Quad struct {
 	Subject   string `json:"subject"`
@@ -126,14 +142,113 @@ func (q Quad) IsValid() bool {
 }
 
 // Prints a quad in N-Quad format.
+//
+// Values that are already written as N-Quad terms (IRIs, blank nodes and
+// literals) are escaped as required; all other values are written as plain
+// literals.
 func (q Quad) NQuad() string {
 	if q.Label == "" {
-		//TODO(barakmich): Proper escaping.
-		return fmt.Sprintf("%s %s %s .", q.Subject, q.Predicate, q.Object)
+		return fmt.Sprintf("%s %s %s .", nquadTerm(q.Subject), nquadTerm(q.Predicate), nquadTerm(q.Object))
+	}
+	return fmt.Sprintf("%s %s %s %s .", nquadTerm(q.Subject), nquadTerm(q.Predicate), nquadTerm(q.Object), nquadTerm(q.Label))
+}
+
+// nquadTerm returns the N-Quad serialisation of the value s.
+func nquadTerm(s string) string {
+	switch {
+	case len(s) >= 2 && s[0] == '<' && s[len(s)-1] == '>':
+		return "<" + escapeIRI(s[1:len(s)-1]) + ">"
+	case isBlankNode(s):
+		return s
+	case len(s) >= 2 && s[0] == '"':
+		if end := strings.LastIndex(s, `"`); end > 0 && isLiteralSuffix(s[end+1:]) {
+			return `"` + escapeLiteral(s[1:end]) + `"` + s[end+1:]
+		}
+	}
+	return `"` + escapeLiteral(s) + `"`
+}
+
+func isBlankNode(s string) bool {
+	if !strings.HasPrefix(s, "_:") || len(s) == 2 || s[len(s)-1] == '.' {
+		return false
+	}
+	for _, r := range s[2:] {
+		if r <= ' ' || strings.ContainsRune(`<>"{}|^\`+"`", r) {
+			return false
+		}
 	}
-	return fmt.Sprintf("%s %s %s %s .", q.Subject, q.Predicate, q.Object, q.Label)
+	return true
+}
+
+// isLiteralSuffix returns whether s is a valid language tag or datatype
+// suffix for a literal.
+func isLiteralSuffix(s string) bool {
+	switch {
+	case s == "":
+		return true
+	case strings.HasPrefix(s, "@"):
+		if len(s) == 1 {
+			return false
+		}
+		for _, r := range s[1:] {
+			if !(r == '-' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+				return false
+			}
+		}
+		return true
+	case strings.HasPrefix(s, "^^<") && strings.HasSuffix(s, ">"):
+		return !strings.ContainsAny(s[3:len(s)-1], "<>\" \n\r\t")
+	}
+	return false
+}
+
+func escapeIRI(s string) string {
+	var buf bytes.Buffer
+	for _, r := range s {
+		if r <= ' ' || strings.ContainsRune(`<>"{}|^\`+"`", r) {
+			fmt.Fprintf(&buf, `\u%04X`, r)
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+func escapeLiteral(s string) string {
+	var buf bytes.Buffer
+	for _, r := range s {
+		switch r {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		default:
+			if r < ' ' {
+				fmt.Fprintf(&buf, `\u%04X`, r)
+				continue
+			}
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
 }
 
 type Unmarshaler interface {
 	Unmarshal() (Quad, error)
 }
+
+// Marshaler is the interface implemented by quad encoders. Marshal writes
+// a single quad to the underlying output.
+type Marshaler interface {
+	Marshal(Quad) error
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quad
+
+import "strings"
+
+// Skolemizer rewrites blank nodes, written as _:label, as IRIs unique to a
+// scope such as a single document, so that blank nodes from different
+// documents that share a label are not merged. The IRI of a blank node is
+// Prefix followed by its label, so a Skolemizer with the same Prefix always
+// rewrites a label to the same IRI.
+type Skolemizer struct {
+	// Prefix is the start of each IRI, which should identify the scope.
+	Prefix string
+
+	// Bare is true if IRIs are written without angle brackets, as the
+	// cquads decoder does.
+	Bare bool
+}
+
+// Value returns v, or its IRI if it is a blank node.
+func (s Skolemizer) Value(v string) string {
+	if !strings.HasPrefix(v, "_:") {
+		return v
+	}
+	if s.Bare {
+		return s.Prefix + v[2:]
+	}
+	return "<" + s.Prefix + v[2:] + ">"
+}
+
+// Quad returns q with its blank nodes rewritten as IRIs.
+func (s Skolemizer) Quad(q Quad) Quad {
+	return Quad{
+		Subject:   s.Value(q.Subject),
+		Predicate: s.Value(q.Predicate),
+		Object:    s.Value(q.Object),
+		Label:     s.Value(q.Label),
+	}
+}
+
+// Unmarshaler returns an Unmarshaler that reads the quads of dec with their
+// blank nodes rewritten as IRIs.
+func (s Skolemizer) Unmarshaler(dec Unmarshaler) Unmarshaler {
+	return skolemUnmarshaler{s: s, dec: dec}
+}
+
+type skolemUnmarshaler struct {
+	s   Skolemizer
+	dec Unmarshaler
+}
+
+func (u skolemUnmarshaler) Unmarshal() (Quad, error) {
+	q, err := u.dec.Unmarshal()
+	if err != nil {
+		return q, err
+	}
+	return u.s.Quad(q), nil
+}
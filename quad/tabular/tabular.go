@@ -0,0 +1,342 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tabular implements reading quads from delimited text, such as CSV
+// and TSV files, as described by a Mapping.
+//
+// Each row of the input is written as a set of quads sharing a subject. The
+// values of quads are given by templates, in which {name} is replaced by the
+// value of the named column of the row. Columns are named by the header row,
+// or numbered from 1 if the input has no header. A quad is not written for a
+// row if any column its templates refer to is empty, and no quads are written
+// for a row with an empty subject.
+package tabular
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/cayley/quad"
+)
+
+const xsdNS = "http://www.w3.org/2001/XMLSchema#"
+
+// Mapping describes how the rows of a table are written as quads.
+type Mapping struct {
+	// Delimiter is the field delimiter, which overrides the default of the
+	// format being read.
+	Delimiter string `json:"delimiter"`
+
+	// NoHeader is true if the first row of the input holds data rather than
+	// column names.
+	NoHeader bool `json:"no_header"`
+
+	// Subject and Label are templates for the subject and label of the quads
+	// written for each row. Label may be empty.
+	Subject string `json:"subject"`
+	Label   string `json:"label"`
+
+	Columns []Column `json:"columns"`
+}
+
+// Column describes a quad written for each row.
+type Column struct {
+	// Column names the column holding the object of the quad. It is a
+	// shorthand for an Object template of "{Column}".
+	Column string `json:"column"`
+
+	// Predicate and Object are templates for the predicate and object of
+	// the quad.
+	Predicate string `json:"predicate"`
+	Object    string `json:"object"`
+
+	// Type is the type the object is converted to. It is one of "string",
+	// "iri", "integer", "decimal", "double", "boolean", "date" or "dateTime",
+	// or a datatype IRI written as <iri>. If Type is empty the object is
+	// written as it is, as a plain literal if Language is given.
+	Type string `json:"type"`
+
+	// Language is the language tag of string objects.
+	Language string `json:"language"`
+}
+
+// ParseMapping reads a Mapping in JSON from r.
+func ParseMapping(r io.Reader) (*Mapping, error) {
+	var m Mapping
+	err := json.NewDecoder(r).Decode(&m)
+	if err != nil {
+		return nil, fmt.Errorf("tabular: invalid mapping: %v", err)
+	}
+	if m.Subject == "" {
+		return nil, fmt.Errorf("tabular: mapping has no subject")
+	}
+	if m.Delimiter != "" && utf8.RuneCountInString(m.Delimiter) != 1 {
+		return nil, fmt.Errorf("tabular: delimiter %q is not a single character", m.Delimiter)
+	}
+	for i, c := range m.Columns {
+		if c.Predicate == "" || c.Column == "" && c.Object == "" {
+			return nil, fmt.Errorf("tabular: mapping column %d needs a predicate and a column or object", i+1)
+		}
+		switch c.Type {
+		case "", "string", "iri", "integer", "decimal", "double", "boolean", "date", "dateTime":
+		default:
+			if !isIRI(c.Type) {
+				return nil, fmt.Errorf("tabular: mapping column %d has unknown type %q", i+1, c.Type)
+			}
+		}
+	}
+	return &m, nil
+}
+
+// Decoder implements reading quads from delimited text.
+type Decoder struct {
+	r *csv.Reader
+	m *Mapping
+
+	// columns holds the compiled templates; it is nil until the first row
+	// has been read.
+	subject, label *template
+	columns        []column
+
+	row   int
+	quads []quad.Quad
+}
+
+type column struct {
+	predicate, object *template
+	typ, lang         string
+}
+
+// NewDecoder returns a decoder that reads rows of fields separated by comma
+// from r, writing them as quads as described by m.
+func NewDecoder(r io.Reader, comma rune, m *Mapping) *Decoder {
+	if m.Delimiter != "" {
+		comma, _ = utf8.DecodeRuneInString(m.Delimiter)
+	}
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	// Tab separated fields are not usually quoted, so may hold stray quotes.
+	cr.LazyQuotes = comma == '\t'
+	return &Decoder{r: cr, m: m}
+}
+
+// Unmarshal returns the next quad of the input as a quad.Quad, or an error.
+func (dec *Decoder) Unmarshal() (quad.Quad, error) {
+	for len(dec.quads) == 0 {
+		record, err := dec.r.Read()
+		if err == io.EOF {
+			return quad.Quad{}, err
+		} else if err != nil {
+			return quad.Quad{}, fmt.Errorf("tabular: %v", err)
+		}
+		dec.row++
+		if dec.columns == nil {
+			err = dec.compile(record)
+			if err != nil {
+				return quad.Quad{}, fmt.Errorf("tabular: %v", err)
+			}
+			if !dec.m.NoHeader {
+				continue
+			}
+		}
+		err = dec.write(record)
+		if err != nil {
+			return quad.Quad{}, fmt.Errorf("tabular: row %d: %v", dec.row, err)
+		}
+	}
+	q := dec.quads[0]
+	dec.quads = dec.quads[1:]
+	return q, nil
+}
+
+// compile compiles the templates of the mapping given the first row of the
+// input.
+func (dec *Decoder) compile(first []string) error {
+	index := make(map[string]int)
+	for i := range first {
+		index[strconv.Itoa(i+1)] = i
+	}
+	if !dec.m.NoHeader {
+		for i, name := range first {
+			index[strings.TrimSpace(name)] = i
+		}
+	}
+	var err error
+	dec.subject, err = compile(dec.m.Subject, index)
+	if err != nil {
+		return err
+	}
+	dec.label, err = compile(dec.m.Label, index)
+	if err != nil {
+		return err
+	}
+	dec.columns = make([]column, 0, len(dec.m.Columns))
+	for _, c := range dec.m.Columns {
+		obj := c.Object
+		if obj == "" {
+			obj = "{" + c.Column + "}"
+		}
+		p, err := compile(c.Predicate, index)
+		if err != nil {
+			return err
+		}
+		o, err := compile(obj, index)
+		if err != nil {
+			return err
+		}
+		dec.columns = append(dec.columns, column{predicate: p, object: o, typ: c.Type, lang: c.Language})
+	}
+	return nil
+}
+
+func (dec *Decoder) write(record []string) error {
+	s, ok := dec.subject.expand(record)
+	if !ok {
+		return nil
+	}
+	label, _ := dec.label.expand(record)
+	for _, c := range dec.columns {
+		p, ok := c.predicate.expand(record)
+		if !ok {
+			continue
+		}
+		o, ok := c.object.expand(record)
+		if !ok {
+			continue
+		}
+		o, err := convert(o, c.typ, c.lang)
+		if err != nil {
+			return err
+		}
+		dec.quads = append(dec.quads, quad.Quad{Subject: s, Predicate: p, Object: o, Label: label})
+	}
+	return nil
+}
+
+// convert returns the value v converted to the type typ.
+func convert(v, typ, lang string) (string, error) {
+	var err error
+	switch typ {
+	case "":
+		if lang == "" {
+			return v, nil
+		}
+		return `"` + v + `"@` + lang, nil
+	case "string":
+		if lang == "" {
+			return `"` + v + `"`, nil
+		}
+		return `"` + v + `"@` + lang, nil
+	case "iri":
+		if isIRI(v) {
+			return v, nil
+		}
+		return "<" + escape(v) + ">", nil
+	case "integer":
+		_, err = strconv.ParseInt(v, 10, 64)
+	case "decimal", "double":
+		_, err = strconv.ParseFloat(v, 64)
+	case "boolean":
+		var b bool
+		b, err = strconv.ParseBool(v)
+		v = strconv.FormatBool(b)
+	case "date":
+		_, err = time.Parse("2006-01-02", v)
+	case "dateTime":
+		_, err = time.Parse(time.RFC3339, v)
+	default:
+		return `"` + v + `"^^` + typ, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid %s %q", typ, v)
+	}
+	return `"` + v + `"^^<` + xsdNS + typ + ">", nil
+}
+
+// template is a compiled value template. Its parts alternate between literal
+// text and the indexes of the columns whose values are inserted.
+type template struct {
+	text []string
+	cols []int
+	iri  bool
+}
+
+func compile(s string, index map[string]int) (*template, error) {
+	t := &template{iri: isIRI(s)}
+	for {
+		i := strings.Index(s, "{")
+		if i < 0 {
+			t.text = append(t.text, s)
+			return t, nil
+		}
+		j := strings.Index(s[i:], "}")
+		if j < 0 {
+			return nil, fmt.Errorf("unclosed column reference in %q", s)
+		}
+		name := s[i+1 : i+j]
+		col, ok := index[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		t.text = append(t.text, s[:i])
+		t.cols = append(t.cols, col)
+		s = s[i+j+1:]
+	}
+}
+
+// expand returns the value of t for record, and whether every column it
+// refers to has a value.
+func (t *template) expand(record []string) (string, bool) {
+	if len(t.cols) == 0 {
+		return t.text[0], t.text[0] != ""
+	}
+	var buf []byte
+	for i, col := range t.cols {
+		v := strings.TrimSpace(record[col])
+		if v == "" {
+			return "", false
+		}
+		if t.iri {
+			v = escape(v)
+		}
+		buf = append(buf, t.text[i]...)
+		buf = append(buf, v...)
+	}
+	buf = append(buf, t.text[len(t.text)-1]...)
+	return string(buf), true
+}
+
+func isIRI(s string) bool {
+	return len(s) >= 2 && s[0] == '<' && s[len(s)-1] == '>'
+}
+
+// escape percent encodes the characters of s that may not appear in an IRI.
+func escape(s string) string {
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || strings.IndexByte(`<>"{}|^\`+"`", c) >= 0 {
+			buf = append(buf, fmt.Sprintf("%%%02X", c)...)
+			continue
+		}
+		buf = append(buf, c)
+	}
+	return string(buf)
+}
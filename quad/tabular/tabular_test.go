@@ -0,0 +1,155 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tabular
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/cayley/quad"
+)
+
+const peopleMapping = `{
+	"subject": "<http://example.com/person/{id}>",
+	"label": "{source}",
+	"columns": [
+		{"column": "name", "predicate": "<http://schema.org/name>", "type": "string", "language": "en"},
+		{"column": "age", "predicate": "<http://schema.org/age>", "type": "integer"},
+		{"column": "member", "predicate": "member", "type": "boolean"},
+		{"predicate": "<http://schema.org/knows>", "object": "<http://example.com/person/{friend}>"},
+		{"column": "status", "predicate": "status"}
+	]
+}`
+
+var decodeTests = []struct {
+	message string
+	mapping string
+	comma   rune
+	input   string
+	expect  []quad.Quad
+	err     bool
+}{
+	{
+		message: "map CSV rows",
+		mapping: peopleMapping,
+		comma:   ',',
+		input: `id,name,age,member,friend,status,source
+alice,Alice,42,1,bob smith,cool,people
+bob smith,"Smith, Bob",,false,,,
+,Nobody,1,true,alice,,
+`,
+		expect: []quad.Quad{
+			{"<http://example.com/person/alice>", "<http://schema.org/name>", `"Alice"@en`, "people"},
+			{"<http://example.com/person/alice>", "<http://schema.org/age>", `"42"^^<` + xsdNS + `integer>`, "people"},
+			{"<http://example.com/person/alice>", "member", `"true"^^<` + xsdNS + `boolean>`, "people"},
+			{"<http://example.com/person/alice>", "<http://schema.org/knows>", "<http://example.com/person/bob%20smith>", "people"},
+			{"<http://example.com/person/alice>", "status", "cool", "people"},
+			{"<http://example.com/person/bob%20smith>", "<http://schema.org/name>", `"Smith, Bob"@en`, ""},
+			{"<http://example.com/person/bob%20smith>", "member", `"false"^^<` + xsdNS + `boolean>`, ""},
+		},
+	},
+	{
+		message: "map TSV rows without a header",
+		mapping: `{
+			"no_header": true,
+			"subject": "{1}",
+			"columns": [
+				{"column": "2", "predicate": "follows"},
+				{"column": "3", "predicate": "<http://schema.org/born>", "type": "date"}
+			]
+		}`,
+		comma: '\t',
+		input: "alice\tbob\t1970-01-01\nbob\t6\" fred\t1980-02-03\n",
+		expect: []quad.Quad{
+			{"alice", "follows", "bob", ""},
+			{"alice", "<http://schema.org/born>", `"1970-01-01"^^<` + xsdNS + `date>`, ""},
+			{"bob", "follows", `6" fred`, ""},
+			{"bob", "<http://schema.org/born>", `"1980-02-03"^^<` + xsdNS + `date>`, ""},
+		},
+	},
+	{
+		message: "reject invalid typed values",
+		mapping: peopleMapping,
+		comma:   ',',
+		input:   "id,name,age,member,friend,status,source\nalice,Alice,old,true,,,\n",
+		err:     true,
+	},
+	{
+		message: "reject unknown columns",
+		mapping: `{"subject": "{id}", "columns": [{"column": "missing", "predicate": "p"}]}`,
+		comma:   ',',
+		input:   "id,name\nalice,Alice\n",
+		err:     true,
+	},
+	{
+		message: "reject rows with the wrong number of fields",
+		mapping: `{"subject": "{id}", "columns": [{"column": "name", "predicate": "p"}]}`,
+		comma:   ',',
+		input:   "id,name\nalice\n",
+		err:     true,
+	},
+}
+
+func TestDecoder(t *testing.T) {
+	for _, test := range decodeTests {
+		m, err := ParseMapping(strings.NewReader(test.mapping))
+		if err != nil {
+			t.Fatalf("Failed to parse mapping to %s: %v", test.message, err)
+		}
+		dec := NewDecoder(strings.NewReader(test.input), test.comma, m)
+		var got []quad.Quad
+		for {
+			var q quad.Quad
+			q, err = dec.Unmarshal()
+			if err != nil {
+				break
+			}
+			got = append(got, q)
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		if (err != nil) != test.err {
+			t.Errorf("Unexpected error to %s, got:%v", test.message, err)
+		}
+		if test.err {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to %s, got:%v expect:%v", test.message, got, test.expect)
+		}
+	}
+}
+
+var mappingTests = []struct {
+	message string
+	mapping string
+}{
+	{"reject a mapping without a subject", `{"columns": []}`},
+	{"reject a column without a predicate", `{"subject": "{id}", "columns": [{"column": "name"}]}`},
+	{"reject an unknown type", `{"subject": "{id}", "columns": [{"column": "name", "predicate": "p", "type": "float"}]}`},
+	{"reject a long delimiter", `{"subject": "{id}", "delimiter": "::"}`},
+}
+
+func TestParseMapping(t *testing.T) {
+	for _, test := range mappingTests {
+		_, err := ParseMapping(strings.NewReader(test.mapping))
+		if err == nil {
+			t.Errorf("Expected error to %s", test.message)
+		}
+	}
+}
@@ -0,0 +1,786 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package turtle implements parsing the RDF 1.1 Turtle and TriG syntaxes.
+//
+// Turtle parsing is performed as defined by http://www.w3.org/TR/turtle/ and
+// TriG parsing as defined by http://www.w3.org/TR/trig/, with the exception
+// that relative IRIs are allowed when no base IRI has been given. Quad values
+// are written using N-Quad term syntax, so IRIs are written as <iri>, blank
+// nodes as _:label and literals as "value", "value"@lang or
+// "value"^^<datatype>. Blank nodes are relabelled so that labels generated
+// for anonymous nodes cannot collide with those in the input. The graph name
+// of a TriG graph is held in the quad's Label.
+package turtle
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/cayley/quad"
+)
+
+const (
+	rdfNS = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xsdNS = "http://www.w3.org/2001/XMLSchema#"
+)
+
+// Decoder implements Turtle and TriG document parsing.
+type Decoder struct {
+	r    *bufio.Reader
+	trig bool
+	line int
+
+	base     string
+	prefixes map[string]string
+	blanks   map[string]string
+	n        int
+
+	// graph is the label of the graph being read; inGraph is true within
+	// the braces of a TriG graph.
+	graph   string
+	inGraph bool
+
+	quads []quad.Quad
+}
+
+// NewDecoder returns a Turtle decoder that takes its input from the provided
+// io.Reader.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:        bufio.NewReader(r),
+		line:     1,
+		prefixes: make(map[string]string),
+		blanks:   make(map[string]string),
+	}
+}
+
+// NewTriGDecoder returns a TriG decoder that takes its input from the
+// provided io.Reader.
+func NewTriGDecoder(r io.Reader) *Decoder {
+	dec := NewDecoder(r)
+	dec.trig = true
+	return dec
+}
+
+// SetBase sets the IRI that relative IRIs in the input are resolved against
+// until the input sets its own base.
+func (dec *Decoder) SetBase(base string) {
+	dec.base = base
+}
+
+// Unmarshal returns the next quad of the input as a quad.Quad, or an error.
+func (dec *Decoder) Unmarshal() (quad.Quad, error) {
+	for len(dec.quads) == 0 {
+		err := dec.statement()
+		if err == io.EOF {
+			return quad.Quad{}, err
+		} else if err != nil {
+			return quad.Quad{}, fmt.Errorf("turtle: line %d: %v", dec.line, err)
+		}
+	}
+	q := dec.quads[0]
+	dec.quads = dec.quads[1:]
+	return q, nil
+}
+
+var errUnexpectedEOF = errors.New("unexpected end of input")
+
+// peek returns the byte i bytes ahead in the input, or zero at the end of
+// the input.
+func (dec *Decoder) peek(i int) byte {
+	b, _ := dec.r.Peek(i + 1)
+	if len(b) <= i {
+		return 0
+	}
+	return b[i]
+}
+
+func (dec *Decoder) next() (rune, error) {
+	r, _, err := dec.r.ReadRune()
+	if err == io.EOF {
+		return 0, errUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	if r == '\n' {
+		dec.line++
+	}
+	return r, nil
+}
+
+func (dec *Decoder) expect(c byte) error {
+	err := dec.space()
+	if err != nil {
+		return err
+	}
+	r, err := dec.next()
+	if err != nil {
+		return err
+	}
+	if r != rune(c) {
+		return fmt.Errorf("expected %q, found %q", c, r)
+	}
+	return nil
+}
+
+// space skips white space and comments.
+func (dec *Decoder) space() error {
+	for {
+		switch dec.peek(0) {
+		case ' ', '\t', '\r', '\n':
+			dec.next()
+		case '#':
+			for {
+				r, err := dec.next()
+				if err != nil || r == '\n' {
+					break
+				}
+			}
+		default:
+			_, err := dec.r.Peek(1)
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// atEnd returns whether all the input has been read.
+func (dec *Decoder) atEnd() bool {
+	_, err := dec.r.Peek(1)
+	return err == io.EOF
+}
+
+// keyword returns whether the input continues with the keyword word, which
+// is matched ignoring case if fold is true, and consumes it if it does.
+func (dec *Decoder) keyword(word string, fold bool) bool {
+	b, _ := dec.r.Peek(len(word) + 1)
+	if len(b) < len(word) {
+		return false
+	}
+	if fold && !strings.EqualFold(string(b[:len(word)]), word) || !fold && string(b[:len(word)]) != word {
+		return false
+	}
+	if len(b) > len(word) && isNameByte(b[len(word)]) {
+		return false
+	}
+	dec.r.Discard(len(word))
+	return true
+}
+
+func isNameByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '_' || c == '-' || c == ':' || c == '%' || c == '\\' || c >= 0x80
+}
+
+func (dec *Decoder) statement() error {
+	err := dec.space()
+	if err != nil {
+		return err
+	}
+	if dec.atEnd() {
+		if dec.inGraph {
+			return errUnexpectedEOF
+		}
+		return io.EOF
+	}
+
+	c := dec.peek(0)
+	switch {
+	case c == '}' && dec.inGraph:
+		dec.next()
+		dec.inGraph = false
+		dec.graph = ""
+		return nil
+	case c == '@' && !dec.inGraph:
+		dec.next()
+		switch {
+		case dec.keyword("prefix", false):
+			err = dec.prefix()
+		case dec.keyword("base", false):
+			err = dec.setBase()
+		default:
+			return fmt.Errorf("unknown directive")
+		}
+		if err != nil {
+			return err
+		}
+		return dec.expect('.')
+	case !dec.inGraph && dec.keyword("PREFIX", true):
+		return dec.prefix()
+	case !dec.inGraph && dec.keyword("BASE", true):
+		return dec.setBase()
+	case dec.trig && !dec.inGraph && dec.keyword("GRAPH", true):
+		err = dec.space()
+		if err != nil {
+			return err
+		}
+		label, err := dec.graphLabel()
+		if err != nil {
+			return err
+		}
+		return dec.openGraph(label)
+	case dec.trig && !dec.inGraph && c == '{':
+		return dec.openGraph("")
+	}
+
+	var s string
+	if c == '[' {
+		dec.next()
+		dec.space()
+		if dec.peek(0) == ']' {
+			dec.next()
+			s = dec.blank("")
+		} else {
+			s = dec.blank("")
+			err = dec.predicateObjectList(s)
+			if err != nil {
+				return err
+			}
+			err = dec.expect(']')
+			if err != nil {
+				return err
+			}
+			dec.space()
+			if c := dec.peek(0); c == '.' || c == '}' && dec.inGraph {
+				return dec.endTriples()
+			}
+		}
+	} else {
+		s, err = dec.subject()
+		if err != nil {
+			return err
+		}
+	}
+	if dec.trig && !dec.inGraph {
+		dec.space()
+		if dec.peek(0) == '{' {
+			return dec.openGraph(s)
+		}
+	}
+	err = dec.predicateObjectList(s)
+	if err != nil {
+		return err
+	}
+	return dec.endTriples()
+}
+
+// endTriples reads the end of a triples statement. The last statement in a
+// TriG graph need not end with a period.
+func (dec *Decoder) endTriples() error {
+	dec.space()
+	if dec.inGraph && dec.peek(0) == '}' {
+		return nil
+	}
+	return dec.expect('.')
+}
+
+func (dec *Decoder) openGraph(label string) error {
+	err := dec.expect('{')
+	if err != nil {
+		return err
+	}
+	dec.graph = label
+	dec.inGraph = true
+	return nil
+}
+
+func (dec *Decoder) graphLabel() (string, error) {
+	if dec.peek(0) == '[' {
+		dec.next()
+		err := dec.expect(']')
+		if err != nil {
+			return "", err
+		}
+		return dec.blank(""), nil
+	}
+	switch dec.peek(0) {
+	case '<':
+		return dec.iriRef()
+	case '_':
+		return dec.blankLabel()
+	}
+	return dec.prefixedName()
+}
+
+func (dec *Decoder) prefix() error {
+	err := dec.space()
+	if err != nil {
+		return err
+	}
+	name, err := dec.name(false)
+	if err != nil {
+		return err
+	}
+	err = dec.expect(':')
+	if err != nil {
+		return err
+	}
+	err = dec.space()
+	if err != nil {
+		return err
+	}
+	iri, err := dec.iriRef()
+	if err != nil {
+		return err
+	}
+	dec.prefixes[name] = iri[1 : len(iri)-1]
+	return nil
+}
+
+func (dec *Decoder) setBase() error {
+	err := dec.space()
+	if err != nil {
+		return err
+	}
+	iri, err := dec.iriRef()
+	if err != nil {
+		return err
+	}
+	dec.base = iri[1 : len(iri)-1]
+	return nil
+}
+
+func (dec *Decoder) subject() (string, error) {
+	switch dec.peek(0) {
+	case '<':
+		return dec.iriRef()
+	case '_':
+		return dec.blankLabel()
+	case '(':
+		return dec.collection()
+	}
+	return dec.prefixedName()
+}
+
+func (dec *Decoder) predicateObjectList(s string) error {
+	for {
+		err := dec.space()
+		if err != nil {
+			return err
+		}
+		var p string
+		if dec.keyword("a", false) {
+			p = "<" + rdfNS + "type>"
+		} else if dec.peek(0) == '<' {
+			p, err = dec.iriRef()
+		} else {
+			p, err = dec.prefixedName()
+		}
+		if err != nil {
+			return err
+		}
+		for {
+			err = dec.space()
+			if err != nil {
+				return err
+			}
+			o, err := dec.object()
+			if err != nil {
+				return err
+			}
+			dec.emit(s, p, o)
+			dec.space()
+			if dec.peek(0) != ',' {
+				break
+			}
+			dec.next()
+		}
+		if dec.peek(0) != ';' {
+			return nil
+		}
+		for dec.peek(0) == ';' {
+			dec.next()
+			dec.space()
+		}
+		switch dec.peek(0) {
+		case '.', ']', '}', 0:
+			return nil
+		}
+	}
+}
+
+func (dec *Decoder) object() (string, error) {
+	switch c := dec.peek(0); {
+	case c == '<':
+		return dec.iriRef()
+	case c == '_':
+		return dec.blankLabel()
+	case c == '(':
+		return dec.collection()
+	case c == '[':
+		dec.next()
+		dec.space()
+		b := dec.blank("")
+		if dec.peek(0) != ']' {
+			err := dec.predicateObjectList(b)
+			if err != nil {
+				return "", err
+			}
+		}
+		return b, dec.expect(']')
+	case c == '"' || c == '\'':
+		return dec.literal()
+	case c == '+' || c == '-' || c == '.' || '0' <= c && c <= '9':
+		return dec.number()
+	case dec.keyword("true", false):
+		return `"true"^^<` + xsdNS + `boolean>`, nil
+	case dec.keyword("false", false):
+		return `"false"^^<` + xsdNS + `boolean>`, nil
+	}
+	return dec.prefixedName()
+}
+
+// collection reads an RDF collection, returning its head.
+func (dec *Decoder) collection() (string, error) {
+	dec.next()
+	var items []string
+	for {
+		err := dec.space()
+		if err != nil {
+			return "", err
+		}
+		if dec.atEnd() {
+			return "", errUnexpectedEOF
+		}
+		if dec.peek(0) == ')' {
+			dec.next()
+			break
+		}
+		o, err := dec.object()
+		if err != nil {
+			return "", err
+		}
+		items = append(items, o)
+	}
+	head := "<" + rdfNS + "nil>"
+	if len(items) == 0 {
+		return head, nil
+	}
+	nodes := make([]string, len(items))
+	for i := range items {
+		nodes[i] = dec.blank("")
+	}
+	for i, o := range items {
+		dec.emit(nodes[i], "<"+rdfNS+"first>", o)
+		rest := head
+		if i+1 < len(items) {
+			rest = nodes[i+1]
+		}
+		dec.emit(nodes[i], "<"+rdfNS+"rest>", rest)
+	}
+	return nodes[0], nil
+}
+
+func (dec *Decoder) emit(s, p, o string) {
+	dec.quads = append(dec.quads, quad.Quad{Subject: s, Predicate: p, Object: o, Label: dec.graph})
+}
+
+// blank returns the blank node with the given label, or a new blank node if
+// label is empty.
+func (dec *Decoder) blank(label string) string {
+	if b, ok := dec.blanks[label]; ok && label != "" {
+		return b
+	}
+	b := fmt.Sprintf("_:b%d", dec.n)
+	dec.n++
+	if label != "" {
+		dec.blanks[label] = b
+	}
+	return b
+}
+
+func (dec *Decoder) blankLabel() (string, error) {
+	dec.next()
+	r, err := dec.next()
+	if err != nil {
+		return "", err
+	}
+	if r != ':' {
+		return "", fmt.Errorf("invalid blank node")
+	}
+	label, err := dec.name(true)
+	if err != nil {
+		return "", err
+	}
+	if label == "" {
+		return "", fmt.Errorf("empty blank node label")
+	}
+	return dec.blank(label), nil
+}
+
+// name reads a prefix, local name or blank node label. Periods are allowed
+// within, but not at the end of, a name; colons only within a local name.
+func (dec *Decoder) name(local bool) (string, error) {
+	var buf bytes.Buffer
+	for {
+		c := dec.peek(0)
+		switch {
+		case c == '.':
+			if !isNameByte(dec.peek(1)) || !local && dec.peek(1) == ':' {
+				return buf.String(), nil
+			}
+		case c == ':':
+			if !local {
+				return buf.String(), nil
+			}
+		case c == '\\' && local:
+			dec.next()
+			r, err := dec.next()
+			if err != nil {
+				return "", err
+			}
+			if !strings.ContainsRune("_~.-!$&'()*+,;=/?#@%", r) {
+				return "", fmt.Errorf("invalid escape %q in local name", r)
+			}
+			buf.WriteRune(r)
+			continue
+		case c == '\\' || !isNameByte(c):
+			return buf.String(), nil
+		}
+		r, err := dec.next()
+		if err != nil {
+			return "", err
+		}
+		buf.WriteRune(r)
+	}
+}
+
+func (dec *Decoder) prefixedName() (string, error) {
+	if dec.atEnd() {
+		return "", errUnexpectedEOF
+	}
+	prefix, err := dec.name(false)
+	if err != nil {
+		return "", err
+	}
+	if dec.peek(0) != ':' {
+		r, _, _ := dec.r.ReadRune()
+		return "", fmt.Errorf("unexpected %q", prefix+string(r))
+	}
+	dec.next()
+	local, err := dec.name(true)
+	if err != nil {
+		return "", err
+	}
+	ns, ok := dec.prefixes[prefix]
+	if !ok {
+		return "", fmt.Errorf("undefined prefix %q", prefix)
+	}
+	return "<" + ns + local + ">", nil
+}
+
+// iriRef reads an IRI reference, resolving it against the base IRI.
+func (dec *Decoder) iriRef() (string, error) {
+	dec.next()
+	var buf bytes.Buffer
+	for {
+		r, err := dec.next()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case r == '>':
+			return "<" + resolve(dec.base, buf.String()) + ">", nil
+		case r == '\\':
+			r, err = dec.unicodeEscape()
+			if err != nil {
+				return "", err
+			}
+		case r <= ' ' || strings.ContainsRune(`<"{}|^`+"`", r):
+			return "", fmt.Errorf("invalid character %q in IRI", r)
+		}
+		buf.WriteRune(r)
+	}
+}
+
+func (dec *Decoder) unicodeEscape() (rune, error) {
+	r, err := dec.next()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	switch r {
+	case 'u':
+		n = 4
+	case 'U':
+		n = 8
+	default:
+		return 0, fmt.Errorf("invalid escape %q", r)
+	}
+	hex := make([]byte, n)
+	_, err = io.ReadFull(dec.r, hex)
+	if err != nil {
+		return 0, errUnexpectedEOF
+	}
+	v, err := strconv.ParseUint(string(hex), 16, 32)
+	if err != nil || !utf8.ValidRune(rune(v)) {
+		return 0, fmt.Errorf("invalid escape %q", hex)
+	}
+	return rune(v), nil
+}
+
+func (dec *Decoder) literal() (string, error) {
+	q, _ := dec.next()
+	long := dec.peek(0) == byte(q) && dec.peek(1) == byte(q)
+	if long {
+		dec.r.Discard(2)
+	}
+	var buf bytes.Buffer
+	for {
+		r, err := dec.next()
+		if err != nil {
+			return "", err
+		}
+		if r == q {
+			if !long {
+				break
+			}
+			if dec.peek(0) == byte(q) && dec.peek(1) == byte(q) && dec.peek(2) != byte(q) {
+				dec.r.Discard(2)
+				break
+			}
+		}
+		switch r {
+		case '\\':
+			r, err = dec.next()
+			if err != nil {
+				return "", err
+			}
+			switch r {
+			case 't':
+				r = '\t'
+			case 'b':
+				r = '\b'
+			case 'n':
+				r = '\n'
+			case 'r':
+				r = '\r'
+			case 'f':
+				r = '\f'
+			case '"', '\'', '\\':
+			case 'u', 'U':
+				dec.r.UnreadRune()
+				r, err = dec.unicodeEscape()
+				if err != nil {
+					return "", err
+				}
+			default:
+				return "", fmt.Errorf("invalid escape %q in string", r)
+			}
+		case '\n', '\r':
+			if !long {
+				return "", fmt.Errorf("unterminated string")
+			}
+		}
+		buf.WriteRune(r)
+	}
+	value := `"` + buf.String() + `"`
+
+	switch {
+	case dec.peek(0) == '@':
+		dec.next()
+		var lang bytes.Buffer
+		for c := dec.peek(0); 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '-' || lang.Len() > 0 && '0' <= c && c <= '9'; c = dec.peek(0) {
+			dec.next()
+			lang.WriteByte(c)
+		}
+		if lang.Len() == 0 {
+			return "", fmt.Errorf("empty language tag")
+		}
+		return value + "@" + lang.String(), nil
+	case dec.peek(0) == '^' && dec.peek(1) == '^':
+		dec.r.Discard(2)
+		var (
+			typ string
+			err error
+		)
+		if dec.peek(0) == '<' {
+			typ, err = dec.iriRef()
+		} else {
+			typ, err = dec.prefixedName()
+		}
+		if err != nil {
+			return "", err
+		}
+		return value + "^^" + typ, nil
+	}
+	return value, nil
+}
+
+func (dec *Decoder) number() (string, error) {
+	var buf bytes.Buffer
+	digits := func() int {
+		var n int
+		for c := dec.peek(0); '0' <= c && c <= '9'; c = dec.peek(0) {
+			dec.next()
+			buf.WriteByte(c)
+			n++
+		}
+		return n
+	}
+	if c := dec.peek(0); c == '+' || c == '-' {
+		dec.next()
+		buf.WriteByte(c)
+	}
+	typ := "integer"
+	n := digits()
+	if dec.peek(0) == '.' && '0' <= dec.peek(1) && dec.peek(1) <= '9' {
+		dec.next()
+		buf.WriteByte('.')
+		n += digits()
+		typ = "decimal"
+	}
+	if c := dec.peek(0); n > 0 && (c == 'e' || c == 'E') {
+		dec.next()
+		buf.WriteByte(c)
+		if c := dec.peek(0); c == '+' || c == '-' {
+			dec.next()
+			buf.WriteByte(c)
+		}
+		if digits() == 0 {
+			return "", fmt.Errorf("invalid number %q", buf.String())
+		}
+		typ = "double"
+	}
+	if n == 0 {
+		return "", fmt.Errorf("invalid number %q", buf.String())
+	}
+	return `"` + buf.String() + `"^^<` + xsdNS + typ + ">", nil
+}
+
+// resolve resolves the IRI ref against base.
+func resolve(base, ref string) string {
+	if base == "" {
+		return ref
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
@@ -0,0 +1,200 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package turtle
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/cayley/quad"
+)
+
+func q(s, p, o, l string) quad.Quad {
+	return quad.Quad{Subject: s, Predicate: p, Object: o, Label: l}
+}
+
+const (
+	rdfType  = "<" + rdfNS + "type>"
+	rdfFirst = "<" + rdfNS + "first>"
+	rdfRest  = "<" + rdfNS + "rest>"
+	rdfNil   = "<" + rdfNS + "nil>"
+)
+
+var decodeTests = []struct {
+	message string
+	trig    bool
+	input   string
+	expect  []quad.Quad
+	err     bool
+}{
+	{
+		message: "parse prefixes and predicate object lists",
+		input: `@prefix ex: <http://example.com/> .
+			PREFIX foaf: <http://xmlns.com/foaf/0.1/>
+			# A comment.
+			ex:alice a foaf:Person ;
+				foaf:knows ex:bob, ex:charlie ;
+				foaf:name "Alice" ; .`,
+		expect: []quad.Quad{
+			q("<http://example.com/alice>", rdfType, "<http://xmlns.com/foaf/0.1/Person>", ""),
+			q("<http://example.com/alice>", "<http://xmlns.com/foaf/0.1/knows>", "<http://example.com/bob>", ""),
+			q("<http://example.com/alice>", "<http://xmlns.com/foaf/0.1/knows>", "<http://example.com/charlie>", ""),
+			q("<http://example.com/alice>", "<http://xmlns.com/foaf/0.1/name>", `"Alice"`, ""),
+		},
+	},
+	{
+		message: "resolve relative IRIs against the base",
+		input: `@base <http://example.com/a/> .
+			<b> <c> <../d> .
+			BASE <http://example.org/>
+			<e> <f> <#g> .
+			@prefix : <> .
+			:h :i :j.`,
+		expect: []quad.Quad{
+			q("<http://example.com/a/b>", "<http://example.com/a/c>", "<http://example.com/d>", ""),
+			q("<http://example.org/e>", "<http://example.org/f>", "<http://example.org/#g>", ""),
+			q("<http://example.org/h>", "<http://example.org/i>", "<http://example.org/j>", ""),
+		},
+	},
+	{
+		message: "parse literal shorthand",
+		input: `@prefix ex: <http://example.com/> .
+			@prefix xsd: <http://www.w3.org/2001/XMLSchema#> .
+			ex:s ex:p 42, -1.5, 1e3, true, "chat"@fr-CA, "x"^^xsd:string, "y"^^<http://example.com/t> .`,
+		expect: []quad.Quad{
+			q("<http://example.com/s>", "<http://example.com/p>", `"42"^^<`+xsdNS+`integer>`, ""),
+			q("<http://example.com/s>", "<http://example.com/p>", `"-1.5"^^<`+xsdNS+`decimal>`, ""),
+			q("<http://example.com/s>", "<http://example.com/p>", `"1e3"^^<`+xsdNS+`double>`, ""),
+			q("<http://example.com/s>", "<http://example.com/p>", `"true"^^<`+xsdNS+`boolean>`, ""),
+			q("<http://example.com/s>", "<http://example.com/p>", `"chat"@fr-CA`, ""),
+			q("<http://example.com/s>", "<http://example.com/p>", `"x"^^<`+xsdNS+`string>`, ""),
+			q("<http://example.com/s>", "<http://example.com/p>", `"y"^^<http://example.com/t>`, ""),
+		},
+	},
+	{
+		message: "parse strings",
+		input: `<s> <p> "a\"b", 'c', """two
+lines "quoted!"""", '''x''', "tab\t" .`,
+		expect: []quad.Quad{
+			q("<s>", "<p>", `"a"b"`, ""),
+			q("<s>", "<p>", `"c"`, ""),
+			q("<s>", "<p>", "\"two\nlines \"quoted!\"\"", ""),
+			q("<s>", "<p>", `"x"`, ""),
+			q("<s>", "<p>", "\"tab\t\"", ""),
+		},
+	},
+	{
+		message: "parse blank nodes, property lists and collections",
+		input: `@prefix : <http://example.com/> .
+			_:x :knows [ :name "Bob" ; :age 7 ] .
+			[ :name "Anon" ] .
+			_:x :list ( 1 _:x ) .
+			() :p [] .`,
+		expect: []quad.Quad{
+			q("_:b1", "<http://example.com/name>", `"Bob"`, ""),
+			q("_:b1", "<http://example.com/age>", `"7"^^<`+xsdNS+`integer>`, ""),
+			q("_:b0", "<http://example.com/knows>", "_:b1", ""),
+			q("_:b2", "<http://example.com/name>", `"Anon"`, ""),
+			q("_:b3", rdfFirst, `"1"^^<`+xsdNS+`integer>`, ""),
+			q("_:b3", rdfRest, "_:b4", ""),
+			q("_:b4", rdfFirst, "_:b0", ""),
+			q("_:b4", rdfRest, rdfNil, ""),
+			q("_:b0", "<http://example.com/list>", "_:b3", ""),
+			q(rdfNil, "<http://example.com/p>", "_:b5", ""),
+		},
+	},
+	{
+		message: "parse local names with periods and escapes",
+		input: `@prefix ex: <http://example.com/> .
+			ex:a.b ex:c\-d ex:e.`,
+		expect: []quad.Quad{
+			q("<http://example.com/a.b>", "<http://example.com/c-d>", "<http://example.com/e>", ""),
+		},
+	},
+	{
+		message: "parse named graphs",
+		trig:    true,
+		input: `@prefix ex: <http://example.com/> .
+			ex:a ex:p ex:b .
+			ex:g { ex:c ex:p ex:d . ex:e ex:p ex:f }
+			GRAPH _:h { ex:c ex:p ex:d }
+			{ ex:i ex:p ex:j . }`,
+		expect: []quad.Quad{
+			q("<http://example.com/a>", "<http://example.com/p>", "<http://example.com/b>", ""),
+			q("<http://example.com/c>", "<http://example.com/p>", "<http://example.com/d>", "<http://example.com/g>"),
+			q("<http://example.com/e>", "<http://example.com/p>", "<http://example.com/f>", "<http://example.com/g>"),
+			q("<http://example.com/c>", "<http://example.com/p>", "<http://example.com/d>", "_:b0"),
+			q("<http://example.com/i>", "<http://example.com/p>", "<http://example.com/j>", ""),
+		},
+	},
+	{
+		message: "reject graphs in Turtle",
+		input:   `<g> { <a> <b> <c> }`,
+		err:     true,
+	},
+	{
+		message: "reject undefined prefixes",
+		input:   `ex:a ex:b ex:c .`,
+		err:     true,
+	},
+	{
+		message: "reject a missing period",
+		input:   `<a> <b> <c>`,
+		err:     true,
+	},
+	{
+		message: "reject an unterminated graph",
+		trig:    true,
+		input:   `<g> { <a> <b> <c> .`,
+		err:     true,
+	},
+}
+
+func TestDecoder(t *testing.T) {
+	for _, test := range decodeTests {
+		var dec *Decoder
+		if test.trig {
+			dec = NewTriGDecoder(strings.NewReader(test.input))
+		} else {
+			dec = NewDecoder(strings.NewReader(test.input))
+		}
+		var (
+			got []quad.Quad
+			err error
+		)
+		for {
+			var q quad.Quad
+			q, err = dec.Unmarshal()
+			if err != nil {
+				break
+			}
+			got = append(got, q)
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		if (err != nil) != test.err {
+			t.Errorf("Unexpected error to %s, got:%v", test.message, err)
+		}
+		if test.err && test.expect == nil {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to %s, got:%v expect:%v", test.message, got, test.expect)
+		}
+	}
+}
@@ -15,6 +15,7 @@
 package gremlin
 
 import (
+	"regexp"
 	"strconv"
 
 	"github.com/barakmich/glog"
@@ -22,6 +23,7 @@ import (
 
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/graph/search"
 	"github.com/google/cayley/quad"
 )
 
@@ -166,7 +168,9 @@ func buildIteratorTreeHelper(obj *otto.Object, qs graph.QuadStore, base graph.It
 	val, _ := obj.Get("_gremlin_type")
 	switch val.String() {
 	case "vertex":
-		if len(stringArgs) == 0 {
+		if query, _ := obj.Get("search_query"); query.IsString() {
+			it = search.NewSearch(qs, query.String())
+		} else if len(stringArgs) == 0 {
 			it = qs.NodesAllIterator()
 		} else {
 			fixed := qs.FixedIterator()
@@ -268,6 +272,27 @@ func buildIteratorTreeHelper(obj *otto.Object, qs graph.QuadStore, base graph.It
 		and.AddSubIterator(fixed)
 		and.AddSubIterator(subIt)
 		it = and
+	case "regex":
+		if len(stringArgs) != 1 {
+			return iterator.NewNull()
+		}
+		re, err := regexp.Compile(stringArgs[0])
+		if err != nil {
+			glog.Errorln("Invalid regular expression:", err)
+			return iterator.NewNull()
+		}
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(iterator.NewRegex(qs.NodesAllIterator(), re, qs))
+		and.AddSubIterator(subIt)
+		it = and
+	case "prefix":
+		if len(stringArgs) != 1 {
+			return iterator.NewNull()
+		}
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(iterator.NewPrefix(qs.NodesAllIterator(), stringArgs[0], qs))
+		and.AddSubIterator(subIt)
+		it = and
 	case "or":
 		arg, _ := obj.Get("_gremlin_values")
 		firstArg, _ := arg.Object().Get("0")
@@ -23,6 +23,7 @@ import (
 	"github.com/robertkrimen/otto"
 
 	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
 )
 
 type worker struct {
@@ -36,15 +37,19 @@ type worker struct {
 	count int
 	limit int
 
+	parallelism int
+	spillBudget int64
+
 	kill <-chan struct{}
 }
 
 func newWorker(qs graph.QuadStore) *worker {
 	env := otto.New()
 	wk := &worker{
-		qs:    qs,
-		env:   env,
-		limit: -1,
+		qs:          qs,
+		env:         env,
+		limit:       -1,
+		spillBudget: iterator.DefaultSpillBudget,
 	}
 	graph, _ := env.Object("graph = {}")
 	env.Run("g = graph")
@@ -67,6 +72,25 @@ func newWorker(qs graph.QuadStore) *worker {
 	})
 	env.Run("graph.V = graph.Vertex")
 
+	// A search is a vertex chain starting from the nodes matching the query.
+	graph.Set("Search", func(call otto.FunctionCall) otto.Value {
+		call.Otto.Run("var out = {}")
+		out, err := call.Otto.Object("out")
+		if err != nil {
+			glog.Error(err.Error())
+			return otto.TrueValue()
+		}
+		out.Set("_gremlin_type", "vertex")
+		query := ""
+		if arg := call.Argument(0); arg.IsString() {
+			query = arg.String()
+		}
+		out.Set("search_query", query)
+		wk.embedTraversals(env, out)
+		wk.embedFinals(env, out)
+		return out.Value()
+	})
+
 	graph.Set("Morphism", func(call otto.FunctionCall) otto.Value {
 		call.Otto.Run("var out = {}")
 		out, _ := call.Otto.Object("out")
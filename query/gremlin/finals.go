@@ -39,8 +39,7 @@ func (wk *worker) embedFinals(env *otto.Otto, obj *otto.Object) {
 
 func (wk *worker) allFunc(env *otto.Otto, obj *otto.Object) func(otto.FunctionCall) otto.Value {
 	return func(call otto.FunctionCall) otto.Value {
-		it := buildIteratorTree(obj, wk.qs)
-		it.Tagger().Add(TopResultTag)
+		it := wk.buildIterator(obj)
 		wk.limit = -1
 		wk.count = 0
 		wk.runIterator(it)
@@ -52,8 +51,7 @@ func (wk *worker) limitFunc(env *otto.Otto, obj *otto.Object) func(otto.Function
 	return func(call otto.FunctionCall) otto.Value {
 		if len(call.ArgumentList) > 0 {
 			limitVal, _ := call.Argument(0).ToInteger()
-			it := buildIteratorTree(obj, wk.qs)
-			it.Tagger().Add(TopResultTag)
+			it := wk.buildIterator(obj)
 			wk.limit = int(limitVal)
 			wk.count = 0
 			wk.runIterator(it)
@@ -64,8 +62,7 @@ func (wk *worker) limitFunc(env *otto.Otto, obj *otto.Object) func(otto.Function
 
 func (wk *worker) toArrayFunc(env *otto.Otto, obj *otto.Object, withTags bool) func(otto.FunctionCall) otto.Value {
 	return func(call otto.FunctionCall) otto.Value {
-		it := buildIteratorTree(obj, wk.qs)
-		it.Tagger().Add(TopResultTag)
+		it := wk.buildIterator(obj)
 		limit := -1
 		if len(call.ArgumentList) > 0 {
 			limitParsed, _ := call.Argument(0).ToInteger()
@@ -91,8 +88,7 @@ func (wk *worker) toArrayFunc(env *otto.Otto, obj *otto.Object, withTags bool) f
 
 func (wk *worker) toValueFunc(env *otto.Otto, obj *otto.Object, withTags bool) func(otto.FunctionCall) otto.Value {
 	return func(call otto.FunctionCall) otto.Value {
-		it := buildIteratorTree(obj, wk.qs)
-		it.Tagger().Add(TopResultTag)
+		it := wk.buildIterator(obj)
 		limit := 1
 		var val otto.Value
 		var err error
@@ -119,8 +115,7 @@ func (wk *worker) toValueFunc(env *otto.Otto, obj *otto.Object, withTags bool) f
 
 func (wk *worker) mapFunc(env *otto.Otto, obj *otto.Object) func(otto.FunctionCall) otto.Value {
 	return func(call otto.FunctionCall) otto.Value {
-		it := buildIteratorTree(obj, wk.qs)
-		it.Tagger().Add(TopResultTag)
+		it := wk.buildIterator(obj)
 		limit := -1
 		if len(call.ArgumentList) == 0 {
 			return otto.NullValue()
@@ -143,10 +138,26 @@ func (wk *worker) tagsToValueMap(m map[string]graph.Value) map[string]string {
 	return outputMap
 }
 
+// buildIterator builds the iterator tree of the chain ending at obj.
+func (wk *worker) buildIterator(obj *otto.Object) graph.Iterator {
+	it := buildIteratorTree(obj, wk.qs)
+	it.Tagger().Add(TopResultTag)
+	return it
+}
+
+// optimize optimizes the iterator tree it, giving it the session's spill
+// budget and evaluating it in parallel if the session allows.
+func (wk *worker) optimize(it graph.Iterator) graph.Iterator {
+	it, _ = it.Optimize()
+	iterator.SpillBudget(it, wk.spillBudget)
+	return iterator.Parallel(it, wk.parallelism)
+}
+
 func (wk *worker) runIteratorToArray(it graph.Iterator, limit int) []map[string]string {
 	output := make([]map[string]string, 0)
 	n := 0
-	it, _ = it.Optimize()
+	it = wk.optimize(it)
+	defer it.Close()
 	for {
 		select {
 		case <-wk.kill:
@@ -178,36 +189,45 @@ func (wk *worker) runIteratorToArray(it graph.Iterator, limit int) []map[string]
 			}
 		}
 	}
-	it.Close()
 	return output
 }
 
 func (wk *worker) runIteratorToArrayNoTags(it graph.Iterator, limit int) []string {
 	output := make([]string, 0)
-	n := 0
-	it, _ = it.Optimize()
+	it = wk.optimize(it)
+	defer it.Close()
+	batch := make([]graph.Value, 100)
 	for {
 		select {
 		case <-wk.kill:
 			return nil
 		default:
 		}
-		if !graph.Next(it) {
+		size := len(batch)
+		if rest := limit - len(output); limit >= 0 && rest < size {
+			size = rest
+			if size < 1 {
+				size = 1
+			}
+		}
+		n := graph.NextBatch(it, batch[:size])
+		if n == 0 {
 			break
 		}
-		output = append(output, wk.qs.NameOf(it.Result()))
-		n++
-		if limit >= 0 && n >= limit {
+		for _, v := range batch[:n] {
+			output = append(output, wk.qs.NameOf(v))
+		}
+		if limit >= 0 && len(output) >= limit {
 			break
 		}
 	}
-	it.Close()
 	return output
 }
 
 func (wk *worker) runIteratorWithCallback(it graph.Iterator, callback otto.Value, this otto.FunctionCall, limit int) {
 	n := 0
-	it, _ = it.Optimize()
+	it = wk.optimize(it)
+	defer it.Close()
 	if glog.V(2) {
 		b, err := json.MarshalIndent(it.Describe(), "", "  ")
 		if err != nil {
@@ -249,7 +269,6 @@ func (wk *worker) runIteratorWithCallback(it graph.Iterator, callback otto.Value
 			}
 		}
 	}
-	it.Close()
 }
 
 func (wk *worker) send(r *Result) bool {
@@ -277,7 +296,8 @@ func (wk *worker) runIterator(it graph.Iterator) {
 		iterator.OutputQueryShapeForIterator(it, wk.qs, wk.shape)
 		return
 	}
-	it, _ = it.Optimize()
+	it = wk.optimize(it)
+	defer it.Close()
 	if glog.V(2) {
 		b, err := json.MarshalIndent(it.Describe(), "", "  ")
 		if err != nil {
@@ -317,5 +337,4 @@ func (wk *worker) runIterator(it graph.Iterator) {
 		bytes, _ := json.MarshalIndent(graph.DumpStats(it), "", "  ")
 		glog.V(2).Infoln(string(bytes))
 	}
-	it.Close()
 }
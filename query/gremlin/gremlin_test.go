@@ -21,6 +21,8 @@ import (
 	"sort"
 	"testing"
 
+	"github.com/robertkrimen/otto"
+
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/quad"
 	"github.com/google/cayley/quad/cquads"
@@ -44,7 +46,16 @@ import (
 //
 
 func makeTestSession(data []quad.Quad) *Session {
-	qs, _ := graph.NewQuadStore("memstore", "", nil)
+	return makeSession(data, nil)
+}
+
+// makeSearchSession returns a session on a store with a search index.
+func makeSearchSession(data []quad.Quad) *Session {
+	return makeSession(data, graph.Options{"search": true})
+}
+
+func makeSession(data []quad.Quad, opts graph.Options) *Session {
+	qs, _ := graph.NewQuadStore("memstore", "", opts)
 	w, _ := graph.NewQuadWriter("single", qs, nil)
 	for _, t := range data {
 		w.AddQuad(t)
@@ -203,6 +214,29 @@ var testQueries = []struct {
 		expect: []string{"bob"},
 	},
 
+	// Gremlin Regex and Prefix tests.
+	{
+		message: "use .Prefix()",
+		query: `
+				g.V().Prefix("cool").All()
+		`,
+		expect: []string{"cool_person"},
+	},
+	{
+		message: "use .Regex()",
+		query: `
+				g.V("charlie").Out("follows").Regex("^(bob|fred)$").All()
+		`,
+		expect: []string{"bob"},
+	},
+	{
+		message: "use .Regex() with an invalid expression",
+		query: `
+				g.V().Regex("(").All()
+		`,
+		expect: nil,
+	},
+
 	// Tag tests.
 	{
 		message: "show a simple save",
@@ -273,8 +307,35 @@ var testQueries = []struct {
 	},
 }
 
-func runQueryGetTag(g []quad.Quad, query string, tag string) []string {
-	js := makeTestSession(g)
+var searchQueries = []struct {
+	message string
+	query   string
+	expect  []string
+}{
+	{
+		message: "use g.Search()",
+		query: `
+				g.Search("cool").All()
+		`,
+		expect: []string{"cool_person"},
+	},
+	{
+		message: "follow the results of g.Search()",
+		query: `
+				g.Search("dan").Out("follows").All()
+		`,
+		expect: []string{"bob", "greg"},
+	},
+	{
+		message: "use g.Search() without a match",
+		query: `
+				g.Search("nobody").All()
+		`,
+		expect: nil,
+	},
+}
+
+func runQueryGetTag(js *Session, query string, tag string) []string {
 	c := make(chan interface{}, 5)
 	js.Execute(query, c, -1)
 
@@ -319,7 +380,7 @@ func TestGremlin(t *testing.T) {
 		if test.tag == "" {
 			test.tag = TopResultTag
 		}
-		got := runQueryGetTag(simpleGraph, test.query, test.tag)
+		got := runQueryGetTag(makeTestSession(simpleGraph), test.query, test.tag)
 		sort.Strings(got)
 		sort.Strings(test.expect)
 		if !reflect.DeepEqual(got, test.expect) {
@@ -328,6 +389,22 @@ func TestGremlin(t *testing.T) {
 	}
 }
 
+func TestGremlinSearch(t *testing.T) {
+	simpleGraph := loadGraph("../../data/testdata.nq", t)
+	for _, test := range searchQueries {
+		got := runQueryGetTag(makeSearchSession(simpleGraph), test.query, TopResultTag)
+		sort.Strings(got)
+		sort.Strings(test.expect)
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to %s, got: %v expected: %v", test.message, got, test.expect)
+		}
+	}
+	got := runQueryGetTag(makeTestSession(simpleGraph), `g.Search("cool").All()`, TopResultTag)
+	if len(got) != 0 {
+		t.Errorf("Unexpected search results without an index: %v", got)
+	}
+}
+
 var issue160TestGraph = []quad.Quad{
 	{"alice", "follows", "bob", ""},
 	{"bob", "follows", "alice", ""},
@@ -368,3 +445,82 @@ func TestIssue160(t *testing.T) {
 		t.Errorf("Unexpected result, got: %q expected: %q", got, expect)
 	}
 }
+
+var parameterizeTests = []struct {
+	src    string
+	text   string
+	params []interface{}
+	ok     bool
+}{
+	{
+		src:    `g.V("alice").Out('follows').GetLimit(2)`,
+		text:   `g.V(_gremlin_params[0]).Out(_gremlin_params[1]).GetLimit(_gremlin_params[2])`,
+		params: []interface{}{"alice", "follows", 2.0},
+		ok:     true,
+	},
+	{
+		src:    "var x1 = 1.5e+3 // \"not\" 5\ng.V(\"a\\\"b\", 0x1F, 017)",
+		text:   "var x1 = _gremlin_params[0] // \"not\" 5\ng.V(\"a\\\"b\", 0x1F, 017)",
+		params: []interface{}{1500.0},
+		ok:     true,
+	},
+	{
+		src: `g.V().Regex(/^a/)`,
+	},
+	{
+		src: `g.V(_gremlin_params[0])`,
+	},
+}
+
+func TestParameterize(t *testing.T) {
+	for _, test := range parameterizeTests {
+		text, params, ok := parameterize(test.src)
+		if ok != test.ok || text != test.text || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("Unexpected parameterization of %q, got:%q %v %t expect:%q %v %t",
+				test.src, text, params, ok, test.text, test.params, test.ok)
+		}
+	}
+}
+
+func TestScriptCache(t *testing.T) {
+	qs, _ := graph.NewQuadStore("memstore", "", nil)
+	w, _ := graph.NewQuadWriter("single", qs, nil)
+	for _, q := range issue160TestGraph {
+		w.AddQuad(q)
+	}
+	run := func(query string) []string {
+		ses := NewSession(qs, -1, false)
+		if _, err := ses.Parse(query); err != nil {
+			t.Fatalf("Failed to parse %q: %v", query, err)
+		}
+		c := make(chan interface{}, 5)
+		go ses.Execute(query, c, -1)
+		var got []string
+		for res := range c {
+			if data := res.(*Result); data.val == nil {
+				got = append(got, qs.NameOf(data.actualResults[TopResultTag]))
+			}
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	// Each query differs only in its constants, so they share a script, but
+	// each is run with its own.
+	for _, test := range []struct {
+		query  string
+		expect []string
+	}{
+		{query: `g.V("alice").Out("follows").All()`, expect: []string{"bob"}},
+		{query: `g.V("dani").Out("follows").All()`, expect: []string{"alice", "charlie"}},
+		{query: `g.V("emily").Out("follows").All()`, expect: nil},
+	} {
+		if got := run(test.query); !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Unexpected results of %q, got:%v expect:%v", test.query, got, test.expect)
+		}
+	}
+	script, _, _ := compile(otto.New(), `g.V("dani").Out("follows").All()`)
+	if other, _, _ := compile(otto.New(), `g.V("alice").Out("follows").All()`); other != script {
+		t.Error("Queries differing in their constants were compiled twice")
+	}
+}
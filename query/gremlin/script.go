@@ -0,0 +1,167 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gremlin
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/robertkrimen/otto"
+)
+
+// paramsName is the name of the array holding the literals of a
+// parameterized script.
+const paramsName = "_gremlin_params"
+
+// maxScripts is the number of compiled scripts kept by scripts.
+const maxScripts = 1024
+
+// scripts holds the compiled scripts of the queries seen by all sessions,
+// keyed by their parameterized source, so that queries differing only in
+// their constants are parsed once.
+var scripts = struct {
+	sync.Mutex
+	m map[string]*otto.Script
+}{m: make(map[string]*otto.Script)}
+
+// compile returns the script of src, and the values of its parameters.
+func compile(env *otto.Otto, src string) (*otto.Script, []interface{}, error) {
+	text, params, ok := parameterize(src)
+	if !ok {
+		script, err := env.Compile("", src)
+		return script, nil, err
+	}
+	scripts.Lock()
+	script, ok := scripts.m[text]
+	scripts.Unlock()
+	if ok {
+		return script, params, nil
+	}
+	script, err := env.Compile("", text)
+	if err != nil {
+		// Not every literal can be a parameter, as with the keys of an
+		// object, so fall back to the source as written.
+		script, err := env.Compile("", src)
+		return script, nil, err
+	}
+	scripts.Lock()
+	if len(scripts.m) >= maxScripts {
+		// Evict an arbitrary script; the common queries will be back.
+		for k := range scripts.m {
+			delete(scripts.m, k)
+			break
+		}
+	}
+	scripts.m[text] = script
+	scripts.Unlock()
+	return script, params, nil
+}
+
+// parameterize returns src with each string literal without escapes and each
+// decimal number literal replaced by an element of the params array, and the
+// values of those literals. It returns false if src has a slash outside of a
+// string or comment, as it cannot tell a regular expression from a division
+// without parsing, or if it refers to the params array itself.
+func parameterize(src string) (string, []interface{}, bool) {
+	if strings.Contains(src, paramsName) {
+		return "", nil, false
+	}
+	var (
+		buf    bytes.Buffer
+		params []interface{}
+	)
+	param := func(v interface{}) {
+		buf.WriteString(paramsName + "[" + strconv.Itoa(len(params)) + "]")
+		params = append(params, v)
+	}
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '/' && strings.HasPrefix(src[i:], "//"):
+			n := strings.IndexByte(src[i:], '\n')
+			if n < 0 {
+				n = len(src) - i
+			}
+			buf.WriteString(src[i : i+n])
+			i += n
+		case c == '/' && strings.HasPrefix(src[i:], "/*"):
+			n := strings.Index(src[i+2:], "*/")
+			if n < 0 {
+				return "", nil, false
+			}
+			buf.WriteString(src[i : i+n+4])
+			i += n + 4
+		case c == '/' || c == '`':
+			return "", nil, false
+		case c == '"' || c == '\'':
+			n := strings.IndexAny(src[i+1:], string(c)+"\\\n")
+			if n < 0 {
+				return "", nil, false
+			}
+			end := i + 1 + n
+			if src[end] != c {
+				// Leave strings with escapes as they are.
+				n = end
+				for n < len(src) && src[n] != c && src[n] != '\n' {
+					if src[n] == '\\' {
+						n++
+					}
+					n++
+				}
+				if n >= len(src) {
+					return "", nil, false
+				}
+				buf.WriteString(src[i : n+1])
+				i = n + 1
+				continue
+			}
+			param(src[i+1 : end])
+			i = end + 1
+		case isIdentByte(c) && (c < '0' || c > '9'):
+			n := i + 1
+			for n < len(src) && isIdentByte(src[n]) {
+				n++
+			}
+			buf.WriteString(src[i:n])
+			i = n
+		case c >= '0' && c <= '9':
+			n := i + 1
+			for n < len(src) && (isIdentByte(src[n]) || src[n] == '.' ||
+				((src[n] == '+' || src[n] == '-') && (src[n-1] == 'e' || src[n-1] == 'E'))) {
+				n++
+			}
+			lit := src[i:n]
+			i = n
+			// Octal and hexadecimal literals are left as they are.
+			f, err := strconv.ParseFloat(lit, 64)
+			if err != nil || (len(lit) > 1 && lit[0] == '0' && lit[1] >= '0' && lit[1] <= '9') {
+				buf.WriteString(lit)
+				continue
+			}
+			param(f)
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	return buf.String(), params, true
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -35,6 +35,7 @@ type Session struct {
 
 	wk      *worker
 	script  *otto.Script
+	params  []interface{}
 	persist *otto.Otto
 
 	timeout time.Duration
@@ -69,6 +70,17 @@ func (s *Session) Debug(ok bool) {
 	s.debug = ok
 }
 
+// SetParallelism sets the number of goroutines a query may use.
+func (s *Session) SetParallelism(n int) {
+	s.wk.parallelism = n
+}
+
+// SetSpillBudget sets the bytes of results each iterator of a query may hold
+// in memory before spilling them to disk.
+func (s *Session) SetSpillBudget(n int64) {
+	s.wk.spillBudget = n
+}
+
 func (s *Session) ShapeOf(query string) (interface{}, error) {
 	// TODO(kortschak) It would be nice to be able
 	// to return an error for bad queries here.
@@ -80,11 +92,11 @@ func (s *Session) ShapeOf(query string) (interface{}, error) {
 }
 
 func (s *Session) Parse(input string) (query.ParseResult, error) {
-	script, err := s.wk.env.Compile("", input)
+	script, params, err := compile(s.wk.env, input)
 	if err != nil {
 		return query.ParseFail, err
 	}
-	s.script = script
+	s.script, s.params = script, params
 	return query.Parsed, nil
 }
 
@@ -141,6 +153,9 @@ func (s *Session) Execute(input string, out chan interface{}, _ int) {
 	if s.script == nil {
 		value, err = s.runUnsafe(input)
 	} else {
+		if s.params != nil {
+			s.wk.env.Set(paramsName, s.params)
+		}
 		value, err = s.runUnsafe(s.script)
 	}
 	out <- &Result{
@@ -149,7 +164,7 @@ func (s *Session) Execute(input string, out chan interface{}, _ int) {
 		val:        &value,
 	}
 	s.wk.results = nil
-	s.script = nil
+	s.script, s.params = nil, nil
 	s.wk.Lock()
 	s.wk.env = s.persist
 	s.wk.Unlock()
@@ -25,6 +25,8 @@ func (wk *worker) embedTraversals(env *otto.Otto, obj *otto.Object) {
 	obj.Set("In", wk.gremlinFunc("in", obj, env))
 	obj.Set("Out", wk.gremlinFunc("out", obj, env))
 	obj.Set("Is", wk.gremlinFunc("is", obj, env))
+	obj.Set("Regex", wk.gremlinFunc("regex", obj, env))
+	obj.Set("Prefix", wk.gremlinFunc("prefix", obj, env))
 	obj.Set("Both", wk.gremlinFunc("both", obj, env))
 	obj.Set("Follow", wk.gremlinFunc("follow", obj, env))
 	obj.Set("FollowR", wk.gremlinFollowR("followr", obj, env))
@@ -17,6 +17,7 @@ package mql
 import (
 	"encoding/json"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/google/cayley/graph"
@@ -178,12 +179,34 @@ func runQuery(g []quad.Quad, query string) interface{} {
 	return result
 }
 
+// unordered sorts the results of a query by their JSON encoding, since MQL
+// does not define the order in which results are returned.
+func unordered(results interface{}) interface{} {
+	list, ok := results.([]interface{})
+	if !ok {
+		return results
+	}
+	keys := make(map[string]interface{})
+	var sorted []string
+	for _, r := range list {
+		b, _ := json.Marshal(r)
+		keys[string(b)] = r
+		sorted = append(sorted, string(b))
+	}
+	sort.Strings(sorted)
+	out := make([]interface{}, len(sorted))
+	for i, k := range sorted {
+		out[i] = keys[k]
+	}
+	return out
+}
+
 func TestMQL(t *testing.T) {
 	for _, test := range testQueries {
 		got := runQuery(simpleGraph, test.query)
 		var expect interface{}
 		json.Unmarshal([]byte(test.expect), &expect)
-		if !reflect.DeepEqual(got, expect) {
+		if !reflect.DeepEqual(unordered(got), unordered(expect)) {
 			b, err := json.MarshalIndent(got, "", " ")
 			if err != nil {
 				t.Fatalf("unexpected JSON marshal error: %v", err)
@@ -30,11 +30,14 @@ type Session struct {
 	qs           graph.QuadStore
 	currentQuery *Query
 	debug        bool
+	parallelism  int
+	spillBudget  int64
 }
 
 func NewSession(qs graph.QuadStore) *Session {
 	var m Session
 	m.qs = qs
+	m.spillBudget = iterator.DefaultSpillBudget
 	return &m
 }
 
@@ -42,6 +45,17 @@ func (s *Session) Debug(ok bool) {
 	s.debug = ok
 }
 
+// SetParallelism sets the number of goroutines a query may use.
+func (s *Session) SetParallelism(n int) {
+	s.parallelism = n
+}
+
+// SetSpillBudget sets the bytes of results each iterator of a query may hold
+// in memory before spilling them to disk.
+func (s *Session) SetSpillBudget(n int64) {
+	s.spillBudget = n
+}
+
 func (s *Session) ShapeOf(query string) (interface{}, error) {
 	var mqlQuery interface{}
 	err := json.Unmarshal([]byte(query), &mqlQuery)
@@ -83,6 +97,8 @@ func (s *Session) Execute(input string, c chan interface{}, _ int) {
 		return
 	}
 	it, _ := s.currentQuery.it.Optimize()
+	iterator.SpillBudget(it, s.spillBudget)
+	it = iterator.Parallel(it, s.parallelism)
 	if glog.V(2) {
 		b, err := json.MarshalIndent(it.Describe(), "", "  ")
 		if err != nil {
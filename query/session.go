@@ -32,6 +32,21 @@ type Session interface {
 	Debug(bool)
 }
 
+// Parallel is implemented by sessions that can evaluate a query on several
+// goroutines.
+type Parallel interface {
+	// SetParallelism sets the number of goroutines a query may use.
+	SetParallelism(int)
+}
+
+// Spiller is implemented by sessions whose queries can move the results they
+// hold to disk.
+type Spiller interface {
+	// SetSpillBudget sets roughly the number of bytes of results each
+	// iterator of a query may hold in memory before spilling them to disk.
+	SetSpillBudget(int64)
+}
+
 type HTTP interface {
 	// Return whether the string is a valid expression.
 	Parse(string) (ParseResult, error)
@@ -113,5 +113,19 @@ func (s *Single) ApplyTransaction(t *graph.Transaction) error {
 		t.Deltas[i].ID = s.currentID.Next()
 		t.Deltas[i].Timestamp = ts
 	}
-	return s.qs.ApplyDeltas(t.Deltas, s.ignoreOpts)
+	pa, ok := s.qs.(graph.PreconditionApplier)
+	if !ok {
+		// Only stores that can check preconditions apply several deltas
+		// atomically.
+		if len(t.Preconditions) != 0 {
+			return graph.ErrPreconditionsUnsupported
+		}
+		if len(t.Deltas) > 1 {
+			return graph.ErrAtomicityUnsupported
+		}
+	}
+	if len(t.Preconditions) == 0 {
+		return s.qs.ApplyDeltas(t.Deltas, s.ignoreOpts)
+	}
+	return pa.ApplyDeltasIf(t.Deltas, t.Preconditions, s.ignoreOpts)
 }